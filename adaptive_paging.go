@@ -0,0 +1,85 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dosa
+
+import "time"
+
+// AdaptivePagingTarget is the origin latency per page that adaptive paging
+// aims for. A page that comes back in less than half of it doubles the size
+// of the next one; a page that takes longer halves it.
+const AdaptivePagingTarget = 100 * time.Millisecond
+
+// WithAdaptivePaging lets WalkRange tune the number of rows it asks for per
+// page to the latency of the connector, between min and max rows. The first
+// page uses the limit of the RangeOp, brought within the bounds, or min if
+// the RangeOp has none; the sizes of the following pages are adjusted
+// towards AdaptivePagingTarget. Range and the other single-call methods
+// always use the limit they are given.
+func WithAdaptivePaging(min, max int) ClientOption {
+	return func(c *client) {
+		if min < 1 {
+			min = 1
+		}
+		if max < min {
+			max = min
+		}
+		c.adaptivePaging = &pageSizer{min: min, max: max}
+	}
+}
+
+// pageSizer holds the bounds of adaptive paging and, for a walk, the size of
+// the next page
+type pageSizer struct {
+	min, max int
+	size     int
+}
+
+// start returns a sizer for a walk whose RangeOp has the given limit
+func (p *pageSizer) start(limit int) *pageSizer {
+	s := &pageSizer{min: p.min, max: p.max, size: limit}
+	if limit <= 0 {
+		s.size = p.min
+	}
+	s.clamp()
+	return s
+}
+
+// observe records how long the last page took and returns the size of the
+// next one
+func (p *pageSizer) observe(latency time.Duration) int {
+	switch {
+	case latency < AdaptivePagingTarget/2:
+		p.size *= 2
+	case latency > AdaptivePagingTarget:
+		p.size /= 2
+	}
+	p.clamp()
+	return p.size
+}
+
+func (p *pageSizer) clamp() {
+	if p.size < p.min {
+		p.size = p.min
+	}
+	if p.size > p.max {
+		p.size = p.max
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dosa
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPageSizer(t *testing.T) {
+	bounds := &pageSizer{min: 10, max: 80}
+
+	// the requested limit is used first, within the bounds
+	assert.Equal(t, 20, bounds.start(20).size)
+	assert.Equal(t, 10, bounds.start(0).size)
+	assert.Equal(t, 80, bounds.start(1000).size)
+
+	// pages grow under low latency, up to the maximum
+	s := bounds.start(20)
+	assert.Equal(t, 40, s.observe(time.Millisecond))
+	assert.Equal(t, 80, s.observe(time.Millisecond))
+	assert.Equal(t, 80, s.observe(time.Millisecond))
+
+	// a latency close to the target keeps the size
+	assert.Equal(t, 80, s.observe(AdaptivePagingTarget))
+
+	// and shrink under high latency, down to the minimum
+	assert.Equal(t, 40, s.observe(2*AdaptivePagingTarget))
+	assert.Equal(t, 20, s.observe(2*AdaptivePagingTarget))
+	assert.Equal(t, 10, s.observe(2*AdaptivePagingTarget))
+	assert.Equal(t, 10, s.observe(2*AdaptivePagingTarget))
+}
@@ -25,6 +25,8 @@ import (
 	"fmt"
 	"os"
 	"reflect"
+	"sort"
+	"time"
 
 	"bytes"
 	"io"
@@ -102,6 +104,41 @@ func ErrorIsAlreadyExists(err error) bool {
 	return ok
 }
 
+// ErrUnsupported is returned when the connector does not support the
+// requested operation
+type ErrUnsupported struct {
+	Op string
+}
+
+// Error returns a message naming the unsupported operation
+func (e *ErrUnsupported) Error() string {
+	return fmt.Sprintf("%s is not supported by this connector", e.Op)
+}
+
+// ErrorIsUnsupported checks if the error is caused by "ErrUnsupported"
+func ErrorIsUnsupported(err error) bool {
+	_, ok := errors.Cause(err).(*ErrUnsupported)
+	return ok
+}
+
+// ErrCountLimitExceeded is returned by Count when the range holds more rows
+// than the client is allowed to page through, see WithCountLimit
+type ErrCountLimitExceeded struct {
+	Limit int64
+}
+
+// Error returns a message naming the limit
+func (e *ErrCountLimitExceeded) Error() string {
+	return fmt.Sprintf("range holds more than %d rows", e.Limit)
+}
+
+// ErrorIsCountLimitExceeded checks if the error is caused by
+// "ErrCountLimitExceeded"
+func ErrorIsCountLimitExceeded(err error) bool {
+	_, ok := errors.Cause(err).(*ErrCountLimitExceeded)
+	return ok
+}
+
 // Client defines the methods to operate with DOSA entities
 type Client interface {
 	// Initialize must be called before any data operation
@@ -120,6 +157,31 @@ type Client interface {
 	// as a result of the read
 	Read(ctx context.Context, fieldsToRead []string, objectToRead DomainObject) error
 
+	// ReadWithPresence is like Read but also reports which of the requested
+	// fields had a value in the datastore, keyed by field name. Absent fields
+	// are left at their zero value, so this distinguishes "absent" from
+	// "zero" for sparse rows.
+	ReadWithPresence(ctx context.Context, fieldsToRead []string, objectToRead DomainObject) (map[string]bool, error)
+
+	// ReadConsistent is like Read but always answered by the source of
+	// truth: connectors that keep copies of rows, such as the fallback cache,
+	// read the row from origin and, before returning, rewrite their copy if
+	// it differs or drop it if origin no longer has the row. Use it for
+	// reconciliation rather than on hot paths. Connectors that do not
+	// implement ConsistentReader fail with ErrUnsupported.
+	ReadConsistent(ctx context.Context, fieldsToRead []string, objectToRead DomainObject) error
+
+	// Exists reports whether a row with the object's primary key exists.
+	// Only the primary key columns are read and the object is not modified.
+	Exists(ctx context.Context, objectToCheck DomainObject) (bool, error)
+
+	// Refresh makes a connector that keeps copies of rows, such as the
+	// fallback cache, reread the object's row from origin and store it, or
+	// drop its copy if origin no longer has the row. Only the primary key
+	// fields of the object are used and it is not modified. Connectors that
+	// do not implement Refresher fail with ErrUnsupported.
+	Refresh(ctx context.Context, objectToRefresh DomainObject) error
+
 	// TODO: Coming in v2.1
 	// MultiRead fetches several rows by primary key. A list of fields can be
 	// specified. Use All() or nil for all fields.
@@ -132,15 +194,45 @@ type Client interface {
 	// to update in fieldsToUpdate (or all the fields if you use dosa.All())
 	Upsert(ctx context.Context, fieldsToUpdate []string, objectToUpdate DomainObject) error
 
+	// UpsertN is like Upsert but also reports how many rows were written.
+	// RowsAffected is RowsAffectedUnknown when the connector cannot tell.
+	UpsertN(ctx context.Context, fieldsToUpdate []string, objectToUpdate DomainObject) (MutationResult, error)
+
+	// UpsertIfChanged reads the object's row and upserts all of its fields
+	// only if the stored values differ from the object's, or the row does not
+	// exist. It reports whether the upsert was made. The object is not
+	// modified. The read and the upsert are separate calls, so a concurrent
+	// writer can change the row in between.
+	UpsertIfChanged(ctx context.Context, objectToUpdate DomainObject) (bool, error)
+
 	// TODO: Coming in v2.1
 	// MultiUpsert creates or updates multiple rows. A list of fields to
 	// update can be specified. Use All() or nil for all fields.
 	// MultiUpsert(context.Context, []string, ...DomainObject) (MultiResult, error)
 
+	// BatchUpsert upserts objects of any registered entity types. The objects
+	// are grouped by entity and each group is sent to the connector as one
+	// MultiUpsert. fieldsToUpdate applies to every object, so each of their
+	// types must have the named fields; use All() or nil for all fields. The
+	// result holds the outcome of each object, including
+	// the objects that could not be sent; the returned error is only set if
+	// the client is not initialized.
+	BatchUpsert(ctx context.Context, fieldsToUpdate []string, objectsToUpdate ...DomainObject) (MultiResult, error)
+
+	// WriteBatch starts a batch of upserts and removes that are committed
+	// together, all or nothing. Nothing is written until Commit is called,
+	// which fails with ErrUnsupported if the connector does not implement
+	// Transactor.
+	WriteBatch(ctx context.Context) *WriteBatch
+
 	// Remove removes a row by primary key. The passed-in entity should contain
 	// the primary key field values, all other fields are ignored.
 	Remove(ctx context.Context, objectToRemove DomainObject) error
 
+	// RemoveN is like Remove but also reports how many rows were removed.
+	// RowsAffected is RowsAffectedUnknown when the connector cannot tell.
+	RemoveN(ctx context.Context, objectToRemove DomainObject) (MutationResult, error)
+
 	// RemoveRange removes all of the rows that fall within the range specified by the
 	// given RemoveRangeOp.
 	RemoveRange(ctx context.Context, removeRangeOp *RemoveRangeOp) error
@@ -161,13 +253,35 @@ type Client interface {
 	// of the range can be fetched with additional calls to the range function.
 	Range(ctx context.Context, rangeOp *RangeOp) ([]DomainObject, string, error)
 
+	// RangeRaw is Range without materialization: the rows are returned as
+	// the field value maps the connector produced, keyed by column name,
+	// which saves the reflection for bulk readers such as exports. Hooks
+	// added with WithAfterRead are not run, as there are no objects.
+	RangeRaw(ctx context.Context, rangeOp *RangeOp) ([]map[string]FieldValue, string, error)
+
+	// RangeAcross is Range over several partitions at once: the rows of each
+	// partition, given as partition key field values, are fetched
+	// concurrently and merged in clustering order up to the limit of the
+	// RangeOp. The continuation token resumes every partition and is only
+	// valid for the same partitions.
+	RangeAcross(ctx context.Context, rangeOp *RangeOp, partitions []map[string]FieldValue) ([]DomainObject, string, error)
+
 	// WalkRange starts at the offset specified by the RangeOp and walks the entire
 	// range of values that fall within the RangeOp conditions. It will make multiple, sequential
 	// range requests, fetching values until there are no more left in the range.
 	//
 	// For each value fetched, the provided onNext function is called with the value as it's argument.
+	// Clients created WithAdaptivePaging vary the limit from one request to the next.
 	WalkRange(ctx context.Context, r *RangeOp, onNext func(value DomainObject) error) error
 
+	// Count returns the number of rows that fall within the RangeOp
+	// conditions. The token, limit and fields of the RangeOp are ignored.
+	// Connectors that implement RangeCounter count the rows themselves;
+	// otherwise the range is paged through, fetching only the primary key
+	// columns, and counting fails with ErrCountLimitExceeded once more rows
+	// than the client's count limit have been seen.
+	Count(ctx context.Context, r *RangeOp) (int64, error)
+
 	// ScanEverything fetches all entities of a type
 	// Before calling ScanEverything, create a scanOp to specify the
 	// table to scan. The return values are an array of objects, that
@@ -176,6 +290,19 @@ type Client interface {
 	// To scan the next set of rows, modify the scanOp to provide
 	// the string returned as an Offset()
 	ScanEverything(ctx context.Context, scanOp *ScanOp) ([]DomainObject, string, error)
+
+	// CacheKeyFor returns the key under which the given entity is stored in
+	// the fallback cache. Fill in ALL of the primary key fields before
+	// calling this method. It fails if the client's connector does not
+	// implement CacheKeyer.
+	CacheKeyFor(objectToKey DomainObject) ([]byte, error)
+}
+
+// CacheKeyer is implemented by connectors that keep rows in a cache keyed by
+// a value derived from the primary key, such as the fallback cache connector.
+type CacheKeyer interface {
+	// CacheKey returns the key under which the row identified by keys is cached
+	CacheKey(ei *EntityInfo, keys map[string]FieldValue) ([]byte, error)
 }
 
 // MultiResult contains the result for each entity operation in the case of
@@ -184,6 +311,17 @@ type Client interface {
 // untouched and error is not nil.
 type MultiResult map[DomainObject]error
 
+// RowsAffectedUnknown is reported in MutationResult when the connector does
+// not know how many rows a mutation affected
+const RowsAffectedUnknown = -1
+
+// MutationResult describes the outcome of UpsertN and RemoveN
+type MutationResult struct {
+	// RowsAffected is the number of rows the backend reported as written or
+	// removed, or RowsAffectedUnknown
+	RowsAffected int
+}
+
 // All is used for "fields []string" to read/update all fields.
 // It's a convenience function for code readability.
 func All() []string { return nil }
@@ -216,16 +354,28 @@ type client struct {
 	initialized bool
 	registrar   Registrar
 	connector   Connector
+	initRetry   *initRetry
+	afterRead   []func(DomainObject) error
+	countLimit  int64
+	// defaultFields maps entity names to the fields read when none are given
+	defaultFields map[string][]string
+	// page size bounds of WalkRange, if it adapts them
+	adaptivePaging *pageSizer
 }
 
 // NewClient returns a new DOSA client for the registry and connector
 // provided. This is currently only a partial implementation to demonstrate
 // basic CRUD functionality.
-func NewClient(reg Registrar, conn Connector) Client {
-	return &client{
-		registrar: reg,
-		connector: conn,
+func NewClient(reg Registrar, conn Connector, opts ...ClientOption) Client {
+	c := &client{
+		registrar:  reg,
+		connector:  conn,
+		countLimit: DefaultCountLimit,
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
+	return c
 }
 
 // Initialize performs initial schema checks against all registered entities.
@@ -245,7 +395,7 @@ func (c *client) Initialize(ctx context.Context) error {
 	}
 
 	// fetch latest version for all registered entities, assume order is preserved
-	version, err := c.connector.CheckSchema(ctx, c.registrar.Scope(), c.registrar.NamePrefix(), eds)
+	version, err := c.checkSchema(ctx, eds)
 	if err != nil {
 		return errors.Wrap(err, "CheckSchema failed")
 	}
@@ -258,6 +408,33 @@ func (c *client) Initialize(ctx context.Context) error {
 	return nil
 }
 
+// checkSchema calls CheckSchema on the connector, retrying transient errors
+// if the client was created with WithInitializeRetry
+func (c *client) checkSchema(ctx context.Context, eds []*EntityDefinition) (int32, error) {
+	start := time.Now()
+	version, err := c.connector.CheckSchema(ctx, c.registrar.Scope(), c.registrar.NamePrefix(), eds)
+	if err == nil || c.initRetry == nil {
+		return version, err
+	}
+
+	deadline := start.Add(c.initRetry.timeout)
+	for attempt := 1; err != nil && c.initRetry.isTransient(err); attempt++ {
+		delay := c.initRetry.backoff.NextDelay(attempt)
+		if time.Now().Add(delay).After(deadline) {
+			break
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return InvalidVersion, ctx.Err()
+		case <-timer.C:
+		}
+		version, err = c.connector.CheckSchema(ctx, c.registrar.Scope(), c.registrar.NamePrefix(), eds)
+	}
+	return version, err
+}
+
 // CreateIfNotExists creates a row, but only if it does not exist. The entity
 // provided must contain values for all components of its primary key for the
 // operation to succeed.
@@ -270,15 +447,93 @@ func (c *client) CreateIfNotExists(ctx context.Context, entity DomainObject) err
 // If `fieldsToRead` is provided, only a subset of fields will be
 // marshalled onto the given entity
 func (c *client) Read(ctx context.Context, fieldsToRead []string, entity DomainObject) error {
+	_, _, _, err := c.read(ctx, fieldsToRead, entity, c.connector.Read)
+	return err
+}
+
+// ReadConsistent reads the entity like Read, through the connector's
+// ConsistentReader, so that its copy of the row is repaired before the read
+// returns
+func (c *client) ReadConsistent(ctx context.Context, fieldsToRead []string, entity DomainObject) error {
+	if !c.initialized {
+		return &ErrNotInitialized{}
+	}
+	cr, ok := c.connector.(ConsistentReader)
+	if !ok {
+		return &ErrUnsupported{Op: "ReadConsistent"}
+	}
+	_, _, _, err := c.read(ctx, fieldsToRead, entity, cr.ReadConsistent)
+	return err
+}
+
+// ReadWithPresence reads the entity like Read and reports, for each requested
+// field, whether the connector returned a value for its column. Fields that
+// were not returned, or were returned as null, are left at their zero value
+// and reported as false.
+func (c *client) ReadWithPresence(ctx context.Context, fieldsToRead []string, entity DomainObject) (map[string]bool, error) {
+	re, columnsToRead, results, err := c.read(ctx, fieldsToRead, entity, c.connector.Read)
+	if err != nil {
+		return nil, err
+	}
+	present := make(map[string]bool, len(columnsToRead))
+	for _, columnName := range columnsToRead {
+		value, ok := results[columnName]
+		present[re.table.ColToField[columnName]] = ok && derefFieldValue(value) != nil
+	}
+	return present, nil
+}
+
+// Exists reads the primary key columns of the entity's row, reporting false
+// rather than an error when the row is not found
+func (c *client) Exists(ctx context.Context, entity DomainObject) (bool, error) {
+	if !c.initialized {
+		return false, &ErrNotInitialized{}
+	}
+	re, err := c.registrar.Find(entity)
+	if err != nil {
+		return false, err
+	}
+	_, err = c.connector.Read(ctx, re.EntityInfo(), re.KeyFieldValues(entity), keyColumnNames(re.info.Def.Key))
+	if ErrorIsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Refresh asks the connector to repopulate its copy of the entity's row
+func (c *client) Refresh(ctx context.Context, entity DomainObject) error {
 	if !c.initialized {
 		return &ErrNotInitialized{}
 	}
+	re, err := c.registrar.Find(entity)
+	if err != nil {
+		return err
+	}
+	r, ok := c.connector.(Refresher)
+	if !ok {
+		return &ErrUnsupported{Op: "Refresh"}
+	}
+	return r.Refresh(ctx, re.EntityInfo(), re.KeyFieldValues(entity))
+}
+
+type readType func(context.Context, *EntityInfo, map[string]FieldValue, []string) (map[string]FieldValue, error)
+
+// read fetches the entity's row with fn and sets the requested fields on it.
+// The registered entity, the columns read and the raw values returned by the
+// connector are passed back to the caller.
+func (c *client) read(ctx context.Context, fieldsToRead []string, entity DomainObject, fn readType) (*RegisteredEntity, []string, map[string]FieldValue, error) {
+	if !c.initialized {
+		return nil, nil, nil, &ErrNotInitialized{}
+	}
 
 	// lookup registered entity, registry will return error if registration
 	// is not found
 	re, err := c.registrar.Find(entity)
 	if err != nil {
-		return err
+		return nil, nil, nil, err
 	}
 
 	// translate entity field values to a map of primary key name/values pairs
@@ -286,20 +541,23 @@ func (c *client) Read(ctx context.Context, fieldsToRead []string, entity DomainO
 	fieldValues := re.KeyFieldValues(entity)
 
 	// build a list of column names from a list of entities field names
-	columnsToRead, err := re.ColumnNames(fieldsToRead)
+	columnsToRead, err := re.ColumnNames(c.projection(re, fieldsToRead))
 	if err != nil {
-		return err
+		return nil, nil, nil, err
 	}
 
-	results, err := c.connector.Read(ctx, re.EntityInfo(), fieldValues, columnsToRead)
+	results, err := fn(ctx, re.EntityInfo(), fieldValues, columnsToRead)
 	if err != nil {
-		return err
+		return nil, nil, nil, err
 	}
 
 	// map results to entity fields
 	re.SetFieldValues(entity, results, columnsToRead)
+	if err := c.runAfterRead(entity); err != nil {
+		return nil, nil, nil, err
+	}
 
-	return nil
+	return re, columnsToRead, results, nil
 }
 
 // MultiRead fetches several entities by primary key, The entities provided
@@ -349,6 +607,66 @@ func (c *client) createOrUpsert(ctx context.Context, fieldsToUpdate []string, en
 	return fn(ctx, re.EntityInfo(), fieldValues)
 }
 
+// UpsertIfChanged upserts the entity unless its row already holds the same
+// values
+func (c *client) UpsertIfChanged(ctx context.Context, entity DomainObject) (bool, error) {
+	if !c.initialized {
+		return false, &ErrNotInitialized{}
+	}
+	re, err := c.registrar.Find(entity)
+	if err != nil {
+		return false, err
+	}
+	desired, err := re.OnlyFieldValues(entity, nil)
+	if err != nil {
+		return false, err
+	}
+	columns := make([]string, 0, len(desired))
+	for name := range desired {
+		columns = append(columns, name)
+	}
+	sort.Strings(columns)
+
+	current, err := c.connector.Read(ctx, re.EntityInfo(), re.KeyFieldValues(entity), columns)
+	switch {
+	case ErrorIsNotFound(err):
+	case err != nil:
+		return false, err
+	default:
+		// compare only the requested columns, with missing ones as null
+		stored := make(map[string]FieldValue, len(columns))
+		for _, name := range columns {
+			stored[name] = current[name]
+		}
+		if FieldValuesEqual(desired, stored) {
+			return false, nil
+		}
+	}
+	if err := c.connector.Upsert(ctx, re.EntityInfo(), desired); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// UpsertN upserts the entity like Upsert. If the connector implements
+// MutationCounter, the number of rows it reports is returned; otherwise
+// RowsAffected is RowsAffectedUnknown.
+func (c *client) UpsertN(ctx context.Context, fieldsToUpdate []string, entity DomainObject) (MutationResult, error) {
+	result := MutationResult{RowsAffected: RowsAffectedUnknown}
+	mc, ok := c.connector.(MutationCounter)
+	if !ok {
+		return result, c.createOrUpsert(ctx, fieldsToUpdate, entity, c.connector.Upsert)
+	}
+	err := c.createOrUpsert(ctx, fieldsToUpdate, entity, func(ctx context.Context, ei *EntityInfo, values map[string]FieldValue) error {
+		n, err := mc.UpsertCounted(ctx, ei, values)
+		if err == nil {
+			result.RowsAffected = n
+		}
+		return err
+	})
+	return result, err
+}
+
 // MultiUpsert updates several entities by primary key, The entities provided
 // must contain values for all components of its primary key for the operation
 // to succeed. If `fieldsToUpdate` is provided, only a subset of fields will be
@@ -357,6 +675,60 @@ func (c *client) MultiUpsert(context.Context, []string, ...DomainObject) (MultiR
 	panic("not implemented")
 }
 
+// BatchUpsert upserts the entities with one MultiUpsert per entity type, in
+// the order each type first appears
+func (c *client) BatchUpsert(ctx context.Context, fieldsToUpdate []string, entities ...DomainObject) (MultiResult, error) {
+	if !c.initialized {
+		return nil, &ErrNotInitialized{}
+	}
+	type batch struct {
+		re       *RegisteredEntity
+		entities []DomainObject
+		values   []map[string]FieldValue
+	}
+	result := MultiResult{}
+	var batches []*batch
+	byEntity := map[*RegisteredEntity]*batch{}
+	for _, entity := range entities {
+		re, err := c.registrar.Find(entity)
+		if err != nil {
+			result[entity] = err
+			continue
+		}
+		values, err := re.OnlyFieldValues(entity, fieldsToUpdate)
+		if err != nil {
+			result[entity] = err
+			continue
+		}
+		for k, v := range re.KeyFieldValues(entity) {
+			values[k] = v
+		}
+		b, ok := byEntity[re]
+		if !ok {
+			b = &batch{re: re}
+			byEntity[re] = b
+			batches = append(batches, b)
+		}
+		b.entities = append(b.entities, entity)
+		b.values = append(b.values, values)
+	}
+
+	for _, b := range batches {
+		errs, err := c.connector.MultiUpsert(ctx, b.re.EntityInfo(), b.values)
+		if err == nil && len(errs) != len(b.entities) {
+			err = fmt.Errorf("MultiUpsert returned %d results for %d rows", len(errs), len(b.entities))
+		}
+		for i, entity := range b.entities {
+			if err != nil {
+				result[entity] = err
+				continue
+			}
+			result[entity] = errs[i]
+		}
+	}
+	return result, nil
+}
+
 // Remove deletes an entity by primary key, The entity provided must contain
 // values for all components of its primary key for the operation to succeed.
 func (c *client) Remove(ctx context.Context, entity DomainObject) error {
@@ -378,6 +750,33 @@ func (c *client) Remove(ctx context.Context, entity DomainObject) error {
 	return err
 }
 
+// RemoveN removes the entity like Remove. If the connector implements
+// MutationCounter, the number of rows it reports is returned; otherwise
+// RowsAffected is RowsAffectedUnknown.
+func (c *client) RemoveN(ctx context.Context, entity DomainObject) (MutationResult, error) {
+	result := MutationResult{RowsAffected: RowsAffectedUnknown}
+	if !c.initialized {
+		return result, &ErrNotInitialized{}
+	}
+
+	re, err := c.registrar.Find(entity)
+	if err != nil {
+		return result, err
+	}
+	keyFieldValues := re.KeyFieldValues(entity)
+
+	mc, ok := c.connector.(MutationCounter)
+	if !ok {
+		return result, c.connector.Remove(ctx, re.EntityInfo(), keyFieldValues)
+	}
+	n, err := mc.RemoveCounted(ctx, re.EntityInfo(), keyFieldValues)
+	if err != nil {
+		return result, err
+	}
+	result.RowsAffected = n
+	return result, nil
+}
+
 // RemoveRange removes all of the rows that fall within the range specified by the
 // given RemoveRangeOp.
 func (c *client) RemoveRange(ctx context.Context, r *RemoveRangeOp) error {
@@ -412,37 +811,90 @@ func (c *client) Range(ctx context.Context, r *RangeOp) ([]DomainObject, string,
 	if !c.initialized {
 		return nil, "", &ErrNotInitialized{}
 	}
+	re, values, token, err := c.rangeValues(ctx, r)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "Range")
+	}
+
+	objectArray := objectsFromValueArray(r.object, values, re, nil)
+	if err := c.runAfterRead(objectArray...); err != nil {
+		return nil, "", errors.Wrap(err, "Range")
+	}
+	return objectArray, token, nil
+}
+
+func (c *client) RangeRaw(ctx context.Context, r *RangeOp) ([]map[string]FieldValue, string, error) {
+	if !c.initialized {
+		return nil, "", &ErrNotInitialized{}
+	}
+	_, values, token, err := c.rangeValues(ctx, r)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "RangeRaw")
+	}
+	return values, token, nil
+}
+
+// rangeValues sends the range request r to the connector and returns the
+// registered entity along with the rows and the continuation token
+func (c *client) rangeValues(ctx context.Context, r *RangeOp) (*RegisteredEntity, []map[string]FieldValue, string, error) {
 	// look up the entity in the registry
 	re, err := c.registrar.Find(r.object)
 	if err != nil {
-		return nil, "", errors.Wrap(err, "Range")
+		return nil, nil, "", err
 	}
 
 	// now convert the client range columns to server side column conditions structure
 	columnConditions, err := convertConditions(r.conditions, re.table)
 	if err != nil {
-		return nil, "", errors.Wrap(err, "Range")
+		return nil, nil, "", err
 	}
 
 	// convert the fieldsToRead to the server side equivalent
-	fieldsToRead, err := re.ColumnNames(r.fieldsToRead)
+	fieldsToRead, err := re.ColumnNames(c.projection(re, r.fieldsToRead))
 	if err != nil {
-		return nil, "", errors.Wrap(err, "Range")
+		return nil, nil, "", err
+	}
+	if r.keysOnly {
+		fieldsToRead = keyColumnNames(re.info.Def.Key)
+	}
+
+	if r.consistency != Eventual {
+		ctx = WithConsistency(ctx, r.consistency)
 	}
 
 	// call the server side method
-	values, token, err := c.connector.Range(ctx, re.info, columnConditions, fieldsToRead, r.token, r.limit)
+	rangeFn := c.connector.Range
+	if r.backward {
+		br, ok := c.connector.(BackwardRanger)
+		if !ok {
+			return nil, nil, "", &ErrUnsupported{Op: "RangeBefore"}
+		}
+		rangeFn = br.RangeBefore
+	} else if fr, ok := c.connector.(FilteringRanger); ok && r.allowFiltering {
+		rangeFn = fr.RangeAllowFiltering
+	}
+	values, token, err := rangeFn(ctx, re.info, columnConditions, fieldsToRead, r.token, r.limit)
 	if err != nil {
-		return nil, "", errors.Wrap(err, "Range")
+		return nil, nil, "", err
 	}
-
-	objectArray := objectsFromValueArray(r.object, values, re, nil)
-	return objectArray, token, nil
+	return re, values, token, nil
 }
 
 func (c *client) WalkRange(ctx context.Context, r *RangeOp, onNext func(value DomainObject) error) error {
+	var sizer *pageSizer
+	if c.adaptivePaging != nil {
+		// the page size is the walk's own business, so the caller's limit is
+		// put back once it is done
+		defer r.Limit(r.limit)
+		sizer = c.adaptivePaging.start(r.limit)
+		r = r.Limit(sizer.size)
+	}
 	for {
+		started := time.Now()
 		results, nextToken, err := c.Range(ctx, r)
+		if sizer != nil {
+			r = r.Limit(sizer.observe(time.Since(started)))
+		}
 
 		if err != nil {
 			return err
@@ -457,10 +909,115 @@ func (c *client) WalkRange(ctx context.Context, r *RangeOp, onNext func(value Do
 		if len(nextToken) == 0 {
 			return nil
 		}
-		r = r.Offset(nextToken)
+		if r.backward {
+			r = r.Before(nextToken)
+		} else {
+			r = r.Offset(nextToken)
+		}
+	}
+}
+
+// countPageSize is the number of keys fetched per page when Count has to
+// page through a range
+const countPageSize = 1000
+
+// Count returns the number of rows in the range described by r
+func (c *client) Count(ctx context.Context, r *RangeOp) (int64, error) {
+	if !c.initialized {
+		return 0, &ErrNotInitialized{}
+	}
+	re, err := c.registrar.Find(r.object)
+	if err != nil {
+		return 0, errors.Wrap(err, "Count")
+	}
+	columnConditions, err := convertConditions(r.conditions, re.table)
+	if err != nil {
+		return 0, errors.Wrap(err, "Count")
+	}
+	if r.consistency != Eventual {
+		ctx = WithConsistency(ctx, r.consistency)
+	}
+
+	if rc, ok := c.connector.(RangeCounter); ok {
+		n, err := rc.CountRange(ctx, re.info, columnConditions)
+		if err != nil {
+			return 0, errors.Wrap(err, "Count")
+		}
+		return n, nil
+	}
+
+	rangeFn := c.connector.Range
+	if fr, ok := c.connector.(FilteringRanger); ok && r.allowFiltering {
+		rangeFn = fr.RangeAllowFiltering
+	}
+	keyColumns := keyColumnNames(re.info.Def.Key)
+	var count int64
+	token := ""
+	for {
+		values, next, err := rangeFn(ctx, re.info, columnConditions, keyColumns, token, countPageSize)
+		if err != nil {
+			return 0, errors.Wrap(err, "Count")
+		}
+		count += int64(len(values))
+		if c.countLimit > 0 && count > c.countLimit {
+			return 0, &ErrCountLimitExceeded{Limit: c.countLimit}
+		}
+		if next == "" {
+			return count, nil
+		}
+		token = next
 	}
 }
 
+// runAfterRead calls the hooks registered with WithAfterRead on each of the
+// objects, stopping at the first error
+func (c *client) runAfterRead(objects ...DomainObject) error {
+	for _, object := range objects {
+		for _, hook := range c.afterRead {
+			if err := hook(object); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// projection returns the fields to read for re when the caller asked for
+// fields: the caller's list if there is one, otherwise the entity's default
+// fields registered with DefaultFields plus any key fields missing from them.
+// A nil result means all fields.
+func (c *client) projection(re *RegisteredEntity, fields []string) []string {
+	if len(fields) > 0 {
+		return fields
+	}
+	defaults, ok := c.defaultFields[re.table.Name]
+	if !ok {
+		return fields
+	}
+	projected := make([]string, 0, len(defaults)+len(re.table.ColToField))
+	listed := make(map[string]bool, len(defaults))
+	for _, field := range defaults {
+		listed[field] = true
+	}
+	for _, column := range keyColumnNames(re.table.Key) {
+		if field := re.table.ColToField[column]; !listed[field] {
+			projected = append(projected, field)
+		}
+	}
+	return append(projected, defaults...)
+}
+
+// keyColumnNames returns the partition key columns followed by the
+// clustering key columns
+func keyColumnNames(key *PrimaryKey) []string {
+	columns := make([]string, 0, len(key.PartitionKeys)+len(key.ClusteringKeys))
+	columns = append(columns, key.PartitionKeys...)
+	for _, ck := range key.ClusteringKeys {
+		columns = append(columns, ck.Name)
+	}
+	return columns
+}
+
 func objectsFromValueArray(object DomainObject, values []map[string]FieldValue, re *RegisteredEntity, columnsToRead []string) []DomainObject {
 	goType := reflect.TypeOf(object).Elem() // get the reflect.Type of the client entity
 	doType := reflect.TypeOf((*DomainObject)(nil)).Elem()
@@ -491,16 +1048,54 @@ func (c *client) ScanEverything(ctx context.Context, sop *ScanOp) ([]DomainObjec
 		return nil, "", errors.Wrap(err, "failed to ScanEverything")
 	}
 
+	columnConditions, err := convertConditions(sop.conditions, re.table)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to ScanEverything")
+	}
+
 	// call the server side method
-	values, token, err := c.connector.Scan(ctx, re.info, fieldsToRead, sop.token, sop.limit)
+	var values []map[string]FieldValue
+	var token string
+	fs, canFilter := c.connector.(FilteredScanner)
+	switch {
+	case len(columnConditions) == 0:
+		values, token, err = c.connector.Scan(ctx, re.info, fieldsToRead, sop.token, sop.limit)
+	case canFilter:
+		values, token, err = fs.ScanFiltered(ctx, re.info, columnConditions, fieldsToRead, sop.token, sop.limit)
+	default:
+		values, token, err = c.connector.Scan(ctx, re.info, withFilterColumns(fieldsToRead, columnConditions), sop.token, sop.limit)
+		values = filterRows(values, columnConditions)
+	}
 	if err != nil {
 		return nil, "", err
 	}
 	objectArray := objectsFromValueArray(sop.object, values, re, nil)
+	if err := c.runAfterRead(objectArray...); err != nil {
+		return nil, "", errors.Wrap(err, "failed to ScanEverything")
+	}
 	return objectArray, token, nil
 
 }
 
+// CacheKeyFor computes the cache key of an entity using the client's
+// connector. The entity provided must contain values for all components of
+// its primary key.
+func (c *client) CacheKeyFor(entity DomainObject) ([]byte, error) {
+	keyer, ok := c.connector.(CacheKeyer)
+	if !ok {
+		return nil, errors.Errorf("connector %T does not support cache keys", c.connector)
+	}
+
+	// lookup registered entity, registry will return error if registration
+	// is not found
+	re, err := c.registrar.Find(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	return keyer.CacheKey(re.EntityInfo(), re.KeyFieldValues(entity))
+}
+
 type adminClient struct {
 	scope     string
 	dirs      []string
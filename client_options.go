@@ -0,0 +1,125 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dosa
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ClientOption configures optional behavior of a client created by NewClient
+type ClientOption func(*client)
+
+// Backoff decides how long to wait before the next attempt of a retried
+// operation. The attempt argument starts at 1 for the delay that follows the
+// first failed attempt. The backoffs in the connectors/retry package satisfy
+// this interface.
+type Backoff interface {
+	NextDelay(attempt int) time.Duration
+}
+
+// initRetry holds the settings of WithInitializeRetry
+type initRetry struct {
+	backoff     Backoff
+	timeout     time.Duration
+	isTransient func(error) bool
+}
+
+// WithInitializeRetry makes Initialize retry schema validation when it fails
+// with a transient error, waiting between attempts according to backoff,
+// until timeout has elapsed since the first attempt. Errors for which
+// isTransient returns false, such as a genuine schema mismatch, fail
+// immediately. A nil isTransient uses ErrorIsTransient.
+func WithInitializeRetry(backoff Backoff, timeout time.Duration, isTransient func(error) bool) ClientOption {
+	if isTransient == nil {
+		isTransient = ErrorIsTransient
+	}
+	return func(c *client) {
+		c.initRetry = &initRetry{
+			backoff:     backoff,
+			timeout:     timeout,
+			isTransient: isTransient,
+		}
+	}
+}
+
+// WithAfterRead registers a hook that is called with every entity the client
+// materializes from a Read, ReadWithPresence, Range, WalkRange or
+// ScanEverything call, after its fields have been set and before it is handed
+// back. Use it for transforms that apply to every read, such as decrypting a
+// column or filling in a derived field. Hooks run in the order they were
+// registered; the first error fails the call and is returned to the caller.
+func WithAfterRead(hook func(DomainObject) error) ClientOption {
+	return func(c *client) {
+		c.afterRead = append(c.afterRead, hook)
+	}
+}
+
+// DefaultCountLimit is the number of rows Count pages through, on connectors
+// that cannot count rows themselves, before giving up
+const DefaultCountLimit = 100000
+
+// WithCountLimit sets how many rows Count may page through on connectors that
+// do not implement RangeCounter. Counting a range that holds more rows than
+// that fails with ErrCountLimitExceeded. A limit of zero or less removes the
+// bound.
+func WithCountLimit(max int64) ClientOption {
+	return func(c *client) {
+		c.countLimit = max
+	}
+}
+
+// DefaultFields sets the fields that Read, ReadWithPresence, Range and
+// WalkRange fetch for the named entity when the caller passes no field list,
+// as with dosa.All(). Use it to leave out heavy columns that most callers do
+// not need. The key fields are always read, whether or not they are listed.
+// An explicit field list on the call takes precedence over the default.
+func DefaultFields(entity string, fields []string) ClientOption {
+	return func(c *client) {
+		if c.defaultFields == nil {
+			c.defaultFields = make(map[string][]string)
+		}
+		c.defaultFields[entity] = fields
+	}
+}
+
+// ErrorIsTransient reports whether err looks like a temporary connectivity
+// problem rather than a permanent failure: network errors that report
+// themselves as temporary or timed out, and refused connections.
+func ErrorIsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	cause := errors.Cause(err)
+	if t, ok := cause.(interface {
+		Temporary() bool
+	}); ok && t.Temporary() {
+		return true
+	}
+	if t, ok := cause.(interface {
+		Timeout() bool
+	}); ok && t.Timeout() {
+		return true
+	}
+	return strings.Contains(err.Error(), "connection refused")
+}
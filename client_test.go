@@ -25,6 +25,8 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/golang/mock/gomock"
@@ -37,6 +39,7 @@ import (
 	dosaRenamed "github.com/uber-go/dosa"
 	_ "github.com/uber-go/dosa/connectors/devnull"
 	_ "github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/connectors/retry"
 	"github.com/uber-go/dosa/mocks"
 	"github.com/uber-go/dosa/testutil"
 )
@@ -229,6 +232,88 @@ func TestClient_Read(t *testing.T) {
 	assert.Equal(t, cte1.Email, results["email"])
 }
 
+func TestClient_ReadWithPresence(t *testing.T) {
+	reg1, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1)
+
+	// uninitialized
+	c1 := dosaRenamed.NewClient(reg1, nullConnector)
+	_, err := c1.ReadWithPresence(ctx, dosaRenamed.All(), cte1)
+	assert.True(t, dosaRenamed.ErrorIsNotInitialized(err))
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().CheckSchema(ctx, gomock.Any(), gomock.Any(), gomock.Any()).Return(int32(1), nil).AnyTimes()
+	c2 := dosaRenamed.NewClient(reg1, mockConn)
+	assert.NoError(t, c2.Initialize(ctx))
+
+	// name is missing from the row and email is null
+	var nullEmail *string
+	mockConn.EXPECT().Read(ctx, gomock.Any(), map[string]dosaRenamed.FieldValue{"id": int64(7)}, []string{"id", "name", "email"}).
+		Return(map[string]dosaRenamed.FieldValue{"id": int64(7), "email": nullEmail}, nil)
+	entity := &ClientTestEntity1{ID: int64(7), Name: "stale", Email: "stale"}
+	present, err := c2.ReadWithPresence(ctx, []string{"ID", "Name", "Email"}, entity)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"ID": true, "Name": false, "Email": false}, present)
+	assert.Equal(t, "", entity.Name)
+	assert.Equal(t, "", entity.Email)
+
+	// all fields are reported when none are requested
+	mockConn.EXPECT().Read(ctx, gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(map[string]dosaRenamed.FieldValue{"id": int64(7), "name": "foo"}, nil)
+	present, err = c2.ReadWithPresence(ctx, dosaRenamed.All(), &ClientTestEntity1{ID: int64(7)})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"ID": true, "Name": true, "Email": false}, present)
+
+	// errors
+	mockConn.EXPECT().Read(ctx, gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, &dosaRenamed.ErrNotFound{})
+	_, err = c2.ReadWithPresence(ctx, dosaRenamed.All(), &ClientTestEntity1{ID: int64(7)})
+	assert.True(t, dosaRenamed.ErrorIsNotFound(err))
+	_, err = c2.ReadWithPresence(ctx, []string{"badfield"}, &ClientTestEntity1{ID: int64(7)})
+	assert.Error(t, err)
+}
+
+func TestClient_Exists(t *testing.T) {
+	reg1, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1)
+
+	// uninitialized
+	c1 := dosaRenamed.NewClient(reg1, nullConnector)
+	_, err := c1.Exists(ctx, cte1)
+	assert.True(t, dosaRenamed.ErrorIsNotInitialized(err))
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().CheckSchema(ctx, gomock.Any(), gomock.Any(), gomock.Any()).Return(int32(1), nil).AnyTimes()
+	c2 := dosaRenamed.NewClient(reg1, mockConn)
+	assert.NoError(t, c2.Initialize(ctx))
+
+	// bad entity
+	_, err = c2.Exists(ctx, cte2)
+	assert.Error(t, err)
+
+	keys := map[string]dosaRenamed.FieldValue{"id": int64(7)}
+	gomock.InOrder(
+		mockConn.EXPECT().Read(ctx, gomock.Any(), keys, []string{"id"}).
+			Return(map[string]dosaRenamed.FieldValue{"id": int64(7)}, nil),
+		mockConn.EXPECT().Read(ctx, gomock.Any(), keys, []string{"id"}).Return(nil, &dosaRenamed.ErrNotFound{}),
+		mockConn.EXPECT().Read(ctx, gomock.Any(), keys, []string{"id"}).Return(nil, assert.AnError),
+	)
+	entity := &ClientTestEntity1{ID: int64(7), Name: "unchanged"}
+	exists, err := c2.Exists(ctx, entity)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, "unchanged", entity.Name)
+
+	exists, err = c2.Exists(ctx, entity)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	exists, err = c2.Exists(ctx, entity)
+	assert.Equal(t, assert.AnError, err)
+	assert.False(t, exists)
+}
+
 func TestClient_Read_pointer_result(t *testing.T) {
 	reg1, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1)
 	reg2, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1, cte2)
@@ -400,6 +485,62 @@ func TestClient_Upsert(t *testing.T) {
 	assert.NoError(t, c3.Upsert(ctx, fieldsToUpdate, cte1))
 	assert.Equal(t, cte1.Email, updatedEmail)
 }
+
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "store not ready" }
+func (temporaryError) Temporary() bool { return true }
+
+func TestClient_InitializeRetry(t *testing.T) {
+	reg1, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	backoff := retry.NewConstantBackoff(time.Millisecond)
+
+	// store becomes ready after two attempts
+	mockConn := mocks.NewMockConnector(ctrl)
+	gomock.InOrder(
+		mockConn.EXPECT().CheckSchema(ctx, scope, namePrefix, gomock.Any()).Return(int32(dosaRenamed.InvalidVersion), temporaryError{}).Times(2),
+		mockConn.EXPECT().CheckSchema(ctx, scope, namePrefix, gomock.Any()).Return(int32(1), nil),
+	)
+	c1 := dosaRenamed.NewClient(reg1, mockConn, dosaRenamed.WithInitializeRetry(backoff, time.Second, nil))
+	assert.NoError(t, c1.Initialize(ctx))
+
+	// a genuine mismatch fails immediately
+	mockConn = mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().CheckSchema(ctx, scope, namePrefix, gomock.Any()).Return(int32(dosaRenamed.InvalidVersion), errors.New("schema mismatch")).Times(1)
+	c2 := dosaRenamed.NewClient(reg1, mockConn, dosaRenamed.WithInitializeRetry(backoff, time.Second, nil))
+	err := c2.Initialize(ctx)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "schema mismatch")
+
+	// gives up once the timeout is reached
+	mockConn = mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().CheckSchema(ctx, scope, namePrefix, gomock.Any()).Return(int32(dosaRenamed.InvalidVersion), temporaryError{}).MinTimes(1)
+	c3 := dosaRenamed.NewClient(reg1, mockConn, dosaRenamed.WithInitializeRetry(backoff, 5*time.Millisecond, nil))
+	err = c3.Initialize(ctx)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "store not ready")
+
+	// a slow first attempt counts towards the timeout
+	mockConn = mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().CheckSchema(ctx, scope, namePrefix, gomock.Any()).
+		Do(func(context.Context, string, string, []*dosaRenamed.EntityDefinition) {
+			time.Sleep(10 * time.Millisecond)
+		}).
+		Return(int32(dosaRenamed.InvalidVersion), temporaryError{}).Times(1)
+	c4 := dosaRenamed.NewClient(reg1, mockConn, dosaRenamed.WithInitializeRetry(backoff, 5*time.Millisecond, nil))
+	assert.Error(t, c4.Initialize(ctx))
+}
+
+func TestErrorIsTransient(t *testing.T) {
+	assert.False(t, dosaRenamed.ErrorIsTransient(nil))
+	assert.False(t, dosaRenamed.ErrorIsTransient(errors.New("schema mismatch")))
+	assert.True(t, dosaRenamed.ErrorIsTransient(errors.Wrap(temporaryError{}, "CheckSchema")))
+	assert.True(t, dosaRenamed.ErrorIsTransient(errors.New("dial tcp: getsockopt: connection refused")))
+	assert.True(t, dosaRenamed.ErrorIsTransient(context.DeadlineExceeded))
+}
+
 func TestClient_CreateIfNotExists(t *testing.T) {
 	reg1, _ := dosaRenamed.NewRegistrar("test", "team.service", cte1)
 	reg2, _ := dosaRenamed.NewRegistrar("test", "team.service", cte1, cte2)
@@ -556,6 +697,448 @@ func TestClient_Range(t *testing.T) {
 	assert.True(t, dosaRenamed.ErrorIsNotFound(err))
 }
 
+func TestClient_RangeRaw(t *testing.T) {
+	reg1, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1)
+	resultRows := []map[string]dosaRenamed.FieldValue{
+		{"id": int64(2), "name": "bar", "email": "bar@email.com"},
+		{"id": int64(3), "name": "baz"},
+	}
+
+	// uninitialized
+	c1 := dosaRenamed.NewClient(reg1, nullConnector)
+	_, _, err := c1.RangeRaw(ctx, dosaRenamed.NewRangeOp(cte1))
+	assert.True(t, dosaRenamed.ErrorIsNotInitialized(err))
+
+	// bad column in range
+	c1.Initialize(ctx)
+	_, _, err = c1.RangeRaw(ctx, dosaRenamed.NewRangeOp(cte1).Eq("borkborkbork", int64(1)))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "RangeRaw")
+	assert.Contains(t, err.Error(), "borkborkbork")
+
+	// the rows are returned as the connector produced them
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().CheckSchema(ctx, gomock.Any(), gomock.Any(), gomock.Any()).Return(int32(1), nil).AnyTimes()
+	mockConn.EXPECT().Range(ctx, gomock.Any(), map[string][]*dosaRenamed.Condition{
+		"id": {{Op: dosaRenamed.Eq, Value: int64(2)}},
+	}, []string{"id", "name"}, "token", 10).Return(resultRows, "continuation-token", nil)
+	c2 := dosaRenamed.NewClient(reg1, mockConn)
+	c2.Initialize(ctx)
+	rop := dosaRenamed.NewRangeOp(cte1).Eq("ID", int64(2)).Fields([]string{"ID", "Name"}).Offset("token").Limit(10)
+	rows, token, err := c2.RangeRaw(ctx, rop)
+	assert.NoError(t, err)
+	assert.Equal(t, resultRows, rows)
+	assert.Equal(t, "continuation-token", token)
+}
+
+// refreshingConnector records the keys it is asked to refresh
+type refreshingConnector struct {
+	dosaRenamed.Connector
+	refreshed []map[string]dosaRenamed.FieldValue
+}
+
+func (c *refreshingConnector) Refresh(_ context.Context, _ *dosaRenamed.EntityInfo, keys map[string]dosaRenamed.FieldValue) error {
+	c.refreshed = append(c.refreshed, keys)
+	return nil
+}
+
+func TestClient_Refresh(t *testing.T) {
+	reg1, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1)
+
+	// uninitialized
+	c1 := dosaRenamed.NewClient(reg1, nullConnector)
+	assert.True(t, dosaRenamed.ErrorIsNotInitialized(c1.Refresh(ctx, cte1)))
+
+	// the devnull connector keeps no copies
+	assert.NoError(t, c1.Initialize(ctx))
+	assert.True(t, dosaRenamed.ErrorIsUnsupported(c1.Refresh(ctx, cte1)))
+
+	conn := &refreshingConnector{Connector: nullConnector}
+	c2 := dosaRenamed.NewClient(reg1, conn)
+	assert.NoError(t, c2.Initialize(ctx))
+	assert.Error(t, c2.Refresh(ctx, cte2))
+	assert.NoError(t, c2.Refresh(ctx, cte1))
+	assert.Equal(t, []map[string]dosaRenamed.FieldValue{{"id": cte1.ID}}, conn.refreshed)
+}
+
+// consistentConnector answers consistent reads with its row
+type consistentConnector struct {
+	dosaRenamed.Connector
+	row  map[string]dosaRenamed.FieldValue
+	keys map[string]dosaRenamed.FieldValue
+}
+
+func (c *consistentConnector) ReadConsistent(_ context.Context, _ *dosaRenamed.EntityInfo, keys map[string]dosaRenamed.FieldValue, _ []string) (map[string]dosaRenamed.FieldValue, error) {
+	c.keys = keys
+	if c.row == nil {
+		return nil, &dosaRenamed.ErrNotFound{}
+	}
+	return c.row, nil
+}
+
+func TestClient_ReadConsistent(t *testing.T) {
+	reg1, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1)
+
+	// uninitialized
+	c1 := dosaRenamed.NewClient(reg1, nullConnector)
+	assert.True(t, dosaRenamed.ErrorIsNotInitialized(c1.ReadConsistent(ctx, nil, cte1)))
+
+	// the devnull connector keeps no copies
+	assert.NoError(t, c1.Initialize(ctx))
+	assert.True(t, dosaRenamed.ErrorIsUnsupported(c1.ReadConsistent(ctx, nil, cte1)))
+
+	conn := &consistentConnector{Connector: nullConnector, row: map[string]dosaRenamed.FieldValue{"id": cte1.ID, "name": "fresh"}}
+	c2 := dosaRenamed.NewClient(reg1, conn)
+	assert.NoError(t, c2.Initialize(ctx))
+	entity := &ClientTestEntity1{ID: cte1.ID}
+	assert.NoError(t, c2.ReadConsistent(ctx, []string{"Name"}, entity))
+	assert.Equal(t, "fresh", entity.Name)
+	assert.Equal(t, map[string]dosaRenamed.FieldValue{"id": cte1.ID}, conn.keys)
+
+	conn.row = nil
+	assert.True(t, dosaRenamed.ErrorIsNotFound(c2.ReadConsistent(ctx, nil, entity)))
+}
+
+// committingConnector records the batches it is asked to commit
+type committingConnector struct {
+	dosaRenamed.Connector
+	committed [][]dosaRenamed.WriteOp
+	err       error
+}
+
+func (c *committingConnector) CommitBatch(_ context.Context, ops []dosaRenamed.WriteOp) error {
+	c.committed = append(c.committed, ops)
+	return c.err
+}
+
+func TestClient_WriteBatch(t *testing.T) {
+	reg1, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1)
+
+	// uninitialized
+	c1 := dosaRenamed.NewClient(reg1, nullConnector)
+	assert.True(t, dosaRenamed.ErrorIsNotInitialized(c1.WriteBatch(ctx).Upsert(nil, cte1).Commit()))
+
+	// the devnull connector has no transactions
+	assert.NoError(t, c1.Initialize(ctx))
+	assert.True(t, dosaRenamed.ErrorIsUnsupported(c1.WriteBatch(ctx).Upsert(nil, cte1).Commit()))
+
+	conn := &committingConnector{Connector: nullConnector}
+	c2 := dosaRenamed.NewClient(reg1, conn)
+	assert.NoError(t, c2.Initialize(ctx))
+
+	// an unregistered object fails the whole batch
+	assert.Error(t, c2.WriteBatch(ctx).Upsert(nil, cte1).Delete(cte2).Commit())
+	assert.Empty(t, conn.committed)
+
+	// an empty batch does not reach the connector
+	assert.NoError(t, c2.WriteBatch(ctx).Commit())
+	assert.Empty(t, conn.committed)
+
+	assert.NoError(t, c2.WriteBatch(ctx).Upsert([]string{"Name"}, cte1).Delete(cte1).Commit())
+	assert.Len(t, conn.committed, 1)
+	ops := conn.committed[0]
+	assert.Len(t, ops, 2)
+	assert.False(t, ops[0].Remove)
+	assert.Equal(t, map[string]dosaRenamed.FieldValue{"id": cte1.ID, "name": cte1.Name}, ops[0].Values)
+	assert.True(t, ops[1].Remove)
+	assert.Equal(t, map[string]dosaRenamed.FieldValue{"id": cte1.ID}, ops[1].Values)
+
+	// commit errors are passed back
+	conn.err = errors.New("aborted")
+	assert.EqualError(t, c2.WriteBatch(ctx).Delete(cte1).Commit(), "aborted")
+}
+
+func TestClient_DefaultFields(t *testing.T) {
+	reg1, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().CheckSchema(ctx, gomock.Any(), gomock.Any(), gomock.Any()).Return(int32(1), nil).AnyTimes()
+	c1 := dosaRenamed.NewClient(reg1, mockConn, dosaRenamed.DefaultFields("clienttestentity1", []string{"Name"}))
+	assert.NoError(t, c1.Initialize(ctx))
+
+	// All() reads the default fields plus the key
+	mockConn.EXPECT().Read(ctx, gomock.Any(), gomock.Any(), []string{"id", "name"}).
+		Return(map[string]dosaRenamed.FieldValue{"id": int64(2), "name": "bar"}, nil)
+	assert.NoError(t, c1.Read(ctx, dosaRenamed.All(), &ClientTestEntity1{ID: int64(2)}))
+	mockConn.EXPECT().Range(ctx, gomock.Any(), gomock.Any(), []string{"id", "name"}, "", gomock.Any()).
+		Return(nil, "", nil)
+	_, _, err := c1.Range(ctx, dosaRenamed.NewRangeOp(cte1))
+	assert.NoError(t, err)
+
+	// an explicit list is used as given
+	mockConn.EXPECT().Read(ctx, gomock.Any(), gomock.Any(), []string{"email"}).
+		Return(map[string]dosaRenamed.FieldValue{"email": "bar@email.com"}, nil)
+	assert.NoError(t, c1.Read(ctx, []string{"Email"}, &ClientTestEntity1{ID: int64(2)}))
+	mockConn.EXPECT().Range(ctx, gomock.Any(), gomock.Any(), []string{"email"}, "", gomock.Any()).
+		Return(nil, "", nil)
+	_, _, err = c1.Range(ctx, dosaRenamed.NewRangeOp(cte1).Fields([]string{"Email"}))
+	assert.NoError(t, err)
+}
+
+// orderingConnector holds rows in memory and applies range conditions on
+// the color column itself, the way a store ordering strings bytewise would
+type orderingConnector struct {
+	dosaRenamed.Connector
+	rows []map[string]dosaRenamed.FieldValue
+}
+
+func (c *orderingConnector) Range(_ context.Context, _ *dosaRenamed.EntityInfo, conditions map[string][]*dosaRenamed.Condition, _ []string, _ string, _ int) ([]map[string]dosaRenamed.FieldValue, string, error) {
+	var rows []map[string]dosaRenamed.FieldValue
+	for _, row := range c.rows {
+		color := row["color"].(string)
+		matches := true
+		for _, cond := range conditions["color"] {
+			switch v := cond.Value.(string); cond.Op {
+			case dosaRenamed.Gt:
+				matches = matches && color > v
+			case dosaRenamed.Lt:
+				matches = matches && color < v
+			}
+		}
+		if matches {
+			rows = append(rows, row)
+		}
+	}
+	return rows, "", nil
+}
+
+func (c *orderingConnector) Scan(context.Context, *dosaRenamed.EntityInfo, []string, string, int) ([]map[string]dosaRenamed.FieldValue, string, error) {
+	return c.rows, "", nil
+}
+
+func TestClient_RangeStringOrdering(t *testing.T) {
+	reg, err := dosaRenamed.NewRegistrar(scope, namePrefix, cte2)
+	assert.NoError(t, err)
+	conn := &orderingConnector{Connector: nullConnector}
+	for _, color := range []string{"Zinc", "amber", "m", "mauve", "navy", "\xc3\xa9cru"} {
+		conn.rows = append(conn.rows, map[string]dosaRenamed.FieldValue{"uuid": cte2.UUID, "color": color, "isactive": true})
+	}
+	c := dosaRenamed.NewClient(reg, conn)
+	assert.NoError(t, c.Initialize(ctx))
+	colors := func(objs []dosaRenamed.DomainObject) []string {
+		result := []string{}
+		for _, obj := range objs {
+			result = append(result, obj.(*ClientTestEntity2).Color)
+		}
+		return result
+	}
+	rop := func() *dosaRenamed.RangeOp {
+		return dosaRenamed.NewRangeOp(cte2).Eq("UUID", cte2.UUID)
+	}
+
+	// bytewise ordering puts upper case before lower case and multi-byte
+	// characters last
+	objs, _, err := c.Range(ctx, rop().Gt("Color", "m"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"mauve", "navy", "\xc3\xa9cru"}, colors(objs))
+	objs, _, err = c.Range(ctx, rop().Gt("Color", "Z").Lt("Color", "n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Zinc", "amber", "m", "mauve"}, colors(objs))
+
+	// the bounds are validated with the same ordering
+	table, err := dosaRenamed.TableFromInstance(cte2)
+	assert.NoError(t, err)
+	ei := &dosaRenamed.EntityInfo{Def: &table.EntityDefinition}
+	assert.NoError(t, rop().Gt("Color", "Z").Lt("Color", "a").ValidateAgainst(ei))
+	assert.Error(t, rop().Gt("Color", "m").Lt("Color", "c").ValidateAgainst(ei))
+	assert.Error(t, rop().Gt("Color", "a").Lt("Color", "Z").ValidateAgainst(ei))
+
+	// client side filtering of a scan orders strings the same way
+	objs, _, err = c.ScanEverything(ctx, dosaRenamed.NewScanOp(cte2).Filter("Color", dosaRenamed.Gt, "m"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"mauve", "navy", "\xc3\xa9cru"}, colors(objs))
+	objs, _, err = c.ScanEverything(ctx, dosaRenamed.NewScanOp(cte2).Filter("Color", dosaRenamed.Lt, "a"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Zinc"}, colors(objs))
+}
+
+// partitionedConnector holds rows in clustering order and serves ranges with
+// equality conditions, paging with the offset into the matching rows as the
+// token
+type partitionedConnector struct {
+	dosaRenamed.Connector
+	rows []map[string]dosaRenamed.FieldValue
+}
+
+func (c *partitionedConnector) Range(_ context.Context, _ *dosaRenamed.EntityInfo, conditions map[string][]*dosaRenamed.Condition, _ []string, token string, limit int) ([]map[string]dosaRenamed.FieldValue, string, error) {
+	var matching []map[string]dosaRenamed.FieldValue
+	for _, row := range c.rows {
+		matches := true
+		for column, conds := range conditions {
+			for _, cond := range conds {
+				switch cond.Op {
+				case dosaRenamed.Eq:
+					matches = matches && row[column] == cond.Value
+				case dosaRenamed.Gt:
+					matches = matches && row[column].(string) > cond.Value.(string)
+				}
+			}
+		}
+		if matches {
+			matching = append(matching, row)
+		}
+	}
+	start := 0
+	if token != "" {
+		start, _ = strconv.Atoi(token)
+	}
+	end := len(matching)
+	if limit > 0 && start+limit < end {
+		end = start + limit
+	}
+	next := ""
+	if end < len(matching) {
+		next = strconv.Itoa(end)
+	}
+	return matching[start:end], next, nil
+}
+
+func TestClient_RangeAcross(t *testing.T) {
+	reg, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte2)
+	conn := &partitionedConnector{Connector: nullConnector}
+	for _, row := range [][2]string{{"p1", "a"}, {"p1", "c"}, {"p1", "e"}, {"p1", "g"}, {"p2", "b"}, {"p2", "d"}, {"p2", "f"}} {
+		conn.rows = append(conn.rows, map[string]dosaRenamed.FieldValue{"uuid": row[0], "color": row[1], "isactive": true})
+	}
+	partitions := []map[string]dosaRenamed.FieldValue{{"UUID": "p1"}, {"UUID": "p2"}}
+	colors := func(objs []dosaRenamed.DomainObject) string {
+		result := ""
+		for _, obj := range objs {
+			result += obj.(*ClientTestEntity2).Color
+		}
+		return result
+	}
+
+	// uninitialized
+	c := dosaRenamed.NewClient(reg, conn)
+	_, _, err := c.RangeAcross(ctx, dosaRenamed.NewRangeOp(cte2), partitions)
+	assert.True(t, dosaRenamed.ErrorIsNotInitialized(err))
+	assert.NoError(t, c.Initialize(ctx))
+
+	// the interleaved partitions come back merged, a page at a time
+	rop := dosaRenamed.NewRangeOp(cte2).Limit(3)
+	var pages []string
+	for {
+		objs, token, err := c.RangeAcross(ctx, rop, partitions)
+		assert.NoError(t, err)
+		pages = append(pages, colors(objs))
+		if token == "" {
+			break
+		}
+		rop = rop.Offset(token)
+	}
+	assert.Equal(t, []string{"abc", "def", "g"}, pages)
+
+	// without a limit everything is merged at once, and the conditions of
+	// the op apply to every partition
+	objs, token, err := c.RangeAcross(ctx, dosaRenamed.NewRangeOp(cte2), partitions)
+	assert.NoError(t, err)
+	assert.Equal(t, "abcdefg", colors(objs))
+	assert.Empty(t, token)
+	objs, _, err = c.RangeAcross(ctx, dosaRenamed.NewRangeOp(cte2).Gt("Color", "c"), partitions)
+	assert.NoError(t, err)
+	assert.Equal(t, "defg", colors(objs))
+
+	// tokens only resume the partitions they were made for
+	_, token, err = c.RangeAcross(ctx, dosaRenamed.NewRangeOp(cte2).Limit(1), partitions)
+	assert.NoError(t, err)
+	_, _, err = c.RangeAcross(ctx, dosaRenamed.NewRangeOp(cte2).Limit(1).Offset(token), partitions[:1])
+	assert.Error(t, err)
+	_, _, err = c.RangeAcross(ctx, dosaRenamed.NewRangeOp(cte2).Offset("not a token"), partitions)
+	assert.Error(t, err)
+	_, _, err = c.RangeAcross(ctx, dosaRenamed.NewRangeOp(cte2), []map[string]dosaRenamed.FieldValue{{"Shade": "p1"}})
+	assert.Error(t, err)
+	_, _, err = c.RangeAcross(ctx, dosaRenamed.NewRangeOp(cte2).Before("x"), partitions)
+	assert.True(t, dosaRenamed.ErrorIsUnsupported(err))
+}
+
+func TestClient_RangeOffsetRoundTrip(t *testing.T) {
+	reg1, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().CheckSchema(ctx, gomock.Any(), gomock.Any(), gomock.Any()).Return(int32(1), nil).AnyTimes()
+	gomock.InOrder(
+		mockConn.EXPECT().Range(ctx, gomock.Any(), gomock.Any(), gomock.Any(), "", 1).
+			Return([]map[string]dosaRenamed.FieldValue{{"id": int64(1)}}, "page-2", nil),
+		mockConn.EXPECT().Range(ctx, gomock.Any(), gomock.Any(), gomock.Any(), "page-2", 1).
+			Return([]map[string]dosaRenamed.FieldValue{{"id": int64(2)}}, "", nil),
+	)
+	c1 := dosaRenamed.NewClient(reg1, mockConn)
+	assert.NoError(t, c1.Initialize(ctx))
+
+	// page 1 returns the token for page 2
+	rop := dosaRenamed.NewRangeOp(cte1).Limit(1)
+	rows, token, err := c1.Range(ctx, rop)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), rows[0].(*ClientTestEntity1).ID)
+	assert.Equal(t, "page-2", token)
+
+	// feeding it back through Offset fetches page 2
+	rows, token, err = c1.Range(ctx, rop.Offset(token))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), rows[0].(*ClientTestEntity1).ID)
+	assert.Equal(t, "", token)
+}
+
+func TestClient_RangeKeysOnly(t *testing.T) {
+	reg1, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte2)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().CheckSchema(ctx, gomock.Any(), gomock.Any(), gomock.Any()).Return(int32(1), nil).AnyTimes()
+	mockConn.EXPECT().Range(ctx, gomock.Any(), gomock.Any(), []string{"uuid", "color"}, "", 0).
+		Return([]map[string]dosaRenamed.FieldValue{{"uuid": cte2.UUID, "color": "blue"}}, "next", nil)
+	c1 := dosaRenamed.NewClient(reg1, mockConn)
+	assert.NoError(t, c1.Initialize(ctx))
+
+	rop := dosaRenamed.NewRangeOp(cte2).Fields([]string{"IsActive"}).KeysOnly()
+	rows, token, err := c1.Range(ctx, rop)
+	assert.NoError(t, err)
+	assert.Equal(t, "next", token)
+	assert.Equal(t, &ClientTestEntity2{UUID: cte2.UUID, Color: "blue"}, rows[0])
+}
+
+func TestClient_WalkRangeAdaptivePaging(t *testing.T) {
+	reg1, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().CheckSchema(ctx, gomock.Any(), gomock.Any(), gomock.Any()).Return(int32(1), nil).AnyTimes()
+	row := map[string]dosaRenamed.FieldValue{"id": int64(2)}
+
+	// a fast connector gets ever larger pages, up to the maximum
+	gomock.InOrder(
+		mockConn.EXPECT().Range(ctx, gomock.Any(), gomock.Any(), gomock.Any(), "", 10).
+			Return([]map[string]dosaRenamed.FieldValue{row}, "token0", nil),
+		mockConn.EXPECT().Range(ctx, gomock.Any(), gomock.Any(), gomock.Any(), "token0", 20).
+			Return([]map[string]dosaRenamed.FieldValue{row}, "token1", nil),
+		mockConn.EXPECT().Range(ctx, gomock.Any(), gomock.Any(), gomock.Any(), "token1", 30).
+			Return([]map[string]dosaRenamed.FieldValue{row}, "token2", nil),
+		mockConn.EXPECT().Range(ctx, gomock.Any(), gomock.Any(), gomock.Any(), "token2", 30).
+			Return([]map[string]dosaRenamed.FieldValue{row}, "", nil),
+	)
+	c := dosaRenamed.NewClient(reg1, mockConn, dosaRenamed.WithAdaptivePaging(5, 30))
+	assert.NoError(t, c.Initialize(ctx))
+	rop := dosaRenamed.NewRangeOp(cte1).Limit(10)
+	before := rop.String()
+	walked := 0
+	assert.NoError(t, c.WalkRange(ctx, rop, func(dosaRenamed.DomainObject) error {
+		walked++
+		return nil
+	}))
+	assert.Equal(t, 4, walked)
+
+	// the caller's limit is left as it was, while single calls use it as is
+	assert.Equal(t, before, rop.Offset("").String())
+	mockConn.EXPECT().Range(ctx, gomock.Any(), gomock.Any(), gomock.Any(), "", 10).
+		Return([]map[string]dosaRenamed.FieldValue{row}, "token0", nil)
+	_, _, err := c.Range(ctx, rop)
+	assert.NoError(t, err)
+}
+
 func TestClient_WalkRange(t *testing.T) {
 	reg1, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1)
 	fieldsToRead := []string{"ID", "Email"}
@@ -678,6 +1261,125 @@ func TestClient_ScanEverything(t *testing.T) {
 	assert.True(t, dosaRenamed.ErrorIsNotFound(err))
 }
 
+// filteringConnector filters scans server side, recording the conditions it was given
+type filteringConnector struct {
+	dosaRenamed.Connector
+	rows       []map[string]dosaRenamed.FieldValue
+	conditions map[string][]*dosaRenamed.Condition
+}
+
+func (c *filteringConnector) ScanFiltered(ctx context.Context, ei *dosaRenamed.EntityInfo, columnConditions map[string][]*dosaRenamed.Condition, minimumFields []string, token string, limit int) ([]map[string]dosaRenamed.FieldValue, string, error) {
+	c.conditions = columnConditions
+	return c.rows, "next", nil
+}
+
+func TestClient_AfterRead(t *testing.T) {
+	reg1, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().CheckSchema(ctx, gomock.Any(), gomock.Any(), gomock.Any()).Return(int32(1), nil).AnyTimes()
+
+	var seen []string
+	hook := func(object dosaRenamed.DomainObject) error {
+		e := object.(*ClientTestEntity1)
+		seen = append(seen, e.Name)
+		e.Email = strings.ToLower(e.Email)
+		return nil
+	}
+	c := dosaRenamed.NewClient(reg1, mockConn, dosaRenamed.WithAfterRead(hook))
+	assert.NoError(t, c.Initialize(ctx))
+
+	rows := []map[string]dosaRenamed.FieldValue{
+		{"id": int64(1), "name": "foo", "email": "FOO@EMAIL.COM"},
+		{"id": int64(2), "name": "bar", "email": "BAR@EMAIL.COM"},
+	}
+	mockConn.EXPECT().Read(ctx, gomock.Any(), gomock.Any(), gomock.Any()).Return(rows[0], nil)
+	entity := &ClientTestEntity1{ID: int64(1)}
+	assert.NoError(t, c.Read(ctx, dosaRenamed.All(), entity))
+	assert.Equal(t, "foo@email.com", entity.Email)
+	assert.Equal(t, []string{"foo"}, seen)
+
+	seen = nil
+	mockConn.EXPECT().Range(ctx, gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(rows, "", nil)
+	objects, _, err := c.Range(ctx, dosaRenamed.NewRangeOp(cte1))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo", "bar"}, seen)
+	assert.Equal(t, "bar@email.com", objects[1].(*ClientTestEntity1).Email)
+
+	seen = nil
+	mockConn.EXPECT().Scan(ctx, gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(rows, "", nil)
+	_, _, err = c.ScanEverything(ctx, dosaRenamed.NewScanOp(cte1))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo", "bar"}, seen)
+
+	// a failing hook fails the call
+	failing := dosaRenamed.NewClient(reg1, mockConn, dosaRenamed.WithAfterRead(func(dosaRenamed.DomainObject) error {
+		return assert.AnError
+	}))
+	assert.NoError(t, failing.Initialize(ctx))
+	mockConn.EXPECT().Read(ctx, gomock.Any(), gomock.Any(), gomock.Any()).Return(rows[0], nil)
+	assert.Equal(t, assert.AnError, failing.Read(ctx, dosaRenamed.All(), &ClientTestEntity1{ID: int64(1)}))
+	mockConn.EXPECT().Range(ctx, gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(rows, "", nil)
+	objects, _, err = failing.Range(ctx, dosaRenamed.NewRangeOp(cte1))
+	assert.Equal(t, assert.AnError, errors.Cause(err))
+	assert.Nil(t, objects)
+	mockConn.EXPECT().Scan(ctx, gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(rows, "", nil)
+	_, _, err = failing.ScanEverything(ctx, dosaRenamed.NewScanOp(cte1))
+	assert.Equal(t, assert.AnError, errors.Cause(err))
+}
+
+func TestClient_ScanFiltered(t *testing.T) {
+	reg2, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte2)
+	rows := []map[string]dosaRenamed.FieldValue{
+		{"uuid": "a", "color": "red", "isactive": true},
+		{"uuid": "b", "color": "blue", "isactive": true},
+		{"uuid": "c", "color": "red", "isactive": false},
+		{"uuid": "d", "color": "red"},
+	}
+
+	// bad filter field
+	c1 := dosaRenamed.NewClient(reg2, nullConnector)
+	c1.Initialize(ctx)
+	_, _, err := c1.ScanEverything(ctx, dosaRenamed.NewScanOp(cte2).Filter("borkborkbork", dosaRenamed.Eq, "red"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "borkborkbork")
+
+	// connector filters server side
+	fc := &filteringConnector{Connector: nullConnector, rows: rows[:1]}
+	c2 := dosaRenamed.NewClient(reg2, fc)
+	c2.Initialize(ctx)
+	sop := dosaRenamed.NewScanOp(cte2).Filter("Color", dosaRenamed.Eq, "red").Filter("IsActive", dosaRenamed.Eq, true)
+	results, token, err := c2.ScanEverything(ctx, sop)
+	assert.NoError(t, err)
+	assert.Equal(t, "next", token)
+	assert.Len(t, results, 1)
+	assert.Equal(t, map[string][]*dosaRenamed.Condition{
+		"color":    {{Op: dosaRenamed.Eq, Value: "red"}},
+		"isactive": {{Op: dosaRenamed.Eq, Value: true}},
+	}, fc.conditions)
+
+	// client side filtering reads the filtered columns even when not projected
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().CheckSchema(ctx, gomock.Any(), gomock.Any(), gomock.Any()).Return(int32(1), nil).AnyTimes()
+	mockConn.EXPECT().Scan(ctx, gomock.Any(), gomock.Any(), "", 10).
+		Do(func(_ context.Context, _ *dosaRenamed.EntityInfo, columns []string, _ string, _ int) {
+			assert.Contains(t, columns, "uuid")
+			assert.Contains(t, columns, "isactive")
+		}).Return(rows, "more", nil)
+	c3 := dosaRenamed.NewClient(reg2, mockConn)
+	c3.Initialize(ctx)
+	sop = dosaRenamed.NewScanOp(cte2).Fields([]string{"UUID"}).Filter("Color", dosaRenamed.Eq, "red").Filter("IsActive", dosaRenamed.Eq, true).Limit(10)
+	results, token, err = c3.ScanEverything(ctx, sop)
+	assert.NoError(t, err)
+	assert.Equal(t, "more", token)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, "a", results[0].(*ClientTestEntity2).UUID)
+	}
+}
+
 func TestClient_Remove(t *testing.T) {
 	reg1, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1)
 
@@ -705,6 +1407,262 @@ func TestClient_Remove(t *testing.T) {
 
 }
 
+// countingConnector reports a fixed number of affected rows for each mutation
+type countingConnector struct {
+	dosaRenamed.Connector
+	rows    int
+	err     error
+	upserts []map[string]dosaRenamed.FieldValue
+	removes []map[string]dosaRenamed.FieldValue
+}
+
+func (c *countingConnector) UpsertCounted(ctx context.Context, ei *dosaRenamed.EntityInfo, values map[string]dosaRenamed.FieldValue) (int, error) {
+	c.upserts = append(c.upserts, values)
+	return c.rows, c.err
+}
+
+func (c *countingConnector) RemoveCounted(ctx context.Context, ei *dosaRenamed.EntityInfo, keys map[string]dosaRenamed.FieldValue) (int, error) {
+	c.removes = append(c.removes, keys)
+	return c.rows, c.err
+}
+
+func TestClient_UpsertNRemoveN(t *testing.T) {
+	reg1, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1)
+
+	// uninitialized
+	c1 := dosaRenamed.NewClient(reg1, nullConnector)
+	result, err := c1.UpsertN(ctx, dosaRenamed.All(), cte1)
+	assert.True(t, dosaRenamed.ErrorIsNotInitialized(err))
+	assert.Equal(t, dosaRenamed.RowsAffectedUnknown, result.RowsAffected)
+	result, err = c1.RemoveN(ctx, cte1)
+	assert.True(t, dosaRenamed.ErrorIsNotInitialized(err))
+	assert.Equal(t, dosaRenamed.RowsAffectedUnknown, result.RowsAffected)
+
+	// connector reports counts
+	conn := &countingConnector{Connector: nullConnector, rows: 1}
+	c2 := dosaRenamed.NewClient(reg1, conn)
+	assert.NoError(t, c2.Initialize(ctx))
+	result, err = c2.UpsertN(ctx, []string{"Name"}, &ClientTestEntity1{ID: int64(123), Name: "foo"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.RowsAffected)
+	assert.Equal(t, []map[string]dosaRenamed.FieldValue{{"id": int64(123), "name": "foo"}}, conn.upserts)
+
+	conn.rows = 0
+	result, err = c2.RemoveN(ctx, &ClientTestEntity1{ID: int64(123)})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.RowsAffected)
+	assert.Equal(t, []map[string]dosaRenamed.FieldValue{{"id": int64(123)}}, conn.removes)
+
+	// errors leave the count unknown
+	conn.rows, conn.err = 1, errors.New("backend down")
+	result, err = c2.UpsertN(ctx, dosaRenamed.All(), cte1)
+	assert.EqualError(t, err, "backend down")
+	assert.Equal(t, dosaRenamed.RowsAffectedUnknown, result.RowsAffected)
+	result, err = c2.RemoveN(ctx, cte1)
+	assert.EqualError(t, err, "backend down")
+	assert.Equal(t, dosaRenamed.RowsAffectedUnknown, result.RowsAffected)
+
+	// bad entity
+	_, err = c2.RemoveN(ctx, cte2)
+	assert.Contains(t, err.Error(), "ClientTestEntity2")
+
+	// connectors without counts fall back to plain mutations
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().CheckSchema(ctx, gomock.Any(), gomock.Any(), gomock.Any()).Return(int32(1), nil).AnyTimes()
+	mockConn.EXPECT().Upsert(ctx, gomock.Any(), map[string]dosaRenamed.FieldValue{"id": int64(123), "name": "foo"}).Return(nil)
+	mockConn.EXPECT().Remove(ctx, gomock.Any(), map[string]dosaRenamed.FieldValue{"id": int64(123)}).Return(nil)
+	c3 := dosaRenamed.NewClient(reg1, mockConn)
+	assert.NoError(t, c3.Initialize(ctx))
+	result, err = c3.UpsertN(ctx, []string{"Name"}, &ClientTestEntity1{ID: int64(123), Name: "foo"})
+	assert.NoError(t, err)
+	assert.Equal(t, dosaRenamed.RowsAffectedUnknown, result.RowsAffected)
+	result, err = c3.RemoveN(ctx, &ClientTestEntity1{ID: int64(123)})
+	assert.NoError(t, err)
+	assert.Equal(t, dosaRenamed.RowsAffectedUnknown, result.RowsAffected)
+}
+
+type cacheKeyerConnector struct {
+	dosaRenamed.Connector
+}
+
+func (c *cacheKeyerConnector) CacheKey(ei *dosaRenamed.EntityInfo, keys map[string]dosaRenamed.FieldValue) ([]byte, error) {
+	return []byte(fmt.Sprintf("%s:%v", ei.Def.Name, keys["id"])), nil
+}
+
+func TestClient_CacheKeyFor(t *testing.T) {
+	reg1, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1)
+
+	// connector without cache keys
+	c1 := dosaRenamed.NewClient(reg1, nullConnector)
+	_, err := c1.CacheKeyFor(cte1)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "does not support cache keys")
+
+	c2 := dosaRenamed.NewClient(reg1, &cacheKeyerConnector{Connector: nullConnector})
+
+	// bad entity
+	_, err = c2.CacheKeyFor(cte2)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "ClientTestEntity2")
+
+	// success case
+	cacheKey, err := c2.CacheKeyFor(&ClientTestEntity1{ID: int64(123)})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("clienttestentity1:123"), cacheKey)
+}
+
+// bidiConnector pages through a fixed set of rows in both directions, using
+// the index of a row as its continuation token
+type bidiConnector struct {
+	dosaRenamed.Connector
+	rows []map[string]dosaRenamed.FieldValue
+}
+
+func (c *bidiConnector) Range(ctx context.Context, ei *dosaRenamed.EntityInfo, columnConditions map[string][]*dosaRenamed.Condition, minimumFields []string, token string, limit int) ([]map[string]dosaRenamed.FieldValue, string, error) {
+	start := 0
+	if token != "" {
+		start, _ = strconv.Atoi(token)
+	}
+	end := start + limit
+	if end >= len(c.rows) {
+		return c.rows[start:], "", nil
+	}
+	return c.rows[start:end], strconv.Itoa(end), nil
+}
+
+func (c *bidiConnector) RangeBefore(ctx context.Context, ei *dosaRenamed.EntityInfo, columnConditions map[string][]*dosaRenamed.Condition, minimumFields []string, token string, limit int) ([]map[string]dosaRenamed.FieldValue, string, error) {
+	end := len(c.rows)
+	if token != "" {
+		end, _ = strconv.Atoi(token)
+	}
+	start := end - limit
+	if start <= 0 {
+		return c.rows[:end], "", nil
+	}
+	return c.rows[start:end], strconv.Itoa(start), nil
+}
+
+func TestClient_RangeBefore(t *testing.T) {
+	reg1, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1)
+	conn := &bidiConnector{Connector: nullConnector}
+	for i := 0; i < 5; i++ {
+		conn.rows = append(conn.rows, map[string]dosaRenamed.FieldValue{"id": int64(i)})
+	}
+	ids := func(objs []dosaRenamed.DomainObject) []int64 {
+		result := make([]int64, len(objs))
+		for i, obj := range objs {
+			result[i] = obj.(*ClientTestEntity1).ID
+		}
+		return result
+	}
+
+	c1 := dosaRenamed.NewClient(reg1, conn)
+	assert.NoError(t, c1.Initialize(ctx))
+
+	// forward
+	objs, token, err := c1.Range(ctx, dosaRenamed.NewRangeOp(cte1).Limit(2))
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{0, 1}, ids(objs))
+	assert.Equal(t, "2", token)
+	objs, token, err = c1.Range(ctx, dosaRenamed.NewRangeOp(cte1).Limit(2).Offset(token))
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2, 3}, ids(objs))
+	assert.Equal(t, "4", token)
+
+	// backward from the forward token returns the page just read
+	objs, token, err = c1.Range(ctx, dosaRenamed.NewRangeOp(cte1).Limit(2).Before(token))
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2, 3}, ids(objs))
+	assert.Equal(t, "2", token)
+	objs, token, err = c1.Range(ctx, dosaRenamed.NewRangeOp(cte1).Limit(2).Before(token))
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{0, 1}, ids(objs))
+	assert.Equal(t, "", token)
+
+	// a backward token pages forward again with Offset
+	objs, _, err = c1.Range(ctx, dosaRenamed.NewRangeOp(cte1).Limit(2).Before("3").Offset("3"))
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{3, 4}, ids(objs))
+
+	// WalkRange keeps the direction
+	var walked []int64
+	err = c1.WalkRange(ctx, dosaRenamed.NewRangeOp(cte1).Limit(2).Before(""), func(obj dosaRenamed.DomainObject) error {
+		walked = append(walked, obj.(*ClientTestEntity1).ID)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{3, 4, 1, 2, 0}, walked)
+
+	// connectors that only page forward
+	c2 := dosaRenamed.NewClient(reg1, nullConnector)
+	assert.NoError(t, c2.Initialize(ctx))
+	_, _, err = c2.Range(ctx, dosaRenamed.NewRangeOp(cte1).Before("2"))
+	assert.Error(t, err)
+	assert.True(t, dosaRenamed.ErrorIsUnsupported(err))
+}
+
+// filteringRangeConnector records the conditions of ranges sent with AllowFiltering
+type filteringRangeConnector struct {
+	dosaRenamed.Connector
+	conditions map[string][]*dosaRenamed.Condition
+}
+
+func (c *filteringRangeConnector) RangeAllowFiltering(ctx context.Context, ei *dosaRenamed.EntityInfo, columnConditions map[string][]*dosaRenamed.Condition, minimumFields []string, token string, limit int) ([]map[string]dosaRenamed.FieldValue, string, error) {
+	c.conditions = columnConditions
+	return []map[string]dosaRenamed.FieldValue{{"id": int64(1), "name": "foo"}}, "", nil
+}
+
+func TestClient_RangeAllowFiltering(t *testing.T) {
+	reg1, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().CheckSchema(ctx, gomock.Any(), gomock.Any(), gomock.Any()).Return(int32(1), nil).AnyTimes()
+	conn := &filteringRangeConnector{Connector: mockConn}
+	c1 := dosaRenamed.NewClient(reg1, conn)
+	assert.NoError(t, c1.Initialize(ctx))
+
+	// the intent is forwarded
+	objs, _, err := c1.Range(ctx, dosaRenamed.NewRangeOp(cte1).Eq("Name", "foo").AllowFiltering())
+	assert.NoError(t, err)
+	assert.Len(t, objs, 1)
+	assert.Equal(t, map[string][]*dosaRenamed.Condition{"name": {{Op: dosaRenamed.Eq, Value: "foo"}}}, conn.conditions)
+
+	// without it the query is a plain range
+	conn.conditions = nil
+	mockConn.EXPECT().Range(ctx, gomock.Any(), gomock.Any(), gomock.Any(), "", 0).Return(nil, "", nil)
+	_, _, err = c1.Range(ctx, dosaRenamed.NewRangeOp(cte1).Eq("ID", int64(1)))
+	assert.NoError(t, err)
+	assert.Nil(t, conn.conditions)
+}
+
+func TestClient_RangeConsistency(t *testing.T) {
+	reg1, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().CheckSchema(ctx, gomock.Any(), gomock.Any(), gomock.Any()).Return(int32(1), nil).AnyTimes()
+	c1 := dosaRenamed.NewClient(reg1, mockConn)
+	assert.NoError(t, c1.Initialize(ctx))
+
+	var seen []dosaRenamed.Consistency
+	mockConn.EXPECT().Range(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(func(rctx context.Context, _ *dosaRenamed.EntityInfo, _ map[string][]*dosaRenamed.Condition, _ []string, _ string, _ int) {
+			seen = append(seen, dosaRenamed.ConsistencyFromContext(rctx))
+		}).Return(nil, "", nil).Times(3)
+
+	rop := dosaRenamed.NewRangeOp(cte1).Eq("ID", int64(1))
+	_, _, err := c1.Range(ctx, rop)
+	assert.NoError(t, err)
+	_, _, err = c1.Range(dosaRenamed.WithConsistency(ctx, dosaRenamed.Strong), rop)
+	assert.NoError(t, err)
+	_, _, err = c1.Range(ctx, rop.Consistency(dosaRenamed.Strong))
+	assert.NoError(t, err)
+	assert.Equal(t, []dosaRenamed.Consistency{dosaRenamed.Eventual, dosaRenamed.Strong, dosaRenamed.Strong}, seen)
+}
+
 /* TODO: Coming in v2.1
 func TestClient_Unimplemented(t *testing.T) {
 	reg1, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1)
@@ -1032,3 +1990,171 @@ func TestErrorIsAlreadyExists(t *testing.T) {
 	assert.True(t, dosaRenamed.ErrorIsAlreadyExists(errors.Wrap(&dosaRenamed.ErrAlreadyExists{}, "wrapped")))
 	assert.Equal(t, "already exists", (&dosaRenamed.ErrAlreadyExists{}).Error())
 }
+
+// rangeCountingConnector counts ranges itself, recording the conditions it was given
+type rangeCountingConnector struct {
+	dosaRenamed.Connector
+	conditions map[string][]*dosaRenamed.Condition
+}
+
+func (c *rangeCountingConnector) CountRange(ctx context.Context, ei *dosaRenamed.EntityInfo, columnConditions map[string][]*dosaRenamed.Condition) (int64, error) {
+	c.conditions = columnConditions
+	return 42, nil
+}
+
+func TestClient_Count(t *testing.T) {
+	reg1, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1)
+
+	// uninitialized
+	_, err := dosaRenamed.NewClient(reg1, nullConnector).Count(ctx, dosaRenamed.NewRangeOp(cte1))
+	assert.True(t, dosaRenamed.ErrorIsNotInitialized(err))
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().CheckSchema(ctx, gomock.Any(), gomock.Any(), gomock.Any()).Return(int32(1), nil).AnyTimes()
+
+	// the connector counts
+	counting := &rangeCountingConnector{Connector: mockConn}
+	c1 := dosaRenamed.NewClient(reg1, counting)
+	assert.NoError(t, c1.Initialize(ctx))
+	n, err := c1.Count(ctx, dosaRenamed.NewRangeOp(cte1).Eq("ID", int64(7)))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), n)
+	assert.Equal(t, map[string][]*dosaRenamed.Condition{"id": {{Op: dosaRenamed.Eq, Value: int64(7)}}}, counting.conditions)
+
+	// bad column
+	_, err = c1.Count(ctx, dosaRenamed.NewRangeOp(cte1).Eq("borkborkbork", int64(1)))
+	assert.Error(t, err)
+
+	// the client pages through the keys
+	page1 := []map[string]dosaRenamed.FieldValue{{"id": int64(1)}, {"id": int64(2)}}
+	page2 := []map[string]dosaRenamed.FieldValue{{"id": int64(3)}}
+	c2 := dosaRenamed.NewClient(reg1, mockConn)
+	assert.NoError(t, c2.Initialize(ctx))
+	gomock.InOrder(
+		mockConn.EXPECT().Range(ctx, gomock.Any(), gomock.Any(), []string{"id"}, "", gomock.Any()).Return(page1, "t1", nil),
+		mockConn.EXPECT().Range(ctx, gomock.Any(), gomock.Any(), []string{"id"}, "t1", gomock.Any()).Return(page2, "", nil),
+	)
+	n, err = c2.Count(ctx, dosaRenamed.NewRangeOp(cte1).Limit(1).Offset("ignored"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+
+	// up to the count limit
+	c3 := dosaRenamed.NewClient(reg1, mockConn, dosaRenamed.WithCountLimit(2))
+	assert.NoError(t, c3.Initialize(ctx))
+	gomock.InOrder(
+		mockConn.EXPECT().Range(ctx, gomock.Any(), gomock.Any(), []string{"id"}, "", gomock.Any()).Return(page1, "t1", nil),
+		mockConn.EXPECT().Range(ctx, gomock.Any(), gomock.Any(), []string{"id"}, "t1", gomock.Any()).Return(page2, "", nil),
+	)
+	_, err = c3.Count(ctx, dosaRenamed.NewRangeOp(cte1))
+	assert.True(t, dosaRenamed.ErrorIsCountLimitExceeded(err))
+
+	// range errors are returned
+	mockConn.EXPECT().Range(ctx, gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, "", assert.AnError)
+	_, err = c2.Count(ctx, dosaRenamed.NewRangeOp(cte1))
+	assert.Equal(t, assert.AnError, errors.Cause(err))
+}
+
+func TestClient_BatchUpsert(t *testing.T) {
+	reg, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1, cte2)
+	a1 := &ClientTestEntity1{ID: int64(1), Name: "a"}
+	a2 := &ClientTestEntity1{ID: int64(2), Name: "b"}
+	b1 := &ClientTestEntity2{UUID: "b1f23fa3-f453-45b4-a5d5-6d73078ac3bd", Color: "red"}
+	unregistered := &ClientTestEntity2{}
+
+	// uninitialized
+	c1 := dosaRenamed.NewClient(reg, nullConnector)
+	_, err := c1.BatchUpsert(ctx, dosaRenamed.All(), a1)
+	assert.True(t, dosaRenamed.ErrorIsNotInitialized(err))
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().CheckSchema(ctx, gomock.Any(), gomock.Any(), gomock.Any()).Return(int32(1), nil).AnyTimes()
+	// one MultiUpsert per entity type, in the order the types first appear;
+	// the second row of the first group fails
+	gomock.InOrder(
+		mockConn.EXPECT().MultiUpsert(ctx, gomock.Any(), []map[string]dosaRenamed.FieldValue{
+			{"id": int64(1), "name": "a", "email": ""},
+			{"id": int64(2), "name": "b", "email": ""},
+		}).Do(func(_ context.Context, ei *dosaRenamed.EntityInfo, _ []map[string]dosaRenamed.FieldValue) {
+			assert.Equal(t, "clienttestentity1", ei.Def.Name)
+		}).Return([]error{nil, assert.AnError}, nil),
+		mockConn.EXPECT().MultiUpsert(ctx, gomock.Any(), []map[string]dosaRenamed.FieldValue{
+			{"uuid": "b1f23fa3-f453-45b4-a5d5-6d73078ac3bd", "color": "red", "isactive": false},
+		}).Do(func(_ context.Context, ei *dosaRenamed.EntityInfo, _ []map[string]dosaRenamed.FieldValue) {
+			assert.Equal(t, "clienttestentity2", ei.Def.Name)
+		}).Return([]error{nil}, nil),
+	)
+	c2 := dosaRenamed.NewClient(reg, mockConn)
+	assert.NoError(t, c2.Initialize(ctx))
+	result, err := c2.BatchUpsert(ctx, dosaRenamed.All(), a1, b1, a2)
+	assert.NoError(t, err)
+	assert.Equal(t, dosaRenamed.MultiResult{a1: nil, a2: assert.AnError, b1: nil}, result)
+
+	// objects that cannot be sent are reported without a call
+	result, err = c2.BatchUpsert(ctx, []string{"Name"}, b1)
+	assert.NoError(t, err)
+	assert.Error(t, result[b1])
+	reg1, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1)
+	c4 := dosaRenamed.NewClient(reg1, mockConn)
+	assert.NoError(t, c4.Initialize(ctx))
+	result, err = c4.BatchUpsert(ctx, dosaRenamed.All(), unregistered)
+	assert.NoError(t, err)
+	assert.Error(t, result[unregistered])
+}
+
+func TestClient_UpsertIfChanged(t *testing.T) {
+	reg1, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1)
+
+	// uninitialized
+	_, err := dosaRenamed.NewClient(reg1, nullConnector).UpsertIfChanged(ctx, cte1)
+	assert.True(t, dosaRenamed.ErrorIsNotInitialized(err))
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().CheckSchema(ctx, gomock.Any(), gomock.Any(), gomock.Any()).Return(int32(1), nil).AnyTimes()
+	c := dosaRenamed.NewClient(reg1, mockConn)
+	assert.NoError(t, c.Initialize(ctx))
+
+	entity := &ClientTestEntity1{ID: int64(7), Name: "foo", Email: "foo@email.com"}
+	keys := map[string]dosaRenamed.FieldValue{"id": int64(7)}
+	columns := []string{"email", "id", "name"}
+	desired := map[string]dosaRenamed.FieldValue{"id": int64(7), "name": "foo", "email": "foo@email.com"}
+
+	// unchanged rows are not written
+	mockConn.EXPECT().Read(ctx, gomock.Any(), keys, columns).Return(desired, nil)
+	changed, err := c.UpsertIfChanged(ctx, entity)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	// changed and missing rows are
+	gomock.InOrder(
+		mockConn.EXPECT().Read(ctx, gomock.Any(), keys, columns).
+			Return(map[string]dosaRenamed.FieldValue{"id": int64(7), "name": "bar", "email": "foo@email.com"}, nil),
+		mockConn.EXPECT().Upsert(ctx, gomock.Any(), desired).Return(nil),
+		mockConn.EXPECT().Read(ctx, gomock.Any(), keys, columns).Return(nil, &dosaRenamed.ErrNotFound{}),
+		mockConn.EXPECT().Upsert(ctx, gomock.Any(), desired).Return(nil),
+	)
+	changed, err = c.UpsertIfChanged(ctx, entity)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	changed, err = c.UpsertIfChanged(ctx, entity)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "foo", entity.Name)
+
+	// errors are returned
+	gomock.InOrder(
+		mockConn.EXPECT().Read(ctx, gomock.Any(), keys, columns).Return(nil, assert.AnError),
+		mockConn.EXPECT().Read(ctx, gomock.Any(), keys, columns).Return(nil, &dosaRenamed.ErrNotFound{}),
+		mockConn.EXPECT().Upsert(ctx, gomock.Any(), desired).Return(assert.AnError),
+	)
+	_, err = c.UpsertIfChanged(ctx, entity)
+	assert.Equal(t, assert.AnError, err)
+	changed, err = c.UpsertIfChanged(ctx, entity)
+	assert.Equal(t, assert.AnError, err)
+	assert.False(t, changed)
+}
@@ -27,8 +27,15 @@ type conditioner struct {
 	conditions map[string][]*Condition
 }
 
+// newCondition builds the condition for an operator and value. It is the
+// single place where the RangeOp and RemoveRangeOp builder methods turn an
+// operator into a Condition.
+func newCondition(op Operator, value interface{}) *Condition {
+	return &Condition{Op: op, Value: value}
+}
+
 func (c *conditioner) appendOp(op Operator, fieldName string, value interface{}) {
-	c.conditions[fieldName] = append(c.conditions[fieldName], &Condition{Op: op, Value: value})
+	c.conditions[fieldName] = append(c.conditions[fieldName], newCondition(op, value))
 }
 
 // convertConditions converts a list of client field names to server side field names
@@ -44,3 +44,26 @@ func TestConvertConditions(t *testing.T) {
 		}
 	}
 }
+
+func TestNewCondition(t *testing.T) {
+	for _, op := range []Operator{Eq, Lt, LtOrEq, Gt, GtOrEq} {
+		cond := newCondition(op, int32(5))
+		assert.Equal(t, &Condition{Op: op, Value: int32(5)}, cond, op.String())
+	}
+}
+
+func TestRangeOpConditions(t *testing.T) {
+	rop := NewRangeOp(&AllTypes{}).
+		Eq("StringType", "a").
+		Gt("Int32Type", int32(1)).
+		GtOrEq("Int64Type", int64(2)).
+		Lt("DoubleType", float64(3)).
+		LtOrEq("BoolType", true)
+	assert.Equal(t, map[string][]*Condition{
+		"StringType": {newCondition(Eq, "a")},
+		"Int32Type":  {newCondition(Gt, int32(1))},
+		"Int64Type":  {newCondition(GtOrEq, int64(2))},
+		"DoubleType": {newCondition(Lt, float64(3))},
+		"BoolType":   {newCondition(LtOrEq, true)},
+	}, rop.conditions)
+}
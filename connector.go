@@ -147,6 +147,81 @@ type Connector interface {
 	Shutdown() error
 }
 
+// BackwardRanger is implemented by connectors that can page through a range
+// backward.
+//
+// Continuation tokens are positions within the range and can be used in both
+// directions. RangeBefore returns at most limit rows that immediately precede
+// the position of token, in the same order Range would return them, along
+// with a token positioned at the first returned row. Passing that token to
+// RangeBefore fetches the previous page, and passing it to Range fetches the
+// rows that follow it. An empty token is returned once the start of the range
+// has been reached.
+type BackwardRanger interface {
+	RangeBefore(ctx context.Context, ei *EntityInfo, columnConditions map[string][]*Condition, minimumFields []string, token string, limit int) ([]map[string]FieldValue, string, error)
+}
+
+// FilteringRanger is implemented by connectors whose backend needs an explicit
+// opt-in before it filters rows, e.g. Cassandra's ALLOW FILTERING. The client
+// calls RangeAllowFiltering instead of Range for a RangeOp with
+// AllowFiltering set; its arguments and results are those of Range.
+type FilteringRanger interface {
+	RangeAllowFiltering(ctx context.Context, ei *EntityInfo, columnConditions map[string][]*Condition, minimumFields []string, token string, limit int) ([]map[string]FieldValue, string, error)
+}
+
+// FilteredScanner is implemented by connectors that can filter the rows of a
+// scan server side. The conditions may be placed on any column; the
+// continuation token and limit behave as they do for Scan.
+type FilteredScanner interface {
+	ScanFiltered(ctx context.Context, ei *EntityInfo, columnConditions map[string][]*Condition, minimumFields []string, token string, limit int) ([]map[string]FieldValue, string, error)
+}
+
+// RangeCounter is implemented by connectors whose backend can count the rows
+// matching a set of range conditions without returning them
+type RangeCounter interface {
+	CountRange(ctx context.Context, ei *EntityInfo, columnConditions map[string][]*Condition) (int64, error)
+}
+
+// MutationCounter is implemented by connectors whose backend reports how many
+// rows a mutation affected. The methods behave like Upsert and Remove and
+// additionally return that count.
+type MutationCounter interface {
+	UpsertCounted(ctx context.Context, ei *EntityInfo, values map[string]FieldValue) (int, error)
+	RemoveCounted(ctx context.Context, ei *EntityInfo, keys map[string]FieldValue) (int, error)
+}
+
+// Refresher is implemented by connectors that keep copies of rows, such as
+// the fallback cache. Refresh reads the row with the given keys from the
+// source of truth, bypassing the copy, and replaces the copy with it before
+// returning; a row the source no longer has is dropped from the copy.
+type Refresher interface {
+	Refresh(ctx context.Context, ei *EntityInfo, keys map[string]FieldValue) error
+}
+
+// ConsistentReader is implemented by connectors that keep copies of rows,
+// such as the fallback cache. ReadConsistent reads the row with the given
+// keys from the source of truth and returns it, after bringing the copy in
+// line with it: a copy that differs is replaced, and the copy of a row the
+// source does not have is dropped.
+type ConsistentReader interface {
+	ReadConsistent(ctx context.Context, ei *EntityInfo, keys map[string]FieldValue, minimumFields []string) (map[string]FieldValue, error)
+}
+
+// WriteOp is one of the writes committed together by a Transactor. Values
+// holds the column values of an upsert and only the primary key of a remove.
+type WriteOp struct {
+	EI     *EntityInfo
+	Remove bool
+	Values map[string]FieldValue
+}
+
+// Transactor is implemented by connectors whose store can apply several
+// writes atomically. CommitBatch applies either all of the writes, in order,
+// or none of them.
+type Transactor interface {
+	CommitBatch(ctx context.Context, ops []WriteOp) error
+}
+
 // CreationArgs contains values for configuring different connectors
 type CreationArgs map[string]interface{}
 
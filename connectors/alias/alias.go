@@ -0,0 +1,158 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package alias
+
+import (
+	"context"
+
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors"
+	"github.com/uber-go/dosa/connectors/base"
+)
+
+// Connector renames entities before passing operations to the next
+// connector. It lets code that still uses an entity's old name keep working
+// against a table that has been renamed in the datastore.
+type Connector struct {
+	base.Connector
+	aliases map[string]string
+}
+
+// NewConnector returns a connector that rewrites entity names found in
+// aliases, a map from old name to new name. Names that are not in the map
+// are passed through unchanged.
+func NewConnector(next dosa.Connector, aliases map[string]string) *Connector {
+	return &Connector{
+		Connector: base.Connector{Next: next},
+		aliases:   aliases,
+	}
+}
+
+// Middleware returns a connectors.Middleware that wraps a connector with
+// NewConnector
+func Middleware(aliases map[string]string) connectors.Middleware {
+	return func(next dosa.Connector) dosa.Connector {
+		return NewConnector(next, aliases)
+	}
+}
+
+// rename returns a copy of ei with the entity name rewritten in both the
+// definition and the schema reference. The caller's EntityInfo is never
+// modified.
+func (c *Connector) rename(ei *dosa.EntityInfo) *dosa.EntityInfo {
+	if ei == nil {
+		return nil
+	}
+	renamed := *ei
+	if ei.Def != nil {
+		if newName, ok := c.aliases[ei.Def.Name]; ok {
+			def := *ei.Def
+			def.Name = newName
+			renamed.Def = &def
+		}
+	}
+	if ei.Ref != nil {
+		if newName, ok := c.aliases[ei.Ref.EntityName]; ok {
+			ref := *ei.Ref
+			ref.EntityName = newName
+			renamed.Ref = &ref
+		}
+	}
+	return &renamed
+}
+
+// renameDefinitions returns copies of the definitions with aliased names
+// rewritten
+func (c *Connector) renameDefinitions(eds []*dosa.EntityDefinition) []*dosa.EntityDefinition {
+	renamed := make([]*dosa.EntityDefinition, len(eds))
+	for i, ed := range eds {
+		renamed[i] = ed
+		if ed == nil {
+			continue
+		}
+		if newName, ok := c.aliases[ed.Name]; ok {
+			def := *ed
+			def.Name = newName
+			renamed[i] = &def
+		}
+	}
+	return renamed
+}
+
+// CreateIfNotExists renames the entity and calls Next
+func (c *Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	return c.Connector.CreateIfNotExists(ctx, c.rename(ei), values)
+}
+
+// Read renames the entity and calls Next
+func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, minimumFields []string) (map[string]dosa.FieldValue, error) {
+	return c.Connector.Read(ctx, c.rename(ei), keys, minimumFields)
+}
+
+// MultiRead renames the entity and calls Next
+func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, minimumFields []string) ([]*dosa.FieldValuesOrError, error) {
+	return c.Connector.MultiRead(ctx, c.rename(ei), keys, minimumFields)
+}
+
+// Upsert renames the entity and calls Next
+func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	return c.Connector.Upsert(ctx, c.rename(ei), values)
+}
+
+// MultiUpsert renames the entity and calls Next
+func (c *Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, multiValues []map[string]dosa.FieldValue) ([]error, error) {
+	return c.Connector.MultiUpsert(ctx, c.rename(ei), multiValues)
+}
+
+// Remove renames the entity and calls Next
+func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	return c.Connector.Remove(ctx, c.rename(ei), keys)
+}
+
+// RemoveRange renames the entity and calls Next
+func (c *Connector) RemoveRange(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition) error {
+	return c.Connector.RemoveRange(ctx, c.rename(ei), columnConditions)
+}
+
+// MultiRemove renames the entity and calls Next
+func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) ([]error, error) {
+	return c.Connector.MultiRemove(ctx, c.rename(ei), multiKeys)
+}
+
+// Range renames the entity and calls Next
+func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, minimumFields []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	return c.Connector.Range(ctx, c.rename(ei), columnConditions, minimumFields, token, limit)
+}
+
+// Scan renames the entity and calls Next
+func (c *Connector) Scan(ctx context.Context, ei *dosa.EntityInfo, minimumFields []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	return c.Connector.Scan(ctx, c.rename(ei), minimumFields, token, limit)
+}
+
+// CheckSchema renames the entity definitions and calls Next
+func (c *Connector) CheckSchema(ctx context.Context, scope, namePrefix string, eds []*dosa.EntityDefinition) (int32, error) {
+	return c.Connector.CheckSchema(ctx, scope, namePrefix, c.renameDefinitions(eds))
+}
+
+// UpsertSchema renames the entity definitions and calls Next
+func (c *Connector) UpsertSchema(ctx context.Context, scope, namePrefix string, eds []*dosa.EntityDefinition) (*dosa.SchemaStatus, error) {
+	return c.Connector.UpsertSchema(ctx, scope, namePrefix, c.renameDefinitions(eds))
+}
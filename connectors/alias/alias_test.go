@@ -0,0 +1,132 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package alias
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/mocks"
+)
+
+var (
+	ctx   = context.TODO()
+	oldEi = &dosa.EntityInfo{
+		Ref: &dosa.SchemaRef{Scope: "scope1", NamePrefix: "namePrefix", EntityName: "old_name"},
+		Def: &dosa.EntityDefinition{
+			Name:    "old_name",
+			Key:     &dosa.PrimaryKey{PartitionKeys: []string{"p1"}},
+			Columns: []*dosa.ColumnDefinition{{Name: "p1", Type: dosa.String}},
+		},
+	}
+	keys = map[string]dosa.FieldValue{"p1": "a"}
+)
+
+// renamedTo matches an EntityInfo whose definition and reference both carry
+// the given name
+type renamedTo string
+
+func (m renamedTo) Matches(x interface{}) bool {
+	ei, ok := x.(*dosa.EntityInfo)
+	return ok && ei.Def.Name == string(m) && ei.Ref.EntityName == string(m)
+}
+
+func (m renamedTo) String() string {
+	return "is an entity named " + string(m)
+}
+
+func TestReadReachesNewName(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().Read(ctx, renamedTo("new_name"), keys, dosa.All()).Return(keys, nil)
+
+	sut := NewConnector(mockConn, map[string]string{"old_name": "new_name"})
+	result, err := sut.Read(ctx, oldEi, keys, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, keys, result)
+
+	// the caller's entity info is left alone
+	assert.Equal(t, "old_name", oldEi.Def.Name)
+	assert.Equal(t, "old_name", oldEi.Ref.EntityName)
+}
+
+func TestEveryMethodRenames(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	n := renamedTo("new_name")
+	mockConn.EXPECT().CreateIfNotExists(ctx, n, keys).Return(nil)
+	mockConn.EXPECT().MultiRead(ctx, n, gomock.Any(), dosa.All()).Return(nil, nil)
+	mockConn.EXPECT().Upsert(ctx, n, keys).Return(nil)
+	mockConn.EXPECT().MultiUpsert(ctx, n, gomock.Any()).Return(nil, nil)
+	mockConn.EXPECT().Remove(ctx, n, keys).Return(nil)
+	mockConn.EXPECT().RemoveRange(ctx, n, gomock.Any()).Return(nil)
+	mockConn.EXPECT().MultiRemove(ctx, n, gomock.Any()).Return(nil, nil)
+	mockConn.EXPECT().Range(ctx, n, gomock.Any(), dosa.All(), "", 10).Return(nil, "", nil)
+	mockConn.EXPECT().Scan(ctx, n, dosa.All(), "", 10).Return(nil, "", nil)
+	mockConn.EXPECT().CheckSchema(ctx, "scope1", "namePrefix", gomock.Any()).
+		Do(func(_ context.Context, _, _ string, eds []*dosa.EntityDefinition) {
+			assert.Equal(t, "new_name", eds[0].Name)
+			assert.Equal(t, "other", eds[1].Name)
+		}).Return(int32(1), nil)
+	mockConn.EXPECT().UpsertSchema(ctx, "scope1", "namePrefix", gomock.Any()).
+		Do(func(_ context.Context, _, _ string, eds []*dosa.EntityDefinition) {
+			assert.Equal(t, "new_name", eds[0].Name)
+		}).Return(&dosa.SchemaStatus{}, nil)
+
+	sut := NewConnector(mockConn, map[string]string{"old_name": "new_name"})
+	multi := []map[string]dosa.FieldValue{keys}
+	assert.NoError(t, sut.CreateIfNotExists(ctx, oldEi, keys))
+	_, err := sut.MultiRead(ctx, oldEi, multi, dosa.All())
+	assert.NoError(t, err)
+	assert.NoError(t, sut.Upsert(ctx, oldEi, keys))
+	_, err = sut.MultiUpsert(ctx, oldEi, multi)
+	assert.NoError(t, err)
+	assert.NoError(t, sut.Remove(ctx, oldEi, keys))
+	assert.NoError(t, sut.RemoveRange(ctx, oldEi, nil))
+	_, err = sut.MultiRemove(ctx, oldEi, multi)
+	assert.NoError(t, err)
+	_, _, err = sut.Range(ctx, oldEi, nil, dosa.All(), "", 10)
+	assert.NoError(t, err)
+	_, _, err = sut.Scan(ctx, oldEi, dosa.All(), "", 10)
+	assert.NoError(t, err)
+
+	other := &dosa.EntityDefinition{Name: "other"}
+	_, err = sut.CheckSchema(ctx, "scope1", "namePrefix", []*dosa.EntityDefinition{oldEi.Def, other})
+	assert.NoError(t, err)
+	_, err = sut.UpsertSchema(ctx, "scope1", "namePrefix", []*dosa.EntityDefinition{oldEi.Def})
+	assert.NoError(t, err)
+	assert.Equal(t, "old_name", oldEi.Def.Name)
+}
+
+func TestUnaliasedNamePassesThrough(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().Upsert(ctx, renamedTo("old_name"), keys).Return(nil)
+
+	sut := NewConnector(mockConn, map[string]string{"something_else": "new_name"})
+	assert.NoError(t, sut.Upsert(ctx, oldEi, keys))
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// rangeAdmissionWindow is how long the counts of the range admission policy
+// are kept before they start over
+const rangeAdmissionWindow = time.Minute
+
+// WithRangeAdmissionThreshold keeps one-off range queries out of the
+// fallback: a range result is only written back once the same query, with
+// the same conditions, token and limit, has been answered by origin k times
+// within a minute. Queries are counted in a small count-min sketch, so a
+// rare query can occasionally be admitted early but never late. A threshold
+// of one or less caches every range, which is the default.
+func WithRangeAdmissionThreshold(k int) Option {
+	return func(c *Connector) {
+		if k <= 1 {
+			c.rangeAdmission = nil
+			return
+		}
+		c.rangeAdmission = newAdmissionSketch(k, rangeAdmissionWindow)
+	}
+}
+
+const (
+	sketchDepth = 4
+	sketchWidth = 1024
+)
+
+// admissionSketch is a count-min sketch of how often keys were seen in the
+// current window
+type admissionSketch struct {
+	threshold int
+	window    time.Duration
+
+	mu      sync.Mutex
+	counts  [sketchDepth][sketchWidth]uint32
+	resetAt time.Time
+}
+
+func newAdmissionSketch(threshold int, window time.Duration) *admissionSketch {
+	return &admissionSketch{threshold: threshold, window: window}
+}
+
+// admit counts one more occurrence of key and reports whether it has now
+// been seen at least threshold times in the window
+func (s *admissionSketch) admit(key []byte, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !now.Before(s.resetAt) {
+		s.counts = [sketchDepth][sketchWidth]uint32{}
+		s.resetAt = now.Add(s.window)
+	}
+	estimate := uint32(0)
+	for row := range s.counts {
+		i := sketchIndex(key, row)
+		if s.counts[row][i] < ^uint32(0) {
+			s.counts[row][i]++
+		}
+		if row == 0 || s.counts[row][i] < estimate {
+			estimate = s.counts[row][i]
+		}
+	}
+	return int(estimate) >= s.threshold
+}
+
+// admitted reports whether key has been seen at least threshold times in the
+// window, without counting it
+func (s *admissionSketch) admitted(key []byte, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !now.Before(s.resetAt) {
+		return false
+	}
+	estimate := uint32(0)
+	for row := range s.counts {
+		count := s.counts[row][sketchIndex(key, row)]
+		if row == 0 || count < estimate {
+			estimate = count
+		}
+	}
+	return int(estimate) >= s.threshold
+}
+
+// sketchIndex hashes key for a row of the sketch, seeding the hash with the
+// row number so that rows collide independently
+func sketchIndex(key []byte, row int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte{byte(row)})
+	_, _ = h.Write(key)
+	return int(h.Sum32() % sketchWidth)
+}
+
+// admitRange reports whether the result of the range query stored under
+// cacheKey should be written back
+func (c *Connector) admitRange(cacheKey []byte) bool {
+	if c.rangeAdmission == nil {
+		return true
+	}
+	return c.rangeAdmission.admit(cacheKey, c.now())
+}
+
+// rangeAdmitted reports whether the range query stored under cacheKey has
+// already been admitted, without counting it as another occurrence
+func (c *Connector) rangeAdmitted(cacheKey []byte) bool {
+	if c.rangeAdmission == nil {
+		return true
+	}
+	return c.rangeAdmission.admitted(cacheKey, c.now())
+}
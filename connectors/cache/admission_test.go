@@ -0,0 +1,70 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/mocks"
+)
+
+func TestRangeAdmissionThreshold(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	connector := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithRangeAdmissionThreshold(3))
+	connector.setSynchronousMode(true)
+	now := testNow
+	connector.now = func() time.Time { return now }
+
+	rows := []map[string]dosa.FieldValue{{"int64key": int64(1)}}
+	mockOrigin.EXPECT().Range(context.TODO(), testEi, nil, dosa.All(), gomock.Any(), 2).Return(rows, "", nil).AnyTimes()
+	rangeOnce := func(token string) {
+		_, _, err := connector.Range(context.TODO(), testEi, nil, dosa.All(), token, 2)
+		assert.NoError(t, err)
+	}
+
+	// the query is cached once it repeats three times
+	rangeOnce("")
+	rangeOnce("")
+	assert.Equal(t, int64(0), connector.Stats().Writes)
+	rangeOnce("")
+	assert.Equal(t, int64(1), connector.Stats().Writes)
+	rangeOnce("")
+	assert.Equal(t, int64(2), connector.Stats().Writes)
+
+	// other queries are counted on their own
+	rangeOnce("other")
+	assert.Equal(t, int64(2), connector.Stats().Writes)
+
+	// and counts start over after the window
+	rangeOnce("other")
+	now = now.Add(rangeAdmissionWindow)
+	rangeOnce("other")
+	assert.Equal(t, int64(2), connector.Stats().Writes)
+}
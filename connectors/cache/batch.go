@@ -0,0 +1,180 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/snapshot"
+)
+
+// readBatcher coalesces point reads of the same entity that arrive within a
+// short window into a single MultiRead
+type readBatcher struct {
+	next     dosa.Connector
+	window   time.Duration
+	maxBatch int
+
+	mux     sync.Mutex
+	pending map[string]*readBatch
+}
+
+// readBatch is a MultiRead that is still collecting keys
+type readBatch struct {
+	id       string
+	ctx      context.Context
+	ei       *dosa.EntityInfo
+	keys     []map[string]dosa.FieldValue
+	results  []chan *dosa.FieldValuesOrError
+	deadline time.Time
+	timer    *time.Timer
+}
+
+func newReadBatcher(next dosa.Connector, window time.Duration, maxBatch int) *readBatcher {
+	return &readBatcher{
+		next:     next,
+		window:   window,
+		maxBatch: maxBatch,
+		pending:  map[string]*readBatch{},
+	}
+}
+
+// read adds keys to the open batch for the entity and waits for its result.
+// The batch is sent once the window has passed or it holds maxBatch keys.
+func (b *readBatcher) read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) (map[string]dosa.FieldValue, error) {
+	result := make(chan *dosa.FieldValuesOrError, 1)
+	id := batchID(ei) + contextID(ctx)
+
+	b.mux.Lock()
+	batch, ok := b.pending[id]
+	if !ok {
+		batch = &readBatch{id: id, ctx: valuesContext{ctx}, ei: ei}
+		b.pending[id] = batch
+		batch.timer = time.AfterFunc(b.window, func() { b.flush(batch) })
+	}
+	batch.keys = append(batch.keys, keys)
+	batch.results = append(batch.results, result)
+	if deadline, ok := ctx.Deadline(); ok && (batch.deadline.IsZero() || deadline.Before(batch.deadline)) {
+		batch.deadline = deadline
+	}
+	full := b.maxBatch > 0 && len(batch.keys) >= b.maxBatch
+	if full {
+		delete(b.pending, id)
+		batch.timer.Stop()
+	}
+	b.mux.Unlock()
+
+	if full {
+		go b.send(batch)
+	}
+
+	select {
+	case r := <-result:
+		return r.Values, r.Error
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// flush sends the batch when its window closes, unless it already filled up
+func (b *readBatcher) flush(batch *readBatch) {
+	b.mux.Lock()
+	if b.pending[batch.id] != batch {
+		b.mux.Unlock()
+		return
+	}
+	delete(b.pending, batch.id)
+	b.mux.Unlock()
+	b.send(batch)
+}
+
+// send issues the MultiRead and hands each caller its row. The request
+// carries the values of the first caller's context, such as its span, but
+// not its cancellation, so one caller giving up does not fail the others; it
+// is bounded by the earliest deadline in the batch. If origin does not
+// support MultiRead the keys are read one at a time instead.
+func (b *readBatcher) send(batch *readBatch) {
+	ctx := batch.ctx
+	if !batch.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, batch.deadline)
+		defer cancel()
+	}
+	rows, err := b.next.MultiRead(ctx, batch.ei, batch.keys, dosa.All())
+	if err != nil && multiReadUnsupported(err) {
+		for i, result := range batch.results {
+			values, err := b.next.Read(ctx, batch.ei, batch.keys[i], dosa.All())
+			result <- &dosa.FieldValuesOrError{Values: values, Error: err}
+		}
+		return
+	}
+	for i, result := range batch.results {
+		switch {
+		case err != nil:
+			result <- &dosa.FieldValuesOrError{Error: err}
+		case i >= len(rows) || rows[i] == nil:
+			result <- &dosa.FieldValuesOrError{Error: fmt.Errorf("no result for key %d of batched read", i)}
+		default:
+			result <- rows[i]
+		}
+	}
+}
+
+// multiReadUnsupported reports whether err says the connector has no
+// MultiRead, as dosa.ErrUnsupported and the "Not implemented" errors of
+// connectors such as redis do
+func multiReadUnsupported(err error) bool {
+	return dosa.ErrorIsUnsupported(err) || strings.EqualFold(err.Error(), "not implemented")
+}
+
+// valuesContext keeps the values of a context but drops its deadline and
+// cancellation
+type valuesContext struct {
+	context.Context
+}
+
+func (valuesContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (valuesContext) Done() <-chan struct{}       { return nil }
+func (valuesContext) Err() error                  { return nil }
+
+// contextID identifies the context values that change what a read returns,
+// so that only reads that agree on them are batched together
+func contextID(ctx context.Context) string {
+	id := fmt.Sprintf("|%d", dosa.ConsistencyFromContext(ctx))
+	if token, ok := snapshot.FromContext(ctx); ok {
+		id += fmt.Sprintf("|%q", token)
+	}
+	return id
+}
+
+// batchID identifies the entity a read is for, including its scope, so that
+// only reads that can share a MultiRead are batched together
+func batchID(ei *dosa.EntityInfo) string {
+	if ei.Ref == nil {
+		return ei.Def.Name
+	}
+	return fmt.Sprintf("%s.%s.%s", ei.Ref.Scope, ei.Ref.NamePrefix, ei.Def.Name)
+}
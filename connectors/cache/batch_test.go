@@ -0,0 +1,187 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/connectors/snapshot"
+)
+
+// multiReadOrigin answers MultiRead by echoing each key with a value, and
+// records the size of every batch it receives
+type multiReadOrigin struct {
+	dosa.Connector
+	mux       sync.Mutex
+	batches   []int
+	snapshots []string
+}
+
+func (o *multiReadOrigin) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, minimumFields []string) ([]*dosa.FieldValuesOrError, error) {
+	o.mux.Lock()
+	o.batches = append(o.batches, len(keys))
+	if token, ok := snapshot.FromContext(ctx); ok {
+		o.snapshots = append(o.snapshots, token)
+	}
+	o.mux.Unlock()
+	results := make([]*dosa.FieldValuesOrError, len(keys))
+	for i, k := range keys {
+		if k["int64key"] == int64(-1) {
+			results[i] = &dosa.FieldValuesOrError{Error: &dosa.ErrNotFound{}}
+			continue
+		}
+		values := map[string]dosa.FieldValue{"strv": "value"}
+		for name, v := range k {
+			values[name] = v
+		}
+		results[i] = &dosa.FieldValuesOrError{Values: values}
+	}
+	return results, nil
+}
+
+func (o *multiReadOrigin) batchSizes() []int {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	return append([]int{}, o.batches...)
+}
+
+func batchKeys(i int64) map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{
+		"an_uuid_key": "d1449c93-25b8-4032-920b-60471d91acc9",
+		"strkey":      "test key string",
+		"int64key":    i,
+	}
+}
+
+// readConcurrently issues one Read per key at the same time and waits for all
+func readConcurrently(t *testing.T, connector *Connector, ids ...int64) []error {
+	errs := make([]error, len(ids))
+	wg := sync.WaitGroup{}
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id int64) {
+			defer wg.Done()
+			values, err := connector.Read(context.TODO(), testEi, batchKeys(id), dosa.All())
+			errs[i] = err
+			if err == nil {
+				assert.Equal(t, id, values["int64key"])
+			}
+		}(i, id)
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestReadBatching(t *testing.T) {
+	origin := &multiReadOrigin{Connector: memory.NewConnector()}
+	connector := NewConnector(origin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithReadBatching(50*time.Millisecond, 100))
+	connector.setSynchronousMode(true)
+
+	for _, err := range readConcurrently(t, connector, 1, 2, 3, 4, 5) {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []int{5}, origin.batchSizes())
+
+	// misses are reported to their own caller only
+	errs := readConcurrently(t, connector, 6, -1)
+	assert.NoError(t, errs[0])
+	assert.True(t, dosa.ErrorIsNotFound(errs[1]))
+}
+
+func TestReadBatchingMaxBatch(t *testing.T) {
+	origin := &multiReadOrigin{Connector: memory.NewConnector()}
+	connector := NewConnector(origin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithReadBatching(time.Hour, 2))
+	connector.setSynchronousMode(true)
+
+	// full batches are sent without waiting for the window
+	for _, err := range readConcurrently(t, connector, 1, 2, 3, 4) {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []int{2, 2}, origin.batchSizes())
+}
+
+func TestReadBatchingCallerContext(t *testing.T) {
+	origin := &multiReadOrigin{Connector: memory.NewConnector()}
+	connector := NewConnector(origin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithReadBatching(time.Hour, 100))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := connector.batcher.read(ctx, testEi, batchKeys(1))
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestReadBatchingContextValues(t *testing.T) {
+	origin := &multiReadOrigin{Connector: memory.NewConnector()}
+	connector := NewConnector(origin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithReadBatching(50*time.Millisecond, 100))
+
+	// reads of different snapshots go in batches of their own, which carry
+	// the snapshot of their callers
+	wg := sync.WaitGroup{}
+	for i, token := range []string{"a", "b", "a", "b"} {
+		wg.Add(1)
+		go func(i int64, token string) {
+			defer wg.Done()
+			_, err := connector.batcher.read(snapshot.NewContext(context.TODO(), token), testEi, batchKeys(i))
+			assert.NoError(t, err)
+		}(int64(i), token)
+	}
+	wg.Wait()
+	assert.Equal(t, []int{2, 2}, origin.batchSizes())
+	origin.mux.Lock()
+	defer origin.mux.Unlock()
+	assert.ElementsMatch(t, []string{"a", "b"}, origin.snapshots)
+}
+
+// noMultiReadOrigin does not support MultiRead
+type noMultiReadOrigin struct {
+	dosa.Connector
+}
+
+func (noMultiReadOrigin) MultiRead(context.Context, *dosa.EntityInfo, []map[string]dosa.FieldValue, []string) ([]*dosa.FieldValuesOrError, error) {
+	return nil, &dosa.ErrUnsupported{Op: "MultiRead"}
+}
+
+func TestReadBatchingWithoutMultiRead(t *testing.T) {
+	origin := noMultiReadOrigin{Connector: memory.NewConnector()}
+	row := batchKeys(1)
+	row["strv"] = "value"
+	assert.NoError(t, origin.Upsert(context.TODO(), testEi, row))
+	connector := NewConnector(origin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithReadBatching(time.Millisecond, 100))
+
+	values, err := connector.batcher.read(context.TODO(), testEi, batchKeys(1))
+	assert.NoError(t, err)
+	assert.Equal(t, "value", values["strv"])
+	_, err = connector.batcher.read(context.TODO(), testEi, batchKeys(2))
+	assert.True(t, dosa.ErrorIsNotFound(err))
+	assert.True(t, multiReadUnsupported(errors.New("Not implemented")))
+}
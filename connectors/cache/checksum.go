@@ -0,0 +1,91 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"sync/atomic"
+
+	"github.com/uber-go/dosa"
+)
+
+// checksumMagic starts every value sealed with a checksum. No encoder of this
+// package starts its output with it.
+var checksumMagic = []byte{0xd0, 0x5a}
+
+// checksumHeaderSize is the size of the magic and the CRC-32 that follows it
+const checksumHeaderSize = 6
+
+// WithChecksums protects the values in the fallback against silent
+// corruption. Every value written is prefixed with a CRC-32 of its bytes,
+// which is verified when it is read back; a value that does not match is
+// counted in Stats.ChecksumMismatches and the checksum_mismatch metric and
+// treated as a cache miss. With repair set the corrupted value is also
+// removed from the fallback, so the next origin read writes it afresh.
+// Values written before checksums were enabled are read unverified; values
+// with checksums cannot be read once they are disabled again.
+func WithChecksums(repair bool) Option {
+	return func(c *Connector) {
+		c.checksums = true
+		c.repairCorrupt = repair
+	}
+}
+
+// sealValue prefixes a value about to be written to the fallback with its
+// checksum, if checksums are enabled
+func (c *Connector) sealValue(v []byte) []byte {
+	if !c.checksums {
+		return v
+	}
+	sealed := make([]byte, checksumHeaderSize+len(v))
+	copy(sealed, checksumMagic)
+	binary.BigEndian.PutUint32(sealed[len(checksumMagic):], crc32.ChecksumIEEE(v))
+	copy(sealed[checksumHeaderSize:], v)
+	return sealed
+}
+
+// openValue verifies and strips the checksum of a value read from the
+// fallback under storedKey
+func (c *Connector) openValue(adaptedEi *dosa.EntityInfo, storedKey, v []byte) ([]byte, error) {
+	if !c.checksums || len(v) < checksumHeaderSize || !bytes.HasPrefix(v, checksumMagic) {
+		return v, nil
+	}
+	payload := v[checksumHeaderSize:]
+	if binary.BigEndian.Uint32(v[len(checksumMagic):]) == crc32.ChecksumIEEE(payload) {
+		return payload, nil
+	}
+	atomic.AddInt64(&c.counters.checksumFailures, 1)
+	if c.stats != nil {
+		c.stats.Counter("checksum_mismatch").Inc(1)
+	}
+	if c.repairCorrupt {
+		_ = c.cacheWrite(func() error {
+			ctx, cancel := createContextForFallback(context.Background())
+			defer cancel()
+			return c.fallback.Remove(ctx, adaptedEi, map[string]dosa.FieldValue{key: storedKey})
+		})
+	}
+	return nil, newCacheError(ErrCacheMiss, errors.New("checksum mismatch"))
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+)
+
+func TestChecksums(t *testing.T) {
+	keys := batchKeys(1)
+	ctx := context.TODO()
+	for _, repair := range []bool{false, true} {
+		connector := NewConnector(memory.NewConnector(), memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+			WithOptions(WithChecksums(repair))
+		connector.setSynchronousMode(true)
+		assert.NoError(t, connector.PutCached(ctx, testEi, keys))
+
+		// a valid checksum is served
+		values, found, err := connector.GetCached(ctx, testEi, keys)
+		assert.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, keys["strkey"], values["strkey"])
+
+		// flip a byte of the stored payload
+		cacheKey, err := connector.CacheKey(testEi, keys)
+		assert.NoError(t, err)
+		adaptedEi := adaptToKeyValue(testEi)
+		stored, err := connector.fallback.Read(ctx, adaptedEi, map[string]dosa.FieldValue{key: cacheKey}, dosa.All())
+		assert.NoError(t, err)
+		corrupted := append([]byte{}, stored[value].([]byte)...)
+		corrupted[len(corrupted)-2] ^= 0xff
+		assert.NoError(t, connector.fallback.Upsert(ctx, adaptedEi, map[string]dosa.FieldValue{key: cacheKey, value: corrupted}))
+
+		_, found, err = connector.GetCached(ctx, testEi, keys)
+		assert.NoError(t, err)
+		assert.False(t, found)
+		assert.Equal(t, int64(1), connector.Stats().ChecksumMismatches)
+
+		// repair removes the corrupted value
+		_, err = connector.fallback.Read(ctx, adaptedEi, map[string]dosa.FieldValue{key: cacheKey}, dosa.All())
+		assert.Equal(t, repair, dosa.ErrorIsNotFound(err))
+	}
+
+	// values written without checksums are still read
+	fallback := memory.NewConnector()
+	plain := NewConnector(memory.NewConnector(), fallback, NewJSONEncoder(), nil, cacheableEntities...)
+	assert.NoError(t, plain.PutCached(ctx, testEi, keys))
+	checked := NewConnector(memory.NewConnector(), fallback, NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithChecksums(false))
+	_, found, err := checked.GetCached(ctx, testEi, keys)
+	assert.NoError(t, err)
+	assert.True(t, found)
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+
+	"github.com/uber-go/dosa"
+)
+
+// CommitBatch commits the writes through origin, which must implement
+// dosa.Transactor, and only once origin has committed them updates or
+// removes the fallback entries of the rows, as Upsert and Remove do. A
+// failed commit leaves the fallback untouched. It implements
+// dosa.Transactor.
+func (c *Connector) CommitBatch(ctx context.Context, ops []dosa.WriteOp) error {
+	t, ok := c.Next.(dosa.Transactor)
+	if !ok {
+		return &dosa.ErrUnsupported{Op: "CommitBatch"}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	canonical := make([]dosa.WriteOp, len(ops))
+	for i, op := range ops {
+		op.Values = c.canonicalColumns(op.EI, op.Values)
+		canonical[i] = op
+	}
+	if err := t.CommitBatch(ctx, canonical); err != nil {
+		return err
+	}
+	if c.readOnlyPopulation {
+		return nil
+	}
+	for _, op := range canonical {
+		if !c.isCacheable(op.EI) || c.isTier(op.EI) {
+			continue
+		}
+		if op.Remove {
+			_ = c.cacheWrite(c.removeEntry(ctx, op.EI, op.Values))
+			continue
+		}
+		c.writeBack(rowsSize(op.Values), c.upsertEntry(ctx, op.EI, op.Values))
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+)
+
+// transactionalOrigin applies committed batches to a memory connector unless
+// it is told to fail them
+type transactionalOrigin struct {
+	*memory.Connector
+	err error
+}
+
+func (o *transactionalOrigin) CommitBatch(ctx context.Context, ops []dosa.WriteOp) error {
+	if o.err != nil {
+		return o.err
+	}
+	for _, op := range ops {
+		var err error
+		if op.Remove {
+			err = o.Remove(ctx, op.EI, op.Values)
+		} else {
+			err = o.Upsert(ctx, op.EI, op.Values)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestCommitBatch(t *testing.T) {
+	ctx := context.TODO()
+	origin := &transactionalOrigin{Connector: memory.NewConnector()}
+	connector := NewConnector(origin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...)
+	connector.setSynchronousMode(true)
+
+	kept := batchKeys(1)
+	kept["strv"] = "before"
+	removed := batchKeys(2)
+	assert.NoError(t, connector.PutCached(ctx, testEi, kept))
+	assert.NoError(t, connector.PutCached(ctx, testEi, removed))
+
+	// a failed commit leaves the fallback untouched
+	origin.err = errors.New("aborted")
+	updated := batchKeys(1)
+	updated["strv"] = "after"
+	ops := []dosa.WriteOp{
+		{EI: testEi, Values: updated},
+		{EI: testEi, Remove: true, Values: batchKeys(2)},
+	}
+	assert.EqualError(t, connector.CommitBatch(ctx, ops), "aborted")
+	values, found, err := connector.GetCached(ctx, testEi, kept)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "before", values["strv"])
+	_, found, err = connector.GetCached(ctx, testEi, removed)
+	assert.NoError(t, err)
+	assert.True(t, found)
+
+	// a successful one updates and removes the entries
+	origin.err = nil
+	assert.NoError(t, connector.CommitBatch(ctx, ops))
+	values, found, err = connector.GetCached(ctx, testEi, kept)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "after", values["strv"])
+	_, found, err = connector.GetCached(ctx, testEi, removed)
+	assert.NoError(t, err)
+	assert.False(t, found)
+	stored, err := origin.Read(ctx, testEi, batchKeys(1), dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, "after", stored["strv"])
+
+	// origins without transactions cannot commit
+	plain := NewConnector(memory.NewConnector(), memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...)
+	assert.True(t, dosa.ErrorIsUnsupported(plain.CommitBatch(ctx, ops)))
+}
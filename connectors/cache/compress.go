@@ -0,0 +1,93 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"bytes"
+	"compress/flate"
+	"io/ioutil"
+)
+
+// The first byte of every value written with compression enabled tells
+// whether the rest is compressed. Neither starts the output of an encoder of
+// this package.
+const (
+	compressionNone  byte = 0xc0
+	compressionFlate byte = 0xc1
+)
+
+// WithCompression compresses the values written to the fallback whose
+// encoding is larger than threshold bytes, with DEFLATE. Smaller values, and
+// values that compression would not make smaller, are stored as they are;
+// compressing them would only cost CPU. A one-byte header records which
+// applies to each value so that both read back. Values written before
+// compression was enabled are read as they are; values written with it
+// cannot be read once it is disabled again.
+func WithCompression(threshold int) Option {
+	return func(c *Connector) {
+		c.compression = true
+		c.compressThreshold = threshold
+	}
+}
+
+// compressValue adds the compression header to a value about to be written to
+// the fallback, compressing the value if it is over the threshold
+func (c *Connector) compressValue(v []byte) []byte {
+	if !c.compression {
+		return v
+	}
+	if len(v) > c.compressThreshold {
+		buf := bytes.NewBuffer(make([]byte, 0, len(v)/2))
+		buf.WriteByte(compressionFlate)
+		w, err := flate.NewWriter(buf, flate.DefaultCompression)
+		if err == nil {
+			_, err = w.Write(v)
+		}
+		if err == nil {
+			err = w.Close()
+		}
+		if err == nil && buf.Len() < len(v)+1 {
+			return buf.Bytes()
+		}
+	}
+	return append([]byte{compressionNone}, v...)
+}
+
+// decompressValue strips the compression header of a value read from the
+// fallback and decompresses it if needed
+func (c *Connector) decompressValue(v []byte) ([]byte, error) {
+	if !c.compression || len(v) == 0 {
+		return v, nil
+	}
+	switch v[0] {
+	case compressionNone:
+		return v[1:], nil
+	case compressionFlate:
+		r := flate.NewReader(bytes.NewReader(v[1:]))
+		defer func() { _ = r.Close() }()
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, newCacheError(ErrCacheDecode, err)
+		}
+		return data, nil
+	}
+	return v, nil
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+)
+
+func TestCompression(t *testing.T) {
+	ctx := context.TODO()
+	fallback := memory.NewConnector()
+	connector := NewConnector(memory.NewConnector(), fallback, NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithCompression(512))
+	connector.setSynchronousMode(true)
+	adaptedEi := adaptToKeyValue(testEi)
+
+	small := batchKeys(1)
+	large := batchKeys(2)
+	large["strv"] = strings.Repeat("compressible ", 200)
+	for _, tc := range []struct {
+		values map[string]dosa.FieldValue
+		header byte
+	}{
+		{values: small, header: compressionNone},
+		{values: large, header: compressionFlate},
+	} {
+		assert.NoError(t, connector.PutCached(ctx, testEi, tc.values))
+		cacheKey, err := connector.CacheKey(testEi, tc.values)
+		assert.NoError(t, err)
+		stored, err := fallback.Read(ctx, adaptedEi, map[string]dosa.FieldValue{key: cacheKey}, dosa.All())
+		assert.NoError(t, err)
+		v := stored[value].([]byte)
+		assert.Equal(t, tc.header, v[0])
+		if tc.header == compressionFlate {
+			assert.True(t, len(v) < len(large["strv"].(string)))
+		}
+
+		values, found, err := connector.GetCached(ctx, testEi, tc.values)
+		assert.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, tc.values["strv"], values["strv"])
+		assert.EqualValues(t, tc.values["int64key"], values["int64key"])
+	}
+
+	// values written without compression are still read
+	plainFallback := memory.NewConnector()
+	plain := NewConnector(memory.NewConnector(), plainFallback, NewJSONEncoder(), nil, cacheableEntities...)
+	assert.NoError(t, plain.PutCached(ctx, testEi, large))
+	compressing := NewConnector(memory.NewConnector(), plainFallback, NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithCompression(512))
+	values, found, err := compressing.GetCached(ctx, testEi, large)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, large["strv"], values["strv"])
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/uber-go/dosa"
+)
+
+// ReadConsistent reads the row with keys from origin, bypassing the
+// fallback, and returns what origin returned. Before returning, it makes the
+// fallback agree with origin: an entry that is missing, expired or holds
+// different values is rewritten, and the entry of a row origin does not have
+// is removed. Either repair is counted in Stats.ConsistentRepairs. Entries
+// split with WithFieldGroups are always rewritten. The repair is synchronous
+// and its error is returned, whatever the WithPopulateOnMiss and
+// WithReadOnlyPopulation settings. It implements dosa.ConsistentReader.
+func (c *Connector) ReadConsistent(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, minimumFields []string) (map[string]dosa.FieldValue, error) {
+	if !c.isCacheable(ei) {
+		return c.Next.Read(ctx, ei, keys, minimumFields)
+	}
+	keys = c.canonicalColumns(ei, keys)
+	e := c.encoderFor(ei)
+	cacheKey, err := buildFullCacheKey(ei, keys, e)
+	if err != nil {
+		return nil, err
+	}
+	source, sourceErr := c.Next.Read(ctx, ei, keys, dosa.All())
+	if dosa.ErrorIsNotFound(sourceErr) {
+		if _, err := c.getValueFromFallback(ctx, adaptToKeyValue(ei), cacheKey); ErrorIsCacheMiss(err) && c.fieldGroups[ei.Def.Name] == nil {
+			return nil, sourceErr
+		}
+		if err := c.dropCached(ctx, ei, cacheKey); err != nil {
+			return nil, err
+		}
+		atomic.AddInt64(&c.counters.consistentRepairs, 1)
+		return nil, sourceErr
+	}
+	if sourceErr != nil {
+		return nil, sourceErr
+	}
+	source = c.canonicalColumns(ei, source)
+	if c.cachedMatches(ctx, ei, e, cacheKey, source) {
+		return source, nil
+	}
+	if err := c.storeCached(ctx, ei, e, cacheKey, source); err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&c.counters.consistentRepairs, 1)
+	return source, nil
+}
+
+// cachedMatches reports whether the point entry stored under cacheKey is
+// live and holds the values a write of source would store
+func (c *Connector) cachedMatches(ctx context.Context, ei *dosa.EntityInfo, e Encoder, cacheKey []byte, source map[string]dosa.FieldValue) bool {
+	if c.fieldGroups[ei.Def.Name] != nil {
+		return false
+	}
+	data, err := c.getValueFromFallback(ctx, adaptToKeyValue(ei), cacheKey)
+	if err != nil {
+		return false
+	}
+	entry, err := c.decodeEntry(e, data)
+	if err != nil {
+		return false
+	}
+	c.applyPin(ei, cacheKey, entry)
+	if entry.expired(c.now()) {
+		return false
+	}
+	// source goes through the encoder too, since encoders such as JSON do
+	// not decode values into the types they were given
+	data, err = e.Encode(c.stripExcluded(ei, source))
+	if err != nil {
+		return false
+	}
+	fresh := map[string]dosa.FieldValue{}
+	if err := e.Decode(data, &fresh); err != nil {
+		return false
+	}
+	return dosa.FieldValuesEqual(entry.Values, fresh)
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+)
+
+func TestReadConsistent(t *testing.T) {
+	ctx := context.TODO()
+	origin := memory.NewConnector()
+	connector := NewConnector(origin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithPopulateOnMiss(false))
+	connector.setSynchronousMode(true)
+	keys := batchKeys(1)
+	row := batchKeys(1)
+	row["strv"] = "fresh"
+	assert.NoError(t, origin.Upsert(ctx, testEi, row))
+	stale := batchKeys(1)
+	stale["strv"] = "stale"
+	assert.NoError(t, connector.PutCached(ctx, testEi, stale))
+
+	// a divergent entry is repaired before the origin value is returned
+	values, err := connector.ReadConsistent(ctx, testEi, keys, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, "fresh", values["strv"])
+	cached, found, err := connector.GetCached(ctx, testEi, keys)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "fresh", cached["strv"])
+	assert.Equal(t, int64(1), connector.Stats().ConsistentRepairs)
+
+	// an entry that agrees is left alone
+	_, err = connector.ReadConsistent(ctx, testEi, keys, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), connector.Stats().ConsistentRepairs)
+
+	// the entry of a row origin no longer has is evicted
+	assert.NoError(t, origin.Remove(ctx, testEi, keys))
+	_, err = connector.ReadConsistent(ctx, testEi, keys, dosa.All())
+	assert.True(t, dosa.ErrorIsNotFound(err))
+	_, found, err = connector.GetCached(ctx, testEi, keys)
+	assert.NoError(t, err)
+	assert.False(t, found)
+	assert.Equal(t, int64(2), connector.Stats().ConsistentRepairs)
+
+	// with nothing left to evict, there is nothing to repair
+	_, err = connector.ReadConsistent(ctx, testEi, keys, dosa.All())
+	assert.True(t, dosa.ErrorIsNotFound(err))
+	assert.Equal(t, int64(2), connector.Stats().ConsistentRepairs)
+}
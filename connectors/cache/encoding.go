@@ -24,6 +24,9 @@ import (
 	"bytes"
 	"encoding/gob"
 	"encoding/json"
+	"reflect"
+
+	"github.com/uber-go/dosa"
 )
 
 // Encoder serializes and deserializes the data in cache
@@ -32,40 +35,137 @@ type Encoder interface {
 	Decode([]byte, interface{}) error
 }
 
-// NewJSONEncoder returns a json encoder
+// NewJSONEncoder returns a json encoder. It implements TypeRegistrar.
 func NewJSONEncoder() Encoder {
 	return &jsonEncoder{}
 }
 
-type jsonEncoder struct{}
+type jsonEncoder struct {
+	customTypes
+}
 
 // Encode marshals an object using golang's encoding/json package
 func (j *jsonEncoder) Encode(v interface{}) ([]byte, error) {
+	v, err := j.encodeValues(v)
+	if err != nil {
+		return nil, err
+	}
 	return json.Marshal(v)
 }
 
 // Decode unmarhsals bytes using golagn's encoding/json package
 func (j *jsonEncoder) Decode(data []byte, v interface{}) error {
-	return json.Unmarshal(data, v)
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	return j.decodeValues(v)
 }
 
-// NewGobEncoder returns a gob encoder
+// NewGobEncoder returns a gob encoder. It implements TypeRegistrar.
 func NewGobEncoder() Encoder {
 	return &gobEncoder{}
 }
 
-type gobEncoder struct{}
+type gobEncoder struct {
+	customTypes
+}
 
 // Encode returns a series of bytes using golang's encoding/gob package
 func (g *gobEncoder) Encode(v interface{}) ([]byte, error) {
+	v, err := g.encodeValues(v)
+	if err != nil {
+		return nil, err
+	}
 	var buf bytes.Buffer
 	e := gob.NewEncoder(&buf)
-	err := e.Encode(v)
+	err = e.Encode(v)
 	return buf.Bytes(), err
 }
 
 // Decode unmarshals bytes into an object using golang's encoding/gob package
 func (g *gobEncoder) Decode(data []byte, v interface{}) error {
 	e := gob.NewDecoder(bytes.NewBuffer(data))
-	return e.Decode(v)
+	if err := e.Decode(v); err != nil {
+		return err
+	}
+	return g.decodeValues(v)
+}
+
+// NilFields controls how an encoder created by NewFieldValueEncoder treats
+// nil field values
+type NilFields int
+
+const (
+	// KeepNilFields encodes nil field values, so they decode as columns that
+	// are present with a nil value
+	KeepNilFields NilFields = iota
+	// OmitNilFields drops nil field values, so they decode as absent columns
+	OmitNilFields
+)
+
+// NewFieldValueEncoder wraps an encoder so that maps of field values are
+// encoded with explicit nil handling. Typed nil pointers, such as an unset
+// *string column, are encoded as nil rather than being left to the
+// underlying encoder, and are then kept or dropped according to nilFields.
+// Zero values are always encoded, so a decoded row can tell a column that is
+// absent apart from one that is present but zero.
+func NewFieldValueEncoder(e Encoder, nilFields NilFields) Encoder {
+	return &fieldValueEncoder{Encoder: e, nilFields: nilFields}
+}
+
+type fieldValueEncoder struct {
+	Encoder
+	nilFields NilFields
+}
+
+// RegisterType registers a custom type with the wrapped encoder. It does
+// nothing if the wrapped encoder does not implement TypeRegistrar.
+func (f *fieldValueEncoder) RegisterType(sample interface{}, marshal func(interface{}) ([]byte, error), unmarshal func([]byte) (interface{}, error)) {
+	if r, ok := f.Encoder.(TypeRegistrar); ok {
+		r.RegisterType(sample, marshal, unmarshal)
+	}
+}
+
+// Encode normalizes nil field values before calling the wrapped encoder
+func (f *fieldValueEncoder) Encode(v interface{}) ([]byte, error) {
+	switch t := v.(type) {
+	case map[string]dosa.FieldValue:
+		v = f.normalize(t)
+	case cacheEntry:
+		t.Values = f.normalize(t.Values)
+		v = t
+	case rangeResults:
+		rows := make([]map[string]dosa.FieldValue, len(t.Rows))
+		for i, row := range t.Rows {
+			rows[i] = f.normalize(row)
+		}
+		t.Rows = rows
+		v = t
+	}
+	return f.Encoder.Encode(v)
+}
+
+func (f *fieldValueEncoder) normalize(values map[string]dosa.FieldValue) map[string]dosa.FieldValue {
+	if values == nil {
+		return nil
+	}
+	result := make(map[string]dosa.FieldValue, len(values))
+	for name, value := range values {
+		if isNilValue(value) {
+			if f.nilFields == OmitNilFields {
+				continue
+			}
+			value = nil
+		}
+		result[name] = value
+	}
+	return result
+}
+
+func isNilValue(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Ptr && rv.IsNil()
 }
@@ -21,9 +21,17 @@
 package cache
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
 	"testing"
 
+	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/mocks"
 )
 
 var j = NewJSONEncoder()
@@ -52,3 +60,212 @@ func TestGobEncoder_Encode(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, []byte{3, 4, 0, 44}, m)
 }
+
+func TestFieldValueEncoder(t *testing.T) {
+	var unset *string
+	values := map[string]dosa.FieldValue{
+		"id":       "a",
+		"optional": unset,
+		"count":    int32(0),
+		"name":     "",
+	}
+
+	for _, e := range []Encoder{j, g} {
+		// absent optional column
+		omit := NewFieldValueEncoder(e, OmitNilFields)
+		data, err := omit.Encode(values)
+		assert.NoError(t, err)
+		result := map[string]dosa.FieldValue{}
+		assert.NoError(t, omit.Decode(data, &result))
+		_, ok := result["optional"]
+		assert.False(t, ok)
+		assert.Len(t, result, 3)
+
+		// present optional column
+		keep := NewFieldValueEncoder(e, KeepNilFields)
+		data, err = keep.Encode(values)
+		assert.NoError(t, err)
+		result = map[string]dosa.FieldValue{}
+		assert.NoError(t, keep.Decode(data, &result))
+		v, ok := result["optional"]
+		assert.True(t, ok)
+		assert.Nil(t, v)
+
+		// present zero columns
+		assert.Contains(t, result, "count")
+		assert.EqualValues(t, 0, result["count"])
+		assert.Contains(t, result, "name")
+		assert.Equal(t, "", result["name"])
+	}
+}
+
+func TestFieldValueEncoder_RangeResults(t *testing.T) {
+	var unset *string
+	e := NewFieldValueEncoder(g, OmitNilFields)
+	data, err := e.Encode(rangeResults{
+		Rows:      []map[string]dosa.FieldValue{{"id": "a", "optional": unset}},
+		TokenNext: "next",
+	})
+	assert.NoError(t, err)
+	result := rangeResults{}
+	assert.NoError(t, e.Decode(data, &result))
+	assert.Equal(t, "next", result.TokenNext)
+	assert.Equal(t, []map[string]dosa.FieldValue{{"id": "a"}}, result.Rows)
+}
+
+// taggedEncoder prefixes everything a JSON encoder writes with its tag and
+// refuses to decode data without it, so its keys and values never pass for
+// those of another tag
+type taggedEncoder struct {
+	tag []byte
+}
+
+func (e taggedEncoder) Encode(v interface{}) ([]byte, error) {
+	data, err := NewJSONEncoder().Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, e.tag...), data...), nil
+}
+
+func (e taggedEncoder) Decode(data []byte, v interface{}) error {
+	if !bytes.HasPrefix(data, e.tag) {
+		return fmt.Errorf("data not written by encoder %q", e.tag)
+	}
+	return NewJSONEncoder().Decode(data[len(e.tag):], v)
+}
+
+// amount is a custom column type unknown to the encoders
+type amount struct {
+	Units    int64
+	Currency string
+}
+
+func registerAmount(r TypeRegistrar) {
+	r.RegisterType(amount{}, func(v interface{}) ([]byte, error) {
+		a := v.(amount)
+		return []byte(fmt.Sprintf("%d %s", a.Units, a.Currency)), nil
+	}, func(data []byte) (interface{}, error) {
+		var a amount
+		_, err := fmt.Sscanf(string(data), "%d %s", &a.Units, &a.Currency)
+		return a, err
+	})
+}
+
+func TestRegisterType(t *testing.T) {
+	price := amount{Units: 1250, Currency: "EUR"}
+	for _, newEncoder := range []func() Encoder{
+		NewJSONEncoder,
+		NewGobEncoder,
+		NewProtoEncoder,
+		func() Encoder { return NewFieldValueEncoder(NewJSONEncoder(), OmitNilFields) },
+	} {
+		e := newEncoder()
+		registerAmount(e.(TypeRegistrar))
+
+		data, err := e.Encode(cacheEntry{Values: map[string]dosa.FieldValue{"price": price, "name": "x"}})
+		assert.NoError(t, err)
+		entry := cacheEntry{}
+		assert.NoError(t, e.Decode(data, &entry))
+		assert.Equal(t, price, entry.Values["price"])
+		assert.Equal(t, "x", entry.Values["name"])
+
+		data, err = e.Encode(rangeResults{Rows: []map[string]dosa.FieldValue{{"price": price}}})
+		assert.NoError(t, err)
+		results := rangeResults{}
+		assert.NoError(t, e.Decode(data, &results))
+		assert.Equal(t, price, results.Rows[0]["price"])
+
+		// an encoder without the type cannot decode it
+		unregistered := newEncoder()
+		assert.Error(t, unregistered.Decode(data, &rangeResults{}))
+	}
+
+	// through the connector
+	e := NewJSONEncoder()
+	registerAmount(e.(TypeRegistrar))
+	connector := NewConnector(memory.NewConnector(), memory.NewConnector(), e, nil, cacheableEntities...)
+	keys := batchKeys(1)
+	values := map[string]dosa.FieldValue{"strv": price}
+	for k, v := range keys {
+		values[k] = v
+	}
+	assert.NoError(t, connector.PutCached(context.TODO(), testEi, values))
+	cached, found, err := connector.GetCached(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, price, cached["strv"])
+
+	// wrapping an encoder that cannot register types makes it a no-op
+	plain := NewFieldValueEncoder(plainEncoder{NewJSONEncoder()}, KeepNilFields)
+	assert.NotPanics(t, func() { registerAmount(plain.(TypeRegistrar)) })
+}
+
+// plainEncoder hides the TypeRegistrar of the encoder it wraps
+type plainEncoder struct {
+	Encoder
+}
+
+func TestSetEncoder(t *testing.T) {
+	keys := map[string]dosa.FieldValue{
+		"an_uuid_key": "d1449c93-25b8-4032-920b-60471d91acc9",
+		"strkey":      "test key string",
+		"int64key":    int64(2932),
+	}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	mockOrigin.EXPECT().Read(gomock.Any(), testEi, keys, dosa.All()).Return(nil, assert.AnError).AnyTimes()
+
+	a, b := taggedEncoder{tag: []byte("a:")}, taggedEncoder{tag: []byte("b:")}
+	connector := NewConnector(mockOrigin, memory.NewConnector(), a, nil, cacheableEntities...)
+	ctx := context.TODO()
+	// the row is cached by both encoders, each under its own key
+	for _, e := range []Encoder{b, a} {
+		connector.SetEncoder(e)
+		row := map[string]dosa.FieldValue{"strv": string(e.(taggedEncoder).tag)}
+		for k, v := range keys {
+			row[k] = v
+		}
+		assert.NoError(t, connector.PutCached(ctx, testEi, row))
+	}
+
+	// every read fails over to the fallback; one that looked the entry up
+	// with one encoder and decoded it with the other would fail
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				values, err := connector.Read(ctx, testEi, keys, dosa.All())
+				if err == nil && values["strv"] != "a:" && values["strv"] != "b:" {
+					err = fmt.Errorf("unexpected value %v", values["strv"])
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	for j := 0; j < 200; j++ {
+		if j%2 == 0 {
+			connector.SetEncoder(b)
+		} else {
+			connector.SetEncoder(a)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	// after a swap, entries written with the old encoder are no longer found
+	connector.SetEncoder(NewJSONEncoder())
+	_, found, err := connector.GetCached(ctx, testEi, keys)
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
@@ -0,0 +1,203 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/uber-go/dosa"
+)
+
+var (
+	// ErrCacheMiss is the kind of error returned when the fallback holds no
+	// entry for a key
+	ErrCacheMiss = errors.New("cache miss")
+	// ErrCacheDecode is the kind of error returned when a fallback entry
+	// cannot be decoded
+	ErrCacheDecode = errors.New("cannot decode cache entry")
+	// ErrCacheEncode is the kind of error returned when values cannot be
+	// encoded into a fallback entry
+	ErrCacheEncode = errors.New("cannot encode cache entry")
+)
+
+// ErrMissingDefinition is returned by the methods that only work with the
+// fallback, such as PutCached, when the EntityInfo they are given has no
+// definition. Reads and writes of such an entity go to origin uncached.
+var ErrMissingDefinition = errors.New("entity info has no definition")
+
+// notCachedError explains why the methods that only work with the fallback
+// reject ei
+func notCachedError(ei *dosa.EntityInfo) error {
+	if !hasDefinition(ei) {
+		return ErrMissingDefinition
+	}
+	return fmt.Errorf("entity %q is not cached", ei.Def.Name)
+}
+
+// cacheError is an error of one of the kinds above, with the error that
+// caused it. On Go 1.13 and later errors.Is matches it against its kind and
+// errors.Unwrap returns the cause; ErrorIsCacheMiss and friends do the same
+// on older versions.
+type cacheError struct {
+	kind error
+	err  error
+}
+
+func newCacheError(kind, err error) error {
+	return &cacheError{kind: kind, err: err}
+}
+
+// Error implements the error interface
+func (e *cacheError) Error() string {
+	return fmt.Sprintf("%v: %v", e.kind, e.err)
+}
+
+// Is reports whether target is the error's kind
+func (e *cacheError) Is(target error) bool {
+	return target == e.kind
+}
+
+// Unwrap returns the error that caused this one
+func (e *cacheError) Unwrap() error {
+	return e.err
+}
+
+// Cause returns the error that caused this one, for github.com/pkg/errors,
+// so that e.g. dosa.ErrorIsNotFound still sees a not found error from the
+// fallback
+func (e *cacheError) Cause() error {
+	return e.err
+}
+
+// isKind walks the chain of causes of err looking for an error of kind
+func isKind(err, kind error) bool {
+	for err != nil {
+		if err == kind {
+			return true
+		}
+		if ce, ok := err.(*cacheError); ok && ce.kind == kind {
+			return true
+		}
+		cause, ok := err.(interface {
+			Cause() error
+		})
+		if !ok {
+			return false
+		}
+		err = cause.Cause()
+	}
+	return false
+}
+
+// ErrorIsCacheMiss checks if the error is, or was caused by, ErrCacheMiss
+func ErrorIsCacheMiss(err error) bool {
+	return isKind(err, ErrCacheMiss)
+}
+
+// ErrorIsCacheDecode checks if the error is, or was caused by, ErrCacheDecode
+func ErrorIsCacheDecode(err error) bool {
+	return isKind(err, ErrCacheDecode)
+}
+
+// ErrorIsCacheEncode checks if the error is, or was caused by, ErrCacheEncode
+func ErrorIsCacheEncode(err error) bool {
+	return isKind(err, ErrCacheEncode)
+}
+
+// ErrFallbackFailed is returned by Read and Range when WithCombinedErrors is
+// set, origin fails and the fallback lookup fails as well
+type ErrFallbackFailed struct {
+	originErr   error
+	fallbackErr error
+}
+
+// Error implements the error interface
+func (e *ErrFallbackFailed) Error() string {
+	return fmt.Sprintf("origin failed: %v; fallback failed: %v", e.originErr, e.fallbackErr)
+}
+
+// OriginErr returns the error origin returned
+func (e *ErrFallbackFailed) OriginErr() error {
+	return e.originErr
+}
+
+// FallbackErr returns the error of the fallback lookup
+func (e *ErrFallbackFailed) FallbackErr() error {
+	return e.fallbackErr
+}
+
+// Cause returns the origin error, so that errors.Cause and the helpers built
+// on it, such as dosa.ErrorIsNotFound, behave as they do without the option
+func (e *ErrFallbackFailed) Cause() error {
+	return e.originErr
+}
+
+// ErrInvalidateFailed is returned by InvalidateMany when some entries could not
+// be removed. Errors holds the error for each element of the keys passed
+// in, nil for those that were removed.
+type ErrInvalidateFailed struct {
+	Errors []error
+}
+
+// Error implements the error interface
+func (e *ErrInvalidateFailed) Error() string {
+	failed := 0
+	var first error
+	for _, err := range e.Errors {
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			failed++
+		}
+	}
+	return fmt.Sprintf("failed to invalidate %d of %d entries, first error: %v", failed, len(e.Errors), first)
+}
+
+// newInvalidateError returns nil if none of errs is set
+func newInvalidateError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return &ErrInvalidateFailed{Errors: errs}
+		}
+	}
+	return nil
+}
+
+// WithCombinedErrors makes Read and Range return an *ErrFallbackFailed naming
+// both failures when origin fails and the fallback cannot be read or decoded.
+// By default only the origin error is returned. Expired entries are a miss
+// rather than a failure and still return the origin error alone.
+func WithCombinedErrors() Option {
+	return func(c *Connector) {
+		c.combineErrors = true
+	}
+}
+
+// fallbackFailed returns the error for a request where both origin and the
+// fallback failed
+func (c *Connector) fallbackFailed(originErr, fallbackErr error) error {
+	if !c.combineErrors {
+		return originErr
+	}
+	return &ErrFallbackFailed{originErr: originErr, fallbackErr: fallbackErr}
+}
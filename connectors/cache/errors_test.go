@@ -0,0 +1,133 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/mocks"
+)
+
+func TestCombinedErrors(t *testing.T) {
+	keys := map[string]dosa.FieldValue{
+		"an_uuid_key": "d1449c93-25b8-4032-920b-60471d91acc9",
+		"strkey":      "test key string",
+		"int64key":    int64(2932),
+	}
+	originErr := &dosa.ErrNotFound{}
+
+	for _, combined := range []bool{false, true} {
+		ctrl := gomock.NewController(t)
+		mockOrigin := mocks.NewMockConnector(ctrl)
+		mockOrigin.EXPECT().Read(context.TODO(), testEi, keys, dosa.All()).Return(nil, originErr)
+		mockOrigin.EXPECT().Range(context.TODO(), testEi, nil, dosa.All(), "", 10).Return(nil, "", originErr)
+		connector := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...)
+		if combined {
+			connector.WithOptions(WithCombinedErrors())
+		}
+		connector.setSynchronousMode(true)
+
+		_, readErr := connector.Read(context.TODO(), testEi, keys, dosa.All())
+		_, _, rangeErr := connector.Range(context.TODO(), testEi, nil, dosa.All(), "", 10)
+		for _, err := range []error{readErr, rangeErr} {
+			// the origin error is still recognisable either way
+			assert.True(t, dosa.ErrorIsNotFound(err))
+			if !combined {
+				assert.Equal(t, originErr, err)
+				continue
+			}
+			both, ok := err.(*ErrFallbackFailed)
+			if assert.True(t, ok, "%T", err) {
+				assert.Equal(t, originErr, both.OriginErr())
+				assert.True(t, dosa.ErrorIsNotFound(both.FallbackErr()))
+				assert.Equal(t, originErr, errors.Cause(err))
+				assert.Contains(t, err.Error(), "fallback failed")
+			}
+		}
+		ctrl.Finish()
+	}
+}
+
+// failingEncoder fails every encode
+type failingEncoder struct {
+	Encoder
+}
+
+func (failingEncoder) Encode(v interface{}) ([]byte, error) {
+	return nil, assert.AnError
+}
+
+func TestCacheErrorKinds(t *testing.T) {
+	keys := map[string]dosa.FieldValue{
+		"an_uuid_key": "d1449c93-25b8-4032-920b-60471d91acc9",
+		"strkey":      "test key string",
+		"int64key":    int64(2932),
+	}
+	ctx := context.TODO()
+	connector := NewConnector(memory.NewConnector(), memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithCombinedErrors())
+	cacheKey := createCacheKey(testEi, keys, connector.encoder)
+
+	// miss, still recognisable as not found
+	_, err := connector.getValueFromFallback(ctx, adaptedEi, cacheKey)
+	assert.True(t, ErrorIsCacheMiss(err))
+	assert.True(t, dosa.ErrorIsNotFound(err))
+	assert.False(t, ErrorIsCacheDecode(err))
+	assert.Contains(t, err.Error(), "cache miss")
+
+	// a row without a value is a miss as well
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockFallback := mocks.NewMockConnector(ctrl)
+	mockFallback.EXPECT().Read(ctx, adaptedEi, gomock.Any(), dosa.All()).Return(map[string]dosa.FieldValue{}, nil)
+	noValue := NewConnector(memory.NewConnector(), mockFallback, NewJSONEncoder(), nil, cacheableEntities...)
+	_, err = noValue.getValueFromFallback(ctx, adaptedEi, cacheKey)
+	assert.True(t, ErrorIsCacheMiss(err))
+	assert.False(t, dosa.ErrorIsNotFound(err))
+
+	// decode
+	assert.NoError(t, connector.writeFallback(ctx, adaptedEi, cacheKey, []byte("not json")))
+	_, err = connector.decodeEntry(connector.encoder, []byte("not json"))
+	assert.True(t, ErrorIsCacheDecode(err))
+	_, _, err = connector.GetCached(ctx, testEi, keys)
+	assert.True(t, ErrorIsCacheDecode(err))
+	down := NewConnector(memory.NewConnector(), connector.fallback, NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithCombinedErrors())
+	_, err = down.Read(ctx, testEi, keys, dosa.All())
+	if both, ok := err.(*ErrFallbackFailed); assert.True(t, ok, "%T", err) {
+		assert.True(t, ErrorIsCacheDecode(both.FallbackErr()))
+	}
+
+	// encode, also through errors.Wrap
+	broken := NewConnector(memory.NewConnector(), memory.NewConnector(), failingEncoder{NewJSONEncoder()}, nil, cacheableEntities...)
+	_, err = broken.encodeEntry(testEi, broken.encoder, keys)
+	assert.True(t, ErrorIsCacheEncode(err))
+	assert.True(t, ErrorIsCacheEncode(errors.Wrap(err, "wrapped")))
+	assert.False(t, ErrorIsCacheEncode(assert.AnError))
+	assert.False(t, ErrorIsCacheMiss(nil))
+	assert.Equal(t, assert.AnError, errors.Cause(err))
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/uber-go/dosa"
+)
+
+// WithRemoveBatching collects the fallback removes of the same entity made
+// within window and sends them as one MultiRemove of at most maxBatch keys,
+// which cuts the number of fallback requests during bulk deletes. A fallback
+// that does not support MultiRemove gets the keys removed one at a time.
+// Until its batch is sent a removed row can still be served from the
+// fallback if origin fails. Close waits for the batches still open.
+func WithRemoveBatching(window time.Duration, maxBatch int) Option {
+	return func(c *Connector) {
+		c.evictionWindow = window
+		c.maxEvictionBatch = maxBatch
+		if c.evictions == nil {
+			c.evictions = map[string]*evictionBatch{}
+		}
+	}
+}
+
+// evictionBatch is a MultiRemove that is still collecting keys
+type evictionBatch struct {
+	id    string
+	ei    *dosa.EntityInfo
+	keys  []map[string]dosa.FieldValue
+	timer *time.Timer
+}
+
+// evict adds the stored key of an entry to the open eviction batch for the
+// entity. The batch is sent once the window has passed or it holds
+// maxEvictionBatch keys. An open batch counts as a pending write.
+func (c *Connector) evict(adaptedEi *dosa.EntityInfo, storedKey []byte) {
+	id := batchID(adaptedEi)
+	c.mux.Lock()
+	batch, ok := c.evictions[id]
+	if !ok {
+		batch = &evictionBatch{id: id, ei: adaptedEi}
+		c.evictions[id] = batch
+		c.pending++
+		batch.timer = time.AfterFunc(c.evictionWindow, func() { c.flushEvictions(batch) })
+	}
+	batch.keys = append(batch.keys, map[string]dosa.FieldValue{key: storedKey})
+	full := c.maxEvictionBatch > 0 && len(batch.keys) >= c.maxEvictionBatch
+	if full {
+		delete(c.evictions, id)
+		batch.timer.Stop()
+	}
+	c.mux.Unlock()
+
+	if full {
+		go c.sendEvictions(batch)
+	}
+}
+
+// flushEvictions sends the batch when its window closes, unless it already
+// filled up
+func (c *Connector) flushEvictions(batch *evictionBatch) {
+	c.mux.Lock()
+	if c.evictions[batch.id] != batch {
+		c.mux.Unlock()
+		return
+	}
+	delete(c.evictions, batch.id)
+	c.mux.Unlock()
+	c.sendEvictions(batch)
+}
+
+// sendEvictions removes the keys of the batch from the fallback. Keys that
+// are not there are not an error.
+func (c *Connector) sendEvictions(batch *evictionBatch) {
+	defer c.writeDone()
+	ctx, cancel := createContextForFallback(context.Background())
+	defer cancel()
+
+	atomic.AddInt64(&c.counters.evictionBatches, 1)
+	results, err := c.fallback.MultiRemove(ctx, batch.ei, batch.keys)
+	if err != nil || len(results) != len(batch.keys) {
+		results = make([]error, len(batch.keys))
+		for i, keys := range batch.keys {
+			results[i] = c.fallback.Remove(ctx, batch.ei, keys)
+		}
+	}
+	for _, err := range results {
+		if err == nil || dosa.ErrorIsNotFound(err) {
+			atomic.AddInt64(&c.counters.removes, 1)
+		}
+	}
+}
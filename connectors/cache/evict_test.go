@@ -0,0 +1,109 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+)
+
+// multiRemoveFallback supports MultiRemove on top of a memory connector and
+// records the size of every batch it receives
+type multiRemoveFallback struct {
+	dosa.Connector
+	mux     sync.Mutex
+	batches []int
+}
+
+func (f *multiRemoveFallback) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) ([]error, error) {
+	f.mux.Lock()
+	f.batches = append(f.batches, len(multiKeys))
+	f.mux.Unlock()
+	results := make([]error, len(multiKeys))
+	for i, keys := range multiKeys {
+		results[i] = f.Connector.Remove(ctx, ei, keys)
+	}
+	return results, nil
+}
+
+// newEvictionConnector returns a connector with n rows cached
+func newEvictionConnector(t *testing.T, fallback dosa.Connector, n int64, opts ...Option) *Connector {
+	connector := NewConnector(memory.NewConnector(), fallback, NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(opts...)
+	connector.setSynchronousMode(true)
+	for i := int64(0); i < n; i++ {
+		assert.NoError(t, connector.PutCached(context.TODO(), testEi, batchKeys(i)))
+	}
+	return connector
+}
+
+func assertEvicted(t *testing.T, connector *Connector, n int64) {
+	for i := int64(0); i < n; i++ {
+		_, found, err := connector.GetCached(context.TODO(), testEi, batchKeys(i))
+		assert.NoError(t, err)
+		assert.False(t, found, "row %d", i)
+	}
+}
+
+func TestRemoveBatchingFull(t *testing.T) {
+	fallback := &multiRemoveFallback{Connector: memory.NewConnector()}
+	connector := newEvictionConnector(t, fallback, 5, WithRemoveBatching(time.Hour, 3))
+	for i := int64(0); i < 3; i++ {
+		assert.NoError(t, connector.Remove(context.TODO(), testEi, batchKeys(i)))
+	}
+	// a full batch goes out without waiting for the window
+	n, err := connector.Close(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, []int{3}, fallback.batches)
+	assertEvicted(t, connector, 3)
+	_, found, err := connector.GetCached(context.TODO(), testEi, batchKeys(3))
+	assert.NoError(t, err)
+	assert.True(t, found)
+
+	stats := connector.Stats()
+	assert.Equal(t, int64(1), stats.EvictionBatches)
+	assert.Equal(t, int64(3), stats.Removes)
+}
+
+func TestRemoveBatchingWindow(t *testing.T) {
+	// the memory connector has no MultiRemove, so the batch is removed
+	// key by key
+	connector := newEvictionConnector(t, memory.NewConnector(), 2, WithRemoveBatching(10*time.Millisecond, 100))
+	for i := int64(0); i < 2; i++ {
+		assert.NoError(t, connector.Remove(context.TODO(), testEi, batchKeys(i)))
+	}
+	// the open batch is sent when the window closes, and Close waits for it
+	n, err := connector.Close(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assertEvicted(t, connector, 2)
+
+	stats := connector.Stats()
+	assert.Equal(t, int64(1), stats.EvictionBatches)
+	assert.Equal(t, int64(2), stats.Removes)
+}
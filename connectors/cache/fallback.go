@@ -22,13 +22,21 @@ package cache
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors"
 	"github.com/uber-go/dosa/connectors/base"
+	"github.com/uber-go/dosa/connectors/retry"
 	"github.com/uber-go/dosa/metrics"
 )
 
@@ -40,6 +48,46 @@ const (
 type rangeResults struct {
 	Rows      []map[string]dosa.FieldValue
 	TokenNext string
+	// Empty marks a range that legitimately returned no rows, so that it can
+	// be told apart from an entry that failed to decode into anything
+	Empty bool `json:",omitempty"`
+	// WrittenAt and TTL are only set for entries that expire
+	WrittenAt *time.Time    `json:",omitempty"`
+	TTL       time.Duration `json:",omitempty"`
+}
+
+// expired returns true if the range entry has outlived its TTL
+func (r *rangeResults) expired(now time.Time) bool {
+	return r.TTL > 0 && r.WrittenAt != nil && !now.Before(r.WrittenAt.Add(r.TTL))
+}
+
+// cacheEntry is the envelope stored in the fallback for point reads. Besides
+// the row itself it records when the entry was written and how long it is
+// meant to live, so that reads can tell how close an entry is to expiring,
+// the soft TTL past which it should be refreshed, and the content ID of the
+// writer, if it has one.
+type cacheEntry struct {
+	Values    map[string]dosa.FieldValue
+	WrittenAt time.Time
+	TTL       time.Duration
+	ContentID string        `json:",omitempty"`
+	SoftTTL   time.Duration `json:",omitempty"`
+}
+
+// expired returns true if the entry has outlived its TTL. Entries without a
+// TTL never expire.
+func (e *cacheEntry) expired(now time.Time) bool {
+	return e.TTL > 0 && e.remaining(now) <= 0
+}
+
+// remaining returns how long the entry has left to live
+func (e *cacheEntry) remaining(now time.Time) time.Duration {
+	return e.WrittenAt.Add(e.TTL).Sub(now)
+}
+
+// stale returns true if the entry has outlived its soft TTL
+func (e *cacheEntry) stale(now time.Time) bool {
+	return e.SoftTTL > 0 && !now.Before(e.WrittenAt.Add(e.SoftTTL))
 }
 
 type rangeQuery struct {
@@ -58,19 +106,557 @@ func NewConnector(origin, fallback dosa.Connector, encoder Encoder, scope metric
 		encoder:           encoder,
 		cacheableEntities: set,
 		stats:             scope,
+		refreshing:        map[string]bool{},
+		counters:          &counters{},
+		now:               time.Now,
+		rng:               rand.New(rand.NewSource(time.Now().UnixNano())),
+		tracer:            noopTracer{},
+		populateOnMiss:    true,
+	}
+}
+
+// Middleware returns a connectors.Middleware that puts the fallback cache in
+// front of the connector it wraps, which becomes the origin. The options are
+// applied with WithOptions.
+func Middleware(fallback dosa.Connector, encoder Encoder, scope metrics.Scope, entities []dosa.DomainObject, opts ...Option) connectors.Middleware {
+	return func(origin dosa.Connector) dosa.Connector {
+		return NewConnector(origin, fallback, encoder, scope, entities...).WithOptions(opts...)
+	}
+}
+
+// Stats holds running totals of the entries the connector has read from,
+// written to and removed from the fallback, and the background writes still
+// pending. They are only an approximation of the fallback's footprint:
+// overwrites of an existing key and evictions or expirations done by the
+// fallback store itself are not accounted for. The divergence counters stay
+// at zero unless WithDivergenceSampleRate is set.
+type Stats struct {
+	// Writes is the number of entries written to the fallback
+	Writes int64
+	// BytesWritten is the total size of the keys and values written
+	BytesWritten int64
+	// WriteErrors is the number of writes to the fallback that failed
+	WriteErrors int64
+	// Removes is the number of entries removed from the fallback
+	Removes int64
+	// Hits is the number of reads served from the fallback
+	Hits int64
+	// Misses is the number of reads that looked up the fallback and found
+	// no live entry, or could not read it
+	Misses int64
+	// PendingWrites is the number of fallback writes running in the
+	// background at the time of the snapshot
+	PendingWrites int
+	// PendingBytes is the estimated size of the rows those writes carry, as
+	// counted against WithMaxPendingBytes. It stays at zero without it.
+	PendingBytes int64
+	// DivergenceChecks is the number of fallback serves compared against a
+	// later origin read
+	DivergenceChecks int64
+	// Divergences is the number of those checks where origin disagreed with
+	// the value served from the fallback
+	Divergences int64
+	// WriteBacksPaused reports whether write-backs are currently paused, see
+	// WithWriteBackPause
+	WriteBacksPaused bool
+	// SkippedWriteBacks is the number of write-backs dropped while paused
+	SkippedWriteBacks int64
+	// HedgedReads is the number of reads that looked up the fallback because
+	// origin was slower than the hedge delay, see WithHedgedReads
+	HedgedReads int64
+	// HedgeWins is the number of hedged reads answered by the fallback
+	// before origin returned
+	HedgeWins int64
+	// KeyMigrations is the number of entries found under the old key scheme
+	// and rewritten under the current one, see WithKeyMigration
+	KeyMigrations int64
+	// WriteRetries is the number of fallback writes attempted again after a
+	// failure, see WithWriteRetries
+	WriteRetries int64
+	// EvictionBatches is the number of batches of removes sent to the
+	// fallback, see WithRemoveBatching
+	EvictionBatches int64
+	// ChecksumMismatches is the number of fallback values whose checksum did
+	// not match, see WithChecksums
+	ChecksumMismatches int64
+	// TierPromotions is the number of entries found in the slower tier and
+	// copied to the faster one, see NewTier
+	TierPromotions int64
+	// ConsistentRepairs is the number of fallback entries ReadConsistent
+	// rewrote or removed because they did not agree with origin
+	ConsistentRepairs int64
+	// PendingBytesDropped is the number of write-backs dropped because they
+	// would have exceeded WithMaxPendingBytes
+	PendingBytesDropped int64
+	// EmptyReadsSkipped is the number of origin reads without any columns
+	// that were not written to the fallback, see WithSkipEmptyReads
+	EmptyReadsSkipped int64
+	// RangePrefetches is the number of range pages read ahead from origin
+	// and written to the fallback, see WithRangePrefetch
+	RangePrefetches int64
+	// Entities breaks the fallback hits, misses and writes down by entity
+	// name. It is only set with WithPerEntityStats.
+	Entities map[string]EntityStats
+}
+
+// EntityStats are the fallback counters of a single entity
+type EntityStats struct {
+	// Hits is the number of reads served from the fallback
+	Hits int64
+	// Misses is the number of reads that looked up the fallback and found
+	// no live entry
+	Misses int64
+	// Writes is the number of entries written to the fallback
+	Writes int64
+}
+
+// counters are updated atomically; they live in their own allocation so the
+// int64 fields stay 64-bit aligned on 32-bit platforms
+type counters struct {
+	writes              int64
+	bytesWritten        int64
+	removes             int64
+	divergenceChecks    int64
+	divergences         int64
+	skippedWriteBacks   int64
+	hedgedReads         int64
+	hedgeWins           int64
+	keyMigrations       int64
+	rangePrefetches     int64
+	writeRetries        int64
+	evictionBatches     int64
+	checksumFailures    int64
+	tierPromotions      int64
+	emptyReadsSkipped   int64
+	pendingBytesDropped int64
+	hits                int64
+	misses              int64
+	writeErrors         int64
+	consistentRepairs   int64
+}
+
+// entityCounters are the counters behind EntityStats
+type entityCounters struct {
+	hits   int64
+	misses int64
+	writes int64
+}
+
+// Stats returns a snapshot of the fallback write counters
+func (c *Connector) Stats() Stats {
+	c.mux.Lock()
+	pendingWrites, pendingBytes := c.pending, c.pendingBytes
+	c.mux.Unlock()
+	return Stats{
+		Writes:              atomic.LoadInt64(&c.counters.writes),
+		BytesWritten:        atomic.LoadInt64(&c.counters.bytesWritten),
+		WriteErrors:         atomic.LoadInt64(&c.counters.writeErrors),
+		Removes:             atomic.LoadInt64(&c.counters.removes),
+		Hits:                atomic.LoadInt64(&c.counters.hits),
+		Misses:              atomic.LoadInt64(&c.counters.misses),
+		PendingWrites:       pendingWrites,
+		PendingBytes:        pendingBytes,
+		DivergenceChecks:    atomic.LoadInt64(&c.counters.divergenceChecks),
+		Divergences:         atomic.LoadInt64(&c.counters.divergences),
+		WriteBacksPaused:    c.writeBacksPaused(),
+		SkippedWriteBacks:   atomic.LoadInt64(&c.counters.skippedWriteBacks),
+		HedgedReads:         atomic.LoadInt64(&c.counters.hedgedReads),
+		HedgeWins:           atomic.LoadInt64(&c.counters.hedgeWins),
+		KeyMigrations:       atomic.LoadInt64(&c.counters.keyMigrations),
+		RangePrefetches:     atomic.LoadInt64(&c.counters.rangePrefetches),
+		WriteRetries:        atomic.LoadInt64(&c.counters.writeRetries),
+		EvictionBatches:     atomic.LoadInt64(&c.counters.evictionBatches),
+		ChecksumMismatches:  atomic.LoadInt64(&c.counters.checksumFailures),
+		TierPromotions:      atomic.LoadInt64(&c.counters.tierPromotions),
+		EmptyReadsSkipped:   atomic.LoadInt64(&c.counters.emptyReadsSkipped),
+		PendingBytesDropped: atomic.LoadInt64(&c.counters.pendingBytesDropped),
+		ConsistentRepairs:   atomic.LoadInt64(&c.counters.consistentRepairs),
+		Entities:            c.entityStats(),
+	}
+}
+
+// entityStats returns a snapshot of the per-entity counters, or nil when
+// they are not kept
+func (c *Connector) entityStats() map[string]EntityStats {
+	if !c.perEntityStats {
+		return nil
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	stats := make(map[string]EntityStats, len(c.entityCounters))
+	for name, ec := range c.entityCounters {
+		stats[name] = EntityStats{
+			Hits:   atomic.LoadInt64(&ec.hits),
+			Misses: atomic.LoadInt64(&ec.misses),
+			Writes: atomic.LoadInt64(&ec.writes),
+		}
+	}
+	return stats
+}
+
+// countersOf returns the counters of the named entity, creating them on
+// first use, or nil when per-entity counters are not kept
+func (c *Connector) countersOf(name string) *entityCounters {
+	if !c.perEntityStats {
+		return nil
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	ec, ok := c.entityCounters[name]
+	if !ok {
+		ec = &entityCounters{}
+		c.entityCounters[name] = ec
+	}
+	return ec
+}
+
+// recordFallback tags span with the outcome of a fallback lookup for ei and
+// counts it as a hit or a miss of the entity
+func (c *Connector) recordFallback(span SpanEnder, ei *dosa.EntityInfo, hit bool) {
+	tagFallback(span, hit)
+	if hit {
+		atomic.AddInt64(&c.counters.hits, 1)
+	} else {
+		atomic.AddInt64(&c.counters.misses, 1)
+	}
+	ec := c.countersOf(entityName(ei))
+	if ec == nil {
+		return
+	}
+	if hit {
+		atomic.AddInt64(&ec.hits, 1)
+	} else {
+		atomic.AddInt64(&ec.misses, 1)
+	}
+}
+
+// servedAgeBuckets are the upper bounds of the served_age buckets, with the
+// tag each is reported under. Older entries are reported as "older".
+var servedAgeBuckets = []struct {
+	max   time.Duration
+	label string
+}{
+	{time.Minute, "1m"},
+	{10 * time.Minute, "10m"},
+	{time.Hour, "1h"},
+	{6 * time.Hour, "6h"},
+	{24 * time.Hour, "1d"},
+}
+
+// servedAgeBucket returns the served_age tag for an entry of the given age
+func servedAgeBucket(age time.Duration) string {
+	for _, b := range servedAgeBuckets {
+		if age <= b.max {
+			return b.label
+		}
+	}
+	return "older"
+}
+
+// recordServedAge counts a fallback serve of an entry written at writtenAt
+// in the fallback.served_age counter, tagged with the entity and the age
+// bucket of the entry, so that the staleness of what is served during an
+// outage can be told from the age distribution
+func (c *Connector) recordServedAge(ei *dosa.EntityInfo, writtenAt time.Time) {
+	if c.stats == nil {
+		return
+	}
+	c.stats.SubScope("fallback").Tagged(map[string]string{
+		"entity": entityName(ei),
+		"age":    servedAgeBucket(c.now().Sub(writtenAt)),
+	}).Counter("served_age").Inc(1)
+}
+
+// Option configures optional behavior of the fallback cache connector
+type Option func(*Connector)
+
+// WithTTL sets how long entries written to the fallback are meant to live.
+// Expired entries are no longer served when origin fails. A TTL of zero, the
+// default, means entries never expire.
+func WithTTL(ttl time.Duration) Option {
+	return func(c *Connector) {
+		c.ttl = ttl
+	}
+}
+
+// WithRangeTTL sets how long range results written to the fallback are meant
+// to live, separately from the point read entries governed by WithTTL. When
+// it is not set, range entries use the WithTTL value.
+func WithRangeTTL(ttl time.Duration) Option {
+	return func(c *Connector) {
+		c.rangeTTL = ttl
+	}
+}
+
+// WithReadBatching collects the origin reads of the same entity that arrive
+// within window and sends them to origin as one MultiRead of at most
+// maxBatch keys, which can cut the number of origin requests under heavy
+// fan-out at the cost of up to window of added latency. Only reads whose
+// contexts ask for the same consistency and snapshot are batched together.
+// The batched request carries the context values of its first caller and is
+// bounded by the earliest deadline among its callers. If origin does not
+// support MultiRead the batched keys are read one at a time.
+func WithReadBatching(window time.Duration, maxBatch int) Option {
+	return func(c *Connector) {
+		c.batcher = newReadBatcher(c.Next, window, maxBatch)
+	}
+}
+
+// WithExcludedColumns keeps the listed columns out of the fallback, keyed by
+// entity name. They are removed from rows before they are encoded, so reads
+// served from the fallback will not contain them; use it for large or
+// sensitive columns that should not be copied to a shared store. Key
+// columns must not be excluded.
+func WithExcludedColumns(columns map[string][]string) Option {
+	return func(c *Connector) {
+		c.excludedColumns = make(map[string]map[string]bool, len(columns))
+		for entity, names := range columns {
+			set := make(map[string]bool, len(names))
+			for _, name := range names {
+				set[name] = true
+			}
+			c.excludedColumns[entity] = set
+		}
+	}
+}
+
+// WithRefreshAheadWindow makes a read served from the fallback refresh the
+// entry in the background when less than d of its TTL remains. The current
+// value is still returned; the refresh re-reads origin and rewrites the
+// entry. Only one refresh per entry is in flight at a time. It has no effect
+// unless a TTL is set with WithTTL.
+func WithRefreshAheadWindow(d time.Duration) Option {
+	return func(c *Connector) {
+		c.refreshAhead = d
+	}
+}
+
+// WithSoftTTL gives point entries a soft TTL besides their hard one, which
+// replaces the WithTTL value. An entry older than soft is still served from
+// the fallback when origin fails, but serving it refreshes it in the
+// background, as WithRefreshAheadWindow does; once older than hard it is no
+// longer served at all. A hard TTL of zero means entries never expire. The
+// soft TTL is recorded in each entry, so entries written before it was set
+// are never refreshed for being stale.
+func WithSoftTTL(soft, hard time.Duration) Option {
+	return func(c *Connector) {
+		c.softTTL = soft
+		c.ttl = hard
+	}
+}
+
+// WithWarmRate limits how many rows per second WarmByScan reads from origin
+// and writes to the fallback. The default of zero means no limit.
+func WithWarmRate(rowsPerSecond float64) Option {
+	return func(c *Connector) {
+		c.warmRate = rowsPerSecond
+	}
+}
+
+// WithDivergenceSampleRate makes a fraction of the reads served from the
+// fallback re-read the row from origin in the background and compare it with
+// the value that was served. rate is clamped to [0, 1]; 0, the default,
+// disables the check and 1 checks every fallback serve. Results show up in
+// Stats and in the "divergence" metrics of the fallback scope.
+func WithDivergenceSampleRate(rate float64) Option {
+	return func(c *Connector) {
+		c.divergenceRate = math.Max(0, math.Min(1, rate))
+	}
+}
+
+// WithDivergenceSeed seeds the random source used to sample divergence
+// checks, making the sampling reproducible
+func WithDivergenceSeed(seed int64) Option {
+	return func(c *Connector) {
+		c.rng = rand.New(rand.NewSource(seed))
+	}
+}
+
+// WithEntityEncoders sets the encoder used for the keys and values of the
+// listed entities, keyed by entity name. Entities that are not listed use the
+// encoder given to NewConnector. Changing an entity's encoder makes the
+// entries already in the fallback for that entity unreadable.
+func WithEntityEncoders(encoders map[string]Encoder) Option {
+	return func(c *Connector) {
+		c.entityEncoders = encoders
+	}
+}
+
+// WithWriteBackPause stops writing origin results back to the fallback for
+// cooldown once failures consecutive fallback writes have failed, so that a
+// degraded fallback is not loaded further. Reads still try the fallback and
+// removes are still sent to it. After the cooldown write-backs resume, and
+// another run of failures starts a new pause.
+func WithWriteBackPause(failures int, cooldown time.Duration) Option {
+	return func(c *Connector) {
+		c.pauseAfter = failures
+		c.pauseCooldown = cooldown
+	}
+}
+
+// WithPopulateOnMiss controls whether point reads answered by origin write
+// their result to the fallback, which is the default. This holds for every
+// read path: origin-first reads, hedged reads whose origin answer arrives
+// after the fallback's, and field group reads, which look in the fallback
+// first and go to origin for the groups it is missing. Turned off, the
+// fallback is only populated by Upsert and the explicit calls such as
+// PutCached and Pin; ranges are not affected.
+func WithPopulateOnMiss(populate bool) Option {
+	return func(c *Connector) {
+		c.populateOnMiss = populate
+	}
+}
+
+// WithReadOnlyPopulation makes the fallback read-aside only: entries are
+// written from origin reads and ranges, while Upsert and Remove go to origin
+// alone. Use it when rows written through the connector are not in the shape
+// reads return. Mutations no longer refresh or remove fallback entries, so
+// the fallback may serve values older than the last write until the next
+// successful read replaces them.
+func WithReadOnlyPopulation(readOnly bool) Option {
+	return func(c *Connector) {
+		c.readOnlyPopulation = readOnly
 	}
 }
 
+// WithCacheFullPagesOnly limits the range entries written to the fallback to
+// pages that hold as many rows as the request's limit. A shorter page usually
+// marks the end of a range, which is cheap to fetch again, so it is not worth
+// the space. Empty pages are not cached either. Requests without a limit are
+// always cached.
+func WithCacheFullPagesOnly(fullOnly bool) Option {
+	return func(c *Connector) {
+		c.cacheFullPagesOnly = fullOnly
+	}
+}
+
+// WithCopyOnRead makes the results served from the fallback safe to modify.
+// Depending on the encoder, the rows it decodes may share their []byte values
+// with its buffers or with other results, so a caller changing one could
+// change what later reads return. With copyOnRead set, every fallback-served
+// row is returned as a new map with its []byte values copied.
+func WithCopyOnRead(copyOnRead bool) Option {
+	return func(c *Connector) {
+		c.copyOnRead = copyOnRead
+	}
+}
+
+// WithPerEntityStats makes Stats break the fallback hits, misses and writes
+// down by entity name. Only the entities the connector caches are counted,
+// which keeps the number of counters bounded.
+func WithPerEntityStats(perEntity bool) Option {
+	return func(c *Connector) {
+		c.perEntityStats = perEntity
+		if perEntity && c.entityCounters == nil {
+			c.entityCounters = map[string]*entityCounters{}
+		}
+	}
+}
+
+// WithSkipEmptyReads keeps origin reads that returned no columns at all out
+// of the fallback, so that an origin answering an edge-case row with an empty
+// map does not have empty rows served during an outage. The skipped reads are
+// counted in Stats.EmptyReadsSkipped. A row that is legitimately empty is not
+// cached either: point reads of it keep failing while origin is down, as if
+// it had never been read. Rows with only their key columns set are not
+// empty and are cached as usual.
+func WithSkipEmptyReads(skip bool) Option {
+	return func(c *Connector) {
+		c.skipEmptyReads = skip
+	}
+}
+
+// WithOptions applies the given options to the connector and returns it. It
+// should be called before the connector is used.
+func (c *Connector) WithOptions(opts ...Option) *Connector {
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
 // Connector is a fallback cache connector
 type Connector struct {
 	base.Connector
 	fallback          dosa.Connector
 	encoder           Encoder
+	entityEncoders    map[string]Encoder
+	encoderMux        sync.RWMutex
 	cacheableEntities map[string]bool
 	mux               sync.Mutex
 	stats             metrics.Scope
 	// Used primarily for testing so that nothing is called in a goroutine
 	synchronous bool
+	ttl         time.Duration
+	rangeTTL    time.Duration
+	batcher     *readBatcher
+	// how oversized cached range pages are served
+	fallbackPaging FallbackPaging
+	refreshAhead   time.Duration
+	softTTL        time.Duration
+	// column names never written to the fallback, by entity name
+	excludedColumns map[string]map[string]bool
+	// cache keys with a refresh in flight, guarded by mux
+	refreshing map[string]bool
+	// number of fallback writes running in the background and the channels
+	// of Close calls waiting for them to finish, guarded by mux
+	pending int
+	// estimated size of the pending write-backs, and its limit
+	pendingBytes    int64
+	maxPendingBytes int64
+	drained         []chan struct{}
+	counters        *counters
+	warmRate        float64
+	now             func() time.Time
+
+	pauseAfter    int
+	pauseCooldown time.Duration
+	// writeFailures and pausedUntil are guarded by mux
+	writeFailures int
+	pausedUntil   time.Time
+
+	divergenceRate float64
+	// rng is guarded by mux
+	rng *rand.Rand
+
+	tracer             Tracer
+	combineErrors      bool
+	readOnlyPopulation bool
+	populateOnMiss     bool
+	cacheFullPagesOnly bool
+	copyOnRead         bool
+	perEntityStats     bool
+	rangePrefetch      bool
+	writeRetries       int
+	pinMux             sync.RWMutex
+	pinned             map[string]bool
+	normalizeColumns   bool
+	checksums          bool
+	compression        bool
+	compressThreshold  int
+	skipEmptyReads     bool
+	repairCorrupt      bool
+	evictionWindow     time.Duration
+	maxEvictionBatch   int
+	evictions          map[string]*evictionBatch
+	writeBackoff       retry.Backoff
+	contentID          string
+	onVersionSkew      func(storedID, currentID string)
+	skewOnce           sync.Once
+	prefetching        map[string]bool
+	prefetchCtx        context.Context
+	cancelPrefetches   context.CancelFunc
+	entityCounters     map[string]*entityCounters
+	hedgeDelay         time.Duration
+	keyHMAC            []byte
+	oldKeyBuilder      KeyBuilder
+	// field groups of wide entities, by entity name
+	fieldGroups       map[string]*fieldGroups
+	maxTokenSize      int
+	tokenFingerprints bool
+	rangeAdmission    *admissionSketch
+	// set by NewTier
+	tier bool
 }
 
 // SetCachedEntities sets the entities that will write and read from the fallback
@@ -82,86 +668,465 @@ func (c *Connector) SetCachedEntities(entities ...dosa.DomainObject) {
 
 // Upsert dual writes to the fallback cache and the origin
 func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
-	w := func() error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	values = c.canonicalColumns(ei, values)
+	if c.isTier(ei) {
+		return c.tierUpsert(ctx, ei, values)
+	}
+	ctx, span := c.startSpan(ctx, "dosa.cache.Upsert", entityName(ei))
+	defer span.Finish()
+
+	if c.isCacheable(ei) && !c.readOnlyPopulation {
+		c.writeBack(rowsSize(values), c.upsertEntry(ctx, ei, values))
+	}
+
+	err := c.Next.Upsert(ctx, ei, values)
+	tagOrigin(span, err)
+	return err
+}
+
+// upsertEntry returns the write of the fallback entry for an upserted row
+func (c *Connector) upsertEntry(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) func() error {
+	return func() error {
 		newCtx, cancel := createContextForFallback(ctx)
 		defer cancel()
 
-		cacheKey := createCacheKey(ei, values, c.encoder)
-		cacheValue, err := c.encoder.Encode(values)
+		e := c.encoderFor(ei)
+		cacheKey := createCacheKey(ei, values, e)
+		if g := c.fieldGroups[ei.Def.Name]; g != nil {
+			return c.upsertGroups(newCtx, ei, e, g, cacheKey, values)
+		}
+		cacheValue, err := c.encodeEntry(ei, e, values)
 		if err != nil {
 			return err
 		}
 		adaptedEi := adaptToKeyValue(ei)
-		newValues := map[string]dosa.FieldValue{
-			key:   cacheKey,
-			value: cacheValue,
-		}
-		return c.fallback.Upsert(newCtx, adaptedEi, newValues)
-	}
-	if c.isCacheable(ei) {
-		_ = c.cacheWrite(w)
+		return c.writeFallback(newCtx, adaptedEi, cacheKey, cacheValue)
 	}
-
-	return c.Next.Upsert(ctx, ei, values)
 }
 
 func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, minimumFields []string) (values map[string]dosa.FieldValue, err error) {
-	// Read from source of truth first
-	source, sourceErr := c.Next.Read(ctx, ei, keys, dosa.All())
-	// If we are not caching for this entity, just return
-	if !c.isCacheable(ei) {
+	// nobody is waiting for the answer of a call whose context is already
+	// done, so don't spend an origin call or a fallback write on it
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	keys = c.canonicalColumns(ei, keys)
+	if c.isTier(ei) {
+		return c.tierRead(ctx, ei, keys, minimumFields)
+	}
+	ctx, span := c.startSpan(ctx, "dosa.cache.Read", entityName(ei))
+	defer span.Finish()
+
+	// If we are not caching for this entity, or the caller requires an
+	// answer from origin, just read from origin
+	if !c.isCacheable(ei) || dosa.ConsistencyFromContext(ctx) == dosa.Strong {
+		source, sourceErr := c.readOrigin(ctx, ei, keys)
+		tagOrigin(span, sourceErr)
 		return source, sourceErr
 	}
 
-	cacheKey := createCacheKey(ei, keys, c.encoder)
-	adaptedEi := adaptToKeyValue(ei)
+	// the encoder is resolved once so that a concurrent SetEncoder cannot
+	// make this read look up an entry with one encoder and decode it, or
+	// write it back, with another
+	e := c.encoderFor(ei)
+	cacheKey := createCacheKey(ei, keys, e)
+	if g := c.fieldGroups[ei.Def.Name]; g != nil {
+		return c.readGroups(ctx, span, ei, e, g, keys, minimumFields, cacheKey)
+	}
+	if c.hedgeDelay > 0 {
+		return c.hedgedRead(ctx, span, ei, e, keys, cacheKey)
+	}
+
+	// Read from source of truth first
+	source, sourceErr := c.readOrigin(ctx, ei, keys)
+	tagOrigin(span, sourceErr)
 	// if source of truth is good, return result and write result to cache
 	if sourceErr == nil {
-		w := func() error {
-			newCtx, cancel := createContextForFallback(ctx)
-			defer cancel()
+		c.writeBackRead(ctx, ei, e, cacheKey, source)
+		return source, sourceErr
+	}
+	// if source of truth fails, try the fallback. If the fallback fails,
+	// return the original error
+	entry, err := c.lookupFallback(ctx, ei, e, keys, cacheKey)
+	return c.serveFallback(span, ei, e, keys, cacheKey, source, sourceErr, entry, err)
+}
 
-			cacheValue, err := c.encoder.Encode(source)
-			if err != nil {
-				return err
-			}
-			newValues := map[string]dosa.FieldValue{
-				key:   cacheKey,
-				value: cacheValue}
+// writeBackRead schedules the write of a row read from origin to the fallback
+func (c *Connector) writeBackRead(ctx context.Context, ei *dosa.EntityInfo, e Encoder, cacheKey []byte, source map[string]dosa.FieldValue) {
+	if !c.populateOnMiss {
+		return
+	}
+	if c.skipEmptyReads && len(source) == 0 {
+		atomic.AddInt64(&c.counters.emptyReadsSkipped, 1)
+		return
+	}
+	c.writeBack(rowsSize(source), func() error {
+		newCtx, cancel := createContextForFallback(ctx)
+		defer cancel()
 
-			return c.fallback.Upsert(newCtx, adaptedEi, newValues)
+		cacheValue, err := c.encodeEntry(ei, e, source)
+		if err != nil {
+			return err
 		}
-		_ = c.cacheWrite(w)
+		return c.writeFallback(newCtx, adaptToKeyValue(ei), cacheKey, cacheValue)
+	})
+}
 
-		return source, sourceErr
+// lookupFallback reads and decodes the point read entry stored under cacheKey
+func (c *Connector) lookupFallback(ctx context.Context, ei *dosa.EntityInfo, e Encoder, keys map[string]dosa.FieldValue, cacheKey []byte) (*cacheEntry, error) {
+	value, err := c.getValueFromFallback(ctx, adaptToKeyValue(ei), cacheKey)
+	if c.oldKeyBuilder != nil && ErrorIsCacheMiss(err) {
+		if migrated, merr := c.migrateKey(ctx, ei, keys, cacheKey); merr == nil {
+			value, err = migrated, nil
+		}
 	}
-	// if source of truth fails, try the fallback. If the fallback fails,
-	// return the original error
-	value, err := c.getValueFromFallback(ctx, adaptedEi, cacheKey)
 	c.logFallback("READ", err)
 	if err != nil {
-		return source, sourceErr
+		return nil, err
 	}
-	result := map[string]dosa.FieldValue{}
-	err = c.encoder.Decode(value, &result)
+	entry, err := c.decodeEntry(e, value)
 	if err != nil {
+		return nil, err
+	}
+	c.applyPin(ei, cacheKey, entry)
+	return entry, nil
+}
+
+// serveFallback returns the values of a fallback entry found by
+// lookupFallback, or the origin result if the lookup failed or the entry has
+// expired
+func (c *Connector) serveFallback(span SpanEnder, ei *dosa.EntityInfo, e Encoder, keys map[string]dosa.FieldValue, cacheKey []byte,
+	source map[string]dosa.FieldValue, sourceErr error, entry *cacheEntry, lookupErr error) (map[string]dosa.FieldValue, error) {
+	if lookupErr != nil {
+		c.recordFallback(span, ei, false)
+		return source, c.fallbackFailed(sourceErr, lookupErr)
+	}
+	now := c.now()
+	if entry.expired(now) {
+		c.recordFallback(span, ei, false)
 		return source, sourceErr
 	}
-	return result, err
+	c.recordFallback(span, ei, true)
+	c.recordServedAge(ei, entry.WrittenAt)
+	if entry.stale(now) || (c.refreshAhead > 0 && entry.TTL > 0 && entry.remaining(now) < c.refreshAhead) {
+		c.scheduleRefresh(ei, e, keys, cacheKey)
+	}
+	if c.sampleDivergence() {
+		c.scheduleDivergenceCheck(ei, e, keys, entry.Values)
+	}
+	return c.served(entry.Values), nil
+}
+
+// readOrigin reads the row from origin, through the read batcher if one is
+// configured
+func (c *Connector) readOrigin(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) (map[string]dosa.FieldValue, error) {
+	var source map[string]dosa.FieldValue
+	var err error
+	if c.batcher != nil && hasDefinition(ei) {
+		source, err = c.batcher.read(ctx, ei, keys)
+	} else {
+		source, err = c.Next.Read(ctx, ei, keys, dosa.All())
+	}
+	return c.canonicalColumns(ei, source), err
+}
+
+// sampleDivergence decides whether a fallback serve should be checked
+// against origin
+func (c *Connector) sampleDivergence() bool {
+	switch {
+	case c.divergenceRate <= 0:
+		return false
+	case c.divergenceRate >= 1:
+		return true
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.rng.Float64() < c.divergenceRate
+}
+
+// scheduleDivergenceCheck re-reads the row from origin in the background and
+// records whether it differs from the values served out of the fallback.
+// Checks where origin is still failing are not counted.
+func (c *Connector) scheduleDivergenceCheck(ei *dosa.EntityInfo, e Encoder, keys, served map[string]dosa.FieldValue) {
+	_ = c.cacheWrite(func() error {
+		newCtx, cancel := createContextForFallback(context.Background())
+		defer cancel()
+
+		source, err := c.Next.Read(newCtx, ei, keys, dosa.All())
+		if err != nil {
+			return err
+		}
+		// source goes through the encoder like the served values did, which
+		// also drops the excluded columns that are never served
+		encoded, err := c.encodeEntry(ei, e, source)
+		if err != nil {
+			return err
+		}
+		normalized, err := c.decodeEntry(e, encoded)
+		if err != nil {
+			return err
+		}
+		atomic.AddInt64(&c.counters.divergenceChecks, 1)
+		diverged := !dosa.FieldValuesEqual(served, normalized.Values)
+		if diverged {
+			atomic.AddInt64(&c.counters.divergences, 1)
+		}
+		if c.stats != nil {
+			s := c.stats.SubScope("fallback").SubScope("divergence").Tagged(map[string]string{"entity": ei.Def.Name})
+			s.Counter("checks").Inc(1)
+			if diverged {
+				s.Counter("diverged").Inc(1)
+			}
+		}
+		return nil
+	})
+}
+
+// scheduleRefresh re-reads the row from origin and rewrites its fallback
+// entry, unless a refresh for the same entry is already in flight
+func (c *Connector) scheduleRefresh(ei *dosa.EntityInfo, e Encoder, keys map[string]dosa.FieldValue, cacheKey []byte) {
+	if c.writeBacksPaused() {
+		atomic.AddInt64(&c.counters.skippedWriteBacks, 1)
+		return
+	}
+	refreshKey := ei.Def.Name + string(cacheKey)
+	c.mux.Lock()
+	if c.refreshing[refreshKey] {
+		c.mux.Unlock()
+		return
+	}
+	c.refreshing[refreshKey] = true
+	c.mux.Unlock()
+
+	_ = c.cacheWrite(func() error {
+		defer func() {
+			c.mux.Lock()
+			delete(c.refreshing, refreshKey)
+			c.mux.Unlock()
+		}()
+		// the refresh outlives the read that triggered it, so it must not
+		// be tied to the caller's context
+		newCtx, cancel := createContextForFallback(context.Background())
+		defer cancel()
+
+		source, err := c.Next.Read(newCtx, ei, keys, dosa.All())
+		if err != nil {
+			return err
+		}
+		source = c.canonicalColumns(ei, source)
+		if c.skipEmptyReads && len(source) == 0 {
+			atomic.AddInt64(&c.counters.emptyReadsSkipped, 1)
+			return nil
+		}
+		cacheValue, err := c.encodeEntry(ei, e, source)
+		if err != nil {
+			return err
+		}
+		return c.writeFallback(newCtx, adaptToKeyValue(ei), cacheKey, cacheValue)
+	})
+}
+
+// writeFallback stores an encoded entry in the fallback and counts it
+func (c *Connector) writeFallback(ctx context.Context, adaptedEi *dosa.EntityInfo, cacheKey, cacheValue []byte) error {
+	cacheKey = c.storedKey(cacheKey)
+	cacheValue = c.sealValue(c.compressValue(cacheValue))
+	newValues := map[string]dosa.FieldValue{
+		key:   cacheKey,
+		value: cacheValue,
+	}
+	err := c.upsertFallback(ctx, adaptedEi, newValues)
+	c.recordWriteResult(err)
+	if err != nil {
+		atomic.AddInt64(&c.counters.writeErrors, 1)
+		return err
+	}
+	atomic.AddInt64(&c.counters.writes, 1)
+	if ec := c.countersOf(adaptedEi.Def.Name); ec != nil {
+		atomic.AddInt64(&ec.writes, 1)
+	}
+	atomic.AddInt64(&c.counters.bytesWritten, int64(len(cacheKey)+len(cacheValue)))
+	return nil
+}
+
+// encodeEntry wraps values in a cacheEntry stamped with the current time and
+// encodes it with e
+func (c *Connector) encodeEntry(ei *dosa.EntityInfo, e Encoder, values map[string]dosa.FieldValue) ([]byte, error) {
+	return c.encodeEntryTTL(ei, e, values, c.ttl)
+}
+
+// encodeEntryTTL is encodeEntry with a TTL other than the connector's
+func (c *Connector) encodeEntryTTL(ei *dosa.EntityInfo, e Encoder, values map[string]dosa.FieldValue, ttl time.Duration) ([]byte, error) {
+	data, err := e.Encode(cacheEntry{
+		Values:    c.stripExcluded(ei, c.canonicalColumns(ei, values)),
+		WrittenAt: c.now(),
+		TTL:       ttl,
+		ContentID: c.contentID,
+		SoftTTL:   c.softTTLFor(ttl),
+	})
+	if err != nil {
+		return nil, newCacheError(ErrCacheEncode, err)
+	}
+	return data, nil
+}
+
+// softTTLFor returns the soft TTL of an entry with the given hard TTL. A soft
+// TTL that is not shorter than the hard one would never take effect, so it
+// is left out.
+func (c *Connector) softTTLFor(ttl time.Duration) time.Duration {
+	if c.softTTL <= 0 || (ttl > 0 && c.softTTL >= ttl) {
+		return 0
+	}
+	return c.softTTL
+}
+
+// stripExcluded returns values without the entity's excluded columns. The
+// map is copied when something has to be removed, since it is also returned
+// to the caller.
+func (c *Connector) stripExcluded(ei *dosa.EntityInfo, values map[string]dosa.FieldValue) map[string]dosa.FieldValue {
+	excluded := c.excludedColumns[ei.Def.Name]
+	if len(excluded) == 0 {
+		return values
+	}
+	stripped := make(map[string]dosa.FieldValue, len(values))
+	for name, v := range values {
+		if !excluded[name] {
+			stripped[name] = v
+		}
+	}
+	return stripped
+}
+
+// encodeRange encodes a page of rows read from origin as a range entry
+func (c *Connector) encodeRange(ei *dosa.EntityInfo, e Encoder, sourceRows []map[string]dosa.FieldValue, tokenNext string) ([]byte, error) {
+	rows := make([]map[string]dosa.FieldValue, len(sourceRows))
+	for i, row := range sourceRows {
+		rows[i] = c.stripExcluded(ei, row)
+	}
+	rangeResults := rangeResults{
+		TokenNext: tokenNext,
+		Rows:      rows,
+		Empty:     len(sourceRows) == 0,
+	}
+	if ttl := c.rangeEntryTTL(); ttl > 0 {
+		now := c.now()
+		rangeResults.WrittenAt = &now
+		rangeResults.TTL = ttl
+	}
+	cacheValue, err := e.Encode(rangeResults)
+	if err != nil {
+		return nil, newCacheError(ErrCacheEncode, err)
+	}
+	return cacheValue, nil
+}
+
+// served returns values as they should be handed to a caller once served
+// from the fallback: as they are, or copied when WithCopyOnRead is set
+func (c *Connector) served(values map[string]dosa.FieldValue) map[string]dosa.FieldValue {
+	if !c.copyOnRead || values == nil {
+		return values
+	}
+	copied := make(map[string]dosa.FieldValue, len(values))
+	for name, v := range values {
+		if b, ok := v.([]byte); ok && b != nil {
+			v = append([]byte{}, b...)
+		}
+		copied[name] = v
+	}
+	return copied
+}
+
+// servedRows is served for each of rows
+func (c *Connector) servedRows(rows []map[string]dosa.FieldValue) []map[string]dosa.FieldValue {
+	if !c.copyOnRead {
+		return rows
+	}
+	copied := make([]map[string]dosa.FieldValue, len(rows))
+	for i, row := range rows {
+		copied[i] = c.served(row)
+	}
+	return copied
+}
+
+// decodeEntry decodes a point read entry. Entries written before the
+// envelope was introduced only hold the values, so they are decoded as an
+// entry without a write time or TTL.
+func (c *Connector) decodeEntry(e Encoder, data []byte) (*cacheEntry, error) {
+	entry := &cacheEntry{}
+	if err := e.Decode(data, entry); err == nil && (entry.Values != nil || !entry.WrittenAt.IsZero()) {
+		if entry.Values == nil {
+			entry.Values = map[string]dosa.FieldValue{}
+		}
+		c.checkContentID(entry.ContentID)
+		return entry, nil
+	}
+	values := map[string]dosa.FieldValue{}
+	if err := e.Decode(data, &values); err != nil {
+		return nil, newCacheError(ErrCacheDecode, err)
+	}
+	return &cacheEntry{Values: values}, nil
 }
 
 // Range returns range from origin, reverts to fallback if origin fails
 func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, minimumFields []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
-	sourceRows, sourceToken, sourceErr := c.Next.Range(ctx, ei, columnConditions, dosa.All(), token, limit)
+	columnConditions = c.canonicalConditions(ei, columnConditions)
 	if !c.isCacheable(ei) {
-		return sourceRows, sourceToken, sourceErr
+		return c.rangePage(ctx, ei, columnConditions, token, limit)
+	}
+	// a cancelled call must not read the fallback to resolve its handle
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+	var err error
+	if c.maxTokenSize > 0 {
+		if token, err = c.resolveTokenHandle(ctx, ei, token); err != nil {
+			return nil, "", err
+		}
+	}
+	if c.tokenFingerprints {
+		if token, err = checkRangeToken(columnConditions, token); err != nil {
+			return nil, "", err
+		}
+	}
+	rows, next, err := c.rangePage(ctx, ei, columnConditions, token, limit)
+	if c.tokenFingerprints {
+		next = fingerprintToken(columnConditions, next)
+	}
+	if c.maxTokenSize > 0 {
+		next = c.tokenHandle(ctx, ei, next)
+	}
+	return rows, next, err
+}
+
+// rangePage serves a Range call once its token is resolved
+func (c *Connector) rangePage(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+	ctx, span := c.startSpan(ctx, "dosa.cache.Range", entityName(ei))
+	defer span.Finish()
+
+	if c.fallbackPaging == RepageFromCache && c.isCacheable(ei) {
+		page, ok, err := parseFallbackPage(token)
+		if err != nil {
+			return nil, "", err
+		}
+		if ok {
+			rows, next, err := c.rangeFromCachedPage(ctx, ei, c.encoderFor(ei), columnConditions, page, limit)
+			c.recordFallback(span, ei, err == nil)
+			return rows, next, err
+		}
 	}
-	keysMap := rangeQuery{
-		Conditions: dosa.NormalizeConditions(columnConditions),
-		Token:      token,
-		Limit:      limit,
+	sourceRows, sourceToken, sourceErr := c.Next.Range(ctx, ei, columnConditions, dosa.All(), token, limit)
+	sourceRows = c.canonicalRows(ei, sourceRows)
+	tagOrigin(span, sourceErr)
+	if !c.isCacheable(ei) || dosa.ConsistencyFromContext(ctx) == dosa.Strong {
+		return sourceRows, sourceToken, sourceErr
 	}
-	cacheKey, _ := c.encoder.Encode(keysMap)
+	e := c.encoderFor(ei)
+	cacheKey := rangeCacheKey(e, columnConditions, token, limit)
 	adaptedEi := adaptToKeyValue(ei)
 
 	if sourceErr == nil {
@@ -169,36 +1134,63 @@ func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnCondit
 			newCtx, cancel := createContextForFallback(ctx)
 			defer cancel()
 
-			rangeResults := rangeResults{
-				TokenNext: sourceToken,
-				Rows:      sourceRows,
-			}
-			cacheValue, err := c.encoder.Encode(rangeResults)
+			cacheValue, err := c.encodeRange(ei, e, sourceRows, sourceToken)
 			if err != nil {
 				return err
 			}
-			newValues := map[string]dosa.FieldValue{
-				key:   cacheKey,
-				value: cacheValue,
-			}
-
-			return c.fallback.Upsert(newCtx, adaptedEi, newValues)
+			return c.writeFallback(newCtx, adaptedEi, cacheKey, cacheValue)
+		}
+		fullEnough := !c.cacheFullPagesOnly || limit <= 0 || len(sourceRows) >= limit
+		if fullEnough && c.admitRange(cacheKey) {
+			c.writeBack(rowsSize(sourceRows...), w)
+		}
+		if c.rangePrefetch && sourceToken != "" {
+			c.prefetchRange(ei, e, columnConditions, sourceToken, limit)
 		}
-		_ = c.cacheWrite(w)
 
 		return sourceRows, sourceToken, sourceErr
 	}
 	value, err := c.getValueFromFallback(ctx, adaptedEi, cacheKey)
 	c.logFallback("RANGE", err)
 	if err != nil {
-		return sourceRows, sourceToken, sourceErr
+		c.recordFallback(span, ei, false)
+		return sourceRows, sourceToken, c.fallbackFailed(sourceErr, err)
 	}
 	unpack := rangeResults{}
-	err = c.encoder.Decode(value, &unpack)
+	err = e.Decode(value, &unpack)
 	if err != nil {
+		err = newCacheError(ErrCacheDecode, err)
+		c.recordFallback(span, ei, false)
+		return sourceRows, sourceToken, c.fallbackFailed(sourceErr, err)
+	}
+	if unpack.expired(c.now()) {
+		c.recordFallback(span, ei, false)
 		return sourceRows, sourceToken, sourceErr
 	}
-	return unpack.Rows, unpack.TokenNext, err
+	// range entries only record when they were written if they expire
+	if unpack.WrittenAt != nil && (unpack.Empty || len(unpack.Rows) > 0) {
+		c.recordServedAge(ei, *unpack.WrittenAt)
+	}
+	if unpack.Empty {
+		c.recordFallback(span, ei, true)
+		return []map[string]dosa.FieldValue{}, unpack.TokenNext, nil
+	}
+	if len(unpack.Rows) == 0 {
+		// nothing usable was cached, treat it as a miss
+		c.recordFallback(span, ei, false)
+		return sourceRows, sourceToken, sourceErr
+	}
+	c.recordFallback(span, ei, true)
+	rows, next := c.pageRows(&unpack, token, limit)
+	return c.servedRows(rows), next, nil
+}
+
+// rangeEntryTTL returns the TTL for range entries
+func (c *Connector) rangeEntryTTL() time.Duration {
+	if c.rangeTTL > 0 {
+		return c.rangeTTL
+	}
+	return c.ttl
 }
 
 // Scan returns scan result from origin.
@@ -209,23 +1201,306 @@ func (c *Connector) Scan(ctx context.Context, ei *dosa.EntityInfo, minimumFields
 
 // Remove deletes an entry
 func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
-	w := func() error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	keys = c.canonicalColumns(ei, keys)
+	if c.isTier(ei) {
+		return c.tierRemove(ctx, ei, keys)
+	}
+	if c.isCacheable(ei) && !c.readOnlyPopulation {
+		_ = c.cacheWrite(c.removeEntry(ctx, ei, keys))
+	}
+
+	return c.Next.Remove(ctx, ei, keys)
+}
+
+// removeEntry returns the removal of the fallback entry for a removed row
+func (c *Connector) removeEntry(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) func() error {
+	return func() error {
 		newCtx, cancel := createContextForFallback(ctx)
 		defer cancel()
-		cacheKey := createCacheKey(ei, keys, c.encoder)
+		cacheKey := createCacheKey(ei, keys, c.encoderFor(ei))
+		if g := c.fieldGroups[ei.Def.Name]; g != nil {
+			return c.removeGroups(newCtx, ei, cacheKey, g.all(ei))
+		}
 		adaptedEi := adaptToKeyValue(ei)
-		return c.fallback.Remove(newCtx, adaptedEi, map[string]dosa.FieldValue{key: cacheKey})
+		if c.evictionWindow > 0 {
+			c.evict(adaptedEi, c.storedKey(cacheKey))
+			return nil
+		}
+		if err := c.fallback.Remove(newCtx, adaptedEi, map[string]dosa.FieldValue{key: c.storedKey(cacheKey)}); err != nil {
+			return err
+		}
+		atomic.AddInt64(&c.counters.removes, 1)
+		return nil
 	}
+}
+
+// warmPageSize is the number of rows WarmByScan asks origin for at a time
+const warmPageSize = 100
 
-	if c.isCacheable(ei) {
-		_ = c.cacheWrite(w)
+// WarmByScan pre-populates the fallback with every row of the entity. It
+// pages through origin with Scan and writes each row under the same key a
+// point Read of that row uses. Writes are done synchronously, paced by
+// WithWarmRate, and the first error stops the sweep.
+func (c *Connector) WarmByScan(ctx context.Context, ei *dosa.EntityInfo) error {
+	if !c.isCacheable(ei) {
+		return notCachedError(ei)
+	}
+	var interval time.Duration
+	if c.warmRate > 0 {
+		interval = time.Duration(float64(time.Second) / c.warmRate)
 	}
 
-	return c.Next.Remove(ctx, ei, keys)
+	token := ""
+	for {
+		rows, next, err := c.Next.Scan(ctx, ei, dosa.All(), token, warmPageSize)
+		if err != nil && !dosa.ErrorIsNotFound(err) {
+			return err
+		}
+		for _, row := range rows {
+			if interval > 0 {
+				timer := time.NewTimer(interval)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return ctx.Err()
+				case <-timer.C:
+				}
+			}
+			e := c.encoderFor(ei)
+			cacheKey, err := buildCacheKey(ei, row, e)
+			if err != nil {
+				return err
+			}
+			if err := c.storeCached(ctx, ei, e, cacheKey, row); err != nil {
+				return err
+			}
+		}
+		if next == "" {
+			return nil
+		}
+		token = next
+	}
+}
+
+// GetCached looks up the row identified by keys in the fallback without
+// consulting origin. The boolean reports whether a live entry was found;
+// missing and expired entries are reported as a miss with a nil error.
+func (c *Connector) GetCached(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) (map[string]dosa.FieldValue, bool, error) {
+	if !c.isCacheable(ei) {
+		return nil, false, notCachedError(ei)
+	}
+	e := c.encoderFor(ei)
+	cacheKey, err := buildFullCacheKey(ei, keys, e)
+	if err != nil {
+		return nil, false, err
+	}
+	if g := c.fieldGroups[ei.Def.Name]; g != nil {
+		return c.getCachedGroups(ctx, ei, e, g, cacheKey)
+	}
+	data, err := c.getValueFromFallback(ctx, adaptToKeyValue(ei), cacheKey)
+	if ErrorIsCacheMiss(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	entry, err := c.decodeEntry(e, data)
+	if err != nil {
+		return nil, false, err
+	}
+	c.applyPin(ei, cacheKey, entry)
+	if entry.expired(c.now()) {
+		return nil, false, nil
+	}
+	return c.served(entry.Values), true, nil
+}
+
+// Refresh reads the row with keys from origin, bypassing the fallback, and
+// writes it to the fallback before returning, whatever the WithPopulateOnMiss
+// and WithReadOnlyPopulation settings. If origin no longer has the row, its
+// entry is removed instead. It implements dosa.Refresher.
+func (c *Connector) Refresh(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	if !c.isCacheable(ei) {
+		return notCachedError(ei)
+	}
+	keys = c.canonicalColumns(ei, keys)
+	e := c.encoderFor(ei)
+	cacheKey, err := buildFullCacheKey(ei, keys, e)
+	if err != nil {
+		return err
+	}
+	values, err := c.Next.Read(ctx, ei, keys, dosa.All())
+	if dosa.ErrorIsNotFound(err) {
+		return c.dropCached(ctx, ei, cacheKey)
+	}
+	if err != nil {
+		return err
+	}
+	return c.storeCached(ctx, ei, e, cacheKey, c.canonicalColumns(ei, values))
+}
+
+// storeCached synchronously writes the fallback entry, or the field groups,
+// of a row read from origin
+func (c *Connector) storeCached(ctx context.Context, ei *dosa.EntityInfo, e Encoder, cacheKey []byte, values map[string]dosa.FieldValue) error {
+	if g := c.fieldGroups[ei.Def.Name]; g != nil {
+		return c.writeGroups(ctx, ei, e, g, cacheKey, values, g.all(ei))
+	}
+	cacheValue, err := c.encodeEntry(ei, e, values)
+	if err != nil {
+		return err
+	}
+	return c.writeFallback(ctx, adaptToKeyValue(ei), cacheKey, cacheValue)
+}
+
+// dropCached synchronously removes the fallback entry, or the field groups,
+// of a row origin no longer has. An entry that is not there is not an error.
+func (c *Connector) dropCached(ctx context.Context, ei *dosa.EntityInfo, cacheKey []byte) error {
+	if g := c.fieldGroups[ei.Def.Name]; g != nil {
+		return c.removeGroups(ctx, ei, cacheKey, g.all(ei))
+	}
+	if err := c.fallback.Remove(ctx, adaptToKeyValue(ei), map[string]dosa.FieldValue{key: c.storedKey(cacheKey)}); err != nil && !dosa.ErrorIsNotFound(err) {
+		return err
+	}
+	atomic.AddInt64(&c.counters.removes, 1)
+	return nil
+}
+
+// NoExpiry is the remaining TTL reported for entries that never expire
+const NoExpiry = time.Duration(math.MaxInt64)
+
+// TTLRemaining returns how long the fallback entry for keys has left before
+// it expires, and whether there is an entry at all. Entries written without a
+// TTL, and pinned ones, report NoExpiry; entries that have expired but are
+// still held by the fallback report zero. Neither origin nor the entry is
+// touched, so asking does not refresh it.
+func (c *Connector) TTLRemaining(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) (time.Duration, bool, error) {
+	if !c.isCacheable(ei) {
+		return 0, false, notCachedError(ei)
+	}
+	e := c.encoderFor(ei)
+	cacheKey, err := buildFullCacheKey(ei, keys, e)
+	if err != nil {
+		return 0, false, err
+	}
+	if g := c.fieldGroups[ei.Def.Name]; g != nil {
+		return c.ttlRemainingGroups(ctx, ei, e, g, cacheKey)
+	}
+	data, err := c.getValueFromFallback(ctx, adaptToKeyValue(ei), cacheKey)
+	if ErrorIsCacheMiss(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	entry, err := c.decodeEntry(e, data)
+	if err != nil {
+		return 0, false, err
+	}
+	c.applyPin(ei, cacheKey, entry)
+	if entry.TTL <= 0 {
+		return NoExpiry, true, nil
+	}
+	if remaining := entry.remaining(c.now()); remaining > 0 {
+		return remaining, true, nil
+	}
+	return 0, true, nil
+}
+
+// PutCached writes values to the fallback without touching origin. values
+// must hold the complete primary key; the entry is stored exactly as a
+// successful origin read of the row would store it. Unlike the automatic
+// write-back, the write is synchronous and its error is returned.
+func (c *Connector) PutCached(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	if !c.isCacheable(ei) {
+		return notCachedError(ei)
+	}
+	e := c.encoderFor(ei)
+	cacheKey, err := buildFullCacheKey(ei, values, e)
+	if err != nil {
+		return err
+	}
+	return c.storeCached(ctx, ei, e, cacheKey, values)
+}
+
+// InvalidateMany removes the fallback entries of the rows identified by
+// keysList, leaving origin untouched. Each element must hold the complete
+// primary key. The entries are removed with a single MultiRemove on the
+// fallback; if the fallback does not support it, they are removed one at a
+// time. Entries that do not exist are not an error. When some removals fail
+// the returned *ErrInvalidateFailed holds the error of each element.
+// Every field group of a row split with WithFieldGroups is removed.
+func (c *Connector) InvalidateMany(ctx context.Context, ei *dosa.EntityInfo, keysList []map[string]dosa.FieldValue) error {
+	if !c.isCacheable(ei) {
+		return notCachedError(ei)
+	}
+	errs := make([]error, len(keysList))
+	var indexes []int
+	var multiKeys []map[string]dosa.FieldValue
+	e := c.encoderFor(ei)
+	names := []string{""}
+	if g := c.fieldGroups[ei.Def.Name]; g != nil {
+		names = g.all(ei)
+	}
+	for i, keys := range keysList {
+		cacheKey, err := buildFullCacheKey(ei, keys, e)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		// every group of a grouped row is removed along with the others
+		for _, name := range names {
+			indexes = append(indexes, i)
+			multiKeys = append(multiKeys, map[string]dosa.FieldValue{key: c.storedKey(groupKey(cacheKey, name))})
+		}
+	}
+
+	adaptedEi := adaptToKeyValue(ei)
+	results, err := c.fallback.MultiRemove(ctx, adaptedEi, multiKeys)
+	if err != nil || len(results) != len(multiKeys) {
+		results = make([]error, len(multiKeys))
+		for j, keys := range multiKeys {
+			results[j] = c.fallback.Remove(ctx, adaptedEi, keys)
+		}
+	}
+	for j, err := range results {
+		if err != nil && !dosa.ErrorIsNotFound(err) {
+			errs[indexes[j]] = err
+			continue
+		}
+		atomic.AddInt64(&c.counters.removes, 1)
+	}
+	return newInvalidateError(errs)
+}
+
+// CacheKey returns the key used to store the row identified by keys in the
+// fallback. It is the same key that Read, Upsert and Remove compute, which
+// makes it useful for looking up entries directly in the fallback store.
+// keys must hold the complete primary key, since no entry is ever stored
+// under the key of a partial one.
+func (c *Connector) CacheKey(ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) ([]byte, error) {
+	if !hasDefinition(ei) {
+		return nil, ErrMissingDefinition
+	}
+	cacheKey, err := buildFullCacheKey(ei, keys, c.encoderFor(ei))
+	if err != nil {
+		return nil, err
+	}
+	return c.storedKey(cacheKey), nil
 }
 
 func (c *Connector) getValueFromFallback(ctx context.Context, ei *dosa.EntityInfo, keyValue []byte) ([]byte, error) {
-	response, err := c.fallback.Read(ctx, ei, map[string]dosa.FieldValue{key: keyValue}, dosa.All())
+	return c.readStored(ctx, ei, c.storedKey(keyValue))
+}
+
+// readStored reads the value stored in the fallback under storedKey
+func (c *Connector) readStored(ctx context.Context, ei *dosa.EntityInfo, storedKey []byte) ([]byte, error) {
+	response, err := c.fallback.Read(ctx, ei, map[string]dosa.FieldValue{key: storedKey}, dosa.All())
+	if dosa.ErrorIsNotFound(err) {
+		return nil, newCacheError(ErrCacheMiss, err)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -233,9 +1508,13 @@ func (c *Connector) getValueFromFallback(ctx context.Context, ei *dosa.EntityInf
 	// unpack the value
 	cacheValue, ok := response[value].([]byte)
 	if !ok {
-		return nil, errors.New("No value in cache for key")
+		return nil, newCacheError(ErrCacheMiss, errors.New("no value in cache for key"))
 	}
-	return cacheValue, nil
+	cacheValue, err = c.openValue(ei, storedKey, cacheValue)
+	if err != nil {
+		return nil, err
+	}
+	return c.decompressValue(cacheValue)
 }
 
 func (c *Connector) logFallback(method string, err error) {
@@ -249,6 +1528,34 @@ func (c *Connector) logFallback(method string, err error) {
 	}
 }
 
+func (c *Connector) writeBacksPaused() bool {
+	if c.pauseAfter <= 0 {
+		return false
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.now().Before(c.pausedUntil)
+}
+
+// recordWriteResult tracks consecutive fallback write failures and starts a
+// pause once there have been pauseAfter of them
+func (c *Connector) recordWriteResult(err error) {
+	if c.pauseAfter <= 0 {
+		return
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if err == nil {
+		c.writeFailures = 0
+		return
+	}
+	c.writeFailures++
+	if c.writeFailures >= c.pauseAfter {
+		c.writeFailures = 0
+		c.pausedUntil = c.now().Add(c.pauseCooldown)
+	}
+}
+
 func (c *Connector) setSynchronousMode(sync bool) {
 	c.synchronous = sync
 }
@@ -257,12 +1564,98 @@ func (c *Connector) cacheWrite(w func() error) error {
 	if c.synchronous {
 		return w()
 	}
-	go func() { _ = w() }()
+	c.mux.Lock()
+	c.pending++
+	c.mux.Unlock()
+	go func() {
+		defer c.writeDone()
+		_ = w()
+	}()
 	return nil
 }
 
+func (c *Connector) writeDone() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.pending--
+	if c.pending == 0 {
+		for _, ch := range c.drained {
+			close(ch)
+		}
+		c.drained = nil
+	}
+}
+
+// Close waits for the fallback writes still running in the background to
+// finish. If ctx is done first, it returns the number of writes that had not
+// completed along with the context's error; those writes may be lost if the
+// process exits. Range prefetches still running are cancelled rather than
+// waited for, and none are started afterwards.
+func (c *Connector) Close(ctx context.Context) (int, error) {
+	c.mux.Lock()
+	if c.cancelPrefetches != nil {
+		c.cancelPrefetches()
+	}
+	if c.pending == 0 {
+		c.mux.Unlock()
+		return 0, nil
+	}
+	ch := make(chan struct{})
+	c.drained = append(c.drained, ch)
+	c.mux.Unlock()
+
+	select {
+	case <-ch:
+		return 0, nil
+	case <-ctx.Done():
+		c.mux.Lock()
+		defer c.mux.Unlock()
+		return c.pending, ctx.Err()
+	}
+}
+
+// SetEncoder replaces the encoder given to NewConnector while the connector
+// is in use. Entities configured with WithEntityEncoders keep their encoder.
+//
+// Each call resolves its encoder once, so a read that is in flight during the
+// swap computes its key, decodes its entry and writes it back with the same
+// encoder, whichever one that is. The entries written with the old encoder
+// are not removed: when the two encoders compute different keys they are no
+// longer found, and otherwise they fail to decode and are treated as misses.
+// Either way a swap effectively invalidates them, so expect origin load to
+// rise while the fallback is repopulated.
+func (c *Connector) SetEncoder(e Encoder) {
+	c.encoderMux.Lock()
+	defer c.encoderMux.Unlock()
+	c.encoder = e
+}
+
+// encoderFor returns the encoder for the entity's cache keys and values
+func (c *Connector) encoderFor(ei *dosa.EntityInfo) Encoder {
+	if e, ok := c.entityEncoders[ei.Def.Name]; ok {
+		return e
+	}
+	c.encoderMux.RLock()
+	defer c.encoderMux.RUnlock()
+	return c.encoder
+}
+
 func (c *Connector) isCacheable(ei *dosa.EntityInfo) bool {
-	return c.cacheableEntities[ei.Def.Name]
+	return hasDefinition(ei) && c.cacheableEntities[ei.Def.Name]
+}
+
+// hasDefinition reports whether ei can be cached at all. Calls for an entity
+// without a definition skip the fallback and go to origin.
+func hasDefinition(ei *dosa.EntityInfo) bool {
+	return ei != nil && ei.Def != nil
+}
+
+// entityName returns the name of the entity, empty if it has no definition
+func entityName(ei *dosa.EntityInfo) string {
+	if !hasDefinition(ei) {
+		return ""
+	}
+	return ei.Def.Name
 }
 
 // returns a set of entity names that should go through the fallback cache
@@ -282,12 +1675,15 @@ func createCachedEntitiesSet(entities []dosa.DomainObject) map[string]bool {
 	return set
 }
 
-// new entity info is being derived from the original to support the structure of a caching connector
+// new entity info is being derived from the original to support the structure of a caching connector.
+// Callers check hasDefinition first; an entity without a definition gets an unnamed table rather than a panic.
 func adaptToKeyValue(ei *dosa.EntityInfo) *dosa.EntityInfo {
 	adaptedEi := &dosa.EntityInfo{}
-	adaptedEi.Ref = ei.Ref
+	if ei != nil {
+		adaptedEi.Ref = ei.Ref
+	}
 	adaptedEi.Def = &dosa.EntityDefinition{
-		Name: ei.Def.Name,
+		Name: entityName(ei),
 		Key: &dosa.PrimaryKey{
 			PartitionKeys: []string{key},
 		},
@@ -301,6 +1697,16 @@ func adaptToKeyValue(ei *dosa.EntityInfo) *dosa.EntityInfo {
 
 // used for single entry reads/writes
 func createCacheKey(ei *dosa.EntityInfo, values map[string]dosa.FieldValue, e Encoder) []byte {
+	cacheKey, err := buildCacheKey(ei, values, e)
+	if err != nil {
+		return []byte{}
+	}
+	return cacheKey
+}
+
+// buildCacheKey encodes the primary key values found in values, ordered by
+// column name so that the encoding is deterministic
+func buildCacheKey(ei *dosa.EntityInfo, values map[string]dosa.FieldValue, e Encoder) ([]byte, error) {
 	keys := []string{}
 	for pk := range ei.Def.KeySet() {
 		if _, ok := values[pk]; ok {
@@ -309,7 +1715,7 @@ func createCacheKey(ei *dosa.EntityInfo, values map[string]dosa.FieldValue, e En
 	}
 
 	if len(keys) == 0 {
-		return []byte{}
+		return []byte{}, nil
 	}
 
 	// sort the keys so that we encode in a deterministic order
@@ -319,11 +1725,91 @@ func createCacheKey(ei *dosa.EntityInfo, values map[string]dosa.FieldValue, e En
 		orderedKeys = append(orderedKeys, map[string]dosa.FieldValue{k: values[k]})
 	}
 
-	cacheKey, err := e.Encode(orderedKeys)
-	if err != nil {
-		return []byte{}
+	return e.Encode(orderedKeys)
+}
+
+// buildFullCacheKey is buildCacheKey for callers that must identify a single
+// row, so every primary key column has to be present
+func buildFullCacheKey(ei *dosa.EntityInfo, values map[string]dosa.FieldValue, e Encoder) ([]byte, error) {
+	missing := []string{}
+	for pk := range ei.Def.KeySet() {
+		if _, ok := values[pk]; !ok {
+			missing = append(missing, pk)
+		}
 	}
-	return cacheKey
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("missing primary key values for entity %q: %v", ei.Def.Name, missing)
+	}
+	return buildCacheKey(ei, values, e)
+}
+
+// operatorRanks orders the operators in canonical range keys. Operators added
+// to dosa later are ranked after these, by their numeric code.
+var operatorRanks = map[dosa.Operator]int{
+	dosa.Eq:     0,
+	dosa.Lt:     1,
+	dosa.LtOrEq: 2,
+	dosa.Gt:     3,
+	dosa.GtOrEq: 4,
+}
+
+func operatorRank(op dosa.Operator) int {
+	if rank, ok := operatorRanks[op]; ok {
+		return rank
+	}
+	return len(operatorRanks) + int(op)
+}
+
+// canonicalizeConditions flattens the conditions into a list sorted by
+// column name, operator and marshaled value. dosa.NormalizeConditions leaves
+// conditions on the same column with the same operator unordered, which
+// would let the key of one range request change between calls.
+func canonicalizeConditions(columnConditions map[string][]*dosa.Condition) []*dosa.ColumnCondition {
+	type keyed struct {
+		cc    *dosa.ColumnCondition
+		value string
+	}
+	list := make([]keyed, 0, len(columnConditions))
+	for column, conds := range columnConditions {
+		for _, cond := range conds {
+			list = append(list, keyed{
+				cc:    &dosa.ColumnCondition{Name: column, Condition: cond},
+				value: marshalConditionValue(cond.Value),
+			})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.cc.Name != b.cc.Name {
+			return a.cc.Name < b.cc.Name
+		}
+		if ra, rb := operatorRank(a.cc.Condition.Op), operatorRank(b.cc.Condition.Op); ra != rb {
+			return ra < rb
+		}
+		return a.value < b.value
+	})
+	if len(list) == 0 {
+		return nil
+	}
+	result := make([]*dosa.ColumnCondition, len(list))
+	for i, k := range list {
+		result[i] = k.cc
+	}
+	return result
+}
+
+// marshalConditionValue returns a sort key for a condition value: its type
+// and JSON encoding, with pointers to nullable types dereferenced
+func marshalConditionValue(v dosa.FieldValue) string {
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return "nil"
+		}
+		v = rv.Elem().Interface()
+	}
+	data, _ := json.Marshal(v)
+	return fmt.Sprintf("%T:%s", v, data)
 }
 
 func createContextForFallback(ctx context.Context) (context.Context, context.CancelFunc) {
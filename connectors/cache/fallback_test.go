@@ -22,6 +22,7 @@ package cache
 
 import (
 	"context"
+	"sync"
 	"testing"
 	"time"
 
@@ -54,8 +55,13 @@ var (
 	cacheableEntities = []dosa.DomainObject{
 		&testentity.TestEntity{},
 	}
+	testNow = time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
 )
 
+func fixedClock() time.Time {
+	return testNow
+}
+
 type BadEncoder struct{}
 
 func (b *BadEncoder) Encode(interface{}) ([]byte, error) {
@@ -119,6 +125,7 @@ func TestUpsertCases(t *testing.T) {
 
 		connector := NewConnector(mockOrigin, mockFallback, tc.encoder, nil, cacheableEntities...)
 		connector.setSynchronousMode(true)
+		connector.now = fixedClock
 		err := connector.Upsert(context.TODO(), testEi, tc.originUpsert.values)
 		assert.NoError(t, err, tc.description)
 	}
@@ -137,7 +144,7 @@ func TestUpsertCases(t *testing.T) {
 			fallbackUpsert: &expectArgs{
 				values: map[string]dosa.FieldValue{
 					key:   []byte(`[{"an_uuid_key":"d1449c93-25b8-4032-920b-60471d91acc9"},{"strkey":"test key string"}]`),
-					value: []byte(`{"BoolV":false,"StrV":"test value string","an_uuid_key":"d1449c93-25b8-4032-920b-60471d91acc9","strkey":"test key string"}`),
+					value: []byte(`{"Values":{"BoolV":false,"StrV":"test value string","an_uuid_key":"d1449c93-25b8-4032-920b-60471d91acc9","strkey":"test key string"},"WrittenAt":"2017-01-01T00:00:00Z","TTL":0}`),
 				},
 			},
 		},
@@ -191,6 +198,7 @@ func TestReadCases(t *testing.T) {
 
 		connector := NewConnector(mockOrigin, mockFallback, tc.encoder, nil, tc.cachedEntities...)
 		connector.setSynchronousMode(true)
+		connector.now = fixedClock
 		t.Run(tc.description, func(t *testing.T) {
 			resp, err := connector.Read(context.TODO(), testEi, tc.originRead.values, []string{})
 			assert.Equal(t, tc.expectedErr, err, tc.description)
@@ -224,7 +232,7 @@ func createReadSuccessTestCase() testCase {
 		fallbackUpsert: &expectArgs{
 			values: map[string]dosa.FieldValue{
 				key:   []byte{},
-				value: []byte(`{"a":"b"}`),
+				value: []byte(`{"Values":{"a":"b"},"WrittenAt":"2017-01-01T00:00:00Z","TTL":0}`),
 			},
 		},
 		expectedResp: originResponse,
@@ -367,6 +375,7 @@ func TestFallbackStats(t *testing.T) {
 		counter      string
 		fallbackResp map[string]dosa.FieldValue
 		fallbackErr  error
+		served       bool
 	}
 	connector := NewConnector(mockOrigin, mockFallback, NewJSONEncoder(), mockStats, cacheableEntities...)
 
@@ -378,6 +387,7 @@ func TestFallbackStats(t *testing.T) {
 		{
 			counter:      "success",
 			fallbackResp: map[string]dosa.FieldValue{"value": []byte("{\"b\": 7}")},
+			served:       true,
 		},
 	}
 	for _, t := range testCases {
@@ -387,11 +397,48 @@ func TestFallbackStats(t *testing.T) {
 		mockStats.EXPECT().Tagged(map[string]string{"method": "READ"}).Return(mockStats)
 		mockStats.EXPECT().Counter(t.counter).Return(mockCounter)
 		mockCounter.EXPECT().Inc(int64(1))
+		if t.served {
+			// the entry has no write time, so it is as old as can be
+			mockStats.EXPECT().SubScope("fallback").Return(mockStats)
+			mockStats.EXPECT().Tagged(map[string]string{"entity": testEi.Def.Name, "age": "older"}).Return(mockStats)
+			mockStats.EXPECT().Counter("served_age").Return(mockCounter)
+			mockCounter.EXPECT().Inc(int64(1))
+		}
 
 		connector.Read(context.TODO(), testEi, nil, []string{})
 	}
 }
 
+func TestServedAge(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	mockStats := mocks.NewMockScope(ctrl)
+	mockCounter := mocks.NewMockCounter(ctrl)
+	connector := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), mockStats, cacheableEntities...)
+	connector.now = func() time.Time { return testNow }
+	keys := batchKeys(1)
+	assert.NoError(t, connector.PutCached(context.TODO(), testEi, keys))
+
+	// ten minutes later origin fails and the entry is served
+	connector.now = func() time.Time { return testNow.Add(10 * time.Minute) }
+	mockOrigin.EXPECT().Read(context.TODO(), testEi, keys, dosa.All()).Return(nil, assert.AnError)
+	mockStats.EXPECT().SubScope("fallback").Return(mockStats).Times(2)
+	mockStats.EXPECT().Tagged(map[string]string{"method": "READ"}).Return(mockStats)
+	mockStats.EXPECT().Counter("success").Return(mockCounter)
+	mockStats.EXPECT().Tagged(map[string]string{"entity": testEi.Def.Name, "age": "10m"}).Return(mockStats)
+	mockStats.EXPECT().Counter("served_age").Return(mockCounter)
+	mockCounter.EXPECT().Inc(int64(1)).Times(2)
+	_, err := connector.Read(context.TODO(), testEi, keys, dosa.All())
+	assert.NoError(t, err)
+
+	assert.Equal(t, "1m", servedAgeBucket(30*time.Second))
+	assert.Equal(t, "10m", servedAgeBucket(9*time.Minute))
+	assert.Equal(t, "1h", servedAgeBucket(11*time.Minute))
+	assert.Equal(t, "1d", servedAgeBucket(23*time.Hour))
+	assert.Equal(t, "older", servedAgeBucket(48*time.Hour))
+}
+
 func TestRangeCases(t *testing.T) {
 	runTestCase := func(tc testCase) {
 		originCtrl := gomock.NewController(t)
@@ -429,6 +476,9 @@ func TestRangeCases(t *testing.T) {
 		createRangeDecodeErrorTestCase(),
 		createRangeFallbackFailTestCase(),
 		createRangeFallbackBadValueTestCase(),
+		createRangeEmptySuccessTestCase(),
+		createRangeEmptyFallbackTestCase(),
+		createRangeEmptyMissTestCase(),
 	}
 	for _, tc := range testCases {
 		runTestCase(tc)
@@ -617,6 +667,66 @@ func createRangeFallbackBadValueTestCase() testCase {
 	}
 }
 
+func createRangeEmptySuccessTestCase() testCase {
+	return testCase{
+		encoder:        NewJSONEncoder(),
+		cachedEntities: cacheableEntities,
+		originRange: &rangeArgs{
+			token: "token",
+			limit: 2,
+			resp:  []map[string]dosa.FieldValue{},
+		},
+		fallbackUpsert: &expectArgs{
+			values: map[string]dosa.FieldValue{
+				key:   []byte(`{"Token":"token","Limit":2}`),
+				value: []byte(`{"Rows":[],"TokenNext":"","Empty":true}`),
+			},
+		},
+		expectedManyResp: []map[string]dosa.FieldValue{},
+		description:      "An empty range from origin is cached as explicitly empty",
+	}
+}
+
+func createRangeEmptyFallbackTestCase() testCase {
+	return testCase{
+		encoder:        NewJSONEncoder(),
+		cachedEntities: cacheableEntities,
+		originRange: &rangeArgs{
+			token: "token",
+			limit: 2,
+			err:   assert.AnError,
+		},
+		fallbackRead: &expectArgs{
+			values: map[string]dosa.FieldValue{
+				key: []byte(`{"Token":"token","Limit":2}`),
+			},
+			resp: map[string]dosa.FieldValue{"value": []byte(`{"Rows":null,"TokenNext":"","Empty":true}`)},
+		},
+		expectedManyResp: []map[string]dosa.FieldValue{},
+		description:      "A cached empty range is served as zero rows when origin fails",
+	}
+}
+
+func createRangeEmptyMissTestCase() testCase {
+	return testCase{
+		encoder:        NewJSONEncoder(),
+		cachedEntities: cacheableEntities,
+		originRange: &rangeArgs{
+			token: "token",
+			limit: 2,
+			err:   assert.AnError,
+		},
+		fallbackRead: &expectArgs{
+			values: map[string]dosa.FieldValue{
+				key: []byte(`{"Token":"token","Limit":2}`),
+			},
+			resp: map[string]dosa.FieldValue{"value": []byte(`{}`)},
+		},
+		expectedErr: assert.AnError,
+		description: "A fallback entry without rows that is not marked empty is a miss",
+	}
+}
+
 // Test scan calls Range
 func TestScan(t *testing.T) {
 	originCtrl := gomock.NewController(t)
@@ -717,6 +827,85 @@ func TestUpsertRead(t *testing.T) {
 	assert.EqualValues(t, values, resp)
 }
 
+func TestReadOnlyPopulation(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	fallback := memory.NewConnector()
+	connector := NewConnector(mockOrigin, fallback, NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithReadOnlyPopulation(true))
+	connector.setSynchronousMode(true)
+
+	keys := map[string]dosa.FieldValue{
+		"an_uuid_key": "d1449c93-25b8-4032-920b-60471d91acc9",
+		"strkey":      "test key string",
+		"int64key":    int64(2932),
+	}
+	row := map[string]dosa.FieldValue{"strv": "written"}
+	for k, v := range keys {
+		row[k] = v
+	}
+
+	// upserts are not cached
+	mockOrigin.EXPECT().Upsert(context.TODO(), testEi, row).Return(nil)
+	assert.NoError(t, connector.Upsert(context.TODO(), testEi, row))
+	_, found, err := connector.GetCached(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.False(t, found)
+	assert.Equal(t, int64(0), connector.Stats().Writes)
+
+	// reads are
+	mockOrigin.EXPECT().Read(context.TODO(), testEi, keys, dosa.All()).Return(map[string]dosa.FieldValue{"strv": "read"}, nil)
+	_, err = connector.Read(context.TODO(), testEi, keys, dosa.All())
+	assert.NoError(t, err)
+	cached, found, err := connector.GetCached(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "read", cached["strv"])
+
+	// and removes leave the entry alone
+	mockOrigin.EXPECT().Remove(context.TODO(), testEi, keys).Return(nil)
+	assert.NoError(t, connector.Remove(context.TODO(), testEi, keys))
+	_, found, err = connector.GetCached(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, int64(0), connector.Stats().Removes)
+}
+
+func TestCancelledContext(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	// neither mock expects any call
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	mockFallback := mocks.NewMockConnector(ctrl)
+	connector := NewConnector(mockOrigin, mockFallback, NewJSONEncoder(), nil, cacheableEntities...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	keys := map[string]dosa.FieldValue{
+		"an_uuid_key": "d1449c93-25b8-4032-920b-60471d91acc9",
+		"strkey":      "test key string",
+		"int64key":    int64(2932),
+	}
+
+	_, err := connector.Read(ctx, testEi, keys, dosa.All())
+	assert.Equal(t, context.Canceled, err)
+	_, _, err = connector.Range(ctx, testEi, nil, dosa.All(), "", 10)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, context.Canceled, connector.Upsert(ctx, testEi, keys))
+	assert.Equal(t, context.Canceled, connector.Remove(ctx, testEi, keys))
+
+	// token handles are not resolved from the fallback either
+	withHandles := NewConnector(mockOrigin, mockFallback, NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithTokenHandles(16))
+	_, _, err = withHandles.Range(ctx, testEi, nil, dosa.All(), tokenHandlePrefix+"handle", 10)
+	assert.Equal(t, context.Canceled, err)
+
+	connector.mux.Lock()
+	assert.Equal(t, 0, connector.pending)
+	connector.mux.Unlock()
+}
+
 // Test the internal method for serializing a cache key
 func TestCreateCacheKey(t *testing.T) {
 	values := map[string]dosa.FieldValue{
@@ -754,3 +943,1157 @@ func TestSettingCachedEntities(t *testing.T) {
 	connector.SetCachedEntities(nil)
 	assert.Empty(t, connector.cacheableEntities)
 }
+
+func TestCanonicalizeConditions(t *testing.T) {
+	ops := []dosa.Operator{dosa.Eq, dosa.Lt, dosa.LtOrEq, dosa.Gt, dosa.GtOrEq}
+
+	// every operator has its own rank, ordered like the operator codes, and
+	// unknown operators sort after all of them
+	ranks := map[int]bool{}
+	for i, op := range ops {
+		ranks[operatorRank(op)] = true
+		if i > 0 {
+			assert.True(t, operatorRank(ops[i-1]) < operatorRank(op), op.String())
+		}
+	}
+	assert.Len(t, ranks, len(ops))
+	assert.Len(t, operatorRanks, len(ops))
+	assert.True(t, operatorRank(dosa.Operator(100)) > operatorRank(dosa.GtOrEq))
+
+	// two conditions per operator on the same column, in every rotation of
+	// the input order
+	var conds []*dosa.Condition
+	for _, op := range ops {
+		conds = append(conds, &dosa.Condition{Op: op, Value: int64(2)}, &dosa.Condition{Op: op, Value: int64(1)})
+	}
+	var expected []*dosa.ColumnCondition
+	for i := range conds {
+		rotated := append(append([]*dosa.Condition{}, conds[i:]...), conds[:i]...)
+		result := canonicalizeConditions(map[string][]*dosa.Condition{
+			"int64key": rotated,
+			"strkey":   {{Op: dosa.Eq, Value: "a"}},
+		})
+		if expected == nil {
+			expected = result
+		}
+		assert.Equal(t, expected, result)
+	}
+	if assert.Len(t, expected, 2*len(ops)+1) {
+		for i, op := range ops {
+			assert.Equal(t, op, expected[2*i].Condition.Op)
+			assert.Equal(t, int64(1), expected[2*i].Condition.Value)
+			assert.Equal(t, int64(2), expected[2*i+1].Condition.Value)
+		}
+		assert.Equal(t, "strkey", expected[2*len(ops)].Name)
+	}
+
+	// the range key does not depend on the order of the conditions either
+	connector := NewConnector(memory.NewConnector(), memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...)
+	key2 := rangeCacheKey(connector.encoder, map[string][]*dosa.Condition{"int64key": {conds[1], conds[0], conds[3], conds[2]}}, "", 10)
+	key3 := rangeCacheKey(connector.encoder, map[string][]*dosa.Condition{"int64key": {conds[0], conds[1], conds[2], conds[3]}}, "", 10)
+	assert.Equal(t, key2, key3)
+	assert.Nil(t, canonicalizeConditions(nil))
+}
+
+// Test that the key returned by the client matches the key of the entry written to the fallback
+func TestClientCacheKeyFor(t *testing.T) {
+	fallback := memory.NewConnector()
+	connector := NewConnector(memory.NewConnector(), fallback, NewJSONEncoder(), nil, cacheableEntities...)
+	connector.setSynchronousMode(true)
+
+	reg, err := dosa.NewRegistrar(schemaRef.Scope, schemaRef.NamePrefix, cacheableEntities...)
+	assert.NoError(t, err)
+	client := dosa.NewClient(reg, connector)
+	assert.NoError(t, client.Initialize(context.TODO()))
+
+	entity := &testentity.TestEntity{
+		UUIDKey:  dosa.NewUUID(),
+		StrKey:   "key",
+		Int64Key: 42,
+		StrV:     "value",
+	}
+	assert.NoError(t, client.Upsert(context.TODO(), dosa.All(), entity))
+
+	cacheKey, err := client.CacheKeyFor(entity)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, cacheKey)
+
+	ei := adaptToKeyValue(testEi)
+	cached, err := fallback.Read(context.TODO(), ei, map[string]dosa.FieldValue{key: cacheKey}, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, cacheKey, cached[key])
+	assert.NotEmpty(t, cached[value])
+}
+
+func TestClientRefresh(t *testing.T) {
+	origin := memory.NewConnector()
+	connector := NewConnector(origin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithPopulateOnMiss(false))
+	connector.setSynchronousMode(true)
+
+	reg, err := dosa.NewRegistrar(schemaRef.Scope, schemaRef.NamePrefix, cacheableEntities...)
+	assert.NoError(t, err)
+	client := dosa.NewClient(reg, connector)
+	assert.NoError(t, client.Initialize(context.TODO()))
+
+	entity := &testentity.TestEntity{
+		UUIDKey:  dosa.NewUUID(),
+		StrKey:   "key",
+		Int64Key: 42,
+		StrV:     "value",
+	}
+	assert.NoError(t, client.Upsert(context.TODO(), dosa.All(), entity))
+	keys := map[string]dosa.FieldValue{"an_uuid_key": entity.UUIDKey, "strkey": "key", "int64key": int64(42)}
+
+	// the row changes in origin behind the cache's back
+	changed := map[string]dosa.FieldValue{"strv": "changed"}
+	for k, v := range keys {
+		changed[k] = v
+	}
+	assert.NoError(t, origin.Upsert(context.TODO(), testEi, changed))
+	cached, _, err := connector.GetCached(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.Equal(t, "value", cached["strv"])
+
+	// refreshing an existing row repopulates its entry
+	assert.NoError(t, client.Refresh(context.TODO(), entity))
+	cached, found, err := connector.GetCached(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "changed", cached["strv"])
+	assert.Equal(t, "value", entity.StrV)
+
+	// refreshing a deleted row evicts it
+	assert.NoError(t, origin.Remove(context.TODO(), testEi, keys))
+	assert.NoError(t, client.Refresh(context.TODO(), entity))
+	_, found, err = connector.GetCached(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	// origin errors are returned and leave the entry alone
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	mockOrigin.EXPECT().Read(context.TODO(), testEi, keys, dosa.All()).Return(nil, assert.AnError)
+	failing := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...)
+	assert.Equal(t, assert.AnError, failing.Refresh(context.TODO(), testEi, keys))
+	uncached := NewConnector(origin, memory.NewConnector(), NewJSONEncoder(), nil)
+	assert.Error(t, uncached.Refresh(context.TODO(), testEi, keys))
+}
+
+func TestCacheKeyNeedsFullKey(t *testing.T) {
+	connector := NewConnector(memory.NewConnector(), memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...)
+	cacheKey, err := connector.CacheKey(testEi, batchKeys(1))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, cacheKey)
+
+	// without its clustering key the row has no entry
+	partial := batchKeys(1)
+	delete(partial, "int64key")
+	_, err = connector.CacheKey(testEi, partial)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "int64key")
+	}
+}
+
+type userCtxKey struct{}
+
+// refreshOrigin fails reads made on behalf of a caller, and blocks reads made
+// by a background refresh until release is closed
+type refreshOrigin struct {
+	dosa.Connector
+	release   chan struct{}
+	mux       sync.Mutex
+	refreshes int
+}
+
+func (o *refreshOrigin) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, minimumFields []string) (map[string]dosa.FieldValue, error) {
+	if ctx.Value(userCtxKey{}) != nil {
+		return nil, assert.AnError
+	}
+	o.mux.Lock()
+	o.refreshes++
+	o.mux.Unlock()
+	<-o.release
+	return o.Connector.Read(ctx, ei, keys, minimumFields)
+}
+
+func TestRefreshAhead(t *testing.T) {
+	values := map[string]dosa.FieldValue{
+		"an_uuid_key": "d1449c93-25b8-4032-920b-60471d91acc9",
+		"strkey":      "test key string",
+		"int64key":    int64(2932),
+		"strv":        "test value string",
+	}
+	origin := &refreshOrigin{Connector: memory.NewConnector(), release: make(chan struct{})}
+	fallback := memory.NewConnector()
+	connector := NewConnector(origin, fallback, NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithTTL(10*time.Minute), WithRefreshAheadWindow(2*time.Minute))
+	connector.setSynchronousMode(true)
+	connector.now = fixedClock
+	assert.NoError(t, connector.Upsert(context.TODO(), testEi, values))
+
+	userCtx := context.WithValue(context.TODO(), userCtxKey{}, true)
+
+	// plenty of TTL left, no refresh
+	connector.now = func() time.Time { return testNow.Add(5 * time.Minute) }
+	resp, err := connector.Read(userCtx, testEi, values, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, "test value string", resp["strv"])
+
+	// near expiry, two reads schedule a single refresh
+	refreshedAt := testNow.Add(9 * time.Minute)
+	connector.now = func() time.Time { return refreshedAt }
+	connector.setSynchronousMode(false)
+	for i := 0; i < 2; i++ {
+		resp, err = connector.Read(userCtx, testEi, values, dosa.All())
+		assert.NoError(t, err)
+		assert.Equal(t, "test value string", resp["strv"])
+	}
+	close(origin.release)
+
+	refreshing := func() bool {
+		connector.mux.Lock()
+		defer connector.mux.Unlock()
+		return len(connector.refreshing) > 0
+	}
+	for deadline := time.Now().Add(time.Second); refreshing() && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	assert.False(t, refreshing())
+	origin.mux.Lock()
+	assert.Equal(t, 1, origin.refreshes)
+	origin.mux.Unlock()
+
+	cacheKey, err := connector.CacheKey(testEi, values)
+	assert.NoError(t, err)
+	data, err := connector.getValueFromFallback(context.TODO(), adaptToKeyValue(testEi), cacheKey)
+	assert.NoError(t, err)
+	entry, err := connector.decodeEntry(connector.encoder, data)
+	assert.NoError(t, err)
+	assert.True(t, refreshedAt.Equal(entry.WrittenAt))
+	assert.Equal(t, 10*time.Minute, entry.TTL)
+
+	// once expired, the entry is no longer served
+	connector.now = func() time.Time { return refreshedAt.Add(10 * time.Minute) }
+	_, err = connector.Read(userCtx, testEi, values, dosa.All())
+	assert.Equal(t, assert.AnError, err)
+}
+
+func TestSoftTTL(t *testing.T) {
+	values := map[string]dosa.FieldValue{
+		"an_uuid_key": "d1449c93-25b8-4032-920b-60471d91acc9",
+		"strkey":      "test key string",
+		"int64key":    int64(2932),
+		"strv":        "test value string",
+	}
+	origin := &refreshOrigin{Connector: memory.NewConnector(), release: make(chan struct{})}
+	close(origin.release)
+	connector := NewConnector(origin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithSoftTTL(5*time.Minute, 10*time.Minute))
+	connector.setSynchronousMode(true)
+	connector.now = fixedClock
+	assert.NoError(t, connector.Upsert(context.TODO(), testEi, values))
+	userCtx := context.WithValue(context.TODO(), userCtxKey{}, true)
+	refreshes := func() int {
+		origin.mux.Lock()
+		defer origin.mux.Unlock()
+		return origin.refreshes
+	}
+	writtenAt := func() time.Time {
+		cacheKey, err := connector.CacheKey(testEi, values)
+		assert.NoError(t, err)
+		data, err := connector.getValueFromFallback(context.TODO(), adaptToKeyValue(testEi), cacheKey)
+		assert.NoError(t, err)
+		entry, err := connector.decodeEntry(connector.encoder, data)
+		assert.NoError(t, err)
+		assert.Equal(t, 5*time.Minute, entry.SoftTTL)
+		assert.Equal(t, 10*time.Minute, entry.TTL)
+		return entry.WrittenAt
+	}
+
+	// within the soft TTL the entry is served as it is
+	connector.now = func() time.Time { return testNow.Add(4 * time.Minute) }
+	resp, err := connector.Read(userCtx, testEi, values, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, "test value string", resp["strv"])
+	assert.Equal(t, 0, refreshes())
+	assert.True(t, testNow.Equal(writtenAt()))
+
+	// past it the entry is still served, and refreshed from origin
+	refreshedAt := testNow.Add(7 * time.Minute)
+	connector.now = func() time.Time { return refreshedAt }
+	resp, err = connector.Read(userCtx, testEi, values, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, "test value string", resp["strv"])
+	assert.Equal(t, 1, refreshes())
+	assert.True(t, refreshedAt.Equal(writtenAt()))
+
+	// past the hard TTL it is a miss
+	connector.now = func() time.Time { return refreshedAt.Add(10 * time.Minute) }
+	_, err = connector.Read(userCtx, testEi, values, dosa.All())
+	assert.Equal(t, assert.AnError, err)
+	assert.Equal(t, 1, refreshes())
+}
+
+func TestDecodeLegacyEntry(t *testing.T) {
+	for _, e := range []Encoder{NewJSONEncoder(), NewGobEncoder()} {
+		connector := NewConnector(nil, nil, e, nil)
+		data, err := e.Encode(map[string]dosa.FieldValue{"a": "b"})
+		assert.NoError(t, err)
+		entry, err := connector.decodeEntry(connector.encoder, data)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]dosa.FieldValue{"a": "b"}, entry.Values)
+		assert.False(t, entry.expired(time.Now()))
+	}
+}
+
+// blockingFallback holds every upsert until release is closed
+type blockingFallback struct {
+	dosa.Connector
+	release chan struct{}
+}
+
+func (f *blockingFallback) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	<-f.release
+	return f.Connector.Upsert(ctx, ei, values)
+}
+
+func TestCloseFlushesPendingWrites(t *testing.T) {
+	fallback := &blockingFallback{Connector: memory.NewConnector(), release: make(chan struct{})}
+	connector := NewConnector(memory.NewConnector(), fallback, NewJSONEncoder(), nil, cacheableEntities...)
+
+	// nothing pending
+	n, err := connector.Close(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	for i := 0; i < 3; i++ {
+		values := map[string]dosa.FieldValue{
+			"an_uuid_key": "d1449c93-25b8-4032-920b-60471d91acc9",
+			"strkey":      "test key string",
+			"int64key":    int64(i),
+		}
+		assert.NoError(t, connector.Upsert(context.TODO(), testEi, values))
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+	n, err = connector.Close(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 3, n)
+
+	close(fallback.release)
+	n, err = connector.Close(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestStats(t *testing.T) {
+	values := map[string]dosa.FieldValue{
+		"an_uuid_key": "d1449c93-25b8-4032-920b-60471d91acc9",
+		"strkey":      "test key string",
+		"int64key":    int64(2932),
+		"strv":        "test value string",
+	}
+	connector := NewConnector(memory.NewConnector(), memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...)
+	connector.setSynchronousMode(true)
+	assert.Equal(t, Stats{}, connector.Stats())
+
+	assert.NoError(t, connector.Upsert(context.TODO(), testEi, values))
+	stats := connector.Stats()
+	assert.Equal(t, int64(1), stats.Writes)
+	assert.True(t, stats.BytesWritten > 0)
+	assert.Equal(t, int64(0), stats.Removes)
+
+	// reading from origin writes the entry back again
+	_, err := connector.Read(context.TODO(), testEi, values, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), connector.Stats().Writes)
+	assert.True(t, connector.Stats().BytesWritten > stats.BytesWritten)
+
+	assert.NoError(t, connector.Remove(context.TODO(), testEi, values))
+	assert.Equal(t, int64(1), connector.Stats().Removes)
+
+	// uncached entities are not counted
+	uncached := NewConnector(memory.NewConnector(), memory.NewConnector(), NewJSONEncoder(), nil)
+	uncached.setSynchronousMode(true)
+	assert.NoError(t, uncached.Upsert(context.TODO(), testEi, values))
+	assert.Equal(t, Stats{}, uncached.Stats())
+}
+
+func TestWarmByScan(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+
+	row := func(i int64) map[string]dosa.FieldValue {
+		return map[string]dosa.FieldValue{
+			"an_uuid_key": "d1449c93-25b8-4032-920b-60471d91acc9",
+			"strkey":      "test key string",
+			"int64key":    i,
+			"strv":        "value",
+		}
+	}
+	page1 := []map[string]dosa.FieldValue{row(1), row(2)}
+	page2 := []map[string]dosa.FieldValue{row(3)}
+	gomock.InOrder(
+		mockOrigin.EXPECT().Scan(context.TODO(), testEi, dosa.All(), "", warmPageSize).Return(page1, "page2", nil),
+		mockOrigin.EXPECT().Scan(context.TODO(), testEi, dosa.All(), "page2", warmPageSize).Return(page2, "", nil),
+	)
+
+	fallback := memory.NewConnector()
+	connector := NewConnector(mockOrigin, fallback, NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithWarmRate(1000))
+	start := time.Now()
+	assert.NoError(t, connector.WarmByScan(context.TODO(), testEi))
+	assert.True(t, time.Since(start) >= 3*time.Millisecond)
+
+	for _, r := range append(page1, page2...) {
+		cacheKey, err := connector.CacheKey(testEi, r)
+		assert.NoError(t, err)
+		data, err := connector.getValueFromFallback(context.TODO(), adaptedEi, cacheKey)
+		assert.NoError(t, err)
+		entry, err := connector.decodeEntry(connector.encoder, data)
+		assert.NoError(t, err)
+		assert.EqualValues(t, r["int64key"], entry.Values["int64key"])
+	}
+	assert.Equal(t, int64(3), connector.Stats().Writes)
+}
+
+func TestWarmByScanErrors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	mockOrigin.EXPECT().Scan(context.TODO(), testEi, dosa.All(), "", warmPageSize).Return(nil, "", assert.AnError)
+
+	uncached := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil)
+	assert.Error(t, uncached.WarmByScan(context.TODO(), testEi))
+
+	connector := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...)
+	assert.Equal(t, assert.AnError, connector.WarmByScan(context.TODO(), testEi))
+}
+
+func TestCacheFullPagesOnly(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	connector := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithCacheFullPagesOnly(true))
+	connector.setSynchronousMode(true)
+
+	rows := []map[string]dosa.FieldValue{{"int64key": int64(1)}, {"int64key": int64(2)}}
+	gomock.InOrder(
+		// a full page and a partial one
+		mockOrigin.EXPECT().Range(context.TODO(), testEi, nil, dosa.All(), "", 2).Return(rows, "next", nil),
+		mockOrigin.EXPECT().Range(context.TODO(), testEi, nil, dosa.All(), "next", 2).Return(rows[:1], "", nil),
+		// origin is down from here on
+		mockOrigin.EXPECT().Range(context.TODO(), testEi, nil, dosa.All(), gomock.Any(), 2).Return(nil, "", assert.AnError).Times(2),
+	)
+	_, _, err := connector.Range(context.TODO(), testEi, nil, dosa.All(), "", 2)
+	assert.NoError(t, err)
+	_, _, err = connector.Range(context.TODO(), testEi, nil, dosa.All(), "next", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), connector.Stats().Writes)
+
+	result, token, err := connector.Range(context.TODO(), testEi, nil, dosa.All(), "", 2)
+	assert.NoError(t, err)
+	assert.Len(t, result, 2)
+	assert.Equal(t, "next", token)
+	_, _, err = connector.Range(context.TODO(), testEi, nil, dosa.All(), "next", 2)
+	assert.Equal(t, assert.AnError, err)
+}
+
+func TestStrongConsistency(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	// any call to the fallback fails the test
+	mockFallback := mocks.NewMockConnector(ctrl)
+	connector := NewConnector(mockOrigin, mockFallback, NewJSONEncoder(), nil, cacheableEntities...)
+	connector.setSynchronousMode(true)
+
+	keys := map[string]dosa.FieldValue{
+		"an_uuid_key": "d1449c93-25b8-4032-920b-60471d91acc9",
+		"strkey":      "test key string",
+		"int64key":    int64(2932),
+	}
+	ctx := dosa.WithConsistency(context.TODO(), dosa.Strong)
+	row := map[string]dosa.FieldValue{"strv": "value"}
+
+	// successful reads are not written back
+	mockOrigin.EXPECT().Read(ctx, testEi, keys, dosa.All()).Return(row, nil)
+	mockOrigin.EXPECT().Range(ctx, testEi, nil, dosa.All(), "", 10).Return([]map[string]dosa.FieldValue{row}, "", nil)
+	result, err := connector.Read(ctx, testEi, keys, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, row, result)
+	rows, _, err := connector.Range(ctx, testEi, nil, dosa.All(), "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 1)
+
+	// and failures return the origin error without a fallback lookup
+	mockOrigin.EXPECT().Read(ctx, testEi, keys, dosa.All()).Return(nil, assert.AnError)
+	mockOrigin.EXPECT().Range(ctx, testEi, nil, dosa.All(), "", 10).Return(nil, "", assert.AnError)
+	_, err = connector.Read(ctx, testEi, keys, dosa.All())
+	assert.Equal(t, assert.AnError, err)
+	_, _, err = connector.Range(ctx, testEi, nil, dosa.All(), "", 10)
+	assert.Equal(t, assert.AnError, err)
+}
+
+func TestInvalidateMany(t *testing.T) {
+	keysFor := func(i int) map[string]dosa.FieldValue {
+		return map[string]dosa.FieldValue{
+			"an_uuid_key": "d1449c93-25b8-4032-920b-60471d91acc9",
+			"strkey":      "test key string",
+			"int64key":    int64(i),
+		}
+	}
+	connector := NewConnector(memory.NewConnector(), memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, connector.PutCached(context.TODO(), testEi, keysFor(i)))
+	}
+
+	// the memory connector has no MultiRemove, so entries are removed one by one
+	err := connector.InvalidateMany(context.TODO(), testEi, []map[string]dosa.FieldValue{keysFor(0), keysFor(2), keysFor(9)})
+	assert.NoError(t, err)
+	for i, cached := range []bool{false, true, false} {
+		_, found, err := connector.GetCached(context.TODO(), testEi, keysFor(i))
+		assert.NoError(t, err)
+		assert.Equal(t, cached, found, "entry %d", i)
+	}
+	assert.Equal(t, int64(3), connector.Stats().Removes)
+
+	// incomplete keys are reported for their position
+	err = connector.InvalidateMany(context.TODO(), testEi, []map[string]dosa.FieldValue{keysFor(1), {"strkey": "x"}})
+	if failed, ok := err.(*ErrInvalidateFailed); assert.True(t, ok, "%T", err) {
+		assert.Len(t, failed.Errors, 2)
+		assert.NoError(t, failed.Errors[0])
+		assert.Error(t, failed.Errors[1])
+		assert.Contains(t, err.Error(), "1 of 2")
+	}
+	_, found, _ := connector.GetCached(context.TODO(), testEi, keysFor(1))
+	assert.False(t, found)
+
+	uncached := NewConnector(memory.NewConnector(), memory.NewConnector(), NewJSONEncoder(), nil)
+	assert.Error(t, uncached.InvalidateMany(context.TODO(), testEi, []map[string]dosa.FieldValue{keysFor(0)}))
+
+	// fallbacks with MultiRemove get a single call
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockFallback := mocks.NewMockConnector(ctrl)
+	mockFallback.EXPECT().MultiRemove(context.TODO(), gomock.Any(), gomock.Any()).
+		Do(func(_ context.Context, _ *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) {
+			assert.Len(t, multiKeys, 2)
+		}).Return([]error{nil, assert.AnError}, nil)
+	connector = NewConnector(memory.NewConnector(), mockFallback, NewJSONEncoder(), nil, cacheableEntities...)
+	err = connector.InvalidateMany(context.TODO(), testEi, []map[string]dosa.FieldValue{keysFor(0), keysFor(1)})
+	if failed, ok := err.(*ErrInvalidateFailed); assert.True(t, ok, "%T", err) {
+		assert.Equal(t, []error{nil, assert.AnError}, failed.Errors)
+	}
+}
+
+func TestGetPutCached(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	fallback := memory.NewConnector()
+	connector := NewConnector(mockOrigin, fallback, NewJSONEncoder(), nil, cacheableEntities...)
+	connector.now = fixedClock
+
+	keys := map[string]dosa.FieldValue{
+		"an_uuid_key": "d1449c93-25b8-4032-920b-60471d91acc9",
+		"strkey":      "test key string",
+		"int64key":    int64(2932),
+	}
+	values := map[string]dosa.FieldValue{"strv": "put value"}
+	for k, v := range keys {
+		values[k] = v
+	}
+
+	// nothing cached yet
+	got, ok, err := connector.GetCached(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Nil(t, got)
+
+	assert.NoError(t, connector.PutCached(context.TODO(), testEi, values))
+	got, ok, err = connector.GetCached(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "put value", got["strv"])
+
+	// a read that falls back to the cache sees the same entry
+	mockOrigin.EXPECT().Read(context.TODO(), testEi, keys, dosa.All()).Return(nil, assert.AnError)
+	read, err := connector.Read(context.TODO(), testEi, keys, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, got, read)
+
+	// expired entries are a miss
+	connector.WithOptions(WithTTL(time.Minute))
+	assert.NoError(t, connector.PutCached(context.TODO(), testEi, values))
+	connector.now = func() time.Time { return testNow.Add(time.Hour) }
+	_, ok, err = connector.GetCached(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// incomplete keys and uncached entities are rejected
+	_, _, err = connector.GetCached(context.TODO(), testEi, map[string]dosa.FieldValue{"strkey": "x"})
+	assert.Error(t, err)
+	assert.Error(t, connector.PutCached(context.TODO(), testEi, map[string]dosa.FieldValue{"strkey": "x"}))
+	uncached := NewConnector(mockOrigin, fallback, NewJSONEncoder(), nil)
+	_, _, err = uncached.GetCached(context.TODO(), testEi, keys)
+	assert.Error(t, err)
+	assert.Error(t, uncached.PutCached(context.TODO(), testEi, values))
+}
+
+func TestTTLRemaining(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	connector := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithTTL(10 * time.Minute))
+	connector.now = func() time.Time { return testNow }
+	keys := batchKeys(1)
+
+	// missing entry
+	remaining, found, err := connector.TTLRemaining(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.False(t, found)
+	assert.Equal(t, time.Duration(0), remaining)
+
+	// entry with time left, asking again later does not refresh it
+	assert.NoError(t, connector.PutCached(context.TODO(), testEi, keys))
+	connector.now = func() time.Time { return testNow.Add(4 * time.Minute) }
+	remaining, found, err = connector.TTLRemaining(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 6*time.Minute, remaining)
+	connector.now = func() time.Time { return testNow.Add(9 * time.Minute) }
+	remaining, found, err = connector.TTLRemaining(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, time.Minute, remaining)
+
+	// expired entry
+	connector.now = func() time.Time { return testNow.Add(time.Hour) }
+	remaining, found, err = connector.TTLRemaining(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, time.Duration(0), remaining)
+
+	// entry without a TTL
+	connector.WithOptions(WithTTL(0))
+	assert.NoError(t, connector.PutCached(context.TODO(), testEi, keys))
+	remaining, found, err = connector.TTLRemaining(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, NoExpiry, remaining)
+
+	_, _, err = connector.TTLRemaining(context.TODO(), testEi, map[string]dosa.FieldValue{"strkey": "x"})
+	assert.Error(t, err)
+}
+
+func TestDivergenceSampling(t *testing.T) {
+	keys := map[string]dosa.FieldValue{
+		"an_uuid_key": "d1449c93-25b8-4032-920b-60471d91acc9",
+		"strkey":      "test key string",
+		"int64key":    int64(2932),
+	}
+	cached := map[string]dosa.FieldValue{"strv": "cached"}
+	for k, v := range keys {
+		cached[k] = v
+	}
+	updated := map[string]dosa.FieldValue{"strv": "updated"}
+	for k, v := range keys {
+		updated[k] = v
+	}
+
+	// newConnector returns a connector whose origin is down for reads made
+	// with the test context and answers with updated for the background
+	// re-reads, which use their own context
+	newConnector := func(ctrl *gomock.Controller, opts ...Option) *Connector {
+		mockOrigin := mocks.NewMockConnector(ctrl)
+		mockOrigin.EXPECT().Read(context.TODO(), testEi, keys, dosa.All()).Return(nil, assert.AnError).AnyTimes()
+		mockOrigin.EXPECT().Read(gomock.Not(context.TODO()), testEi, keys, dosa.All()).Return(updated, nil).AnyTimes()
+		connector := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+			WithOptions(opts...)
+		connector.setSynchronousMode(true)
+		assert.NoError(t, connector.PutCached(context.TODO(), testEi, cached))
+		return connector
+	}
+	readN := func(connector *Connector, n int) {
+		for i := 0; i < n; i++ {
+			values, err := connector.Read(context.TODO(), testEi, keys, dosa.All())
+			assert.NoError(t, err)
+			assert.Equal(t, "cached", values["strv"])
+		}
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	never := newConnector(ctrl, WithDivergenceSampleRate(0))
+	readN(never, 10)
+	assert.Equal(t, int64(0), never.Stats().DivergenceChecks)
+
+	always := newConnector(ctrl, WithDivergenceSampleRate(1))
+	readN(always, 10)
+	assert.Equal(t, int64(10), always.Stats().DivergenceChecks)
+	assert.Equal(t, int64(10), always.Stats().Divergences)
+
+	// the same seed samples the same serves
+	first := newConnector(ctrl, WithDivergenceSampleRate(0.5), WithDivergenceSeed(42))
+	second := newConnector(ctrl, WithDivergenceSampleRate(0.5), WithDivergenceSeed(42))
+	readN(first, 100)
+	readN(second, 100)
+	checks := first.Stats().DivergenceChecks
+	assert.True(t, checks > 0 && checks < 100, "checked %d of 100 serves", checks)
+	assert.Equal(t, checks, second.Stats().DivergenceChecks)
+}
+
+// Excluded columns are not served from the fallback, so they must not count
+// as a divergence either
+func TestDivergenceWithExcludedColumns(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	connector := NewConnector(mockOrigin, memory.NewConnector(), NewGobEncoder(), nil, cacheableEntities...).
+		WithOptions(
+			WithExcludedColumns(map[string][]string{testEi.Def.Name: {"blobv"}}),
+			WithDivergenceSampleRate(1),
+		)
+	connector.setSynchronousMode(true)
+
+	keys := batchKeys(1)
+	row := batchKeys(1)
+	row["strv"] = "same"
+	row["blobv"] = []byte{1, 2, 3}
+	assert.NoError(t, connector.PutCached(context.TODO(), testEi, row))
+
+	mockOrigin.EXPECT().Read(context.TODO(), testEi, keys, dosa.All()).Return(nil, assert.AnError)
+	mockOrigin.EXPECT().Read(gomock.Not(context.TODO()), testEi, keys, dosa.All()).Return(row, nil)
+	values, err := connector.Read(context.TODO(), testEi, keys, dosa.All())
+	assert.NoError(t, err)
+	assert.NotContains(t, values, "blobv")
+	assert.Equal(t, int64(1), connector.Stats().DivergenceChecks)
+	assert.Equal(t, int64(0), connector.Stats().Divergences)
+}
+
+func TestDivergenceWithJSONEncoder(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	connector := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithDivergenceSampleRate(1))
+	connector.setSynchronousMode(true)
+
+	// JSON decodes the integer columns as float64, on both sides
+	keys := batchKeys(1)
+	row := batchKeys(1)
+	row["an_int64_value"] = int64(7)
+	row["int32v"] = int32(3)
+	assert.NoError(t, connector.PutCached(context.TODO(), testEi, row))
+
+	mockOrigin.EXPECT().Read(context.TODO(), testEi, keys, dosa.All()).Return(nil, assert.AnError)
+	mockOrigin.EXPECT().Read(gomock.Not(context.TODO()), testEi, keys, dosa.All()).Return(row, nil)
+	_, err := connector.Read(context.TODO(), testEi, keys, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), connector.Stats().DivergenceChecks)
+	assert.Equal(t, int64(0), connector.Stats().Divergences)
+}
+
+// countingEncoder is a JSON encoder that counts how often it is used
+type countingEncoder struct {
+	Encoder
+	encodes, decodes int
+}
+
+func (e *countingEncoder) Encode(v interface{}) ([]byte, error) {
+	e.encodes++
+	return e.Encoder.Encode(v)
+}
+
+func (e *countingEncoder) Decode(data []byte, v interface{}) error {
+	e.decodes++
+	return e.Encoder.Decode(data, v)
+}
+
+func TestEntityEncoders(t *testing.T) {
+	table, err := dosa.TableFromInstance(&testentity.TestNamedImportEntity{})
+	assert.NoError(t, err)
+	otherEi := &dosa.EntityInfo{Ref: &schemaRef, Def: &table.EntityDefinition}
+
+	defaultEncoder := &countingEncoder{Encoder: NewJSONEncoder()}
+	entityEncoder := &countingEncoder{Encoder: NewJSONEncoder()}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	mockOrigin.EXPECT().Range(context.TODO(), testEi, gomock.Any(), dosa.All(), "", 10).Return(nil, "", assert.AnError)
+	connector := NewConnector(mockOrigin, memory.NewConnector(), defaultEncoder, nil,
+		&testentity.TestEntity{}, &testentity.TestNamedImportEntity{}).
+		WithOptions(WithEntityEncoders(map[string]Encoder{testEi.Def.Name: entityEncoder}))
+	connector.now = fixedClock
+
+	values := map[string]dosa.FieldValue{
+		"an_uuid_key": "d1449c93-25b8-4032-920b-60471d91acc9",
+		"strkey":      "test key string",
+		"int64key":    int64(2932),
+		"strv":        "value",
+	}
+
+	// key and value of the listed entity both use its encoder
+	assert.NoError(t, connector.PutCached(context.TODO(), testEi, values))
+	assert.Equal(t, 2, entityEncoder.encodes)
+	assert.Equal(t, 0, defaultEncoder.encodes)
+	got, ok, err := connector.GetCached(context.TODO(), testEi, values)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "value", got["strv"])
+	assert.Equal(t, 3, entityEncoder.encodes)
+	assert.Equal(t, 1, entityEncoder.decodes)
+	assert.Equal(t, 0, defaultEncoder.decodes)
+
+	// other entities keep the default encoder
+	assert.NoError(t, connector.PutCached(context.TODO(), otherEi, values))
+	_, ok, err = connector.GetCached(context.TODO(), otherEi, values)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 3, defaultEncoder.encodes)
+	assert.Equal(t, 1, defaultEncoder.decodes)
+	assert.Equal(t, 3, entityEncoder.encodes)
+
+	// range entries follow the same rule
+	_, _, _ = connector.Range(context.TODO(), testEi, nil, dosa.All(), "", 10)
+	assert.Equal(t, 3, defaultEncoder.encodes)
+	assert.Equal(t, 4, entityEncoder.encodes)
+}
+
+func TestWriteBackPause(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	mockFallback := mocks.NewMockConnector(ctrl)
+	values := map[string]dosa.FieldValue{
+		"an_uuid_key": "d1449c93-25b8-4032-920b-60471d91acc9",
+		"strkey":      "test key string",
+		"int64key":    int64(2932),
+	}
+	mockOrigin.EXPECT().Upsert(context.TODO(), testEi, values).Return(nil).Times(7)
+
+	now := testNow
+	connector := NewConnector(mockOrigin, mockFallback, NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithWriteBackPause(3, time.Minute))
+	connector.setSynchronousMode(true)
+	connector.now = func() time.Time { return now }
+
+	// three failures in a row pause write-backs
+	mockFallback.EXPECT().Upsert(gomock.Any(), adaptedEi, gomock.Any()).Return(assert.AnError).Times(3)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, connector.Upsert(context.TODO(), testEi, values))
+	}
+	assert.True(t, connector.Stats().WriteBacksPaused)
+
+	// while paused the fallback gets no writes, but reads still try it
+	assert.NoError(t, connector.Upsert(context.TODO(), testEi, values))
+	mockOrigin.EXPECT().Read(context.TODO(), testEi, values, dosa.All()).Return(nil, assert.AnError)
+	mockFallback.EXPECT().Read(context.TODO(), adaptedEi, gomock.Any(), dosa.All()).Return(nil, &dosa.ErrNotFound{})
+	_, err := connector.Read(context.TODO(), testEi, values, dosa.All())
+	assert.Error(t, err)
+	assert.Equal(t, int64(1), connector.Stats().SkippedWriteBacks)
+
+	// write-backs resume after the cooldown, and a success resets the count
+	now = now.Add(time.Minute)
+	assert.False(t, connector.Stats().WriteBacksPaused)
+	gomock.InOrder(
+		mockFallback.EXPECT().Upsert(gomock.Any(), adaptedEi, gomock.Any()).Return(assert.AnError).Times(2),
+		mockFallback.EXPECT().Upsert(gomock.Any(), adaptedEi, gomock.Any()).Return(nil),
+	)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, connector.Upsert(context.TODO(), testEi, values))
+	}
+	assert.False(t, connector.Stats().WriteBacksPaused)
+	assert.Equal(t, int64(1), connector.Stats().SkippedWriteBacks)
+}
+
+func TestRangeTTL(t *testing.T) {
+	keys := map[string]dosa.FieldValue{
+		"an_uuid_key": "d1449c93-25b8-4032-920b-60471d91acc9",
+		"strkey":      "test key string",
+		"int64key":    int64(2932),
+	}
+	row := map[string]dosa.FieldValue{"strv": "value"}
+	for k, v := range keys {
+		row[k] = v
+	}
+	rows := []map[string]dosa.FieldValue{row}
+
+	for _, test := range []struct {
+		descript  string
+		opts      []Option
+		pointLive bool
+	}{
+		{descript: "range TTL", opts: []Option{WithTTL(time.Hour), WithRangeTTL(time.Minute)}, pointLive: true},
+		{descript: "global TTL", opts: []Option{WithTTL(time.Minute)}},
+	} {
+		ctrl := gomock.NewController(t)
+		mockOrigin := mocks.NewMockConnector(ctrl)
+		now := testNow
+		connector := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+			WithOptions(test.opts...)
+		connector.setSynchronousMode(true)
+		connector.now = func() time.Time { return now }
+
+		// populate both kinds of entries
+		mockOrigin.EXPECT().Read(context.TODO(), testEi, keys, dosa.All()).Return(row, nil)
+		mockOrigin.EXPECT().Range(context.TODO(), testEi, nil, dosa.All(), "", 10).Return(rows, "", nil)
+		_, err := connector.Read(context.TODO(), testEi, keys, dosa.All())
+		assert.NoError(t, err, test.descript)
+		_, _, err = connector.Range(context.TODO(), testEi, nil, dosa.All(), "", 10)
+		assert.NoError(t, err, test.descript)
+
+		// origin is down from here on
+		mockOrigin.EXPECT().Read(context.TODO(), testEi, keys, dosa.All()).Return(nil, assert.AnError).AnyTimes()
+		mockOrigin.EXPECT().Range(context.TODO(), testEi, nil, dosa.All(), "", 10).Return(nil, "", assert.AnError).AnyTimes()
+
+		now = testNow.Add(30 * time.Second)
+		result, _, err := connector.Range(context.TODO(), testEi, nil, dosa.All(), "", 10)
+		assert.NoError(t, err, test.descript)
+		assert.Equal(t, "value", result[0]["strv"], test.descript)
+
+		// the range entry has expired, the point entry only in the global case
+		now = testNow.Add(2 * time.Minute)
+		_, _, err = connector.Range(context.TODO(), testEi, nil, dosa.All(), "", 10)
+		assert.Equal(t, assert.AnError, err, test.descript)
+		_, err = connector.Read(context.TODO(), testEi, keys, dosa.All())
+		if test.pointLive {
+			assert.NoError(t, err, test.descript)
+		} else {
+			assert.Equal(t, assert.AnError, err, test.descript)
+		}
+		ctrl.Finish()
+	}
+}
+
+func TestExcludedColumns(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	fallback := memory.NewConnector()
+	connector := NewConnector(mockOrigin, fallback, NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithExcludedColumns(map[string][]string{testEi.Def.Name: {"blobv", "strv"}}))
+	connector.setSynchronousMode(true)
+	connector.now = fixedClock
+
+	keys := map[string]dosa.FieldValue{
+		"an_uuid_key": "d1449c93-25b8-4032-920b-60471d91acc9",
+		"strkey":      "test key string",
+		"int64key":    int64(2932),
+	}
+	row := map[string]dosa.FieldValue{"strv": "secret", "blobv": []byte{1, 2, 3}, "int32v": int32(7)}
+	for k, v := range keys {
+		row[k] = v
+	}
+
+	// origin results are returned whole
+	mockOrigin.EXPECT().Read(context.TODO(), testEi, keys, dosa.All()).Return(row, nil)
+	values, err := connector.Read(context.TODO(), testEi, keys, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", values["strv"])
+	mockOrigin.EXPECT().Range(context.TODO(), testEi, nil, dosa.All(), "", 10).Return([]map[string]dosa.FieldValue{row}, "", nil)
+	rows, _, err := connector.Range(context.TODO(), testEi, nil, dosa.All(), "", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", rows[0]["strv"])
+
+	// but the encoded fallback entry does not have the excluded columns
+	cacheKey, err := connector.CacheKey(testEi, keys)
+	assert.NoError(t, err)
+	data, err := connector.getValueFromFallback(context.TODO(), adaptedEi, cacheKey)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "secret")
+	assert.NotContains(t, string(data), "blobv")
+	assert.Contains(t, string(data), "int32v")
+
+	// nor do reads served from the fallback
+	mockOrigin.EXPECT().Read(context.TODO(), testEi, keys, dosa.All()).Return(nil, assert.AnError)
+	values, err = connector.Read(context.TODO(), testEi, keys, dosa.All())
+	assert.NoError(t, err)
+	assert.NotContains(t, values, "strv")
+	assert.NotContains(t, values, "blobv")
+	assert.EqualValues(t, 7, values["int32v"])
+	mockOrigin.EXPECT().Range(context.TODO(), testEi, nil, dosa.All(), "", 10).Return(nil, "", assert.AnError)
+	rows, _, err = connector.Range(context.TODO(), testEi, nil, dosa.All(), "", 10)
+	assert.NoError(t, err)
+	assert.NotContains(t, rows[0], "strv")
+	assert.NotContains(t, rows[0], "blobv")
+	assert.EqualValues(t, 2932, rows[0]["int64key"])
+}
+
+func TestMissingEntityDefinition(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	// the fallback mock expects no calls
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	connector := NewConnector(mockOrigin, mocks.NewMockConnector(ctrl), NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithReadBatching(time.Millisecond, 10), WithTokenHandles(1))
+	connector.setSynchronousMode(true)
+	ei := &dosa.EntityInfo{Ref: testEi.Ref}
+	keys := map[string]dosa.FieldValue{"strkey": "test key string"}
+
+	// reads and writes go to origin uncached
+	mockOrigin.EXPECT().Read(context.TODO(), ei, keys, dosa.All()).Return(keys, nil)
+	_, err := connector.Read(context.TODO(), ei, keys, dosa.All())
+	assert.NoError(t, err)
+	mockOrigin.EXPECT().Range(context.TODO(), ei, nil, dosa.All(), "", 10).Return(nil, "next", nil)
+	_, token, err := connector.Range(context.TODO(), ei, nil, dosa.All(), "", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "next", token)
+	mockOrigin.EXPECT().Upsert(context.TODO(), ei, keys).Return(nil)
+	assert.NoError(t, connector.Upsert(context.TODO(), ei, keys))
+	mockOrigin.EXPECT().Remove(context.TODO(), ei, keys).Return(nil)
+	assert.NoError(t, connector.Remove(context.TODO(), ei, keys))
+
+	// and the fallback-only methods fail
+	assert.Equal(t, ErrMissingDefinition, connector.PutCached(context.TODO(), ei, keys))
+	_, _, err = connector.GetCached(context.TODO(), ei, keys)
+	assert.Equal(t, ErrMissingDefinition, err)
+	_, err = connector.CacheKey(ei, keys)
+	assert.Equal(t, ErrMissingDefinition, err)
+	_, err = connector.Inspect(context.TODO(), ei, keys)
+	assert.Equal(t, ErrMissingDefinition, err)
+	assert.NotPanics(t, func() { adaptToKeyValue(ei) })
+}
+
+// sharingEncoder hands out the same decoded entry for the same data, as an
+// encoder decoding into pooled buffers would
+type sharingEncoder struct {
+	Encoder
+	mux     sync.Mutex
+	decoded map[string]*cacheEntry
+}
+
+func (e *sharingEncoder) Decode(data []byte, v interface{}) error {
+	entry, ok := v.(*cacheEntry)
+	if !ok {
+		return e.Encoder.Decode(data, v)
+	}
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if shared, ok := e.decoded[string(data)]; ok {
+		*entry = *shared
+		return nil
+	}
+	if err := e.Encoder.Decode(data, entry); err != nil {
+		return err
+	}
+	e.decoded[string(data)] = entry
+	return nil
+}
+
+func TestCopyOnRead(t *testing.T) {
+	keys := map[string]dosa.FieldValue{
+		"an_uuid_key": "d1449c93-25b8-4032-920b-60471d91acc9",
+		"strkey":      "test key string",
+		"int64key":    int64(2932),
+	}
+	row := map[string]dosa.FieldValue{"blobv": []byte("blob")}
+	for k, v := range keys {
+		row[k] = v
+	}
+	ctx := context.TODO()
+	for _, copyOnRead := range []bool{false, true} {
+		ctrl := gomock.NewController(t)
+		mockOrigin := mocks.NewMockConnector(ctrl)
+		mockOrigin.EXPECT().Read(gomock.Any(), testEi, keys, dosa.All()).Return(nil, assert.AnError).Times(2)
+		encoder := &sharingEncoder{Encoder: NewProtoEncoder(), decoded: map[string]*cacheEntry{}}
+		connector := NewConnector(mockOrigin, memory.NewConnector(), encoder, nil, cacheableEntities...).
+			WithOptions(WithCopyOnRead(copyOnRead))
+		assert.NoError(t, connector.PutCached(ctx, testEi, row))
+
+		values, err := connector.Read(ctx, testEi, keys, dosa.All())
+		assert.NoError(t, err)
+		values["blobv"].([]byte)[0] = 'X'
+		values, err = connector.Read(ctx, testEi, keys, dosa.All())
+		assert.NoError(t, err)
+		if copyOnRead {
+			assert.Equal(t, []byte("blob"), values["blobv"])
+		} else {
+			// without copies, the caller's change leaks into the next read
+			assert.Equal(t, []byte("Xlob"), values["blobv"])
+		}
+		ctrl.Finish()
+	}
+}
+
+func TestPerEntityStats(t *testing.T) {
+	keys := map[string]dosa.FieldValue{
+		"an_uuid_key": "d1449c93-25b8-4032-920b-60471d91acc9",
+		"strkey":      "test key string",
+		"int64key":    int64(2932),
+	}
+	otherKeys := map[string]dosa.FieldValue{
+		"an_uuid_key": "d1449c93-25b8-4032-920b-60471d91acc9",
+		"strkey":      "other key string",
+		"int64key":    int64(2932),
+	}
+	table, _ := dosa.TableFromInstance(&testentity.TestNamedImportEntity{})
+	otherEi := &dosa.EntityInfo{Ref: &schemaRef, Def: &table.EntityDefinition}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	mockOrigin.EXPECT().Read(gomock.Any(), gomock.Any(), gomock.Any(), dosa.All()).Return(nil, assert.AnError).AnyTimes()
+	entities := []dosa.DomainObject{&testentity.TestEntity{}, &testentity.TestNamedImportEntity{}}
+	ctx := context.TODO()
+
+	// without the option there is no breakdown
+	connector := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil, entities...)
+	assert.NoError(t, connector.PutCached(ctx, testEi, keys))
+	assert.Nil(t, connector.Stats().Entities)
+
+	connector = NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil, entities...).
+		WithOptions(WithPerEntityStats(true))
+	assert.Empty(t, connector.Stats().Entities)
+	assert.NoError(t, connector.PutCached(ctx, testEi, keys))
+	for i := 0; i < 2; i++ {
+		_, err := connector.Read(ctx, testEi, keys, dosa.All())
+		assert.NoError(t, err)
+	}
+	_, err := connector.Read(ctx, otherEi, otherKeys, dosa.All())
+	assert.Error(t, err)
+
+	stats := connector.Stats()
+	assert.Equal(t, map[string]EntityStats{
+		testEi.Def.Name:  {Hits: 2, Writes: 1},
+		otherEi.Def.Name: {Misses: 1},
+	}, stats.Entities)
+	assert.Equal(t, int64(1), stats.Writes)
+}
+
+func TestSkipEmptyReads(t *testing.T) {
+	keys := batchKeys(1)
+	ctx := context.TODO()
+	for _, skip := range []bool{false, true} {
+		ctrl := gomock.NewController(t)
+		mockOrigin := mocks.NewMockConnector(ctrl)
+		mockOrigin.EXPECT().Read(ctx, testEi, keys, dosa.All()).Return(map[string]dosa.FieldValue{}, nil)
+		connector := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+			WithOptions(WithSkipEmptyReads(skip))
+		connector.setSynchronousMode(true)
+
+		values, err := connector.Read(ctx, testEi, keys, dosa.All())
+		assert.NoError(t, err)
+		assert.Empty(t, values)
+		_, found, err := connector.GetCached(ctx, testEi, keys)
+		assert.NoError(t, err)
+		assert.Equal(t, !skip, found)
+		stats := connector.Stats()
+		if skip {
+			assert.Equal(t, int64(1), stats.EmptyReadsSkipped)
+			assert.Equal(t, int64(0), stats.Writes)
+		} else {
+			assert.Equal(t, int64(0), stats.EmptyReadsSkipped)
+			assert.Equal(t, int64(1), stats.Writes)
+		}
+		ctrl.Finish()
+	}
+}
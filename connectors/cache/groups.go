@@ -0,0 +1,371 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/uber-go/dosa"
+)
+
+// WithFieldGroups splits the cached rows of a wide entity into groups of
+// columns that are stored, and expire, separately. groups maps a group name
+// to the names of its columns; each column belongs to at most one group and
+// key columns must not be listed. Columns that are not listed form an
+// unnamed group of their own, stored under the row's usual key. Give the
+// groups their own TTLs with WithFieldGroupTTLs.
+//
+// Reads of a grouped entity are answered from the fallback first: the groups
+// holding the requested columns that are cached and live are served as they
+// are, and only the columns of missing or expired groups are read from origin
+// and written back. If that origin read fails the read fails. An Upsert
+// rewrites the groups whose columns it sets in full and drops the ones it
+// sets in part, and a Remove drops every group. PutCached, WarmByScan and
+// Refresh write every group, InvalidateMany drops every group, and
+// GetCached and TTLRemaining only report a row as cached when all of its
+// groups are. Ranges are cached as usual.
+func WithFieldGroups(entity string, groups map[string][]string) Option {
+	return func(c *Connector) {
+		g := c.fieldGroupsOf(entity)
+		for name, columns := range groups {
+			for _, column := range columns {
+				g.byColumn[column] = name
+			}
+		}
+		g.columns = map[string][]string{}
+		for column, name := range g.byColumn {
+			g.columns[name] = append(g.columns[name], column)
+		}
+		for _, columns := range g.columns {
+			sort.Strings(columns)
+		}
+	}
+}
+
+// WithFieldGroupTTLs sets the TTLs of the field groups of an entity, see
+// WithFieldGroups. Groups without a TTL, including the unnamed group, use
+// the WithTTL value.
+func WithFieldGroupTTLs(entity string, ttls map[string]time.Duration) Option {
+	return func(c *Connector) {
+		g := c.fieldGroupsOf(entity)
+		for name, ttl := range ttls {
+			g.ttls[name] = ttl
+		}
+	}
+}
+
+// fieldGroups is the field group configuration of an entity
+type fieldGroups struct {
+	// group name by column name, and sorted column names by group name
+	byColumn map[string]string
+	columns  map[string][]string
+	ttls     map[string]time.Duration
+}
+
+func (c *Connector) fieldGroupsOf(entity string) *fieldGroups {
+	if c.fieldGroups == nil {
+		c.fieldGroups = map[string]*fieldGroups{}
+	}
+	g, ok := c.fieldGroups[entity]
+	if !ok {
+		g = &fieldGroups{
+			byColumn: map[string]string{},
+			columns:  map[string][]string{},
+			ttls:     map[string]time.Duration{},
+		}
+		c.fieldGroups[entity] = g
+	}
+	return g
+}
+
+// needed returns the sorted names of the groups holding the non-key columns
+// in fields, or of every group when fields is empty
+func (g *fieldGroups) needed(ei *dosa.EntityInfo, fields []string) []string {
+	if len(fields) == 0 {
+		return g.all(ei)
+	}
+	keys := ei.Def.KeySet()
+	set := map[string]bool{}
+	for _, field := range fields {
+		if _, ok := keys[field]; !ok {
+			set[g.byColumn[field]] = true
+		}
+	}
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// all returns the sorted names of every group of the entity, including the
+// unnamed one if some non-key columns are not in a named group
+func (g *fieldGroups) all(ei *dosa.EntityInfo) []string {
+	names := make([]string, 0, len(g.columns)+1)
+	if len(g.ungrouped(ei)) > 0 {
+		names = append(names, "")
+	}
+	for name := range g.columns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ungrouped returns the non-key columns of the entity that are not in a
+// named group
+func (g *fieldGroups) ungrouped(ei *dosa.EntityInfo) []string {
+	keys := ei.Def.KeySet()
+	var columns []string
+	for _, col := range ei.Def.Columns {
+		if _, ok := keys[col.Name]; ok {
+			continue
+		}
+		if _, ok := g.byColumn[col.Name]; !ok {
+			columns = append(columns, col.Name)
+		}
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// columnsOf returns the columns of the named groups
+func (g *fieldGroups) columnsOf(ei *dosa.EntityInfo, names []string) []string {
+	var columns []string
+	for _, name := range names {
+		if name == "" {
+			columns = append(columns, g.ungrouped(ei)...)
+			continue
+		}
+		columns = append(columns, g.columns[name]...)
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// groupKey returns the cache key of a group of the row stored under cacheKey
+func groupKey(cacheKey []byte, name string) []byte {
+	if name == "" {
+		return cacheKey
+	}
+	k := make([]byte, 0, len(cacheKey)+1+len(name))
+	k = append(k, cacheKey...)
+	k = append(k, '#')
+	return append(k, name...)
+}
+
+// groupValues returns the key columns of values along with those of columns
+// it holds, and how many of columns it holds
+func groupValues(ei *dosa.EntityInfo, values map[string]dosa.FieldValue, columns []string) (map[string]dosa.FieldValue, int) {
+	group := map[string]dosa.FieldValue{}
+	for k := range ei.Def.KeySet() {
+		if v, ok := values[k]; ok {
+			group[k] = v
+		}
+	}
+	found := 0
+	for _, column := range columns {
+		if v, ok := values[column]; ok {
+			group[column] = v
+			found++
+		}
+	}
+	return group, found
+}
+
+func (c *Connector) groupTTL(g *fieldGroups, name string) time.Duration {
+	if ttl, ok := g.ttls[name]; ok {
+		return ttl
+	}
+	return c.ttl
+}
+
+// readGroups serves the groups needed for minimumFields from the fallback,
+// reading the missing or expired ones from origin
+func (c *Connector) readGroups(ctx context.Context, span SpanEnder, ei *dosa.EntityInfo, e Encoder, g *fieldGroups,
+	keys map[string]dosa.FieldValue, minimumFields []string, cacheKey []byte) (map[string]dosa.FieldValue, error) {
+	adaptedEi := adaptToKeyValue(ei)
+	now := c.now()
+	values := map[string]dosa.FieldValue{}
+	var stale []string
+	var oldest time.Time
+	for _, name := range g.needed(ei, minimumFields) {
+		value, err := c.getValueFromFallback(ctx, adaptedEi, groupKey(cacheKey, name))
+		var entry *cacheEntry
+		if err == nil {
+			entry, err = c.decodeEntry(e, value)
+		}
+		if err != nil || entry.expired(now) {
+			stale = append(stale, name)
+			continue
+		}
+		for k, v := range c.served(entry.Values) {
+			values[k] = v
+		}
+		if oldest.IsZero() || entry.WrittenAt.Before(oldest) {
+			oldest = entry.WrittenAt
+		}
+	}
+	if len(stale) == 0 {
+		c.recordFallback(span, ei, true)
+		c.recordServedAge(ei, oldest)
+		return values, nil
+	}
+
+	source, err := c.Next.Read(ctx, ei, keys, g.columnsOf(ei, stale))
+	tagOrigin(span, err)
+	if err != nil {
+		return nil, err
+	}
+	source = c.canonicalColumns(ei, source)
+	if c.populateOnMiss {
+		c.writeBack(rowsSize(source), func() error {
+			newCtx, cancel := createContextForFallback(ctx)
+			defer cancel()
+			return c.writeGroups(newCtx, ei, e, g, cacheKey, source, stale)
+		})
+	}
+	for k, v := range source {
+		values[k] = v
+	}
+	return values, nil
+}
+
+// writeGroups writes the named groups of values to the fallback, stopping at
+// the first error
+func (c *Connector) writeGroups(ctx context.Context, ei *dosa.EntityInfo, e Encoder, g *fieldGroups, cacheKey []byte, values map[string]dosa.FieldValue, names []string) error {
+	adaptedEi := adaptToKeyValue(ei)
+	for _, name := range names {
+		group, _ := groupValues(ei, values, g.columnsOf(ei, []string{name}))
+		cacheValue, err := c.encodeEntryTTL(ei, e, group, c.groupTTL(g, name))
+		if err != nil {
+			return err
+		}
+		if err := c.writeFallback(ctx, adaptedEi, groupKey(cacheKey, name), cacheValue); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upsertGroups rewrites the groups whose columns are all set in values and
+// removes the ones only some of whose columns are
+func (c *Connector) upsertGroups(ctx context.Context, ei *dosa.EntityInfo, e Encoder, g *fieldGroups, cacheKey []byte, values map[string]dosa.FieldValue) error {
+	var complete, partial []string
+	for _, name := range g.all(ei) {
+		columns := g.columnsOf(ei, []string{name})
+		switch _, found := groupValues(ei, values, columns); {
+		case found == len(columns):
+			complete = append(complete, name)
+		case found > 0:
+			partial = append(partial, name)
+		}
+	}
+	if err := c.removeGroups(ctx, ei, cacheKey, partial); err != nil {
+		return err
+	}
+	return c.writeGroups(ctx, ei, e, g, cacheKey, values, complete)
+}
+
+// removeGroups removes the named groups from the fallback. Groups that are
+// not there are not an error.
+func (c *Connector) removeGroups(ctx context.Context, ei *dosa.EntityInfo, cacheKey []byte, names []string) error {
+	adaptedEi := adaptToKeyValue(ei)
+	for _, name := range names {
+		k := c.storedKey(groupKey(cacheKey, name))
+		if err := c.fallback.Remove(ctx, adaptedEi, map[string]dosa.FieldValue{key: k}); err != nil && !dosa.ErrorIsNotFound(err) {
+			return err
+		}
+		atomic.AddInt64(&c.counters.removes, 1)
+	}
+	return nil
+}
+
+// cachedGroups returns the fallback entries of every group of the row stored
+// under cacheKey, or nil if one of them is missing
+func (c *Connector) cachedGroups(ctx context.Context, ei *dosa.EntityInfo, e Encoder, g *fieldGroups, cacheKey []byte) (map[string]*cacheEntry, error) {
+	adaptedEi := adaptToKeyValue(ei)
+	entries := map[string]*cacheEntry{}
+	for _, name := range g.all(ei) {
+		data, err := c.getValueFromFallback(ctx, adaptedEi, groupKey(cacheKey, name))
+		if ErrorIsCacheMiss(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entry, err := c.decodeEntry(e, data)
+		if err != nil {
+			return nil, err
+		}
+		c.applyPin(ei, cacheKey, entry)
+		entries[name] = entry
+	}
+	return entries, nil
+}
+
+// getCachedGroups serves GetCached for a grouped entity: the row is cached if
+// every one of its groups is there and live
+func (c *Connector) getCachedGroups(ctx context.Context, ei *dosa.EntityInfo, e Encoder, g *fieldGroups, cacheKey []byte) (map[string]dosa.FieldValue, bool, error) {
+	entries, err := c.cachedGroups(ctx, ei, e, g, cacheKey)
+	if err != nil || entries == nil {
+		return nil, false, err
+	}
+	now := c.now()
+	values := map[string]dosa.FieldValue{}
+	for _, entry := range entries {
+		if entry.expired(now) {
+			return nil, false, nil
+		}
+		for k, v := range c.served(entry.Values) {
+			values[k] = v
+		}
+	}
+	return values, true, nil
+}
+
+// ttlRemainingGroups serves TTLRemaining for a grouped entity: the row
+// expires with the first of its groups to expire
+func (c *Connector) ttlRemainingGroups(ctx context.Context, ei *dosa.EntityInfo, e Encoder, g *fieldGroups, cacheKey []byte) (time.Duration, bool, error) {
+	entries, err := c.cachedGroups(ctx, ei, e, g, cacheKey)
+	if err != nil || entries == nil {
+		return 0, false, err
+	}
+	now := c.now()
+	remaining := NoExpiry
+	for _, entry := range entries {
+		if entry.TTL <= 0 {
+			continue
+		}
+		left := entry.remaining(now)
+		if left < 0 {
+			left = 0
+		}
+		if left < remaining {
+			remaining = left
+		}
+	}
+	return remaining, true, nil
+}
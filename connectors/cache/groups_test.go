@@ -0,0 +1,243 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/mocks"
+)
+
+func TestFieldGroups(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	connector := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(
+			WithTTL(time.Hour),
+			WithFieldGroups(testEi.Def.Name, map[string][]string{
+				"score":   {"an_int64_value"},
+				"profile": {"strv", "boolv"},
+			}),
+			WithFieldGroupTTLs(testEi.Def.Name, map[string]time.Duration{"score": time.Minute}),
+		)
+	connector.setSynchronousMode(true)
+	now := testNow
+	connector.now = func() time.Time { return now }
+
+	keys := map[string]dosa.FieldValue{
+		"an_uuid_key": "d1449c93-25b8-4032-920b-60471d91acc9",
+		"strkey":      "test key string",
+		"int64key":    int64(2932),
+	}
+	withValues := func(values map[string]dosa.FieldValue) map[string]dosa.FieldValue {
+		for k, v := range keys {
+			values[k] = v
+		}
+		return values
+	}
+	fields := []string{"strv", "an_int64_value"}
+
+	// nothing is cached, so both groups come from origin
+	mockOrigin.EXPECT().Read(gomock.Any(), testEi, keys, []string{"an_int64_value", "boolv", "strv"}).
+		Return(withValues(map[string]dosa.FieldValue{"strv": "name", "boolv": true, "an_int64_value": int64(1)}), nil)
+	values, err := connector.Read(context.TODO(), testEi, keys, fields)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, values["an_int64_value"])
+	assert.Equal(t, int64(2), connector.Stats().Writes)
+
+	// both are now served from the fallback; the JSON encoder decodes
+	// numbers as float64, hence EqualValues
+	values, err = connector.Read(context.TODO(), testEi, keys, fields)
+	assert.NoError(t, err)
+	assert.Equal(t, "name", values["strv"])
+	assert.Equal(t, true, values["boolv"])
+	assert.EqualValues(t, 1, values["an_int64_value"])
+
+	// once the score expires only it is read again
+	now = now.Add(2 * time.Minute)
+	mockOrigin.EXPECT().Read(gomock.Any(), testEi, keys, []string{"an_int64_value"}).
+		Return(withValues(map[string]dosa.FieldValue{"an_int64_value": int64(2)}), nil)
+	values, err = connector.Read(context.TODO(), testEi, keys, fields)
+	assert.NoError(t, err)
+	assert.Equal(t, "name", values["strv"])
+	assert.EqualValues(t, 2, values["an_int64_value"])
+
+	// a failed origin read of an expired group fails the read
+	now = now.Add(2 * time.Minute)
+	mockOrigin.EXPECT().Read(gomock.Any(), testEi, keys, []string{"an_int64_value"}).Return(nil, assert.AnError)
+	_, err = connector.Read(context.TODO(), testEi, keys, fields)
+	assert.Equal(t, assert.AnError, err)
+
+	// a profile-only read does not need the score
+	values, err = connector.Read(context.TODO(), testEi, keys, []string{"strv"})
+	assert.NoError(t, err)
+	assert.Equal(t, "name", values["strv"])
+
+	// an upsert rewrites the groups it sets in full and drops the others
+	mockOrigin.EXPECT().Upsert(gomock.Any(), testEi, gomock.Any()).Return(nil)
+	assert.NoError(t, connector.Upsert(context.TODO(), testEi, withValues(map[string]dosa.FieldValue{"an_int64_value": int64(3), "strv": "renamed"})))
+	values, err = connector.Read(context.TODO(), testEi, keys, []string{"an_int64_value"})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, values["an_int64_value"])
+	mockOrigin.EXPECT().Read(gomock.Any(), testEi, keys, []string{"boolv", "strv"}).
+		Return(withValues(map[string]dosa.FieldValue{"strv": "renamed", "boolv": false}), nil)
+	values, err = connector.Read(context.TODO(), testEi, keys, []string{"strv"})
+	assert.NoError(t, err)
+	assert.Equal(t, "renamed", values["strv"])
+
+	// a remove drops every group
+	mockOrigin.EXPECT().Remove(gomock.Any(), testEi, keys).Return(nil)
+	assert.NoError(t, connector.Remove(context.TODO(), testEi, keys))
+	mockOrigin.EXPECT().Read(gomock.Any(), testEi, keys, []string{"an_int64_value", "boolv", "strv"}).Return(nil, &dosa.ErrNotFound{})
+	_, err = connector.Read(context.TODO(), testEi, keys, fields)
+	assert.True(t, dosa.ErrorIsNotFound(err))
+}
+
+func TestPopulateOnMiss(t *testing.T) {
+	keys := batchKeys(1)
+	row := batchKeys(1)
+	row["strv"] = "from origin"
+	for _, populate := range []bool{true, false} {
+		ctrl := gomock.NewController(t)
+		mockOrigin := mocks.NewMockConnector(ctrl)
+		connector := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+			WithOptions(
+				WithFieldGroups(testEi.Def.Name, map[string][]string{"profile": {"strv"}}),
+				WithPopulateOnMiss(populate),
+			)
+		connector.setSynchronousMode(true)
+
+		// the fallback is consulted first and misses, so origin answers
+		mockOrigin.EXPECT().Read(gomock.Any(), testEi, keys, []string{"strv"}).Return(row, nil)
+		values, err := connector.Read(context.TODO(), testEi, keys, []string{"strv"})
+		assert.NoError(t, err)
+		assert.Equal(t, "from origin", values["strv"])
+
+		if populate {
+			// populated, so the next read does not go to origin
+			assert.Equal(t, int64(1), connector.Stats().Writes)
+			values, err = connector.Read(context.TODO(), testEi, keys, []string{"strv"})
+			assert.NoError(t, err)
+			assert.Equal(t, "from origin", values["strv"])
+		} else {
+			assert.Equal(t, int64(0), connector.Stats().Writes)
+		}
+		ctrl.Finish()
+	}
+
+	// origin-first reads are not written back either when turned off
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	connector := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithPopulateOnMiss(false))
+	connector.setSynchronousMode(true)
+	mockOrigin.EXPECT().Read(gomock.Any(), testEi, keys, dosa.All()).Return(row, nil)
+	_, err := connector.Read(context.TODO(), testEi, keys, dosa.All())
+	assert.NoError(t, err)
+	_, found, err := connector.GetCached(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestFieldGroupsCachedAccess(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	connector := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(
+			WithTTL(time.Hour),
+			WithFieldGroups(testEi.Def.Name, map[string][]string{"profile": {"strv", "boolv"}}),
+			WithFieldGroupTTLs(testEi.Def.Name, map[string]time.Duration{"profile": time.Minute}),
+		)
+	connector.setSynchronousMode(true)
+	now := testNow
+	connector.now = func() time.Time { return now }
+
+	keys := batchKeys(1)
+	row := batchKeys(1)
+	row["strv"] = "name"
+	row["boolv"] = true
+	row["an_int64_value"] = int64(7)
+
+	// nothing is cached yet
+	_, found, err := connector.GetCached(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.False(t, found)
+	_, found, err = connector.TTLRemaining(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	// PutCached writes every group, so a read is served without origin
+	assert.NoError(t, connector.PutCached(context.TODO(), testEi, row))
+	values, err := connector.Read(context.TODO(), testEi, keys, []string{"strv"})
+	assert.NoError(t, err)
+	assert.Equal(t, "name", values["strv"])
+	cached, found, err := connector.GetCached(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "name", cached["strv"])
+	assert.EqualValues(t, 7, cached["an_int64_value"])
+
+	// the unnamed group only holds the ungrouped columns
+	ungrouped, err := connector.getValueFromFallback(context.TODO(), adaptToKeyValue(testEi), createCacheKey(testEi, keys, connector.encoderFor(testEi)))
+	assert.NoError(t, err)
+	entry, err := connector.decodeEntry(connector.encoderFor(testEi), ungrouped)
+	assert.NoError(t, err)
+	assert.NotContains(t, entry.Values, "strv")
+	assert.Contains(t, entry.Values, "an_int64_value")
+
+	// the row expires with its shortest-lived group
+	now = now.Add(30 * time.Second)
+	remaining, found, err := connector.TTLRemaining(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 30*time.Second, remaining)
+	now = now.Add(time.Minute)
+	_, found, err = connector.GetCached(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	// InvalidateMany drops every group
+	assert.NoError(t, connector.PutCached(context.TODO(), testEi, row))
+	assert.NoError(t, connector.InvalidateMany(context.TODO(), testEi, []map[string]dosa.FieldValue{keys}))
+	_, found, err = connector.TTLRemaining(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.False(t, found)
+	mockOrigin.EXPECT().Read(gomock.Any(), testEi, keys, []string{"boolv", "strv"}).Return(nil, &dosa.ErrNotFound{})
+	_, err = connector.Read(context.TODO(), testEi, keys, []string{"strv"})
+	assert.True(t, dosa.ErrorIsNotFound(err))
+
+	// WarmByScan writes every group too
+	mockOrigin.EXPECT().Scan(gomock.Any(), testEi, dosa.All(), "", warmPageSize).Return([]map[string]dosa.FieldValue{row}, "", nil)
+	assert.NoError(t, connector.WarmByScan(context.TODO(), testEi))
+	cached, found, err = connector.GetCached(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, true, cached["boolv"])
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/uber-go/dosa"
+)
+
+// WithHedgedReads bounds how long a slow origin can hold up a Read. Once
+// origin has taken longer than delay, the fallback is looked up in parallel
+// and whichever answers first is returned. A successful origin response that
+// arrives before a usable fallback entry always wins; when the fallback wins,
+// the origin read keeps running in the background and its result is written
+// back as usual. A delay of zero or less disables hedging, which is the
+// default. Hedging only applies to point reads.
+func WithHedgedReads(delay time.Duration) Option {
+	return func(c *Connector) {
+		c.hedgeDelay = delay
+	}
+}
+
+type originResult struct {
+	values map[string]dosa.FieldValue
+	err    error
+}
+
+type fallbackResult struct {
+	entry *cacheEntry
+	err   error
+}
+
+// hedgedRead reads from origin and, if origin has not answered within
+// hedgeDelay, races it against a fallback lookup
+func (c *Connector) hedgedRead(ctx context.Context, span SpanEnder, ei *dosa.EntityInfo, e Encoder, keys map[string]dosa.FieldValue, cacheKey []byte) (map[string]dosa.FieldValue, error) {
+	originCh := make(chan originResult, 1)
+	go func() {
+		values, err := c.readOrigin(ctx, ei, keys)
+		originCh <- originResult{values: values, err: err}
+	}()
+
+	timer := time.NewTimer(c.hedgeDelay)
+	defer timer.Stop()
+
+	// fallbackCh stays nil, and so never selected, until the hedge starts
+	var fallbackCh chan fallbackResult
+	startFallback := func() {
+		if fallbackCh != nil {
+			return
+		}
+		atomic.AddInt64(&c.counters.hedgedReads, 1)
+		span.SetTag(tagHedged, true)
+		fallbackCh = make(chan fallbackResult, 1)
+		go func() {
+			entry, err := c.lookupFallback(ctx, ei, e, keys, cacheKey)
+			fallbackCh <- fallbackResult{entry: entry, err: err}
+		}()
+	}
+	// finish answers from origin if it succeeded, and otherwise from the
+	// fallback lookup, starting it if it has not been already
+	finish := func(o originResult, f *fallbackResult) (map[string]dosa.FieldValue, error) {
+		tagOrigin(span, o.err)
+		if o.err == nil {
+			c.writeBackRead(ctx, ei, e, cacheKey, o.values)
+			return o.values, nil
+		}
+		if f == nil {
+			startFallback()
+			r := <-fallbackCh
+			f = &r
+		}
+		return c.serveFallback(span, ei, e, keys, cacheKey, o.values, o.err, f.entry, f.err)
+	}
+
+	for {
+		select {
+		case o := <-originCh:
+			return finish(o, nil)
+		case <-timer.C:
+			startFallback()
+		case f := <-fallbackCh:
+			// prefer origin if it finished at the same time
+			select {
+			case o := <-originCh:
+				return finish(o, &f)
+			default:
+			}
+			if f.err != nil || f.entry.expired(c.now()) {
+				// nothing usable in the fallback, so wait for origin
+				return finish(<-originCh, &f)
+			}
+			atomic.AddInt64(&c.counters.hedgeWins, 1)
+			c.awaitOrigin(ctx, ei, e, cacheKey, originCh)
+			return c.serveFallback(span, ei, e, keys, cacheKey, nil, nil, f.entry, nil)
+		}
+	}
+}
+
+// awaitOrigin writes back the result of an origin read that lost the race
+// against the fallback. It counts as a pending write, so Close waits for it.
+func (c *Connector) awaitOrigin(ctx context.Context, ei *dosa.EntityInfo, e Encoder, cacheKey []byte, originCh <-chan originResult) {
+	c.mux.Lock()
+	c.pending++
+	c.mux.Unlock()
+	go func() {
+		defer c.writeDone()
+		if o := <-originCh; o.err == nil {
+			c.writeBackRead(ctx, ei, e, cacheKey, o.values)
+		}
+	}()
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/mocks"
+)
+
+func TestHedgedReads(t *testing.T) {
+	keys := map[string]dosa.FieldValue{
+		"an_uuid_key": "d1449c93-25b8-4032-920b-60471d91acc9",
+		"strkey":      "test key string",
+		"int64key":    int64(2932),
+	}
+	withValue := func(v string) map[string]dosa.FieldValue {
+		row := map[string]dosa.FieldValue{"strv": v}
+		for k, val := range keys {
+			row[k] = val
+		}
+		return row
+	}
+	newConnector := func(t *testing.T, delay time.Duration) (*Connector, *mocks.MockConnector, *gomock.Controller) {
+		ctrl := gomock.NewController(t)
+		mockOrigin := mocks.NewMockConnector(ctrl)
+		connector := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+			WithOptions(WithHedgedReads(delay))
+		return connector, mockOrigin, ctrl
+	}
+
+	t.Run("slow origin loses to the fallback", func(t *testing.T) {
+		connector, mockOrigin, ctrl := newConnector(t, 10*time.Millisecond)
+		defer ctrl.Finish()
+		assert.NoError(t, connector.PutCached(context.TODO(), testEi, withValue("cached")))
+
+		release := make(chan struct{})
+		mockOrigin.EXPECT().Read(context.TODO(), testEi, keys, dosa.All()).
+			Do(func(context.Context, *dosa.EntityInfo, map[string]dosa.FieldValue, []string) { <-release }).
+			Return(withValue("origin"), nil)
+
+		values, err := connector.Read(context.TODO(), testEi, keys, dosa.All())
+		assert.NoError(t, err)
+		assert.Equal(t, "cached", values["strv"])
+		stats := connector.Stats()
+		assert.Equal(t, int64(1), stats.HedgedReads)
+		assert.Equal(t, int64(1), stats.HedgeWins)
+
+		// the origin result still lands in the fallback once it arrives
+		close(release)
+		n, err := connector.Close(context.TODO())
+		assert.NoError(t, err)
+		assert.Equal(t, 0, n)
+		cached, found, err := connector.GetCached(context.TODO(), testEi, keys)
+		assert.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "origin", cached["strv"])
+	})
+
+	t.Run("fast origin is not hedged", func(t *testing.T) {
+		connector, mockOrigin, ctrl := newConnector(t, time.Hour)
+		defer ctrl.Finish()
+		assert.NoError(t, connector.PutCached(context.TODO(), testEi, withValue("cached")))
+
+		mockOrigin.EXPECT().Read(context.TODO(), testEi, keys, dosa.All()).Return(withValue("origin"), nil)
+		values, err := connector.Read(context.TODO(), testEi, keys, dosa.All())
+		assert.NoError(t, err)
+		assert.Equal(t, "origin", values["strv"])
+		_, err = connector.Close(context.TODO())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), connector.Stats().HedgedReads)
+	})
+
+	t.Run("fallback miss waits for origin", func(t *testing.T) {
+		connector, mockOrigin, ctrl := newConnector(t, time.Millisecond)
+		defer ctrl.Finish()
+
+		originErr := errors.New("origin unavailable")
+		mockOrigin.EXPECT().Read(context.TODO(), testEi, keys, dosa.All()).
+			Do(func(context.Context, *dosa.EntityInfo, map[string]dosa.FieldValue, []string) {
+				time.Sleep(20 * time.Millisecond)
+			}).
+			Return(nil, originErr)
+
+		_, err := connector.Read(context.TODO(), testEi, keys, dosa.All())
+		assert.Equal(t, originErr, err)
+		stats := connector.Stats()
+		assert.Equal(t, int64(1), stats.HedgedReads)
+		assert.Equal(t, int64(0), stats.HedgeWins)
+	})
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa/connectors/memory"
+)
+
+func TestStatsHandler(t *testing.T) {
+	ctx := context.TODO()
+	origin := memory.NewConnector()
+	connector := NewConnector(origin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithPerEntityStats(true))
+	connector.setSynchronousMode(true)
+	keys := batchKeys(1)
+	assert.NoError(t, connector.PutCached(ctx, testEi, keys))
+	_, found, err := connector.GetCached(ctx, testEi, keys)
+	assert.NoError(t, err)
+	assert.True(t, found)
+
+	server := httptest.NewServer(StatsHandler(connector))
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = resp.Body.Close() }()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	var stats map[string]interface{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&stats))
+	for _, name := range []string{"Hits", "Misses", "Writes", "WriteErrors", "Removes", "PendingWrites", "PendingBytes", "WriteBacksPaused"} {
+		assert.Contains(t, stats, name)
+	}
+	assert.EqualValues(t, 1, stats["Writes"])
+	assert.EqualValues(t, 0, stats["PendingWrites"])
+	assert.IsType(t, map[string]interface{}{}, stats["Entities"])
+
+	resp, err = http.Post(server.URL, "application/json", nil)
+	if assert.NoError(t, err) {
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
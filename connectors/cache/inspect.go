@@ -0,0 +1,135 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/uber-go/dosa"
+)
+
+// InspectResult describes a row as origin returns it and the state of its
+// fallback entry
+type InspectResult struct {
+	// Origin holds the row read from origin, nil if the read failed
+	Origin map[string]dosa.FieldValue
+	// OriginErr is the error origin returned, if any
+	OriginErr error
+	// Cached reports whether the fallback holds an entry for the row,
+	// expired or not
+	Cached bool
+	// CachedValues holds the values of the fallback entry
+	CachedValues map[string]dosa.FieldValue
+	// WrittenAt and TTL are zero for entries written before entries carried
+	// a write time
+	WrittenAt time.Time
+	TTL       time.Duration
+	// Expired reports whether the entry is past its TTL and would no
+	// longer be served
+	Expired bool
+	// MatchesOrigin reports whether the entry holds the values last read from
+	// origin. It is only set when the row is cached and origin was read
+	// successfully; Diff then lists the columns that differ.
+	MatchesOrigin bool
+	Diff          []string
+}
+
+// Inspect reads the row from origin and its entry from the fallback, for
+// tools that show an operator what the cache holds. Origin errors are
+// reported in the result rather than returned. The fallback is not updated.
+//
+// To compare the two, the origin values are passed through the same
+// encoding as a write back, so columns excluded from the fallback and type
+// changes made by the encoder are not reported as differences.
+func (c *Connector) Inspect(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) (InspectResult, error) {
+	result := InspectResult{}
+	if !c.isCacheable(ei) {
+		return result, notCachedError(ei)
+	}
+	e := c.encoderFor(ei)
+	cacheKey, err := buildFullCacheKey(ei, keys, e)
+	if err != nil {
+		return result, err
+	}
+
+	result.Origin, result.OriginErr = c.Next.Read(ctx, ei, keys, dosa.All())
+	if result.OriginErr != nil {
+		result.Origin = nil
+	}
+
+	data, err := c.getValueFromFallback(ctx, adaptToKeyValue(ei), cacheKey)
+	if ErrorIsCacheMiss(err) {
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+	entry, err := c.decodeEntry(e, data)
+	if err != nil {
+		return result, err
+	}
+	result.Cached = true
+	result.CachedValues = entry.Values
+	result.WrittenAt = entry.WrittenAt
+	result.TTL = entry.TTL
+	result.Expired = entry.expired(c.now())
+
+	if result.OriginErr != nil {
+		return result, nil
+	}
+	encoded, err := c.encodeEntry(ei, e, result.Origin)
+	if err != nil {
+		return result, err
+	}
+	normalized, err := c.decodeEntry(e, encoded)
+	if err != nil {
+		return result, err
+	}
+	result.Diff = dosa.FieldValuesDiff(normalized.Values, entry.Values)
+	result.MatchesOrigin = len(result.Diff) == 0
+	return result, nil
+}
+
+// VerifyAgainstCache reads the row from origin and its entry from the
+// fallback and returns the sorted names of the columns whose values differ,
+// empty when they agree. Both reads are made before it returns, for jobs that
+// audit the cache as they go; the values are compared as they are in Inspect
+// and the fallback is not updated. An origin error is returned as it is, and
+// a row without a live fallback entry fails with an error for which
+// ErrorIsCacheMiss is true.
+func (c *Connector) VerifyAgainstCache(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) ([]string, error) {
+	result, err := c.Inspect(ctx, ei, keys)
+	if err != nil {
+		return nil, err
+	}
+	if result.OriginErr != nil {
+		return nil, result.OriginErr
+	}
+	if !result.Cached || result.Expired {
+		return nil, newCacheError(ErrCacheMiss, errors.New("no live fallback entry for the row"))
+	}
+	if result.Diff == nil {
+		return []string{}, nil
+	}
+	return result.Diff, nil
+}
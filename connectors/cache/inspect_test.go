@@ -0,0 +1,158 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/mocks"
+)
+
+func TestInspect(t *testing.T) {
+	keys := map[string]dosa.FieldValue{
+		"an_uuid_key": "d1449c93-25b8-4032-920b-60471d91acc9",
+		"strkey":      "test key string",
+		"int64key":    int64(2932),
+	}
+	row := func(strv string) map[string]dosa.FieldValue {
+		r := map[string]dosa.FieldValue{"strv": strv}
+		for k, v := range keys {
+			r[k] = v
+		}
+		return r
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	connector := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithTTL(time.Minute))
+	connector.setSynchronousMode(true)
+	connector.now = func() time.Time { return testNow }
+
+	// uncached entities and partial keys are rejected
+	uncached := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil)
+	_, err := uncached.Inspect(context.TODO(), testEi, keys)
+	assert.Error(t, err)
+	_, err = connector.Inspect(context.TODO(), testEi, map[string]dosa.FieldValue{"strkey": "test key string"})
+	assert.Error(t, err)
+
+	// absent
+	mockOrigin.EXPECT().Read(context.TODO(), testEi, keys, dosa.All()).Return(row("origin"), nil)
+	result, err := connector.Inspect(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.False(t, result.Cached)
+	assert.False(t, result.MatchesOrigin)
+	assert.Equal(t, row("origin"), result.Origin)
+
+	// present and matching
+	assert.NoError(t, connector.PutCached(context.TODO(), testEi, row("origin")))
+	mockOrigin.EXPECT().Read(context.TODO(), testEi, keys, dosa.All()).Return(row("origin"), nil)
+	result, err = connector.Inspect(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.True(t, result.Cached)
+	assert.True(t, result.MatchesOrigin)
+	assert.Empty(t, result.Diff)
+	assert.Equal(t, testNow, result.WrittenAt)
+	assert.Equal(t, time.Minute, result.TTL)
+	assert.False(t, result.Expired)
+
+	// diverged
+	mockOrigin.EXPECT().Read(context.TODO(), testEi, keys, dosa.All()).Return(row("changed"), nil)
+	result, err = connector.Inspect(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.True(t, result.Cached)
+	assert.False(t, result.MatchesOrigin)
+	assert.Equal(t, []string{"strv"}, result.Diff)
+	assert.Equal(t, "origin", result.CachedValues["strv"])
+
+	// origin down and the entry expired
+	connector.now = func() time.Time { return testNow.Add(time.Hour) }
+	mockOrigin.EXPECT().Read(context.TODO(), testEi, keys, dosa.All()).Return(nil, assert.AnError)
+	result, err = connector.Inspect(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.Equal(t, assert.AnError, result.OriginErr)
+	assert.Nil(t, result.Origin)
+	assert.True(t, result.Cached)
+	assert.True(t, result.Expired)
+	assert.False(t, result.MatchesOrigin)
+}
+
+func TestVerifyAgainstCache(t *testing.T) {
+	keys := map[string]dosa.FieldValue{
+		"an_uuid_key": "d1449c93-25b8-4032-920b-60471d91acc9",
+		"strkey":      "test key string",
+		"int64key":    int64(2932),
+	}
+	row := func(strv string, boolv bool) map[string]dosa.FieldValue {
+		r := map[string]dosa.FieldValue{"strv": strv, "boolv": boolv}
+		for k, v := range keys {
+			r[k] = v
+		}
+		return r
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	connector := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithTTL(time.Minute))
+	connector.now = func() time.Time { return testNow }
+
+	// no entry
+	mockOrigin.EXPECT().Read(context.TODO(), testEi, keys, dosa.All()).Return(row("origin", true), nil)
+	_, err := connector.VerifyAgainstCache(context.TODO(), testEi, keys)
+	assert.True(t, ErrorIsCacheMiss(err))
+
+	// matching
+	assert.NoError(t, connector.PutCached(context.TODO(), testEi, row("origin", true)))
+	mockOrigin.EXPECT().Read(context.TODO(), testEi, keys, dosa.All()).Return(row("origin", true), nil)
+	diff, err := connector.VerifyAgainstCache(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.Empty(t, diff)
+
+	// diverging, which leaves the entry alone
+	mockOrigin.EXPECT().Read(context.TODO(), testEi, keys, dosa.All()).Return(row("changed", false), nil)
+	diff, err = connector.VerifyAgainstCache(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"boolv", "strv"}, diff)
+	cached, _, err := connector.GetCached(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.Equal(t, "origin", cached["strv"])
+	assert.Equal(t, int64(1), connector.Stats().Writes)
+
+	// origin errors are returned
+	mockOrigin.EXPECT().Read(context.TODO(), testEi, keys, dosa.All()).Return(nil, assert.AnError)
+	_, err = connector.VerifyAgainstCache(context.TODO(), testEi, keys)
+	assert.Equal(t, assert.AnError, err)
+
+	// and expired entries are not compared
+	connector.now = func() time.Time { return testNow.Add(time.Hour) }
+	mockOrigin.EXPECT().Read(context.TODO(), testEi, keys, dosa.All()).Return(row("origin", true), nil)
+	_, err = connector.VerifyAgainstCache(context.TODO(), testEi, keys)
+	assert.True(t, ErrorIsCacheMiss(err))
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"sync/atomic"
+
+	"github.com/uber-go/dosa"
+)
+
+// KeyBuilder returns the key under which the fallback stores the entry of the
+// row identified by keys. Connector.CacheKey is a KeyBuilder.
+type KeyBuilder func(ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) ([]byte, error)
+
+// WithKeyHMAC stores fallback entries under the HMAC-SHA256 of their cache
+// key, signed with secret, instead of under the key itself. Cache keys are
+// built from primary key values, so without it anyone who can list the
+// fallback store can read those values; with it the stored keys reveal
+// nothing without the secret. Unlike a plain hash, the keys cannot be
+// recovered by hashing guessed values. Every connector sharing a fallback
+// must use the same secret, and changing it makes existing entries
+// unreachable until they expire.
+func WithKeyHMAC(secret []byte) Option {
+	return func(c *Connector) {
+		c.keyHMAC = append([]byte(nil), secret...)
+	}
+}
+
+// storedKey returns the key under which the entry for cacheKey is stored in
+// the fallback
+func (c *Connector) storedKey(cacheKey []byte) []byte {
+	if len(c.keyHMAC) == 0 {
+		return cacheKey
+	}
+	mac := hmac.New(sha256.New, c.keyHMAC)
+	_, _ = mac.Write(cacheKey)
+	return mac.Sum(nil)
+}
+
+// WithKeyMigration migrates fallback entries lazily after a change to the way
+// keys are stored, such as enabling WithKeyHMAC or switching encoders. When a
+// point read misses under the current key, the entry is looked up under the
+// key old would have stored it under; if it is there it is served and written
+// again under the current key. The old entry is left to expire so that
+// connectors still using the old scheme keep finding it. To migrate from the
+// settings of another connector, pass its CacheKey method.
+func WithKeyMigration(old KeyBuilder) Option {
+	return func(c *Connector) {
+		c.oldKeyBuilder = old
+	}
+}
+
+// migrateKey reads the entry of keys stored under the old key scheme and
+// schedules its write under cacheKey
+func (c *Connector) migrateKey(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, cacheKey []byte) ([]byte, error) {
+	oldKey, err := c.oldKeyBuilder(ei, keys)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(oldKey, c.storedKey(cacheKey)) {
+		return nil, newCacheError(ErrCacheMiss, errors.New("old key is the current key"))
+	}
+	adaptedEi := adaptToKeyValue(ei)
+	cacheValue, err := c.readStored(ctx, adaptedEi, oldKey)
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&c.counters.keyMigrations, 1)
+	c.writeBack(int64(len(cacheValue)), func() error {
+		newCtx, cancel := createContextForFallback(ctx)
+		defer cancel()
+		return c.writeFallback(newCtx, adaptedEi, cacheKey, cacheValue)
+	})
+	return cacheValue, nil
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/mocks"
+)
+
+func TestKeyHMAC(t *testing.T) {
+	keys := map[string]dosa.FieldValue{
+		"an_uuid_key": "d1449c93-25b8-4032-920b-60471d91acc9",
+		"strkey":      "someone@example.com",
+		"int64key":    int64(2932),
+	}
+	row := map[string]dosa.FieldValue{"strv": "cached"}
+	for k, v := range keys {
+		row[k] = v
+	}
+	fallback := memory.NewConnector()
+	newConnector := func(opts ...Option) *Connector {
+		return NewConnector(nil, fallback, NewJSONEncoder(), nil, cacheableEntities...).WithOptions(opts...)
+	}
+	plain := newConnector()
+	signed := newConnector(WithKeyHMAC([]byte("secret")))
+	sameSecret := newConnector(WithKeyHMAC([]byte("secret")))
+	otherSecret := newConnector(WithKeyHMAC([]byte("other secret")))
+
+	plainKey, err := plain.CacheKey(testEi, keys)
+	assert.NoError(t, err)
+	signedKey, err := signed.CacheKey(testEi, keys)
+	assert.NoError(t, err)
+	assert.Len(t, signedKey, 32)
+	assert.False(t, bytes.Contains(signedKey, []byte("someone@example.com")))
+
+	// keys are stable for a secret and differ across secrets
+	again, err := sameSecret.CacheKey(testEi, keys)
+	assert.NoError(t, err)
+	assert.Equal(t, signedKey, again)
+	otherKey, err := otherSecret.CacheKey(testEi, keys)
+	assert.NoError(t, err)
+	assert.NotEqual(t, signedKey, otherKey)
+	assert.NotEqual(t, plainKey, signedKey)
+
+	// entries are stored under the signed key and found with the same secret
+	assert.NoError(t, signed.PutCached(context.TODO(), testEi, row))
+	_, err = fallback.Read(context.TODO(), adaptToKeyValue(testEi), map[string]dosa.FieldValue{key: signedKey}, dosa.All())
+	assert.NoError(t, err)
+	_, err = fallback.Read(context.TODO(), adaptToKeyValue(testEi), map[string]dosa.FieldValue{key: plainKey}, dosa.All())
+	assert.True(t, dosa.ErrorIsNotFound(err))
+
+	cached, found, err := sameSecret.GetCached(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "cached", cached["strv"])
+	_, found, err = otherSecret.GetCached(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.False(t, found)
+	_, found, err = plain.GetCached(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	// and removed with it too
+	assert.NoError(t, sameSecret.InvalidateMany(context.TODO(), testEi, []map[string]dosa.FieldValue{keys}))
+	_, found, err = signed.GetCached(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestKeyMigration(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	keys := map[string]dosa.FieldValue{
+		"an_uuid_key": "d1449c93-25b8-4032-920b-60471d91acc9",
+		"strkey":      "test key string",
+		"int64key":    int64(2932),
+	}
+	row := map[string]dosa.FieldValue{"strv": "cached"}
+	for k, v := range keys {
+		row[k] = v
+	}
+	fallback := memory.NewConnector()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	old := NewConnector(nil, fallback, NewJSONEncoder(), nil, cacheableEntities...)
+	connector := NewConnector(mockOrigin, fallback, NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithKeyHMAC([]byte("secret")), WithKeyMigration(old.CacheKey))
+	connector.setSynchronousMode(true)
+
+	// an entry written under the old scheme is served
+	assert.NoError(t, old.PutCached(context.TODO(), testEi, row))
+	_, found, err := connector.GetCached(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.False(t, found)
+	mockOrigin.EXPECT().Read(context.TODO(), testEi, keys, dosa.All()).Return(nil, assert.AnError).Times(2)
+	values, err := connector.Read(context.TODO(), testEi, keys, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, "cached", values["strv"])
+	assert.Equal(t, int64(1), connector.Stats().KeyMigrations)
+
+	// and rewritten under the new one, which is what later reads find
+	cached, found, err := connector.GetCached(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "cached", cached["strv"])
+	values, err = connector.Read(context.TODO(), testEi, keys, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, "cached", values["strv"])
+	assert.Equal(t, int64(1), connector.Stats().KeyMigrations)
+
+	// the old entry is still there for connectors using the old scheme
+	_, found, err = old.GetCached(context.TODO(), testEi, keys)
+	assert.NoError(t, err)
+	assert.True(t, found)
+}
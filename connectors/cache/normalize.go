@@ -0,0 +1,106 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"strings"
+
+	"github.com/uber-go/dosa"
+)
+
+// WithColumnNormalization makes the connector spell column names the way
+// the entity definition does, matching them case-insensitively. It applies
+// to the keys, values and conditions passed to Read, Upsert, Remove and
+// Range, to the rows origin returns and to entries written to the fallback,
+// so that an origin answering with UserID for a column defined as userid
+// neither misses the cache nor leaves fields unset when rows are decoded
+// into entities. Names that match no column are passed on unchanged. It is
+// off by default; entries written before it was turned on are served as they
+// were stored.
+func WithColumnNormalization(normalize bool) Option {
+	return func(c *Connector) {
+		c.normalizeColumns = normalize
+	}
+}
+
+// canonicalColumns returns values with its names spelled as in the entity
+// definition. The map is only copied when a name has to change.
+func (c *Connector) canonicalColumns(ei *dosa.EntityInfo, values map[string]dosa.FieldValue) map[string]dosa.FieldValue {
+	if !c.normalizeColumns || values == nil || !hasDefinition(ei) {
+		return values
+	}
+	names := columnSpellings(ei)
+	changed := false
+	for name := range values {
+		if canonical, ok := names[strings.ToLower(name)]; ok && canonical != name {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return values
+	}
+	canonical := make(map[string]dosa.FieldValue, len(values))
+	for name, v := range values {
+		if spelled, ok := names[strings.ToLower(name)]; ok {
+			name = spelled
+		}
+		canonical[name] = v
+	}
+	return canonical
+}
+
+// canonicalRows applies canonicalColumns to every row
+func (c *Connector) canonicalRows(ei *dosa.EntityInfo, rows []map[string]dosa.FieldValue) []map[string]dosa.FieldValue {
+	if !c.normalizeColumns {
+		return rows
+	}
+	for i, row := range rows {
+		rows[i] = c.canonicalColumns(ei, row)
+	}
+	return rows
+}
+
+// canonicalConditions returns conditions keyed by the column names spelled as
+// in the entity definition
+func (c *Connector) canonicalConditions(ei *dosa.EntityInfo, conditions map[string][]*dosa.Condition) map[string][]*dosa.Condition {
+	if !c.normalizeColumns || conditions == nil || !hasDefinition(ei) {
+		return conditions
+	}
+	names := columnSpellings(ei)
+	canonical := make(map[string][]*dosa.Condition, len(conditions))
+	for name, conds := range conditions {
+		if spelled, ok := names[strings.ToLower(name)]; ok {
+			name = spelled
+		}
+		canonical[name] = append(canonical[name], conds...)
+	}
+	return canonical
+}
+
+// columnSpellings maps the lowercased column names of ei to their spelling
+func columnSpellings(ei *dosa.EntityInfo) map[string]string {
+	names := make(map[string]string, len(ei.Def.Columns))
+	for _, col := range ei.Def.Columns {
+		names[strings.ToLower(col.Name)] = col.Name
+	}
+	return names
+}
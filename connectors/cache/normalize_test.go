@@ -0,0 +1,114 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/mocks"
+)
+
+// mixedCaseKeys are the keys of batchKeys(1) named with the casing of the
+// entity's Go fields rather than its column names
+func mixedCaseKeys() map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{
+		"An_UUID_Key": "d1449c93-25b8-4032-920b-60471d91acc9",
+		"StrKey":      "test key string",
+		"Int64Key":    int64(1),
+	}
+}
+
+func TestColumnNormalization(t *testing.T) {
+	ctx := context.TODO()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	connector := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithColumnNormalization(true))
+	connector.setSynchronousMode(true)
+
+	canonical := batchKeys(1)
+	canonical["strv"] = "upserted"
+	upserted := mixedCaseKeys()
+	upserted["StrV"] = "upserted"
+
+	// a mixed-case upsert reaches origin and the fallback with the
+	// definition's names
+	mockOrigin.EXPECT().Upsert(ctx, testEi, canonical).Return(nil)
+	assert.NoError(t, connector.Upsert(ctx, testEi, upserted))
+	cached, found, err := connector.GetCached(ctx, testEi, batchKeys(1))
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "upserted", cached["strv"])
+
+	// mixed-case keys find the entry during an outage
+	mockOrigin.EXPECT().Read(ctx, testEi, batchKeys(1), dosa.All()).Return(nil, assert.AnError)
+	values, err := connector.Read(ctx, testEi, mixedCaseKeys(), dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, "upserted", values["strv"])
+
+	// mixed-case rows from origin are returned and cached canonically
+	fromOrigin := mixedCaseKeys()
+	fromOrigin["STRV"] = "read"
+	mockOrigin.EXPECT().Read(ctx, testEi, batchKeys(1), dosa.All()).Return(fromOrigin, nil)
+	values, err = connector.Read(ctx, testEi, batchKeys(1), dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, "read", values["strv"])
+	assert.NotContains(t, values, "STRV")
+	cached, _, err = connector.GetCached(ctx, testEi, batchKeys(1))
+	assert.NoError(t, err)
+	assert.Equal(t, "read", cached["strv"])
+
+	// ranges with mixed-case conditions share the entry of canonical ones
+	mixedConds := map[string][]*dosa.Condition{"StrKey": {{Op: dosa.Eq, Value: "test key string"}}}
+	conds := map[string][]*dosa.Condition{"strkey": {{Op: dosa.Eq, Value: "test key string"}}}
+	mockOrigin.EXPECT().Range(ctx, testEi, conds, dosa.All(), "", 10).
+		Return([]map[string]dosa.FieldValue{fromOrigin}, "", nil)
+	rows, _, err := connector.Range(ctx, testEi, mixedConds, dosa.All(), "", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "read", rows[0]["strv"])
+	mockOrigin.EXPECT().Range(ctx, testEi, conds, dosa.All(), "", 10).Return(nil, "", assert.AnError)
+	rows, _, err = connector.Range(ctx, testEi, conds, dosa.All(), "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 1)
+	assert.Equal(t, "read", rows[0]["strv"])
+}
+
+func TestColumnNormalizationOff(t *testing.T) {
+	ctx := context.TODO()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	connector := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...)
+	connector.setSynchronousMode(true)
+
+	fromOrigin := batchKeys(1)
+	fromOrigin["StrV"] = "read"
+	mockOrigin.EXPECT().Read(ctx, testEi, batchKeys(1), dosa.All()).Return(fromOrigin, nil)
+	values, err := connector.Read(ctx, testEi, batchKeys(1), dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, fromOrigin, values)
+}
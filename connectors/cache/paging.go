@@ -0,0 +1,257 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/uber-go/dosa"
+)
+
+// FallbackPaging controls what a Range served from the fallback does with a
+// cached page that holds more rows than the requested limit
+type FallbackPaging int
+
+const (
+	// Truncate returns the first limit rows of the cached page along with the
+	// page's own continuation token, so the remaining rows are skipped
+	Truncate FallbackPaging = iota + 1
+	// RepageFromCache returns the cached page limit rows at a time. Until the
+	// page is exhausted, the continuation token points into the cached page
+	// and the following calls are served from it without going to origin.
+	// The last slice carries the page's own token, which resumes paging
+	// against origin.
+	RepageFromCache
+)
+
+// WithFallbackPaging sets how oversized cached range pages are served. When
+// it is not set, cached pages are returned whole, whatever the limit.
+func WithFallbackPaging(mode FallbackPaging) Option {
+	return func(c *Connector) {
+		c.fallbackPaging = mode
+	}
+}
+
+// fallbackPagePrefix marks continuation tokens that point into a cached page
+const fallbackPagePrefix = "dosa-fallback-page:"
+
+// fallbackPage is the position within a cached range page. Token and Limit
+// are those of the request that cached the page, which the cache key is
+// derived from.
+type fallbackPage struct {
+	Token  string
+	Limit  int
+	Offset int
+}
+
+func (p fallbackPage) String() string {
+	data, _ := json.Marshal(p)
+	return fallbackPagePrefix + base64.RawURLEncoding.EncodeToString(data)
+}
+
+// parseFallbackPage decodes a token produced by fallbackPage.String. The
+// boolean is false for tokens that do not point into a cached page.
+func parseFallbackPage(token string) (fallbackPage, bool, error) {
+	var page fallbackPage
+	if !strings.HasPrefix(token, fallbackPagePrefix) {
+		return page, false, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(token, fallbackPagePrefix))
+	if err == nil {
+		err = json.Unmarshal(data, &page)
+	}
+	if err != nil || page.Offset < 0 {
+		return page, true, fmt.Errorf("malformed fallback page token %q", token)
+	}
+	return page, true, nil
+}
+
+// rangeCacheKey returns the fallback key of a range request encoded with e
+func rangeCacheKey(e Encoder, columnConditions map[string][]*dosa.Condition, token string, limit int) []byte {
+	cacheKey, _ := e.Encode(rangeQuery{
+		Conditions: canonicalizeConditions(columnConditions),
+		Token:      token,
+		Limit:      limit,
+	})
+	return cacheKey
+}
+
+// pageRows applies the fallback paging mode to a cached page that was
+// requested with token and limit
+func (c *Connector) pageRows(cached *rangeResults, token string, limit int) ([]map[string]dosa.FieldValue, string) {
+	if limit <= 0 || len(cached.Rows) <= limit {
+		return cached.Rows, cached.TokenNext
+	}
+	switch c.fallbackPaging {
+	case Truncate:
+		return cached.Rows[:limit], cached.TokenNext
+	case RepageFromCache:
+		return cached.Rows[:limit], fallbackPage{Token: token, Limit: limit, Offset: limit}.String()
+	}
+	return cached.Rows, cached.TokenNext
+}
+
+// rangeFromCachedPage serves the next limit rows of a cached page without
+// consulting origin, which would not understand the token
+func (c *Connector) rangeFromCachedPage(ctx context.Context, ei *dosa.EntityInfo, e Encoder, columnConditions map[string][]*dosa.Condition, page fallbackPage, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	cacheKey := rangeCacheKey(e, columnConditions, page.Token, page.Limit)
+	value, err := c.getValueFromFallback(ctx, adaptToKeyValue(ei), cacheKey)
+	c.logFallback("RANGE", err)
+	if err != nil {
+		return nil, "", fmt.Errorf("cached range page is no longer available: %v", err)
+	}
+	cached := rangeResults{}
+	if err := e.Decode(value, &cached); err != nil {
+		return nil, "", newCacheError(ErrCacheDecode, err)
+	}
+	if cached.expired(c.now()) {
+		return nil, "", fmt.Errorf("cached range page has expired")
+	}
+	if page.Offset >= len(cached.Rows) {
+		return []map[string]dosa.FieldValue{}, cached.TokenNext, nil
+	}
+	rows := cached.Rows[page.Offset:]
+	if limit <= 0 || len(rows) <= limit {
+		return c.servedRows(rows), cached.TokenNext, nil
+	}
+	page.Offset += limit
+	return c.servedRows(rows[:limit]), page.String(), nil
+}
+
+// WithTokenHandles keeps the continuation tokens returned by Range short.
+// A token longer than maxSize bytes is stored in the fallback and replaced
+// by an opaque handle, which Range resolves back to the token when it is
+// passed in again. Handles live as long as the fallback keeps them; passing
+// one that is no longer there fails the Range. If a token cannot be stored it
+// is returned as it is. Only cached entities are affected.
+func WithTokenHandles(maxSize int) Option {
+	return func(c *Connector) {
+		c.maxTokenSize = maxSize
+	}
+}
+
+// tokenHandlePrefix marks continuation tokens that are handles for a token
+// stored in the fallback
+const tokenHandlePrefix = "dosa-token:"
+
+// tokenHandle returns a handle for token if it is too long, storing it in
+// the fallback under the handle
+func (c *Connector) tokenHandle(ctx context.Context, ei *dosa.EntityInfo, token string) string {
+	if len(token) <= c.maxTokenSize {
+		return token
+	}
+	sum := sha256.Sum256([]byte(token))
+	handle := tokenHandlePrefix + base64.RawURLEncoding.EncodeToString(sum[:16])
+	if err := c.writeFallback(ctx, adaptToKeyValue(ei), []byte(handle), []byte(token)); err != nil {
+		return token
+	}
+	return handle
+}
+
+// resolveTokenHandle returns the token a handle stands for. Other tokens are
+// returned unchanged.
+func (c *Connector) resolveTokenHandle(ctx context.Context, ei *dosa.EntityInfo, token string) (string, error) {
+	if !strings.HasPrefix(token, tokenHandlePrefix) {
+		return token, nil
+	}
+	value, err := c.getValueFromFallback(ctx, adaptToKeyValue(ei), []byte(token))
+	if err != nil {
+		return "", fmt.Errorf("continuation token %q is no longer available: %v", token, err)
+	}
+	return string(value), nil
+}
+
+// maxRangePrefetches bounds the number of range prefetches in flight
+const maxRangePrefetches = 16
+
+// WithRangePrefetch reads the next page of a range ahead of the caller. When
+// Range serves a page from origin that has a continuation token, the page
+// after it is read from origin in the background, with the same conditions
+// and limit, and written to the fallback, so that it is there if origin
+// fails when the caller asks for it. Prefetched pages are subject to
+// WithCacheFullPagesOnly and WithRangeAdmissionThreshold like any other:
+// partial pages are dropped, and the next page is only read once its own
+// query has been admitted. At most one prefetch per page and
+// maxRangePrefetches in all run at a time; others are skipped. Close cancels
+// the prefetches still running.
+func WithRangePrefetch(prefetch bool) Option {
+	return func(c *Connector) {
+		c.rangePrefetch = prefetch
+		if prefetch && c.prefetching == nil {
+			c.prefetching = map[string]bool{}
+			c.prefetchCtx, c.cancelPrefetches = context.WithCancel(context.Background())
+		}
+	}
+}
+
+// prefetchRange reads the page of the range that starts at token from origin
+// and writes it to the fallback in the background
+func (c *Connector) prefetchRange(ei *dosa.EntityInfo, e Encoder, columnConditions map[string][]*dosa.Condition, token string, limit int) {
+	if c.writeBacksPaused() {
+		atomic.AddInt64(&c.counters.skippedWriteBacks, 1)
+		return
+	}
+	cacheKey := rangeCacheKey(e, columnConditions, token, limit)
+	// pages of queries that are not admitted would not be written back
+	if !c.rangeAdmitted(cacheKey) {
+		return
+	}
+	prefetchKey := ei.Def.Name + string(cacheKey)
+	c.mux.Lock()
+	if c.prefetching[prefetchKey] || len(c.prefetching) >= maxRangePrefetches || c.prefetchCtx.Err() != nil {
+		c.mux.Unlock()
+		return
+	}
+	c.prefetching[prefetchKey] = true
+	ctx := c.prefetchCtx
+	c.mux.Unlock()
+
+	_ = c.cacheWrite(func() error {
+		defer func() {
+			c.mux.Lock()
+			delete(c.prefetching, prefetchKey)
+			c.mux.Unlock()
+		}()
+		newCtx, cancel := createContextForFallback(ctx)
+		defer cancel()
+
+		rows, next, err := c.Next.Range(newCtx, ei, columnConditions, dosa.All(), token, limit)
+		if err != nil {
+			return err
+		}
+		rows = c.canonicalRows(ei, rows)
+		if c.cacheFullPagesOnly && limit > 0 && len(rows) < limit {
+			return nil
+		}
+		cacheValue, err := c.encodeRange(ei, e, rows, next)
+		if err != nil {
+			return err
+		}
+		atomic.AddInt64(&c.counters.rangePrefetches, 1)
+		return c.writeFallback(newCtx, adaptToKeyValue(ei), cacheKey, cacheValue)
+	})
+}
@@ -0,0 +1,261 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/mocks"
+)
+
+// newPagingConnector returns a connector with a cached page of five rows for
+// a request with limit 2, and an origin that is down from then on
+func newPagingConnector(t *testing.T, ctrl *gomock.Controller, opts ...Option) *Connector {
+	rows := make([]map[string]dosa.FieldValue, 5)
+	for i := range rows {
+		rows[i] = map[string]dosa.FieldValue{"int64key": int64(i)}
+	}
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	gomock.InOrder(
+		mockOrigin.EXPECT().Range(context.TODO(), testEi, nil, dosa.All(), "", 2).Return(rows, "origin-next", nil),
+		mockOrigin.EXPECT().Range(context.TODO(), testEi, nil, dosa.All(), "", 2).Return(nil, "", assert.AnError).AnyTimes(),
+	)
+	connector := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(opts...)
+	connector.setSynchronousMode(true)
+	_, _, err := connector.Range(context.TODO(), testEi, nil, dosa.All(), "", 2)
+	assert.NoError(t, err)
+	return connector
+}
+
+func pageKeys(rows []map[string]dosa.FieldValue) []int64 {
+	keys := make([]int64, len(rows))
+	for i, row := range rows {
+		keys[i] = int64(row["int64key"].(float64))
+	}
+	return keys
+}
+
+func TestFallbackPagingRepage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	connector := newPagingConnector(t, ctrl, WithFallbackPaging(RepageFromCache))
+
+	rows, token, err := connector.Range(context.TODO(), testEi, nil, dosa.All(), "", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{0, 1}, pageKeys(rows))
+
+	// the following pages come from the cached page, origin is not asked
+	rows, token, err = connector.Range(context.TODO(), testEi, nil, dosa.All(), token, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2, 3}, pageKeys(rows))
+	rows, token, err = connector.Range(context.TODO(), testEi, nil, dosa.All(), token, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{4}, pageKeys(rows))
+	assert.Equal(t, "origin-next", token)
+
+	// later calls may use a different limit
+	rows, token, err = connector.Range(context.TODO(), testEi, nil, dosa.All(), "", 2)
+	assert.NoError(t, err)
+	rows, token, err = connector.Range(context.TODO(), testEi, nil, dosa.All(), token, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2, 3, 4}, pageKeys(rows))
+	assert.Equal(t, "origin-next", token)
+}
+
+func TestFallbackPagingTruncate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	connector := newPagingConnector(t, ctrl, WithFallbackPaging(Truncate))
+
+	rows, token, err := connector.Range(context.TODO(), testEi, nil, dosa.All(), "", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{0, 1}, pageKeys(rows))
+	assert.Equal(t, "origin-next", token)
+}
+
+func TestFallbackPagingDefault(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	connector := newPagingConnector(t, ctrl)
+
+	rows, token, err := connector.Range(context.TODO(), testEi, nil, dosa.All(), "", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{0, 1, 2, 3, 4}, pageKeys(rows))
+	assert.Equal(t, "origin-next", token)
+}
+
+func TestFallbackPagingBadTokens(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	connector := newPagingConnector(t, ctrl, WithFallbackPaging(RepageFromCache))
+
+	_, _, err := connector.Range(context.TODO(), testEi, nil, dosa.All(), fallbackPagePrefix+"!!", 2)
+	assert.Contains(t, err.Error(), "malformed fallback page token")
+
+	// a token for a page that was never cached
+	token := fallbackPage{Token: "elsewhere", Limit: 2, Offset: 2}.String()
+	_, _, err = connector.Range(context.TODO(), testEi, nil, dosa.All(), token, 2)
+	assert.Contains(t, err.Error(), "no longer available")
+
+	// offsets past the end of the page resume against origin
+	token = fallbackPage{Limit: 2, Offset: 9}.String()
+	rows, next, err := connector.Range(context.TODO(), testEi, nil, dosa.All(), token, 2)
+	assert.NoError(t, err)
+	assert.Empty(t, rows)
+	assert.Equal(t, "origin-next", next)
+}
+
+func TestTokenHandles(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	connector := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithTokenHandles(64))
+	connector.setSynchronousMode(true)
+
+	longToken := strings.Repeat("t", 500)
+	rows := []map[string]dosa.FieldValue{{"int64key": int64(1)}}
+	gomock.InOrder(
+		mockOrigin.EXPECT().Range(gomock.Any(), testEi, nil, dosa.All(), "", 1).Return(rows, longToken, nil),
+		mockOrigin.EXPECT().Range(gomock.Any(), testEi, nil, dosa.All(), longToken, 1).Return(rows, "short", nil),
+	)
+
+	// the long token is replaced by a handle
+	_, handle, err := connector.Range(context.TODO(), testEi, nil, dosa.All(), "", 1)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(handle, tokenHandlePrefix))
+	assert.True(t, len(handle) <= 64)
+
+	// which is resolved on the next call, and short tokens pass through
+	_, next, err := connector.Range(context.TODO(), testEi, nil, dosa.All(), handle, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "short", next)
+
+	// handles that are not stored fail
+	_, _, err = connector.Range(context.TODO(), testEi, nil, dosa.All(), tokenHandlePrefix+"unknown", 1)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "no longer available")
+}
+
+func TestRangePrefetch(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	connector := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithRangePrefetch(true))
+	connector.setSynchronousMode(true)
+
+	page1 := []map[string]dosa.FieldValue{{"int64key": int64(1)}, {"int64key": int64(2)}}
+	page2 := []map[string]dosa.FieldValue{{"int64key": int64(3)}}
+	gomock.InOrder(
+		mockOrigin.EXPECT().Range(gomock.Any(), testEi, nil, dosa.All(), "", 2).Return(page1, "page2", nil),
+		mockOrigin.EXPECT().Range(gomock.Any(), testEi, nil, dosa.All(), "page2", 2).Return(page2, "", nil),
+		mockOrigin.EXPECT().Range(gomock.Any(), testEi, nil, dosa.All(), "page2", 2).Return(nil, "", assert.AnError),
+	)
+
+	// serving page 1 reads page 2 ahead, once
+	rows, next, err := connector.Range(context.TODO(), testEi, nil, dosa.All(), "", 2)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 2)
+	assert.Equal(t, "page2", next)
+	assert.Equal(t, int64(1), connector.Stats().RangePrefetches)
+
+	// so it is there when origin fails
+	rows, next, err = connector.Range(context.TODO(), testEi, nil, dosa.All(), "page2", 2)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, rows[0]["int64key"])
+	assert.Equal(t, "", next)
+	assert.Equal(t, int64(1), connector.Stats().RangePrefetches)
+}
+
+func TestRangePrefetchCancelledByClose(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	connector := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithRangePrefetch(true))
+
+	page1 := []map[string]dosa.FieldValue{{"int64key": int64(1)}}
+	started := make(chan struct{})
+	mockOrigin.EXPECT().Range(gomock.Any(), testEi, nil, dosa.All(), "", 1).Return(page1, "page2", nil)
+	mockOrigin.EXPECT().Range(gomock.Any(), testEi, nil, dosa.All(), "page2", 1).
+		Do(func(ctx context.Context, _ *dosa.EntityInfo, _ map[string][]*dosa.Condition, _ []string, _ string, _ int) {
+			close(started)
+			<-ctx.Done()
+		}).
+		Return(nil, "", context.Canceled)
+
+	_, _, err := connector.Range(context.TODO(), testEi, nil, dosa.All(), "", 1)
+	assert.NoError(t, err)
+	<-started
+	n, err := connector.Close(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, int64(0), connector.Stats().RangePrefetches)
+}
+
+func TestRangePrefetchGating(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	connector := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithRangePrefetch(true), WithCacheFullPagesOnly(true), WithRangeAdmissionThreshold(2))
+	connector.setSynchronousMode(true)
+
+	full := []map[string]dosa.FieldValue{{"int64key": int64(1)}, {"int64key": int64(2)}}
+	partial := []map[string]dosa.FieldValue{{"int64key": int64(3)}}
+	mockOrigin.EXPECT().Range(gomock.Any(), testEi, nil, dosa.All(), "", 2).Return(full, "page2", nil).Times(1)
+	mockOrigin.EXPECT().Range(gomock.Any(), testEi, nil, dosa.All(), "page2", 2).Return(full, "page3", nil).Times(3)
+	gomock.InOrder(
+		mockOrigin.EXPECT().Range(gomock.Any(), testEi, nil, dosa.All(), "page3", 2).Return(full, "", nil).Times(2),
+		// the prefetch finds page 3 shrunk
+		mockOrigin.EXPECT().Range(gomock.Any(), testEi, nil, dosa.All(), "page3", 2).Return(partial, "", nil).Times(1),
+	)
+	rangeOnce := func(token string) {
+		_, _, err := connector.Range(context.TODO(), testEi, nil, dosa.All(), token, 2)
+		assert.NoError(t, err)
+	}
+
+	// a one-off query is not cached, and page 2 is not prefetched since
+	// its query has not been admitted
+	rangeOnce("")
+	assert.Equal(t, int64(0), connector.Stats().Writes)
+
+	// page 2 is admitted on its second read, page 3 is not prefetched yet
+	rangeOnce("page2")
+	rangeOnce("page2")
+	assert.Equal(t, int64(1), connector.Stats().Writes)
+	rangeOnce("page3")
+	rangeOnce("page3")
+	assert.Equal(t, int64(2), connector.Stats().Writes)
+
+	// once admitted page 3 is prefetched, but a partial page is not cached
+	rangeOnce("page2")
+	assert.Equal(t, int64(3), connector.Stats().Writes)
+	assert.Equal(t, int64(0), connector.Stats().RangePrefetches)
+}
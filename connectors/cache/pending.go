@@ -0,0 +1,114 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"reflect"
+	"sync/atomic"
+
+	"github.com/uber-go/dosa"
+)
+
+// WithMaxPendingBytes caps the memory held by background fallback writes
+// that have not completed yet. The size of each write-back is estimated from
+// the rows it carries when it is scheduled; one that would take the pending
+// total over n bytes is dropped and counted in Stats.PendingBytesDropped, so
+// that a slow fallback sheds work instead of accumulating it. Writes are
+// accepted again as earlier ones drain. Refreshes and prefetches, whose rows
+// are only read once they run, are not counted. Zero, the default, means no
+// limit.
+func WithMaxPendingBytes(n int) Option {
+	return func(c *Connector) {
+		c.maxPendingBytes = int64(n)
+	}
+}
+
+// reservePending takes size bytes from the pending-bytes budget for a write
+// about to be scheduled, reporting whether the write may go ahead
+func (c *Connector) reservePending(size int64) bool {
+	if c.maxPendingBytes <= 0 || c.synchronous {
+		return true
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.pendingBytes+size > c.maxPendingBytes {
+		return false
+	}
+	c.pendingBytes += size
+	return true
+}
+
+// releasePending returns the bytes of a completed write to the budget
+func (c *Connector) releasePending(size int64) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.pendingBytes -= size
+}
+
+// writeBack schedules a write of origin data to the fallback, whose rows are
+// estimated to take size bytes, unless write backs are paused because the
+// fallback has been failing or the write does not fit the pending-bytes
+// budget
+func (c *Connector) writeBack(size int64, w func() error) {
+	if c.writeBacksPaused() {
+		atomic.AddInt64(&c.counters.skippedWriteBacks, 1)
+		return
+	}
+	if c.maxPendingBytes <= 0 || c.synchronous {
+		_ = c.cacheWrite(w)
+		return
+	}
+	if !c.reservePending(size) {
+		atomic.AddInt64(&c.counters.pendingBytesDropped, 1)
+		return
+	}
+	_ = c.cacheWrite(func() error {
+		defer c.releasePending(size)
+		return w()
+	})
+}
+
+// rowsSize estimates the memory held by rows: the length of column names,
+// strings and blobs, and eight bytes for other values
+func rowsSize(rows ...map[string]dosa.FieldValue) int64 {
+	var size int64
+	for _, row := range rows {
+		for name, v := range row {
+			size += int64(len(name)) + valueSize(v)
+		}
+	}
+	return size
+}
+
+func valueSize(v dosa.FieldValue) int64 {
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		v = rv.Elem().Interface()
+	}
+	switch t := v.(type) {
+	case string:
+		return int64(len(t))
+	case []byte:
+		return int64(len(t))
+	case dosa.UUID:
+		return int64(len(t))
+	}
+	return 8
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+)
+
+// slowFallback holds every upsert until it is released
+type slowFallback struct {
+	*memory.Connector
+	release chan struct{}
+}
+
+func (f *slowFallback) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	<-f.release
+	return f.Connector.Upsert(ctx, ei, values)
+}
+
+func TestMaxPendingBytes(t *testing.T) {
+	ctx := context.TODO()
+	fallback := &slowFallback{Connector: memory.NewConnector(), release: make(chan struct{})}
+	rowSize := rowsSize(batchKeys(1))
+	connector := NewConnector(memory.NewConnector(), fallback, NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithMaxPendingBytes(int(2 * rowSize)))
+
+	// the fallback does not drain, so only two rows fit the budget
+	for i := int64(1); i <= 5; i++ {
+		assert.NoError(t, connector.Upsert(ctx, testEi, batchKeys(i)))
+	}
+	assert.Equal(t, int64(3), connector.Stats().PendingBytesDropped)
+	connector.mux.Lock()
+	assert.Equal(t, 2*rowSize, connector.pendingBytes)
+	connector.mux.Unlock()
+
+	// once drained, writes are accepted again
+	close(fallback.release)
+	n, err := connector.Close(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	connector.mux.Lock()
+	assert.Equal(t, int64(0), connector.pendingBytes)
+	connector.mux.Unlock()
+	assert.NoError(t, connector.Upsert(ctx, testEi, batchKeys(6)))
+	_, err = connector.Close(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), connector.Stats().PendingBytesDropped)
+	assert.Equal(t, int64(3), connector.Stats().Writes)
+}
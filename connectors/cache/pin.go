@@ -0,0 +1,129 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+
+	"github.com/uber-go/dosa"
+)
+
+// EvictionPinner is implemented by fallbacks that evict entries on their
+// own, such as filecache with a size cap. Pin and Unpin hand it the key/value
+// row of each entry they pin, so that the fallback keeps pinned entries
+// however full it gets.
+type EvictionPinner interface {
+	PinEntry(ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error
+	UnpinEntry(ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error
+}
+
+// Pin exempts the fallback entry of the row identified by keys from expiry,
+// for rows that must stay available from the fallback through an origin
+// outage however long it lasts. keys must hold the complete primary key. If
+// the row is not in the fallback yet it is read from origin and written
+// there, and Pin fails if that read does. A pinned entry is still rewritten
+// by upserts and reads of the row and removed along with it, and its point
+// reads ignore the TTL it was written with. If the fallback implements
+// EvictionPinner the entry is also exempt from its eviction; other stores,
+// such as redis under memory pressure, may still drop it, after which the
+// row is read from origin again. Pins are kept by the connector and do not
+// survive it.
+func (c *Connector) Pin(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	if !c.isCacheable(ei) {
+		return notCachedError(ei)
+	}
+	cacheKey, err := buildFullCacheKey(ei, keys, c.encoderFor(ei))
+	if err != nil {
+		return err
+	}
+	if p, ok := c.fallback.(EvictionPinner); ok {
+		for _, k := range c.pinnedKeys(ei, cacheKey) {
+			if err := p.PinEntry(adaptToKeyValue(ei), k); err != nil {
+				return err
+			}
+		}
+	}
+	c.pinMux.Lock()
+	if c.pinned == nil {
+		c.pinned = map[string]bool{}
+	}
+	c.pinned[pinKey(ei, cacheKey)] = true
+	c.pinMux.Unlock()
+
+	if _, found, err := c.GetCached(ctx, ei, keys); err == nil && found {
+		return nil
+	}
+	values, err := c.Next.Read(ctx, ei, keys, dosa.All())
+	if err != nil {
+		return err
+	}
+	values = c.canonicalColumns(ei, values)
+	return c.PutCached(ctx, ei, values)
+}
+
+// Unpin lets the entry of the row identified by keys expire again
+func (c *Connector) Unpin(ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	if !c.isCacheable(ei) {
+		return notCachedError(ei)
+	}
+	cacheKey, err := buildFullCacheKey(ei, keys, c.encoderFor(ei))
+	if err != nil {
+		return err
+	}
+	c.pinMux.Lock()
+	delete(c.pinned, pinKey(ei, cacheKey))
+	c.pinMux.Unlock()
+	if p, ok := c.fallback.(EvictionPinner); ok {
+		for _, k := range c.pinnedKeys(ei, cacheKey) {
+			if err := p.UnpinEntry(adaptToKeyValue(ei), k); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// pinnedKeys returns the key/value rows of the fallback entries of a pinned
+// row, one per field group of grouped entities
+func (c *Connector) pinnedKeys(ei *dosa.EntityInfo, cacheKey []byte) []map[string]dosa.FieldValue {
+	names := []string{""}
+	if g := c.fieldGroups[ei.Def.Name]; g != nil {
+		names = g.all(ei)
+	}
+	keys := make([]map[string]dosa.FieldValue, len(names))
+	for i, name := range names {
+		keys[i] = map[string]dosa.FieldValue{key: c.storedKey(groupKey(cacheKey, name))}
+	}
+	return keys
+}
+
+// applyPin clears the TTL of a decoded point entry if it is pinned
+func (c *Connector) applyPin(ei *dosa.EntityInfo, cacheKey []byte, entry *cacheEntry) {
+	c.pinMux.RLock()
+	defer c.pinMux.RUnlock()
+	if len(c.pinned) > 0 && c.pinned[pinKey(ei, cacheKey)] {
+		entry.TTL = 0
+	}
+}
+
+func pinKey(ei *dosa.EntityInfo, cacheKey []byte) string {
+	return ei.Def.Name + string(cacheKey)
+}
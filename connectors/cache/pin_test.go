@@ -0,0 +1,107 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/filecache"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/mocks"
+)
+
+func TestPin(t *testing.T) {
+	pinned, other := batchKeys(1), batchKeys(2)
+	row := map[string]dosa.FieldValue{"strv": "config"}
+	for k, v := range pinned {
+		row[k] = v
+	}
+	ctx := context.TODO()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	connector := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithTTL(time.Minute))
+	now := testNow
+	connector.now = func() time.Time { return now }
+
+	// pinning a row that is not cached reads it from origin
+	mockOrigin.EXPECT().Read(ctx, testEi, pinned, dosa.All()).Return(row, nil)
+	assert.NoError(t, connector.Pin(ctx, testEi, pinned))
+	assert.NoError(t, connector.PutCached(ctx, testEi, other))
+	assert.Error(t, connector.Pin(ctx, testEi, map[string]dosa.FieldValue{"strkey": "partial"}))
+
+	// long past the TTL, only the pinned entry is still served
+	now = now.Add(time.Hour)
+	mockOrigin.EXPECT().Read(ctx, testEi, gomock.Any(), dosa.All()).Return(nil, assert.AnError).Times(3)
+	values, err := connector.Read(ctx, testEi, pinned, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, "config", values["strv"])
+	_, err = connector.Read(ctx, testEi, other, dosa.All())
+	assert.Equal(t, assert.AnError, err)
+	_, found, err := connector.GetCached(ctx, testEi, pinned)
+	assert.NoError(t, err)
+	assert.True(t, found)
+
+	// once unpinned it expires like any other
+	assert.NoError(t, connector.Unpin(testEi, pinned))
+	_, err = connector.Read(ctx, testEi, pinned, dosa.All())
+	assert.Equal(t, assert.AnError, err)
+}
+
+func TestPinExemptsFromFallbackEviction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pin")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	// a cap so small that every write evicts all but the newest entry
+	fallback, err := filecache.NewConnector(dir, filecache.WithMaxBytes(1))
+	assert.NoError(t, err)
+	ctx := context.TODO()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	connector := NewConnector(mocks.NewMockConnector(ctrl), fallback, NewJSONEncoder(), nil, cacheableEntities...)
+
+	assert.NoError(t, connector.PutCached(ctx, testEi, batchKeys(1)))
+	assert.NoError(t, connector.Pin(ctx, testEi, batchKeys(1)))
+	for i := int64(2); i <= 4; i++ {
+		assert.NoError(t, connector.PutCached(ctx, testEi, batchKeys(i)))
+	}
+	_, found, err := connector.GetCached(ctx, testEi, batchKeys(1))
+	assert.NoError(t, err)
+	assert.True(t, found)
+	_, found, err = connector.GetCached(ctx, testEi, batchKeys(2))
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	// unpinned, it goes with the next write
+	assert.NoError(t, connector.Unpin(testEi, batchKeys(1)))
+	assert.NoError(t, connector.PutCached(ctx, testEi, batchKeys(5)))
+	_, found, err = connector.GetCached(ctx, testEi, batchKeys(1))
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/uber-go/dosa"
+)
+
+// EncodedValue is a set of column values encoded once for the fallback by
+// PreEncode, to be written under many keys with UpsertEncoded
+type EncodedValue struct {
+	entity  string
+	encoder Encoder
+	values  map[string]dosa.FieldValue
+	data    []byte
+}
+
+// PreEncode encodes values, the non-key columns of a row of ei, the way the
+// fallback stores them. The result can be upserted to any number of keys
+// with UpsertEncoded without encoding it again. The entry's TTL runs from
+// the time of the PreEncode call, so long-lived producers should encode
+// again from time to time, and after a SetEncoder call.
+func (c *Connector) PreEncode(ei *dosa.EntityInfo, values map[string]dosa.FieldValue) (EncodedValue, error) {
+	if !c.isCacheable(ei) {
+		return EncodedValue{}, notCachedError(ei)
+	}
+	values = c.canonicalColumns(ei, values)
+	e := c.encoderFor(ei)
+	data, err := c.encodeEntry(ei, e, values)
+	if err != nil {
+		return EncodedValue{}, err
+	}
+	return EncodedValue{entity: ei.Def.Name, encoder: e, values: values, data: data}, nil
+}
+
+// UpsertEncoded upserts the row made of keys and the pre-encoded values to
+// origin, and writes the encoded bytes to the fallback as Upsert would. The
+// fallback entry holds only the columns given to PreEncode; reads served from
+// it return those, the keys being known to the caller. Entities that store
+// field groups or are tiered are encoded per call, as with Upsert.
+func (c *Connector) UpsertEncoded(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, ev EncodedValue) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ev.entity != entityName(ei) {
+		return fmt.Errorf("value was encoded for entity %q, not %q", ev.entity, entityName(ei))
+	}
+	keys = c.canonicalColumns(ei, keys)
+	values := make(map[string]dosa.FieldValue, len(ev.values)+len(keys))
+	for name, v := range ev.values {
+		values[name] = v
+	}
+	for name, v := range keys {
+		values[name] = v
+	}
+	if c.isTier(ei) || c.fieldGroups[ei.Def.Name] != nil {
+		return c.Upsert(ctx, ei, values)
+	}
+	ctx, span := c.startSpan(ctx, "dosa.cache.UpsertEncoded", entityName(ei))
+	defer span.Finish()
+
+	if !c.readOnlyPopulation {
+		c.writeBack(int64(len(ev.data)), func() error {
+			newCtx, cancel := createContextForFallback(ctx)
+			defer cancel()
+			return c.writeFallback(newCtx, adaptToKeyValue(ei), createCacheKey(ei, keys, ev.encoder), ev.data)
+		})
+	}
+
+	err := c.Next.Upsert(ctx, ei, values)
+	tagOrigin(span, err)
+	return err
+}
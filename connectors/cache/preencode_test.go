@@ -0,0 +1,110 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/devnull"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/mocks"
+	"github.com/uber-go/dosa/testentity"
+)
+
+func TestUpsertEncoded(t *testing.T) {
+	ctx := context.TODO()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	connector := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...)
+	connector.setSynchronousMode(true)
+
+	template := map[string]dosa.FieldValue{"strv": "template", "an_int64_value": int64(7)}
+	ev, err := connector.PreEncode(testEi, template)
+	assert.NoError(t, err)
+
+	for i := int64(1); i <= 3; i++ {
+		row := batchKeys(i)
+		for name, v := range template {
+			row[name] = v
+		}
+		mockOrigin.EXPECT().Upsert(ctx, testEi, row).Return(nil)
+		assert.NoError(t, connector.UpsertEncoded(ctx, testEi, batchKeys(i), ev))
+	}
+	assert.Equal(t, int64(3), connector.Stats().Writes)
+
+	// a normal Read falls back to the pre-encoded entry
+	mockOrigin.EXPECT().Read(ctx, testEi, batchKeys(2), dosa.All()).Return(nil, assert.AnError)
+	values, err := connector.Read(ctx, testEi, batchKeys(2), dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, "template", values["strv"])
+	assert.EqualValues(t, 7, values["an_int64_value"])
+
+	// the value is bound to its entity
+	otherEi := createTestEi(schemaRef)
+	otherEi.Def = &dosa.EntityDefinition{Name: "other", Key: testEi.Def.Key, Columns: testEi.Def.Columns}
+	assert.Error(t, connector.UpsertEncoded(ctx, otherEi, batchKeys(1), ev))
+	uncached := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil)
+	_, err = uncached.PreEncode(testEi, template)
+	assert.Error(t, err)
+}
+
+// benchmarkTemplate is a row heavy enough for its encoding to matter
+var benchmarkTemplate = map[string]dosa.FieldValue{
+	"strv":           strings.Repeat("template value ", 64),
+	"an_int64_value": int64(7),
+	"blobv":          []byte(strings.Repeat("x", 4096)),
+}
+
+func newBenchmarkConnector() *Connector {
+	connector := NewConnector(devnull.NewConnector(), memory.NewConnector(), NewJSONEncoder(), nil, &testentity.TestEntity{})
+	connector.setSynchronousMode(true)
+	return connector
+}
+
+func BenchmarkUpsert(b *testing.B) {
+	connector := newBenchmarkConnector()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		row := batchKeys(int64(i % 100))
+		for name, v := range benchmarkTemplate {
+			row[name] = v
+		}
+		_ = connector.Upsert(context.TODO(), testEi, row)
+	}
+}
+
+func BenchmarkUpsertEncoded(b *testing.B) {
+	connector := newBenchmarkConnector()
+	ev, err := connector.PreEncode(testEi, benchmarkTemplate)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = connector.UpsertEncoded(context.TODO(), testEi, batchKeys(int64(i%100)), ev)
+	}
+}
@@ -0,0 +1,579 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/uber-go/dosa"
+)
+
+// protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// NewProtoEncoder returns an encoder that writes the Protocol Buffers
+// messages described in cache.proto, so that the fallback store can be read
+// from other languages. It supports the values the cache connector stores:
+// field value maps, cache keys, point entries, range queries and range
+// results. Field values must be one of the DOSA scalar types or a pointer to
+// one, or of a type registered through TypeRegistrar, which it implements.
+func NewProtoEncoder() Encoder {
+	return &protoEncoder{}
+}
+
+type protoEncoder struct {
+	customTypes
+}
+
+// Encode marshals v into the protobuf message matching its type
+func (p *protoEncoder) Encode(v interface{}) ([]byte, error) {
+	v, err := p.encodeValues(v)
+	if err != nil {
+		return nil, err
+	}
+	w := &protoWriter{}
+	switch t := v.(type) {
+	case map[string]dosa.FieldValue:
+		err = w.row(t)
+	case []map[string]dosa.FieldValue:
+		for _, row := range t {
+			err = w.message(1, func(w *protoWriter) error { return w.row(row) })
+			if err != nil {
+				break
+			}
+		}
+	case cacheEntry:
+		err = w.message(1, func(w *protoWriter) error { return w.row(t.Values) })
+		if !t.WrittenAt.IsZero() {
+			w.varint(2, uint64(t.WrittenAt.UnixNano()))
+		}
+		w.varint(3, uint64(t.TTL))
+		if t.ContentID != "" {
+			w.string(4, t.ContentID)
+		}
+		if t.SoftTTL != 0 {
+			w.varint(5, uint64(t.SoftTTL))
+		}
+	case rangeQuery:
+		for _, cc := range t.Conditions {
+			err = w.message(1, func(w *protoWriter) error { return w.condition(cc) })
+			if err != nil {
+				break
+			}
+		}
+		w.string(2, t.Token)
+		w.varint(3, uint64(int64(t.Limit)))
+	case rangeResults:
+		for _, row := range t.Rows {
+			err = w.message(1, func(w *protoWriter) error { return w.row(row) })
+			if err != nil {
+				break
+			}
+		}
+		w.string(2, t.TokenNext)
+		if t.Empty {
+			w.varint(3, 1)
+		}
+		if t.WrittenAt != nil {
+			w.varint(4, uint64(t.WrittenAt.UnixNano()))
+		}
+		if t.TTL != 0 {
+			w.varint(5, uint64(t.TTL))
+		}
+	default:
+		return nil, fmt.Errorf("proto encoder cannot encode %T", v)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return w.buf, nil
+}
+
+// Decode unmarshals data into v, which must point to one of the types
+// supported by Encode
+func (p *protoEncoder) Decode(data []byte, v interface{}) error {
+	if err := p.decode(data, v); err != nil {
+		return err
+	}
+	return p.decodeValues(v)
+}
+
+func (p *protoEncoder) decode(data []byte, v interface{}) error {
+	r := &protoReader{buf: data}
+	switch t := v.(type) {
+	case *map[string]dosa.FieldValue:
+		row, err := r.row()
+		if err != nil {
+			return err
+		}
+		*t = row
+		return nil
+	case *[]map[string]dosa.FieldValue:
+		rows := []map[string]dosa.FieldValue{}
+		err := r.fields(func(field, wire int) error {
+			if field != 1 {
+				return r.skip(wire)
+			}
+			row, err := r.embeddedRow()
+			rows = append(rows, row)
+			return err
+		})
+		*t = rows
+		return err
+	case *cacheEntry:
+		entry := cacheEntry{}
+		err := r.fields(func(field, wire int) error {
+			switch field {
+			case 1:
+				row, err := r.embeddedRow()
+				entry.Values = row
+				return err
+			case 2:
+				n, err := r.varint()
+				entry.WrittenAt = time.Unix(0, int64(n)).UTC()
+				return err
+			case 3:
+				n, err := r.varint()
+				entry.TTL = time.Duration(n)
+				return err
+			case 4:
+				s, err := r.bytes()
+				entry.ContentID = string(s)
+				return err
+			case 5:
+				n, err := r.varint()
+				entry.SoftTTL = time.Duration(n)
+				return err
+			}
+			return r.skip(wire)
+		})
+		*t = entry
+		return err
+	case *rangeQuery:
+		query := rangeQuery{}
+		err := r.fields(func(field, wire int) error {
+			switch field {
+			case 1:
+				inner, err := r.embedded()
+				if err != nil {
+					return err
+				}
+				cc, err := inner.condition()
+				query.Conditions = append(query.Conditions, cc)
+				return err
+			case 2:
+				s, err := r.bytes()
+				query.Token = string(s)
+				return err
+			case 3:
+				n, err := r.varint()
+				query.Limit = int(int64(n))
+				return err
+			}
+			return r.skip(wire)
+		})
+		*t = query
+		return err
+	case *rangeResults:
+		results := rangeResults{}
+		err := r.fields(func(field, wire int) error {
+			switch field {
+			case 1:
+				row, err := r.embeddedRow()
+				results.Rows = append(results.Rows, row)
+				return err
+			case 2:
+				s, err := r.bytes()
+				results.TokenNext = string(s)
+				return err
+			case 3:
+				n, err := r.varint()
+				results.Empty = n != 0
+				return err
+			case 4:
+				n, err := r.varint()
+				writtenAt := time.Unix(0, int64(n)).UTC()
+				results.WrittenAt = &writtenAt
+				return err
+			case 5:
+				n, err := r.varint()
+				results.TTL = time.Duration(n)
+				return err
+			}
+			return r.skip(wire)
+		})
+		*t = results
+		return err
+	}
+	return fmt.Errorf("proto encoder cannot decode into %T", v)
+}
+
+type protoWriter struct {
+	buf []byte
+}
+
+func (w *protoWriter) tag(field, wire int) {
+	w.buf = appendUvarint(w.buf, uint64(field)<<3|uint64(wire))
+}
+
+func (w *protoWriter) varint(field int, v uint64) {
+	w.tag(field, wireVarint)
+	w.buf = appendUvarint(w.buf, v)
+}
+
+func (w *protoWriter) fixed64(field int, v uint64) {
+	w.tag(field, wireFixed64)
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	w.buf = append(w.buf, b[:]...)
+}
+
+func (w *protoWriter) bytes(field int, b []byte) {
+	w.tag(field, wireBytes)
+	w.buf = appendUvarint(w.buf, uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+func (w *protoWriter) string(field int, s string) {
+	if s != "" {
+		w.bytes(field, []byte(s))
+	}
+}
+
+// message writes an embedded message built by f as field
+func (w *protoWriter) message(field int, f func(*protoWriter) error) error {
+	inner := &protoWriter{}
+	if err := f(inner); err != nil {
+		return err
+	}
+	w.bytes(field, inner.buf)
+	return nil
+}
+
+// row writes the fields of a Row message, in column name order
+func (w *protoWriter) row(values map[string]dosa.FieldValue) error {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err := w.message(1, func(w *protoWriter) error {
+			w.bytes(1, []byte(name))
+			return w.message(2, func(w *protoWriter) error {
+				return w.value(values[name])
+			})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *protoWriter) condition(cc *dosa.ColumnCondition) error {
+	w.string(1, cc.Name)
+	if cc.Condition == nil {
+		return nil
+	}
+	w.varint(2, uint64(int64(cc.Condition.Op)))
+	return w.message(3, func(w *protoWriter) error {
+		return w.value(cc.Condition.Value)
+	})
+}
+
+// value writes the fields of a Value message
+func (w *protoWriter) value(v dosa.FieldValue) error {
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			v = nil
+		} else {
+			v = rv.Elem().Interface()
+		}
+	}
+	switch t := v.(type) {
+	case nil:
+		w.varint(1, 1)
+	case bool:
+		var b uint64
+		if t {
+			b = 1
+		}
+		w.varint(2, b)
+	case int32:
+		w.varint(3, uint64(int64(t)))
+	case int64:
+		w.varint(4, uint64(t))
+	case float64:
+		w.fixed64(5, math.Float64bits(t))
+	case string:
+		w.bytes(6, []byte(t))
+	case []byte:
+		w.bytes(7, t)
+	case dosa.UUID:
+		w.bytes(8, []byte(t))
+	case time.Time:
+		w.varint(9, uint64(t.UnixNano()))
+	case customValue:
+		return w.message(10, func(w *protoWriter) error {
+			w.string(1, t.Type)
+			w.bytes(2, t.Data)
+			return nil
+		})
+	default:
+		return fmt.Errorf("proto encoder cannot encode field value of type %T", v)
+	}
+	return nil
+}
+
+type protoReader struct {
+	buf []byte
+}
+
+func (r *protoReader) varint() (uint64, error) {
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		return 0, fmt.Errorf("proto encoder: malformed varint")
+	}
+	r.buf = r.buf[n:]
+	return v, nil
+}
+
+func (r *protoReader) fixed64() (uint64, error) {
+	if len(r.buf) < 8 {
+		return 0, fmt.Errorf("proto encoder: truncated fixed64")
+	}
+	v := binary.LittleEndian.Uint64(r.buf)
+	r.buf = r.buf[8:]
+	return v, nil
+}
+
+func (r *protoReader) bytes() ([]byte, error) {
+	n, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(r.buf)) < n {
+		return nil, fmt.Errorf("proto encoder: truncated field")
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b, nil
+}
+
+func (r *protoReader) skip(wire int) error {
+	var err error
+	switch wire {
+	case wireVarint:
+		_, err = r.varint()
+	case wireFixed64:
+		_, err = r.fixed64()
+	case wireBytes:
+		_, err = r.bytes()
+	case wireFixed32:
+		if len(r.buf) < 4 {
+			return fmt.Errorf("proto encoder: truncated fixed32")
+		}
+		r.buf = r.buf[4:]
+	default:
+		err = fmt.Errorf("proto encoder: unsupported wire type %d", wire)
+	}
+	return err
+}
+
+// fields calls f for each field of the message until the input is consumed
+func (r *protoReader) fields(f func(field, wire int) error) error {
+	for len(r.buf) > 0 {
+		tag, err := r.varint()
+		if err != nil {
+			return err
+		}
+		if err := f(int(tag>>3), int(tag&7)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// embedded returns a reader over the embedded message in the next
+// length-delimited field
+func (r *protoReader) embedded() (*protoReader, error) {
+	b, err := r.bytes()
+	return &protoReader{buf: b}, err
+}
+
+// embeddedRow parses the next field as an embedded Row message
+func (r *protoReader) embeddedRow() (map[string]dosa.FieldValue, error) {
+	inner, err := r.embedded()
+	if err != nil {
+		return nil, err
+	}
+	return inner.row()
+}
+
+// row parses the fields of a Row message
+func (r *protoReader) row() (map[string]dosa.FieldValue, error) {
+	row := map[string]dosa.FieldValue{}
+	err := r.fields(func(field, wire int) error {
+		if field != 1 {
+			return r.skip(wire)
+		}
+		entry, err := r.embedded()
+		if err != nil {
+			return err
+		}
+		var name string
+		var value dosa.FieldValue
+		err = entry.fields(func(field, wire int) error {
+			switch field {
+			case 1:
+				s, err := entry.bytes()
+				name = string(s)
+				return err
+			case 2:
+				inner, err := entry.embedded()
+				if err != nil {
+					return err
+				}
+				value, err = inner.value()
+				return err
+			}
+			return entry.skip(wire)
+		})
+		row[name] = value
+		return err
+	})
+	return row, err
+}
+
+func (r *protoReader) condition() (*dosa.ColumnCondition, error) {
+	cc := &dosa.ColumnCondition{Condition: &dosa.Condition{}}
+	err := r.fields(func(field, wire int) error {
+		switch field {
+		case 1:
+			s, err := r.bytes()
+			cc.Name = string(s)
+			return err
+		case 2:
+			n, err := r.varint()
+			cc.Condition.Op = dosa.Operator(int64(n))
+			return err
+		case 3:
+			inner, err := r.embedded()
+			if err != nil {
+				return err
+			}
+			cc.Condition.Value, err = inner.value()
+			return err
+		}
+		return r.skip(wire)
+	})
+	return cc, err
+}
+
+// value parses the fields of a Value message
+func (r *protoReader) value() (dosa.FieldValue, error) {
+	var value dosa.FieldValue
+	err := r.fields(func(field, wire int) error {
+		var err error
+		switch field {
+		case 1:
+			_, err = r.varint()
+			value = nil
+		case 2:
+			var n uint64
+			n, err = r.varint()
+			value = n != 0
+		case 3:
+			var n uint64
+			n, err = r.varint()
+			value = int32(int64(n))
+		case 4:
+			var n uint64
+			n, err = r.varint()
+			value = int64(n)
+		case 5:
+			var n uint64
+			n, err = r.fixed64()
+			value = math.Float64frombits(n)
+		case 6:
+			var b []byte
+			b, err = r.bytes()
+			value = string(b)
+		case 7:
+			var b []byte
+			b, err = r.bytes()
+			value = append([]byte{}, b...)
+		case 8:
+			var b []byte
+			b, err = r.bytes()
+			value = dosa.UUID(b)
+		case 9:
+			var n uint64
+			n, err = r.varint()
+			value = time.Unix(0, int64(n)).UTC()
+		case 10:
+			var inner *protoReader
+			if inner, err = r.embedded(); err == nil {
+				value, err = inner.customValue()
+			}
+		default:
+			err = r.skip(wire)
+		}
+		return err
+	})
+	return value, err
+}
+
+// customValue reads the fields of a CustomValue message
+func (r *protoReader) customValue() (customValue, error) {
+	cv := customValue{}
+	err := r.fields(func(field, wire int) error {
+		switch field {
+		case 1:
+			b, err := r.bytes()
+			cv.Type = string(b)
+			return err
+		case 2:
+			b, err := r.bytes()
+			cv.Data = append([]byte{}, b...)
+			return err
+		}
+		return r.skip(wire)
+	})
+	return cv, err
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	return append(buf, b[:n]...)
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/mocks"
+)
+
+var p = NewProtoEncoder()
+
+func TestProtoEncoder_FieldValues(t *testing.T) {
+	str := "pointed"
+	var unset *int64
+	now := time.Unix(1500000000, 123).UTC()
+	values := map[string]dosa.FieldValue{
+		"bool":      true,
+		"int32":     int32(-7),
+		"int64":     int64(1) << 40,
+		"double":    float64(3.25),
+		"string":    "hello",
+		"blob":      []byte{0, 1, 2},
+		"uuid":      dosa.UUID("d1449c93-25b8-4032-920b-60471d91acc9"),
+		"timestamp": now,
+		"zero":      int64(0),
+		"null":      nil,
+	}
+	data, err := p.Encode(values)
+	assert.NoError(t, err)
+	result := map[string]dosa.FieldValue{}
+	assert.NoError(t, p.Decode(data, &result))
+	assert.Equal(t, values, result)
+
+	// pointers are written as the values they point to
+	data, err = p.Encode(map[string]dosa.FieldValue{"ptr": &str, "unset": unset})
+	assert.NoError(t, err)
+	assert.NoError(t, p.Decode(data, &result))
+	assert.Equal(t, map[string]dosa.FieldValue{"ptr": "pointed", "unset": nil}, result)
+
+	// the encoding is deterministic
+	again, err := p.Encode(values)
+	assert.NoError(t, err)
+	first, _ := p.Encode(values)
+	assert.Equal(t, first, again)
+
+	_, err = p.Encode(map[string]dosa.FieldValue{"bad": struct{}{}})
+	assert.Error(t, err)
+}
+
+func TestProtoEncoder_Wire(t *testing.T) {
+	// Row{fields: {"a": Value{int64_value: 1}}}
+	data, err := p.Encode(map[string]dosa.FieldValue{"a": int64(1)})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x0a, 0x07, 0x0a, 0x01, 'a', 0x12, 0x02, 0x20, 0x01}, data)
+}
+
+func TestProtoEncoder_CacheKey(t *testing.T) {
+	keys := []map[string]dosa.FieldValue{{"a": "x"}, {"b": int64(2)}}
+	data, err := p.Encode(keys)
+	assert.NoError(t, err)
+	var result []map[string]dosa.FieldValue
+	assert.NoError(t, p.Decode(data, &result))
+	assert.Equal(t, keys, result)
+}
+
+func TestProtoEncoder_CacheEntry(t *testing.T) {
+	entry := cacheEntry{
+		Values:    map[string]dosa.FieldValue{"a": "b"},
+		WrittenAt: time.Unix(1500000000, 0).UTC(),
+		TTL:       time.Minute,
+		SoftTTL:   30 * time.Second,
+	}
+	data, err := p.Encode(entry)
+	assert.NoError(t, err)
+	result := cacheEntry{}
+	assert.NoError(t, p.Decode(data, &result))
+	assert.Equal(t, entry, result)
+}
+
+func TestProtoEncoder_Range(t *testing.T) {
+	query := rangeQuery{
+		Conditions: []*dosa.ColumnCondition{
+			{Name: "c1", Condition: &dosa.Condition{Op: dosa.GtOrEq, Value: int32(5)}},
+			{Name: "c2", Condition: &dosa.Condition{Op: dosa.Eq, Value: "v"}},
+		},
+		Token: "token",
+		Limit: 10,
+	}
+	data, err := p.Encode(query)
+	assert.NoError(t, err)
+	queryResult := rangeQuery{}
+	assert.NoError(t, p.Decode(data, &queryResult))
+	assert.Equal(t, query, queryResult)
+
+	writtenAt := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	results := rangeResults{
+		Rows:      []map[string]dosa.FieldValue{{"a": "b"}, {"a": "c"}},
+		TokenNext: "next",
+		WrittenAt: &writtenAt,
+		TTL:       time.Minute,
+	}
+	data, err = p.Encode(results)
+	assert.NoError(t, err)
+	rangeResult := rangeResults{}
+	assert.NoError(t, p.Decode(data, &rangeResult))
+	assert.Equal(t, results, rangeResult)
+
+	data, err = p.Encode(rangeResults{Empty: true})
+	assert.NoError(t, err)
+	rangeResult = rangeResults{}
+	assert.NoError(t, p.Decode(data, &rangeResult))
+	assert.True(t, rangeResult.Empty)
+	assert.Empty(t, rangeResult.Rows)
+}
+
+func TestProtoEncoder_Errors(t *testing.T) {
+	_, err := p.Encode(22)
+	assert.Error(t, err)
+	var i int
+	assert.Error(t, p.Decode([]byte{}, &i))
+	result := map[string]dosa.FieldValue{}
+	assert.Error(t, p.Decode([]byte{0x0a, 0x07, 0x0a}, &result))
+}
+
+func TestProtoEncoder_Fallback(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	values := map[string]dosa.FieldValue{
+		"an_uuid_key": dosa.UUID("d1449c93-25b8-4032-920b-60471d91acc9"),
+		"strkey":      "test key string",
+		"int64key":    int64(2932),
+		"strv":        "test value string",
+		"boolv":       false,
+	}
+	mockOrigin.EXPECT().Upsert(context.TODO(), testEi, values).Return(nil)
+	mockOrigin.EXPECT().Read(context.TODO(), testEi, values, dosa.All()).Return(nil, assert.AnError)
+
+	connector := NewConnector(mockOrigin, memory.NewConnector(), NewProtoEncoder(), nil, cacheableEntities...)
+	connector.setSynchronousMode(true)
+	assert.NoError(t, connector.Upsert(context.TODO(), testEi, values))
+	resp, err := connector.Read(context.TODO(), testEi, values, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, values, resp)
+}
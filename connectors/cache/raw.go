@@ -0,0 +1,123 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+
+	"github.com/uber-go/dosa"
+)
+
+// RawCached returns the bytes stored in the fallback for the row identified
+// by keys, exactly as they were written, and whether there are any. keys must
+// hold the complete primary key. Nothing is decoded or verified, so expired
+// and corrupted entries are returned too.
+//
+// The bytes are the point entry wrapped in the envelopes enabled when it was
+// written, outermost first:
+//
+//	checksum     WithChecksums: 0xd0 0x5a, then a big-endian CRC-32 (IEEE)
+//	             of the rest of the value
+//	compression  WithCompression: 0xc0 if the rest is stored as it is, or
+//	             0xc1 if it is raw DEFLATE data
+//	entry        the connector's encoder applied to a struct with the fields
+//	             Values (the row, keyed by column name), WrittenAt, TTL (in
+//	             nanoseconds) and, if set, ContentID and SoftTTL
+//
+// A value without the checksum magic has no checksum, and one whose first
+// remaining byte is neither compression header was written without
+// compression. Entities split with WithFieldGroups have no point entry, and
+// report none.
+func (c *Connector) RawCached(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) ([]byte, bool, error) {
+	if !c.isCacheable(ei) {
+		return nil, false, notCachedError(ei)
+	}
+	if c.fieldGroups[ei.Def.Name] != nil {
+		return nil, false, nil
+	}
+	storedKey, err := c.rawKey(ei, keys)
+	if err != nil {
+		return nil, false, err
+	}
+	return c.readRaw(ctx, ei, storedKey)
+}
+
+// InvalidateIfMatches removes the fallback entry of the row identified by
+// keys only if its stored bytes, as RawCached returns them, are still
+// expected, and reports whether it did. Use it to evict a version known to
+// be stale without discarding a newer one a concurrent refresh may have
+// written in the meantime. The fallback offers no compare-and-delete, so the
+// comparison and the removal are separate calls: this narrows the race but
+// a write landing between them is still removed. Like RawCached, it never
+// matches entities split with WithFieldGroups.
+func (c *Connector) InvalidateIfMatches(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, expectedEncoded []byte) (bool, error) {
+	if !c.isCacheable(ei) {
+		return false, notCachedError(ei)
+	}
+	if c.fieldGroups[ei.Def.Name] != nil {
+		return false, nil
+	}
+	storedKey, err := c.rawKey(ei, keys)
+	if err != nil {
+		return false, err
+	}
+	raw, found, err := c.readRaw(ctx, ei, storedKey)
+	if err != nil || !found || !bytes.Equal(raw, expectedEncoded) {
+		return false, err
+	}
+	err = c.fallback.Remove(ctx, adaptToKeyValue(ei), map[string]dosa.FieldValue{key: storedKey})
+	if dosa.ErrorIsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	atomic.AddInt64(&c.counters.removes, 1)
+	return true, nil
+}
+
+// rawKey returns the key the point entry of the row identified by keys is
+// stored under
+func (c *Connector) rawKey(ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) ([]byte, error) {
+	cacheKey, err := buildFullCacheKey(ei, keys, c.encoderFor(ei))
+	if err != nil {
+		return nil, err
+	}
+	return c.storedKey(cacheKey), nil
+}
+
+// readRaw reads the bytes stored under storedKey, without opening them
+func (c *Connector) readRaw(ctx context.Context, ei *dosa.EntityInfo, storedKey []byte) ([]byte, bool, error) {
+	response, err := c.fallback.Read(ctx, adaptToKeyValue(ei), map[string]dosa.FieldValue{key: storedKey}, dosa.All())
+	if dosa.ErrorIsNotFound(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	raw, ok := response[value].([]byte)
+	if !ok {
+		return nil, false, nil
+	}
+	return raw, true, nil
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+)
+
+func TestRawCached(t *testing.T) {
+	ctx := context.TODO()
+	fallback := memory.NewConnector()
+	connector := NewConnector(memory.NewConnector(), fallback, NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithChecksums(false), WithCompression(1<<20))
+	keys := batchKeys(1)
+
+	_, found, err := connector.RawCached(ctx, testEi, keys)
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	assert.NoError(t, connector.PutCached(ctx, testEi, keys))
+	raw, found, err := connector.RawCached(ctx, testEi, keys)
+	assert.NoError(t, err)
+	assert.True(t, found)
+
+	cacheKey, err := connector.CacheKey(testEi, keys)
+	assert.NoError(t, err)
+	stored, err := fallback.Read(ctx, adaptToKeyValue(testEi), map[string]dosa.FieldValue{key: cacheKey}, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, stored[value], raw)
+
+	// the envelopes are laid out as documented
+	assert.Equal(t, checksumMagic, raw[:2])
+	assert.Equal(t, compressionNone, raw[checksumHeaderSize])
+	var entry cacheEntry
+	assert.NoError(t, NewJSONEncoder().Decode(raw[checksumHeaderSize+1:], &entry))
+	assert.Equal(t, keys["strkey"], entry.Values["strkey"])
+
+	// the key must be complete
+	_, _, err = connector.RawCached(ctx, testEi, map[string]dosa.FieldValue{"strkey": "a"})
+	assert.Error(t, err)
+}
+
+func TestRawCachedFieldGroups(t *testing.T) {
+	ctx := context.TODO()
+	connector := NewConnector(memory.NewConnector(), memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithFieldGroups(testEi.Def.Name, map[string][]string{"profile": {"strv"}}))
+	keys := batchKeys(1)
+	row := batchKeys(1)
+	row["strv"] = "name"
+	row["boolv"] = true
+
+	// the entry of the ungrouped columns is not reported as the point entry
+	assert.NoError(t, connector.PutCached(ctx, testEi, row))
+	raw, found, err := connector.RawCached(ctx, testEi, keys)
+	assert.NoError(t, err)
+	assert.False(t, found)
+	assert.Nil(t, raw)
+
+	removed, err := connector.InvalidateIfMatches(ctx, testEi, keys, nil)
+	assert.NoError(t, err)
+	assert.False(t, removed)
+	_, found, err = connector.GetCached(ctx, testEi, keys)
+	assert.NoError(t, err)
+	assert.True(t, found)
+}
+
+func TestInvalidateIfMatches(t *testing.T) {
+	ctx := context.TODO()
+	connector := NewConnector(memory.NewConnector(), memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...)
+	connector.now = fixedClock
+	keys := batchKeys(1)
+	stale := batchKeys(1)
+	stale["strv"] = "stale"
+	assert.NoError(t, connector.PutCached(ctx, testEi, stale))
+	staleBytes, found, err := connector.RawCached(ctx, testEi, keys)
+	assert.NoError(t, err)
+	assert.True(t, found)
+
+	// a newer entry does not match and is left intact
+	fresh := batchKeys(1)
+	fresh["strv"] = "fresh"
+	assert.NoError(t, connector.PutCached(ctx, testEi, fresh))
+	removed, err := connector.InvalidateIfMatches(ctx, testEi, keys, staleBytes)
+	assert.NoError(t, err)
+	assert.False(t, removed)
+	values, found, err := connector.GetCached(ctx, testEi, keys)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "fresh", values["strv"])
+
+	// the entry that is expected is removed
+	freshBytes, _, err := connector.RawCached(ctx, testEi, keys)
+	assert.NoError(t, err)
+	removed, err = connector.InvalidateIfMatches(ctx, testEi, keys, freshBytes)
+	assert.NoError(t, err)
+	assert.True(t, removed)
+	_, found, err = connector.GetCached(ctx, testEi, keys)
+	assert.NoError(t, err)
+	assert.False(t, found)
+	assert.Equal(t, int64(1), connector.Stats().Removes)
+
+	// there is nothing left to match
+	removed, err = connector.InvalidateIfMatches(ctx, testEi, keys, freshBytes)
+	assert.NoError(t, err)
+	assert.False(t, removed)
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/retry"
+)
+
+// WithWriteRetries attempts a failed fallback write up to n more times,
+// waiting between attempts as backoff says, so that a transient failure does
+// not leave the entry out of the cache until the row is read again. A nil
+// backoff uses the default of the retry connector. Retries stop when the
+// write's context is done, which for the writes made in the background is
+// their detached timeout. Only the final outcome of a write counts towards
+// WithWriteBackPause.
+func WithWriteRetries(n int, backoff retry.Backoff) Option {
+	return func(c *Connector) {
+		if backoff == nil {
+			backoff = retry.NewExponentialBackoff(10*time.Millisecond, time.Second, nil)
+		}
+		c.writeRetries = n
+		c.writeBackoff = backoff
+	}
+}
+
+// upsertFallback writes values to the fallback, retrying as configured with
+// WithWriteRetries
+func (c *Connector) upsertFallback(ctx context.Context, adaptedEi *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	for attempt := 1; ; attempt++ {
+		err := c.fallback.Upsert(ctx, adaptedEi, values)
+		if err == nil || attempt > c.writeRetries || ctx.Err() != nil {
+			return err
+		}
+		timer := time.NewTimer(c.writeBackoff.NextDelay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		atomic.AddInt64(&c.counters.writeRetries, 1)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/connectors/retry"
+)
+
+// flakyFallback fails the first failures upserts it is given
+type flakyFallback struct {
+	dosa.Connector
+	mux      sync.Mutex
+	failures int
+	upserts  int
+}
+
+func (f *flakyFallback) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	f.mux.Lock()
+	f.upserts++
+	fail := f.upserts <= f.failures
+	f.mux.Unlock()
+	if fail {
+		return assert.AnError
+	}
+	return f.Connector.Upsert(ctx, ei, values)
+}
+
+func TestWriteRetries(t *testing.T) {
+	keys := map[string]dosa.FieldValue{
+		"an_uuid_key": "d1449c93-25b8-4032-920b-60471d91acc9",
+		"strkey":      "test key string",
+		"int64key":    int64(2932),
+	}
+	ctx := context.TODO()
+	origin := memory.NewConnector()
+	assert.NoError(t, origin.Upsert(ctx, testEi, keys))
+	fallback := &flakyFallback{Connector: memory.NewConnector(), failures: 1}
+	connector := NewConnector(origin, fallback, NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithWriteRetries(2, retry.NewConstantBackoff(time.Millisecond)))
+
+	// the write back fails once, and is then retried in the background
+	_, err := connector.Read(ctx, testEi, keys, dosa.All())
+	assert.NoError(t, err)
+	n, err := connector.Close(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	_, found, err := connector.GetCached(ctx, testEi, keys)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	stats := connector.Stats()
+	assert.Equal(t, int64(1), stats.WriteRetries)
+	assert.Equal(t, int64(1), stats.Writes)
+
+	// retries are bounded
+	fallback.failures = 10
+	fallback.upserts = 0
+	assert.Error(t, connector.PutCached(ctx, testEi, keys))
+	assert.Equal(t, 3, fallback.upserts)
+
+	// and stop when the write's context is done
+	fallback.upserts = 0
+	connector = NewConnector(origin, fallback, NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithWriteRetries(5, retry.NewConstantBackoff(time.Hour)))
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, assert.AnError, connector.PutCached(timeout, testEi, keys))
+	assert.Equal(t, 1, fallback.upserts)
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/metrics"
+)
+
+// NewTier returns a cache connector meant to be the fallback of another
+// cache connector, making a two tier cache out of a small, fast store and a
+// larger, slower one:
+//
+//	tier := cache.NewTier(fastStore, slowStore, scope, entities...)
+//	connector := cache.NewConnector(origin, tier, encoder, scope, entities...)
+//
+// The outer connector encodes entries as usual and hands them to the tier as
+// key/value rows, which the tier stores verbatim instead of adapting and
+// encoding them a second time. Writes and removes go to both stores. Reads
+// are served by the fast store; an entry it does not have is looked up in
+// the slow store and, if found there, copied to the fast store in the
+// background. The tier must be given the same entities as the outer
+// connector. Calls for entities that are not key/value rows of a cached
+// entity are handled like those of any cache connector, with fast as origin.
+func NewTier(fast, slow dosa.Connector, scope metrics.Scope, entities ...dosa.DomainObject) *Connector {
+	c := NewConnector(fast, slow, NewJSONEncoder(), scope, entities...)
+	c.tier = true
+	return c
+}
+
+// isTier reports whether this connector was made by NewTier and ei describes
+// the key/value rows another cache connector stores for a cached entity
+func (c *Connector) isTier(ei *dosa.EntityInfo) bool {
+	if !c.tier || !c.isCacheable(ei) || ei.Def.Key == nil || len(ei.Def.Columns) != 2 {
+		return false
+	}
+	pk := ei.Def.Key
+	if len(pk.PartitionKeys) != 1 || pk.PartitionKeys[0] != key || len(pk.ClusteringKeys) != 0 {
+		return false
+	}
+	for _, col := range ei.Def.Columns {
+		if (col.Name != key && col.Name != value) || col.Type != dosa.Blob {
+			return false
+		}
+	}
+	return true
+}
+
+// tierUpsert writes an entry to the fast store, and to the slow one in the
+// background
+func (c *Connector) tierUpsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	c.writeBack(rowsSize(values), func() error {
+		newCtx, cancel := createContextForFallback(ctx)
+		defer cancel()
+		return c.upsertFallback(newCtx, ei, values)
+	})
+	return c.Next.Upsert(ctx, ei, values)
+}
+
+// tierRead reads an entry from the fast store, or else from the slow one,
+// promoting it to the fast store when it is found there
+func (c *Connector) tierRead(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, minimumFields []string) (map[string]dosa.FieldValue, error) {
+	values, err := c.Next.Read(ctx, ei, keys, minimumFields)
+	if err == nil {
+		return values, nil
+	}
+	slowValues, slowErr := c.fallback.Read(ctx, ei, keys, dosa.All())
+	if slowErr != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&c.counters.tierPromotions, 1)
+	c.writeBack(rowsSize(slowValues), func() error {
+		newCtx, cancel := createContextForFallback(ctx)
+		defer cancel()
+		return c.Next.Upsert(newCtx, ei, slowValues)
+	})
+	return slowValues, nil
+}
+
+// tierRemove removes an entry from both stores. Entries missing from either
+// are not an error.
+func (c *Connector) tierRemove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	slowErr := c.fallback.Remove(ctx, ei, keys)
+	if err := c.Next.Remove(ctx, ei, keys); err != nil && !dosa.ErrorIsNotFound(err) {
+		return err
+	}
+	if slowErr != nil && !dosa.ErrorIsNotFound(slowErr) {
+		return slowErr
+	}
+	return nil
+}
+
+// MultiRemove removes the entries of a tier from both stores one at a time,
+// and passes other calls on to the next connector
+func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) ([]error, error) {
+	if !c.isTier(ei) {
+		return c.Connector.MultiRemove(ctx, ei, multiKeys)
+	}
+	results := make([]error, len(multiKeys))
+	for i, keys := range multiKeys {
+		results[i] = c.tierRemove(ctx, ei, keys)
+	}
+	return results, nil
+}
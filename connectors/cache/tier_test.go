@@ -0,0 +1,97 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/mocks"
+)
+
+func TestTier(t *testing.T) {
+	keys := batchKeys(1)
+	row := map[string]dosa.FieldValue{"strv": "tiered"}
+	for k, v := range keys {
+		row[k] = v
+	}
+	ctx := context.TODO()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	fast, slow := memory.NewConnector(), memory.NewConnector()
+	tier := NewTier(fast, slow, nil, cacheableEntities...)
+	tier.setSynchronousMode(true)
+	connector := NewConnector(mockOrigin, tier, NewJSONEncoder(), nil, cacheableEntities...)
+	connector.setSynchronousMode(true)
+
+	// writes reach both tiers, stored as the outer connector encoded them
+	mockOrigin.EXPECT().Upsert(ctx, testEi, row).Return(nil)
+	assert.NoError(t, connector.Upsert(ctx, testEi, row))
+	cacheKey, err := connector.CacheKey(testEi, keys)
+	assert.NoError(t, err)
+	adaptedEi := adaptToKeyValue(testEi)
+	stored := map[string]dosa.FieldValue{key: cacheKey}
+	fastRow, err := fast.Read(ctx, adaptedEi, stored, dosa.All())
+	assert.NoError(t, err)
+	slowRow, err := slow.Read(ctx, adaptedEi, stored, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, fastRow, slowRow)
+	assert.Equal(t, cacheKey, fastRow[key])
+
+	// a miss in the fast tier that hits the slow one populates the fast one
+	assert.NoError(t, fast.Remove(ctx, adaptedEi, stored))
+	mockOrigin.EXPECT().Read(ctx, testEi, keys, dosa.All()).Return(nil, assert.AnError).Times(2)
+	values, err := connector.Read(ctx, testEi, keys, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, "tiered", values["strv"])
+	assert.Equal(t, int64(1), tier.Stats().TierPromotions)
+	promoted, err := fast.Read(ctx, adaptedEi, stored, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, slowRow, promoted)
+
+	// served from the fast tier from then on
+	_, err = connector.Read(ctx, testEi, keys, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), tier.Stats().TierPromotions)
+
+	// removes clear both tiers
+	mockOrigin.EXPECT().Remove(ctx, testEi, keys).Return(nil)
+	assert.NoError(t, connector.Remove(ctx, testEi, keys))
+	_, err = fast.Read(ctx, adaptedEi, stored, dosa.All())
+	assert.True(t, dosa.ErrorIsNotFound(err))
+	_, err = slow.Read(ctx, adaptedEi, stored, dosa.All())
+	assert.True(t, dosa.ErrorIsNotFound(err))
+}
+
+func TestIsTierOnlyForNewTier(t *testing.T) {
+	adaptedEi := adaptToKeyValue(testEi)
+	tier := NewTier(memory.NewConnector(), memory.NewConnector(), nil, cacheableEntities...)
+	assert.True(t, tier.isTier(adaptedEi))
+
+	// an ordinary connector caching an entity of the same shape is no tier
+	connector := NewConnector(memory.NewConnector(), memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...)
+	assert.False(t, connector.isTier(adaptedEi))
+}
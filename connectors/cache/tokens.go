@@ -0,0 +1,96 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/uber-go/dosa"
+)
+
+// ErrTokenMismatch is returned by Range and Scan with WithTokenFingerprints
+// when they are given a continuation token that was issued for a range with other conditions.
+// Scans are ranges without conditions, so resuming a scan with the token of
+// a conditional range, or the other way round, fails with it too, instead
+// of silently returning rows of the wrong range.
+var ErrTokenMismatch = errors.New("continuation token was issued for a range with other conditions")
+
+// ErrorIsTokenMismatch checks if the error is, or was caused by,
+// ErrTokenMismatch
+func ErrorIsTokenMismatch(err error) bool {
+	return isKind(err, ErrTokenMismatch)
+}
+
+// WithTokenFingerprints makes the continuation tokens returned by Range and
+// Scan carry a fingerprint of the conditions of their range, and rejects a
+// token passed in for a range with other conditions with ErrTokenMismatch.
+// Tokens without a fingerprint are passed to origin as they are. Only cached
+// entities are affected.
+func WithTokenFingerprints(enabled bool) Option {
+	return func(c *Connector) {
+		c.tokenFingerprints = enabled
+	}
+}
+
+// rangeTokenPrefix marks continuation tokens that carry the fingerprint of
+// the conditions of their range, in front of the token of the page
+const rangeTokenPrefix = "dosa-range:"
+
+// conditionsFingerprint identifies a set of range conditions. It does not
+// depend on the encoder, so tokens survive a SetEncoder call.
+func conditionsFingerprint(columnConditions map[string][]*dosa.Condition) string {
+	var data []byte
+	if conds := canonicalizeConditions(columnConditions); len(conds) > 0 {
+		data, _ = json.Marshal(conds)
+	}
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:9])
+}
+
+// fingerprintToken prefixes the continuation token of a page with the
+// fingerprint of the conditions of its range. The end of a range stays an
+// empty token.
+func fingerprintToken(columnConditions map[string][]*dosa.Condition, token string) string {
+	if token == "" {
+		return ""
+	}
+	return rangeTokenPrefix + conditionsFingerprint(columnConditions) + ":" + token
+}
+
+// checkRangeToken returns the page token carried by a token made by
+// fingerprintToken, failing with ErrTokenMismatch if it was made for other
+// conditions. Tokens without a fingerprint, such as those issued before
+// fingerprints were added, are returned unchanged.
+func checkRangeToken(columnConditions map[string][]*dosa.Condition, token string) (string, error) {
+	if !strings.HasPrefix(token, rangeTokenPrefix) {
+		return token, nil
+	}
+	rest := strings.TrimPrefix(token, rangeTokenPrefix)
+	i := strings.IndexByte(rest, ':')
+	if i < 0 || rest[:i] != conditionsFingerprint(columnConditions) {
+		return "", ErrTokenMismatch
+	}
+	return rest[i+1:], nil
+}
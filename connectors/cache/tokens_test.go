@@ -0,0 +1,121 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/mocks"
+)
+
+func TestTokenFingerprints(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	connector := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithTokenFingerprints(true))
+	connector.setSynchronousMode(true)
+
+	conds := map[string][]*dosa.Condition{"strkey": {{Op: dosa.Eq, Value: "test key string"}}}
+	rows := []map[string]dosa.FieldValue{{"int64key": int64(1)}}
+	gomock.InOrder(
+		mockOrigin.EXPECT().Range(gomock.Any(), testEi, nil, dosa.All(), "", 1).Return(rows, "scan-next", nil),
+		mockOrigin.EXPECT().Range(gomock.Any(), testEi, conds, dosa.All(), "", 1).Return(rows, "range-next", nil),
+		mockOrigin.EXPECT().Range(gomock.Any(), testEi, nil, dosa.All(), "scan-next", 1).Return(rows, "", nil),
+		mockOrigin.EXPECT().Range(gomock.Any(), testEi, conds, dosa.All(), "range-next", 1).Return(rows, "", nil),
+	)
+
+	_, scanToken, err := connector.Scan(context.TODO(), testEi, dosa.All(), "", 1)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(scanToken, rangeTokenPrefix))
+	_, rangeToken, err := connector.Range(context.TODO(), testEi, conds, dosa.All(), "", 1)
+	assert.NoError(t, err)
+	assert.NotEqual(t, scanToken, rangeToken)
+
+	// a scan does not resume from the token of a conditional range
+	_, _, err = connector.Scan(context.TODO(), testEi, dosa.All(), rangeToken, 1)
+	assert.True(t, ErrorIsTokenMismatch(err))
+	// nor a conditional range from the token of a scan, or of other conditions
+	_, _, err = connector.Range(context.TODO(), testEi, conds, dosa.All(), scanToken, 1)
+	assert.True(t, ErrorIsTokenMismatch(err))
+	other := map[string][]*dosa.Condition{"strkey": {{Op: dosa.Eq, Value: "other"}}}
+	_, _, err = connector.Range(context.TODO(), testEi, other, dosa.All(), rangeToken, 1)
+	assert.Equal(t, ErrTokenMismatch, err)
+
+	// tokens resume their own range, and the end of a range stays empty
+	_, next, err := connector.Scan(context.TODO(), testEi, dosa.All(), scanToken, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "", next)
+	_, next, err = connector.Range(context.TODO(), testEi, conds, dosa.All(), rangeToken, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "", next)
+}
+
+func TestTokenFingerprintsWithHandles(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	connector := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithTokenFingerprints(true), WithTokenHandles(32))
+	connector.setSynchronousMode(true)
+
+	conds := map[string][]*dosa.Condition{"strkey": {{Op: dosa.Eq, Value: "test key string"}}}
+	longToken := strings.Repeat("t", 100)
+	mockOrigin.EXPECT().Range(gomock.Any(), testEi, conds, dosa.All(), "", 1).Return(nil, longToken, nil)
+
+	// the fingerprint is checked once the handle is resolved
+	_, handle, err := connector.Range(context.TODO(), testEi, conds, dosa.All(), "", 1)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(handle, tokenHandlePrefix))
+	_, _, err = connector.Scan(context.TODO(), testEi, dosa.All(), handle, 1)
+	assert.True(t, ErrorIsTokenMismatch(err))
+}
+
+func TestCheckRangeToken(t *testing.T) {
+	conds := map[string][]*dosa.Condition{"strkey": {{Op: dosa.Eq, Value: "a"}}}
+
+	// tokens without a fingerprint pass through
+	token, err := checkRangeToken(conds, "plain")
+	assert.NoError(t, err)
+	assert.Equal(t, "plain", token)
+
+	// the order of conditions does not change the fingerprint
+	both := map[string][]*dosa.Condition{"strkey": {{Op: dosa.Gt, Value: "a"}, {Op: dosa.Lt, Value: "z"}}}
+	swapped := map[string][]*dosa.Condition{"strkey": {{Op: dosa.Lt, Value: "z"}, {Op: dosa.Gt, Value: "a"}}}
+	token, err = checkRangeToken(swapped, fingerprintToken(both, "next"))
+	assert.NoError(t, err)
+	assert.Equal(t, "next", token)
+
+	// empty conditions are a scan
+	token, err = checkRangeToken(nil, fingerprintToken(map[string][]*dosa.Condition{}, "next"))
+	assert.NoError(t, err)
+	assert.Equal(t, "next", token)
+
+	_, err = checkRangeToken(conds, rangeTokenPrefix+"malformed")
+	assert.Equal(t, ErrTokenMismatch, err)
+	assert.Equal(t, "", fingerprintToken(conds, ""))
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import "context"
+
+// span tags set by the connector
+const (
+	tagEntity      = "entity"
+	tagSource      = "source"
+	tagCache       = "cache"
+	tagOriginError = "origin.error"
+	tagHedged      = "hedged"
+
+	sourceOrigin   = "origin"
+	sourceFallback = "fallback"
+	cacheHit       = "hit"
+	cacheMiss      = "miss"
+)
+
+// Tracer starts a span for each connector operation. It is a seam for
+// OpenTracing or OpenTelemetry: an adapter starts a child span of the span
+// in ctx and returns a context carrying the new span.
+type Tracer interface {
+	StartSpan(ctx context.Context, name string) (context.Context, SpanEnder)
+}
+
+// SpanEnder tags and finishes a span started by a Tracer
+type SpanEnder interface {
+	SetTag(key string, value interface{})
+	Finish()
+}
+
+// WithTracer sets the tracer used to create a span per Read, Range and
+// Upsert. Spans are tagged with the entity name and with the source the
+// result was served from, "origin" or "fallback". When origin fails, the
+// span also carries the origin error and whether the fallback had the entry.
+func WithTracer(t Tracer) Option {
+	return func(c *Connector) {
+		c.tracer = t
+	}
+}
+
+type noopTracer struct{}
+
+func (noopTracer) StartSpan(ctx context.Context, name string) (context.Context, SpanEnder) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetTag(key string, value interface{}) {}
+func (noopSpan) Finish()                              {}
+
+// startSpan starts a span for the operation on the entity
+func (c *Connector) startSpan(ctx context.Context, name, entity string) (context.Context, SpanEnder) {
+	ctx, span := c.tracer.StartSpan(ctx, name)
+	span.SetTag(tagEntity, entity)
+	return ctx, span
+}
+
+// tagOrigin records the result of the origin call on the span
+func tagOrigin(span SpanEnder, err error) {
+	span.SetTag(tagSource, sourceOrigin)
+	if err != nil {
+		span.SetTag(tagOriginError, err.Error())
+	}
+}
+
+// tagFallback records whether the fallback served the request
+func tagFallback(span SpanEnder, hit bool) {
+	if hit {
+		span.SetTag(tagSource, sourceFallback)
+		span.SetTag(tagCache, cacheHit)
+		return
+	}
+	span.SetTag(tagCache, cacheMiss)
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/mocks"
+)
+
+type fakeSpan struct {
+	name     string
+	tags     map[string]interface{}
+	finished bool
+}
+
+func (s *fakeSpan) SetTag(key string, value interface{}) { s.tags[key] = value }
+func (s *fakeSpan) Finish()                              { s.finished = true }
+
+type fakeTracer struct {
+	spans []*fakeSpan
+}
+
+func (t *fakeTracer) StartSpan(ctx context.Context, name string) (context.Context, SpanEnder) {
+	span := &fakeSpan{name: name, tags: map[string]interface{}{}}
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+func TestTracer(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockOrigin := mocks.NewMockConnector(ctrl)
+	tracer := &fakeTracer{}
+	connector := NewConnector(mockOrigin, memory.NewConnector(), NewJSONEncoder(), nil, cacheableEntities...).
+		WithOptions(WithTracer(tracer))
+	connector.setSynchronousMode(true)
+
+	keys := map[string]dosa.FieldValue{
+		"an_uuid_key": "d1449c93-25b8-4032-920b-60471d91acc9",
+		"strkey":      "test key string",
+		"int64key":    int64(2932),
+	}
+	row := map[string]dosa.FieldValue{"strv": "value"}
+	for k, v := range keys {
+		row[k] = v
+	}
+
+	gomock.InOrder(
+		mockOrigin.EXPECT().Upsert(context.TODO(), testEi, row).Return(nil),
+		mockOrigin.EXPECT().Read(context.TODO(), testEi, keys, dosa.All()).Return(nil, assert.AnError).Times(2),
+		mockOrigin.EXPECT().Range(context.TODO(), testEi, nil, dosa.All(), "", 10).Return(nil, "", assert.AnError),
+	)
+	assert.NoError(t, connector.Upsert(context.TODO(), testEi, row))
+	_, err := connector.Read(context.TODO(), testEi, keys, dosa.All())
+	assert.NoError(t, err)
+	// the fallback no longer has the entry
+	mockOrigin.EXPECT().Remove(context.TODO(), testEi, keys).Return(nil)
+	assert.NoError(t, connector.Remove(context.TODO(), testEi, keys))
+	_, err = connector.Read(context.TODO(), testEi, keys, dosa.All())
+	assert.Equal(t, assert.AnError, err)
+	_, _, err = connector.Range(context.TODO(), testEi, nil, dosa.All(), "", 10)
+	assert.Equal(t, assert.AnError, err)
+
+	if !assert.Len(t, tracer.spans, 4) {
+		return
+	}
+	for _, span := range tracer.spans {
+		assert.True(t, span.finished, span.name)
+		assert.Equal(t, testEi.Def.Name, span.tags[tagEntity], span.name)
+	}
+	assert.Equal(t, "dosa.cache.Upsert", tracer.spans[0].name)
+	assert.Equal(t, sourceOrigin, tracer.spans[0].tags[tagSource])
+	assert.NotContains(t, tracer.spans[0].tags, tagOriginError)
+
+	assert.Equal(t, "dosa.cache.Read", tracer.spans[1].name)
+	assert.Equal(t, sourceFallback, tracer.spans[1].tags[tagSource])
+	assert.Equal(t, cacheHit, tracer.spans[1].tags[tagCache])
+	assert.Equal(t, assert.AnError.Error(), tracer.spans[1].tags[tagOriginError])
+
+	assert.Equal(t, "dosa.cache.Read", tracer.spans[2].name)
+	assert.Equal(t, sourceOrigin, tracer.spans[2].tags[tagSource])
+	assert.Equal(t, cacheMiss, tracer.spans[2].tags[tagCache])
+
+	assert.Equal(t, "dosa.cache.Range", tracer.spans[3].name)
+	assert.Equal(t, sourceOrigin, tracer.spans[3].tags[tagSource])
+	assert.Equal(t, cacheMiss, tracer.spans[3].tags[tagCache])
+	assert.Equal(t, assert.AnError.Error(), tracer.spans[3].tags[tagOriginError])
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"encoding/base64"
+	"encoding/gob"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/uber-go/dosa"
+)
+
+// TypeRegistrar is implemented by the encoders of this package. The field
+// values of a type registered with RegisterType are encoded with marshal,
+// tagged with the name of the type, and decoded back into that type with
+// unmarshal, so that columns of types the encoder knows nothing about, such
+// as a currency amount, come out of the fallback as they went in. The type
+// is identified by its name as reported by reflect, like "money.Amount", and
+// registering a type again replaces its functions.
+//
+// Entries holding a custom type can only be decoded by encoders that have
+// registered it, so every service sharing a fallback store must register
+// the same types, under the same names, before it reads or writes it.
+type TypeRegistrar interface {
+	RegisterType(sample interface{}, marshal func(interface{}) ([]byte, error), unmarshal func([]byte) (interface{}, error))
+}
+
+func init() {
+	gob.Register(customValue{})
+}
+
+// customValue is what a field value of a registered type is encoded as
+type customValue struct {
+	Type string `json:"$dosaType"`
+	Data []byte `json:"$data"`
+}
+
+type customType struct {
+	marshal   func(interface{}) ([]byte, error)
+	unmarshal func([]byte) (interface{}, error)
+}
+
+// customTypes holds the types registered with an encoder. Its zero value has
+// no types registered.
+type customTypes struct {
+	mux   sync.RWMutex
+	types map[string]customType
+}
+
+// RegisterType implements TypeRegistrar
+func (t *customTypes) RegisterType(sample interface{}, marshal func(interface{}) ([]byte, error), unmarshal func([]byte) (interface{}, error)) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.types == nil {
+		t.types = map[string]customType{}
+	}
+	t.types[reflect.TypeOf(sample).String()] = customType{marshal: marshal, unmarshal: unmarshal}
+}
+
+func (t *customTypes) empty() bool {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+	return len(t.types) == 0
+}
+
+func (t *customTypes) lookup(name string) (customType, bool) {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+	ct, ok := t.types[name]
+	return ct, ok
+}
+
+// encodeValues returns v, which is about to be encoded, with the field
+// values of registered types replaced by their customValue. The rows of v
+// are copied rather than modified.
+func (t *customTypes) encodeValues(v interface{}) (interface{}, error) {
+	if t.empty() {
+		return v, nil
+	}
+	var err error
+	switch x := v.(type) {
+	case map[string]dosa.FieldValue:
+		return t.encodeRow(x)
+	case []map[string]dosa.FieldValue:
+		return t.encodeRows(x)
+	case cacheEntry:
+		x.Values, err = t.encodeRow(x.Values)
+		return x, err
+	case rangeResults:
+		x.Rows, err = t.encodeRows(x.Rows)
+		return x, err
+	}
+	return v, nil
+}
+
+func (t *customTypes) encodeRows(rows []map[string]dosa.FieldValue) ([]map[string]dosa.FieldValue, error) {
+	if rows == nil {
+		return nil, nil
+	}
+	result := make([]map[string]dosa.FieldValue, len(rows))
+	for i, row := range rows {
+		var err error
+		if result[i], err = t.encodeRow(row); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (t *customTypes) encodeRow(values map[string]dosa.FieldValue) (map[string]dosa.FieldValue, error) {
+	if values == nil {
+		return nil, nil
+	}
+	result := make(map[string]dosa.FieldValue, len(values))
+	for name, value := range values {
+		result[name] = value
+		if value == nil {
+			continue
+		}
+		typeName := reflect.TypeOf(value).String()
+		ct, ok := t.lookup(typeName)
+		if !ok {
+			continue
+		}
+		data, err := ct.marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("cannot marshal column %q of type %s: %v", name, typeName, err)
+		}
+		result[name] = customValue{Type: typeName, Data: data}
+	}
+	return result, nil
+}
+
+// decodeValues turns the customValues in the rows v points to, which has
+// just been decoded, back into field values of their registered types. It
+// fails on values of types that are not registered, even when none are.
+func (t *customTypes) decodeValues(v interface{}) error {
+	switch x := v.(type) {
+	case *map[string]dosa.FieldValue:
+		return t.decodeRow(*x)
+	case *[]map[string]dosa.FieldValue:
+		return t.decodeRows(*x)
+	case *cacheEntry:
+		return t.decodeRow(x.Values)
+	case *rangeResults:
+		return t.decodeRows(x.Rows)
+	}
+	return nil
+}
+
+func (t *customTypes) decodeRows(rows []map[string]dosa.FieldValue) error {
+	for _, row := range rows {
+		if err := t.decodeRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *customTypes) decodeRow(values map[string]dosa.FieldValue) error {
+	for name, value := range values {
+		cv, ok, err := asCustomValue(value)
+		if err != nil {
+			return fmt.Errorf("malformed custom value in column %q: %v", name, err)
+		}
+		if !ok {
+			continue
+		}
+		ct, ok := t.lookup(cv.Type)
+		if !ok {
+			return fmt.Errorf("column %q holds a value of unregistered type %s", name, cv.Type)
+		}
+		decoded, err := ct.unmarshal(cv.Data)
+		if err != nil {
+			return fmt.Errorf("cannot unmarshal column %q of type %s: %v", name, cv.Type, err)
+		}
+		values[name] = decoded
+	}
+	return nil
+}
+
+// asCustomValue recognizes a decoded customValue. Encoders that decode field
+// values into generic types, such as JSON, return it as a map.
+func asCustomValue(value dosa.FieldValue) (customValue, bool, error) {
+	switch x := value.(type) {
+	case customValue:
+		return x, true, nil
+	case map[string]interface{}:
+		typeName, ok := x["$dosaType"].(string)
+		if !ok || len(x) != 2 {
+			return customValue{}, false, nil
+		}
+		encoded, _ := x["$data"].(string)
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return customValue{}, false, err
+		}
+		return customValue{Type: typeName, Data: data}, true, nil
+	}
+	return customValue{}, false, nil
+}
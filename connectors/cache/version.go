@@ -0,0 +1,60 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+// WithContentID stamps every point entry written to the fallback with id,
+// which should change whenever the encoding of entries does, for instance
+// with the encoder, its settings or the version of the service writing them.
+// Entries written without a content ID are read as they always were.
+func WithContentID(id string) Option {
+	return func(c *Connector) {
+		c.contentID = id
+	}
+}
+
+// OnVersionSkew calls f the first time a point entry is decoded whose content
+// ID differs from the one set with WithContentID, which means another version
+// of the writer shares the fallback: a fleet in the middle of a rollout, or
+// one that has been left mixed. Entries written without a content ID count as
+// a different version. f is called at most once per connector, and the
+// version_skew counter is incremented along with it. Without WithContentID
+// nothing is checked.
+func OnVersionSkew(f func(storedID, currentID string)) Option {
+	return func(c *Connector) {
+		c.onVersionSkew = f
+	}
+}
+
+// checkContentID reports the content ID of a decoded entry as version skew
+// if it differs from the connector's
+func (c *Connector) checkContentID(storedID string) {
+	if c.contentID == "" || storedID == c.contentID {
+		return
+	}
+	c.skewOnce.Do(func() {
+		if c.stats != nil {
+			c.stats.Counter("version_skew").Inc(1)
+		}
+		if c.onVersionSkew != nil {
+			c.onVersionSkew(storedID, c.contentID)
+		}
+	})
+}
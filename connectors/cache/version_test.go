@@ -0,0 +1,69 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+)
+
+func TestVersionSkew(t *testing.T) {
+	keys := map[string]dosa.FieldValue{
+		"an_uuid_key": "d1449c93-25b8-4032-920b-60471d91acc9",
+		"strkey":      "test key string",
+		"int64key":    int64(2932),
+	}
+	ctx := context.TODO()
+	fallback := memory.NewConnector()
+	for _, encoder := range []Encoder{NewJSONEncoder(), NewProtoEncoder()} {
+		writer := NewConnector(memory.NewConnector(), fallback, encoder, nil, cacheableEntities...).
+			WithOptions(WithContentID("v1"))
+		assert.NoError(t, writer.PutCached(ctx, testEi, keys))
+
+		type skew struct{ stored, current string }
+		var reported []skew
+		report := func(storedID, currentID string) {
+			reported = append(reported, skew{storedID, currentID})
+		}
+
+		// the same version does not report anything
+		same := NewConnector(memory.NewConnector(), fallback, encoder, nil, cacheableEntities...).
+			WithOptions(WithContentID("v1"), OnVersionSkew(report))
+		_, found, err := same.GetCached(ctx, testEi, keys)
+		assert.NoError(t, err)
+		assert.True(t, found)
+		assert.Empty(t, reported)
+
+		// a newer one reports the first mismatch only
+		newer := NewConnector(memory.NewConnector(), fallback, encoder, nil, cacheableEntities...).
+			WithOptions(WithContentID("v2"), OnVersionSkew(report))
+		for i := 0; i < 3; i++ {
+			_, found, err = newer.GetCached(ctx, testEi, keys)
+			assert.NoError(t, err)
+			assert.True(t, found)
+		}
+		assert.Equal(t, []skew{{"v1", "v2"}}, reported)
+	}
+}
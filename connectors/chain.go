@@ -0,0 +1,44 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package connectors
+
+import "github.com/uber-go/dosa"
+
+// Middleware wraps a connector in another one. The wrapping connectors in
+// this repository, such as retry, ratelimit and cache, each provide a
+// Middleware constructor taking the same arguments as their NewConnector,
+// minus the connector to wrap.
+type Middleware func(next dosa.Connector) dosa.Connector
+
+// Chain wraps base in the middlewares and returns the outermost connector.
+// Middlewares are listed outermost first: in
+//
+//	Chain(origin, retry.Middleware(), cache.Middleware(...))
+//
+// a call goes through retry, then the cache, then origin, so every attempt
+// made by retry passes through the cache.
+func Chain(base dosa.Connector, middlewares ...Middleware) dosa.Connector {
+	c := base
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		c = middlewares[i](c)
+	}
+	return c
+}
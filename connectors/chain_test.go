@@ -0,0 +1,98 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package connectors_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors"
+	"github.com/uber-go/dosa/connectors/alias"
+	"github.com/uber-go/dosa/connectors/base"
+	"github.com/uber-go/dosa/connectors/dedup"
+	"github.com/uber-go/dosa/connectors/memory"
+)
+
+var testEi = &dosa.EntityInfo{
+	Ref: &dosa.SchemaRef{Scope: "scope1", NamePrefix: "namePrefix", EntityName: "old"},
+	Def: &dosa.EntityDefinition{
+		Name:    "old",
+		Key:     &dosa.PrimaryKey{PartitionKeys: []string{"p1"}},
+		Columns: []*dosa.ColumnDefinition{{Name: "p1", Type: dosa.String}},
+	},
+}
+
+// recorder appends its name to calls on each Read before calling next
+type recorder struct {
+	base.Connector
+	name  string
+	calls *[]string
+}
+
+func (r *recorder) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, minimumFields []string) (map[string]dosa.FieldValue, error) {
+	*r.calls = append(*r.calls, r.name)
+	return r.Connector.Read(ctx, ei, keys, minimumFields)
+}
+
+func record(name string, calls *[]string) connectors.Middleware {
+	return func(next dosa.Connector) dosa.Connector {
+		return &recorder{Connector: base.Connector{Next: next}, name: name, calls: calls}
+	}
+}
+
+func TestChainOrder(t *testing.T) {
+	var calls []string
+	c := connectors.Chain(memory.NewConnector(), record("outer", &calls), record("inner", &calls))
+	_, err := c.Read(context.TODO(), testEi, map[string]dosa.FieldValue{"p1": "a"}, dosa.All())
+	assert.True(t, dosa.ErrorIsNotFound(err))
+	assert.Equal(t, []string{"outer", "inner"}, calls)
+
+	// without middlewares the base connector is returned
+	m := memory.NewConnector()
+	assert.Equal(t, dosa.Connector(m), connectors.Chain(m))
+}
+
+func TestChainWrappers(t *testing.T) {
+	origin := memory.NewConnector()
+	c := connectors.Chain(origin,
+		dedup.Middleware(time.Minute),
+		alias.Middleware(map[string]string{"old": "new"}),
+	)
+	assert.IsType(t, &dedup.Connector{}, c)
+
+	// the row is written to the renamed entity, and the repeated upsert is
+	// dropped before it reaches the alias connector
+	row := map[string]dosa.FieldValue{"p1": "a"}
+	assert.NoError(t, c.Upsert(context.TODO(), testEi, row))
+	assert.NoError(t, c.Upsert(context.TODO(), testEi, row))
+	assert.Equal(t, int64(1), c.(*dedup.Connector).Skipped())
+
+	renamed := *testEi
+	def := *testEi.Def
+	def.Name = "new"
+	renamed.Def = &def
+	values, err := origin.Read(context.TODO(), &renamed, row, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, "a", values["p1"])
+}
@@ -0,0 +1,310 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package dedup contains a connector that drops upserts which write the same
+// values as the previous upsert of the row.
+package dedup
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors"
+	"github.com/uber-go/dosa/connectors/base"
+)
+
+// DefaultMaxEntries is the number of rows remembered when WithMaxEntries is
+// not given
+const DefaultMaxEntries = 10000
+
+// Option configures optional behavior of the dedup connector
+type Option func(*Connector)
+
+// WithMaxEntries bounds the number of rows whose last upsert is remembered.
+// The least recently written rows are forgotten first.
+func WithMaxEntries(n int) Option {
+	return func(c *Connector) {
+		if n > 0 {
+			c.maxEntries = n
+		}
+	}
+}
+
+// Connector skips an Upsert when the previous upsert of the same row, made
+// through this connector less than the window ago, wrote identical values.
+// Only a hash of the values is kept. Writes that overlap with another write
+// or remove of the same row are never used to skip a later upsert, and
+// removes, multi upserts and CreateIfNotExists make the connector forget the
+// rows they touch. Changes made to origin behind the connector's back are
+// not seen, so the window should be short.
+type Connector struct {
+	base.Connector
+	window     time.Duration
+	maxEntries int
+	skipped    int64
+	now        func() time.Time
+
+	mux     sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+// entry is the state kept for one row
+type entry struct {
+	key     string
+	entity  string
+	hash    [sha256.Size]byte
+	valid   bool
+	expires time.Time
+	// writes in flight, and whether any of them overlapped
+	inflight  int
+	contended bool
+}
+
+// NewConnector returns a connector that deduplicates upserts repeated within
+// window
+func NewConnector(next dosa.Connector, window time.Duration, opts ...Option) *Connector {
+	c := &Connector{
+		Connector:  base.Connector{Next: next},
+		window:     window,
+		maxEntries: DefaultMaxEntries,
+		now:        time.Now,
+		entries:    map[string]*list.Element{},
+		lru:        list.New(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Middleware returns a connectors.Middleware that wraps a connector with
+// NewConnector
+func Middleware(window time.Duration, opts ...Option) connectors.Middleware {
+	return func(next dosa.Connector) dosa.Connector {
+		return NewConnector(next, window, opts...)
+	}
+}
+
+// Skipped returns the number of upserts that were not sent downstream
+func (c *Connector) Skipped() int64 {
+	return atomic.LoadInt64(&c.skipped)
+}
+
+// Upsert calls Next unless the row was last written with the same values
+func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	key := rowKey(ei, values)
+	hash := hashValues(values)
+	e, skip := c.begin(entityID(ei), key, &hash)
+	if skip {
+		atomic.AddInt64(&c.skipped, 1)
+		return nil
+	}
+	err := c.Connector.Upsert(ctx, ei, values)
+	c.end(e, &hash, err == nil)
+	return err
+}
+
+// CreateIfNotExists calls Next and forgets the row
+func (c *Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	e, _ := c.begin(entityID(ei), rowKey(ei, values), nil)
+	defer c.end(e, nil, false)
+	return c.Connector.CreateIfNotExists(ctx, ei, values)
+}
+
+// MultiUpsert calls Next and forgets the rows
+func (c *Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, multiValues []map[string]dosa.FieldValue) ([]error, error) {
+	defer c.forgetAll(c.beginAll(ei, multiValues))
+	return c.Connector.MultiUpsert(ctx, ei, multiValues)
+}
+
+// Remove calls Next and forgets the row
+func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	e, _ := c.begin(entityID(ei), rowKey(ei, keys), nil)
+	defer c.end(e, nil, false)
+	return c.Connector.Remove(ctx, ei, keys)
+}
+
+// MultiRemove calls Next and forgets the rows
+func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) ([]error, error) {
+	defer c.forgetAll(c.beginAll(ei, multiKeys))
+	return c.Connector.MultiRemove(ctx, ei, multiKeys)
+}
+
+// RemoveRange calls Next and forgets every row of the entity, since the
+// rows in the range are not known
+func (c *Connector) RemoveRange(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition) error {
+	err := c.Connector.RemoveRange(ctx, ei, columnConditions)
+	c.forgetEntity(entityID(ei))
+	return err
+}
+
+// begin registers a write of the row. When hash is set and matches the last
+// write, which has not expired and with no write in flight, it reports that
+// the write can be skipped and registers nothing.
+func (c *Connector) begin(entity, key string, hash *[sha256.Size]byte) (*entry, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	var e *entry
+	if elem, ok := c.entries[key]; ok {
+		e = elem.Value.(*entry)
+		if hash != nil && e.valid && e.inflight == 0 && e.hash == *hash && c.now().Before(e.expires) {
+			c.lru.MoveToFront(elem)
+			return nil, true
+		}
+		c.lru.MoveToFront(elem)
+	} else {
+		e = &entry{key: key, entity: entity}
+		c.entries[key] = c.lru.PushFront(e)
+		c.evict()
+	}
+	e.valid = false
+	e.inflight++
+	if e.inflight > 1 {
+		e.contended = true
+	}
+	return e, false
+}
+
+// end completes a write registered by begin. The hash is remembered only if
+// the write succeeded and did not overlap with another one.
+func (c *Connector) end(e *entry, hash *[sha256.Size]byte, ok bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	e.inflight--
+	if ok && hash != nil && !e.contended {
+		e.hash = *hash
+		e.valid = true
+		e.expires = c.now().Add(c.window)
+	}
+	if e.inflight == 0 {
+		e.contended = false
+	}
+}
+
+func (c *Connector) beginAll(ei *dosa.EntityInfo, rows []map[string]dosa.FieldValue) []*entry {
+	entries := make([]*entry, len(rows))
+	for i, row := range rows {
+		entries[i], _ = c.begin(entityID(ei), rowKey(ei, row), nil)
+	}
+	return entries
+}
+
+func (c *Connector) forgetAll(entries []*entry) {
+	for _, e := range entries {
+		c.end(e, nil, false)
+	}
+}
+
+// forgetEntity drops the remembered values of every row of the entity
+func (c *Connector) forgetEntity(entity string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for _, elem := range c.entries {
+		if e := elem.Value.(*entry); e.entity == entity {
+			e.valid = false
+		}
+	}
+}
+
+// evict drops the least recently used rows without writes in flight until
+// the connector is within its bound. It must be called with mux held.
+func (c *Connector) evict() {
+	for elem := c.lru.Back(); elem != nil && len(c.entries) > c.maxEntries; {
+		prev := elem.Prev()
+		if e := elem.Value.(*entry); e.inflight == 0 {
+			c.lru.Remove(elem)
+			delete(c.entries, e.key)
+		}
+		elem = prev
+	}
+}
+
+// entityID identifies the entity across scopes and prefixes
+func entityID(ei *dosa.EntityInfo) string {
+	if ei.Ref == nil {
+		return ei.Def.Name
+	}
+	return ei.Ref.Scope + "\x00" + ei.Ref.NamePrefix + "\x00" + ei.Def.Name
+}
+
+// rowKey identifies the row by its entity and primary key values
+func rowKey(ei *dosa.EntityInfo, values map[string]dosa.FieldValue) string {
+	keys := map[string]dosa.FieldValue{}
+	for column := range ei.Def.KeySet() {
+		keys[column] = values[column]
+	}
+	hash := hashValues(keys)
+	return entityID(ei) + "\x00" + string(hash[:])
+}
+
+// hashValues hashes the column names and values in column order
+func hashValues(values map[string]dosa.FieldValue) [sha256.Size]byte {
+	columns := make([]string, 0, len(values))
+	for column := range values {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	h := sha256.New()
+	for _, column := range columns {
+		writeString(h, column)
+		writeString(h, fieldValueString(values[column]))
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// writeString writes s with its length so that adjacent strings cannot run
+// into each other
+func writeString(h io.Writer, s string) {
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(s)))
+	_, _ = h.Write(n[:])
+	_, _ = h.Write([]byte(s))
+}
+
+// fieldValueString renders the value with its type, dereferencing the
+// pointers of nullable types
+func fieldValueString(v dosa.FieldValue) string {
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return "<nil>"
+		}
+		v = rv.Elem().Interface()
+	}
+	switch v := v.(type) {
+	case time.Time:
+		return "time:" + v.UTC().Format(time.RFC3339Nano)
+	case []byte:
+		return fmt.Sprintf("bytes:%x", v)
+	}
+	return fmt.Sprintf("%T:%v", v, v)
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dedup
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+)
+
+var testEi = &dosa.EntityInfo{
+	Ref: &dosa.SchemaRef{Scope: "scope1", NamePrefix: "namePrefix", EntityName: "eName"},
+	Def: &dosa.EntityDefinition{
+		Name: "t1",
+		Key:  &dosa.PrimaryKey{PartitionKeys: []string{"p1"}},
+		Columns: []*dosa.ColumnDefinition{
+			{Name: "p1", Type: dosa.String},
+			{Name: "c1", Type: dosa.Int64},
+		},
+	},
+}
+
+// countingConnector counts the upserts that reach it
+type countingConnector struct {
+	dosa.Connector
+	upserts int64
+}
+
+func (c *countingConnector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	atomic.AddInt64(&c.upserts, 1)
+	return c.Connector.Upsert(ctx, ei, values)
+}
+
+func row(p1 string, c1 int64) map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{"p1": p1, "c1": c1}
+}
+
+func newTestConnector(opts ...Option) (*Connector, *countingConnector, *time.Time) {
+	next := &countingConnector{Connector: memory.NewConnector()}
+	now := time.Unix(1500000000, 0)
+	c := NewConnector(next, time.Minute, opts...)
+	c.now = func() time.Time { return now }
+	return c, next, &now
+}
+
+func TestDuplicateSkipped(t *testing.T) {
+	ctx := context.TODO()
+	c, next, now := newTestConnector()
+
+	assert.NoError(t, c.Upsert(ctx, testEi, row("a", 1)))
+	assert.NoError(t, c.Upsert(ctx, testEi, row("a", 1)))
+	assert.Equal(t, int64(1), next.upserts)
+	assert.Equal(t, int64(1), c.Skipped())
+
+	// a changed value is written, and becomes the value to compare with
+	assert.NoError(t, c.Upsert(ctx, testEi, row("a", 2)))
+	assert.NoError(t, c.Upsert(ctx, testEi, row("a", 2)))
+	assert.Equal(t, int64(2), next.upserts)
+	values, err := c.Read(ctx, testEi, map[string]dosa.FieldValue{"p1": "a"}, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), values["c1"])
+
+	// other rows are independent
+	assert.NoError(t, c.Upsert(ctx, testEi, row("b", 2)))
+	assert.Equal(t, int64(3), next.upserts)
+
+	// once the window has passed the value is written again
+	*now = now.Add(2 * time.Minute)
+	assert.NoError(t, c.Upsert(ctx, testEi, row("a", 2)))
+	assert.Equal(t, int64(4), next.upserts)
+	assert.Equal(t, int64(2), c.Skipped())
+}
+
+func TestRemoveForgets(t *testing.T) {
+	ctx := context.TODO()
+	c, next, _ := newTestConnector()
+
+	assert.NoError(t, c.Upsert(ctx, testEi, row("a", 1)))
+	assert.NoError(t, c.Remove(ctx, testEi, map[string]dosa.FieldValue{"p1": "a"}))
+	assert.NoError(t, c.Upsert(ctx, testEi, row("a", 1)))
+	assert.Equal(t, int64(2), next.upserts)
+
+	// the memory connector has no MultiUpsert, the rows are forgotten anyway
+	_, err := c.MultiUpsert(ctx, testEi, []map[string]dosa.FieldValue{row("a", 1)})
+	assert.Error(t, err)
+	assert.NoError(t, c.Upsert(ctx, testEi, row("a", 1)))
+	assert.Equal(t, int64(3), next.upserts)
+
+	assert.NoError(t, c.RemoveRange(ctx, testEi, map[string][]*dosa.Condition{"p1": {{Op: dosa.Eq, Value: "a"}}}))
+	assert.NoError(t, c.Upsert(ctx, testEi, row("a", 1)))
+	assert.Equal(t, int64(4), next.upserts)
+	assert.Equal(t, int64(0), c.Skipped())
+}
+
+func TestMaxEntries(t *testing.T) {
+	ctx := context.TODO()
+	c, next, _ := newTestConnector(WithMaxEntries(2))
+
+	for _, p1 := range []string{"a", "b", "c"} {
+		assert.NoError(t, c.Upsert(ctx, testEi, row(p1, 1)))
+	}
+	assert.Len(t, c.entries, 2)
+	assert.Equal(t, 2, c.lru.Len())
+
+	// "a" was forgotten, "c" was not
+	assert.NoError(t, c.Upsert(ctx, testEi, row("a", 1)))
+	assert.NoError(t, c.Upsert(ctx, testEi, row("c", 1)))
+	assert.Equal(t, int64(4), next.upserts)
+	assert.Equal(t, int64(1), c.Skipped())
+}
+
+func TestConcurrentUpserts(t *testing.T) {
+	ctx := context.TODO()
+	c, next, _ := newTestConnector(WithMaxEntries(4))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				p1 := string(rune('a' + (i+j)%8))
+				assert.NoError(t, c.Upsert(ctx, testEi, row(p1, int64(j%2))))
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int64(20*50), next.upserts+c.Skipped())
+	assert.True(t, len(c.entries) <= 4)
+
+	// the last value written is the one remembered
+	assert.NoError(t, c.Upsert(ctx, testEi, row("a", 5)))
+	assert.NoError(t, c.Upsert(ctx, testEi, row("a", 5)))
+	values, err := c.Read(ctx, testEi, map[string]dosa.FieldValue{"p1": "a"}, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), values["c1"])
+}
+
+func TestHashValues(t *testing.T) {
+	s := "x"
+	var nilString *string
+	ts := time.Unix(100, 0)
+	assert.Equal(t, hashValues(map[string]dosa.FieldValue{"a": "x"}), hashValues(map[string]dosa.FieldValue{"a": &s}))
+	assert.Equal(t, hashValues(map[string]dosa.FieldValue{"a": ts}), hashValues(map[string]dosa.FieldValue{"a": ts.In(time.FixedZone("z", 3600))}))
+	assert.NotEqual(t, hashValues(map[string]dosa.FieldValue{"a": "x"}), hashValues(map[string]dosa.FieldValue{"a": nilString}))
+	assert.NotEqual(t, hashValues(map[string]dosa.FieldValue{"a": int32(1)}), hashValues(map[string]dosa.FieldValue{"a": int64(1)}))
+	assert.NotEqual(t, hashValues(map[string]dosa.FieldValue{"ab": "c"}), hashValues(map[string]dosa.FieldValue{"a": "bc"}))
+	assert.NotEqual(t, hashValues(map[string]dosa.FieldValue{"a": []byte{1}}), hashValues(map[string]dosa.FieldValue{"a": []byte{1, 0}}))
+}
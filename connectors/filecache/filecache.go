@@ -0,0 +1,360 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package filecache contains a key/value connector that keeps its entries in
+// files on the local disk. It stores the entities the fallback cache
+// connector writes, so it can serve as a fallback that survives restarts on
+// hosts without a shared cache.
+package filecache
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/base"
+)
+
+// entrySuffix is the extension of entry files. Values are first written to
+// files named with tmpPrefix, so that a crash mid-write never leaves a
+// partial entry behind.
+const (
+	entrySuffix = ".entry"
+	tmpPrefix   = "tmp-"
+)
+
+// Option configures optional behavior of the file cache connector
+type Option func(*Connector)
+
+// WithMaxBytes caps the total size of the stored values. When an upsert
+// takes the total over the cap, the least recently used entries are evicted
+// until it fits again, skipping pinned entries. Zero, the default, means no
+// cap.
+func WithMaxBytes(n int64) Option {
+	return func(c *Connector) {
+		c.maxBytes = n
+	}
+}
+
+// Connector stores key/value entities as one file per entry in a directory.
+// Entities must have a single blob partition key and a single blob value
+// column, the shape used by the fallback cache connector. It is safe for
+// concurrent use by multiple goroutines, but not by multiple processes
+// sharing the same directory.
+type Connector struct {
+	base.Connector
+	dir      string
+	maxBytes int64
+
+	mux    sync.Mutex
+	lru    *list.List
+	files  map[string]*list.Element
+	size   int64
+	pinned map[string]bool
+}
+
+// file is an entry of the LRU list
+type file struct {
+	name string
+	size int64
+}
+
+// NewConnector returns a connector keeping its entries in dir, which is
+// created if needed. Entries left in dir by a previous run are kept, with
+// their modification time standing in for their last use.
+func NewConnector(dir string, opts ...Option) (*Connector, error) {
+	c := &Connector{
+		dir:    dir,
+		lru:    list.New(),
+		files:  map[string]*list.Element{},
+		pinned: map[string]bool{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "cannot create file cache directory")
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// load rebuilds the LRU list from the entries already on disk
+func (c *Connector) load() error {
+	infos, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return errors.Wrap(err, "cannot list file cache directory")
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().After(infos[j].ModTime())
+	})
+	for _, info := range infos {
+		switch {
+		case strings.HasPrefix(info.Name(), tmpPrefix):
+			_ = os.Remove(filepath.Join(c.dir, info.Name()))
+		case strings.HasSuffix(info.Name(), entrySuffix) && info.Mode().IsRegular():
+			c.files[info.Name()] = c.lru.PushBack(&file{name: info.Name(), size: info.Size()})
+			c.size += info.Size()
+		}
+	}
+	return c.evict()
+}
+
+// Read returns the value stored for the key
+func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, fieldsToRead []string) (map[string]dosa.FieldValue, error) {
+	keyName, valueName, err := keyValueNames(ei)
+	if err != nil {
+		return nil, err
+	}
+	name, err := fileName(ei, keys[keyName])
+	if err != nil {
+		return nil, err
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	elem, ok := c.files[name]
+	if !ok {
+		return nil, &dosa.ErrNotFound{}
+	}
+	data, err := ioutil.ReadFile(filepath.Join(c.dir, name))
+	if os.IsNotExist(err) {
+		c.drop(elem)
+		return nil, &dosa.ErrNotFound{}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read file cache entry")
+	}
+	c.lru.MoveToFront(elem)
+	now := time.Now()
+	_ = os.Chtimes(filepath.Join(c.dir, name), now, now)
+
+	result := make(map[string]dosa.FieldValue, len(keys)+1)
+	for k, v := range keys {
+		result[k] = v
+	}
+	result[valueName] = data
+	return result, nil
+}
+
+// Upsert stores the value for the key, replacing any previous value
+func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	keyName, valueName, err := keyValueNames(ei)
+	if err != nil {
+		return err
+	}
+	name, err := fileName(ei, values[keyName])
+	if err != nil {
+		return err
+	}
+	data, ok := values[valueName].([]byte)
+	if !ok || len(data) == 0 {
+		return errors.New("no value specified")
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	// write to a temporary file and rename it so that readers never see a
+	// partially written entry
+	tmp, err := ioutil.TempFile(c.dir, tmpPrefix)
+	if err != nil {
+		return errors.Wrap(err, "cannot write file cache entry")
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(c.dir, name))
+	}
+	if err == nil {
+		// stamp the entry with the same clock Read uses, since the file
+		// system's own timestamps can lag behind it
+		now := time.Now()
+		_ = os.Chtimes(filepath.Join(c.dir, name), now, now)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return errors.Wrap(err, "cannot write file cache entry")
+	}
+
+	if elem, ok := c.files[name]; ok {
+		f := elem.Value.(*file)
+		c.size += int64(len(data)) - f.size
+		f.size = int64(len(data))
+		c.lru.MoveToFront(elem)
+	} else {
+		c.files[name] = c.lru.PushFront(&file{name: name, size: int64(len(data))})
+		c.size += int64(len(data))
+	}
+	return c.evict()
+}
+
+// Remove deletes the entry for the key. Removing a missing entry is not an
+// error.
+func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	keyName, _, err := keyValueNames(ei)
+	if err != nil {
+		return err
+	}
+	name, err := fileName(ei, keys[keyName])
+	if err != nil {
+		return err
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	elem, ok := c.files[name]
+	if !ok {
+		return nil
+	}
+	if err := os.Remove(filepath.Join(c.dir, name)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "cannot remove file cache entry")
+	}
+	c.drop(elem)
+	return nil
+}
+
+// PinEntry exempts the entry for the key from eviction, whether or not it is
+// stored yet. Pins are kept in memory and do not survive the connector.
+func (c *Connector) PinEntry(ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	name, err := keyFileName(ei, keys)
+	if err != nil {
+		return err
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.pinned[name] = true
+	return nil
+}
+
+// UnpinEntry lets the entry for the key be evicted again
+func (c *Connector) UnpinEntry(ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	name, err := keyFileName(ei, keys)
+	if err != nil {
+		return err
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	delete(c.pinned, name)
+	return c.evict()
+}
+
+// Shutdown does nothing; entries stay on disk for the next run
+func (c *Connector) Shutdown() error {
+	return nil
+}
+
+// evict removes least recently used entries that are not pinned until the
+// cap is met. The most recent entry is always kept, even if it alone exceeds
+// the cap, and so are pinned ones. c.mux must be held.
+func (c *Connector) evict() error {
+	if c.maxBytes <= 0 {
+		return nil
+	}
+	elem := c.lru.Back()
+	for c.size > c.maxBytes && elem != nil && elem != c.lru.Front() {
+		f := elem.Value.(*file)
+		prev := elem.Prev()
+		if !c.pinned[f.name] {
+			if err := os.Remove(filepath.Join(c.dir, f.name)); err != nil && !os.IsNotExist(err) {
+				return errors.Wrap(err, "cannot evict file cache entry")
+			}
+			c.drop(elem)
+		}
+		elem = prev
+	}
+	return nil
+}
+
+// drop forgets an entry. c.mux must be held.
+func (c *Connector) drop(elem *list.Element) {
+	f := c.lru.Remove(elem).(*file)
+	delete(c.files, f.name)
+	c.size -= f.size
+}
+
+// keyValueNames checks that the entity has the key/value shape and returns
+// the names of its key and value columns
+func keyValueNames(ei *dosa.EntityInfo) (string, string, error) {
+	if len(ei.Def.Key.PartitionKeys) != 1 || len(ei.Def.Key.ClusteringKeys) != 0 {
+		return "", "", errors.Errorf("entity %q should only have a single key", ei.Def.Name)
+	}
+	if len(ei.Def.Columns) != 2 || ei.Def.Columns[0].Type != dosa.Blob || ei.Def.Columns[1].Type != dosa.Blob {
+		return "", "", errors.Errorf("entity %q should have one []byte key and one []byte value", ei.Def.Name)
+	}
+	keyName := ei.Def.Key.PartitionKeys[0]
+	if ei.Def.Columns[0].Name == keyName {
+		return keyName, ei.Def.Columns[1].Name, nil
+	}
+	return keyName, ei.Def.Columns[0].Name, nil
+}
+
+// keyFileName returns the name of the file holding the entry for keys
+func keyFileName(ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) (string, error) {
+	keyName, _, err := keyValueNames(ei)
+	if err != nil {
+		return "", err
+	}
+	return fileName(ei, keys[keyName])
+}
+
+// fileName returns the name of the file holding the entry for key, a hash
+// of the entity's schema reference and the key
+func fileName(ei *dosa.EntityInfo, key dosa.FieldValue) (string, error) {
+	keyBytes, ok := key.([]byte)
+	if !ok || len(keyBytes) == 0 {
+		return "", errors.New("no key specified")
+	}
+	h := sha256.New()
+	if ei.Ref != nil {
+		fmt.Fprintf(h, "%s\x00%s\x00", ei.Ref.Scope, ei.Ref.NamePrefix)
+	}
+	fmt.Fprintf(h, "%s\x00", ei.Def.Name)
+	_, _ = h.Write(keyBytes)
+	return hex.EncodeToString(h.Sum(nil)) + entrySuffix, nil
+}
+
+func init() {
+	dosa.RegisterConnector("filecache", func(args dosa.CreationArgs) (dosa.Connector, error) {
+		dir, ok := args["dir"].(string)
+		if !ok || dir == "" {
+			return nil, errors.New("filecache: missing dir argument")
+		}
+		var opts []Option
+		if maxBytes, ok := args["maxbytes"].(int64); ok {
+			opts = append(opts, WithMaxBytes(maxBytes))
+		}
+		return NewConnector(dir, opts...)
+	})
+}
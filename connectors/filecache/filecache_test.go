@@ -0,0 +1,220 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package filecache
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+)
+
+var (
+	ctx = context.TODO()
+	ei  = &dosa.EntityInfo{
+		Ref: &dosa.SchemaRef{Scope: "testing", NamePrefix: "example"},
+		Def: &dosa.EntityDefinition{
+			Name:    "awesome_test_entity",
+			Key:     &dosa.PrimaryKey{PartitionKeys: []string{"key"}},
+			Columns: []*dosa.ColumnDefinition{{Name: "key", Type: dosa.Blob}, {Name: "value", Type: dosa.Blob}},
+		},
+	}
+)
+
+func entry(k, v string) map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{"key": []byte(k), "value": []byte(v)}
+}
+
+func key(k string) map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{"key": []byte(k)}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "filecache")
+	assert.NoError(t, err)
+	return dir
+}
+
+func TestRoundTripAcrossRestarts(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	c, err := NewConnector(dir)
+	assert.NoError(t, err)
+	_, err = c.Read(ctx, ei, key("k1"), dosa.All())
+	assert.True(t, dosa.ErrorIsNotFound(err))
+
+	assert.NoError(t, c.Upsert(ctx, ei, entry("k1", "v1")))
+	assert.NoError(t, c.Upsert(ctx, ei, entry("k2", "v2")))
+	assert.NoError(t, c.Upsert(ctx, ei, entry("k1", "v1 again")))
+	result, err := c.Read(ctx, ei, key("k1"), dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, entry("k1", "v1 again"), result)
+	assert.NoError(t, c.Shutdown())
+
+	// a new connector on the same directory sees the entries
+	c, err = NewConnector(dir)
+	assert.NoError(t, err)
+	result, err = c.Read(ctx, ei, key("k2"), dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, entry("k2", "v2"), result)
+	assert.Equal(t, int64(len("v1 again")+len("v2")), c.size)
+
+	assert.NoError(t, c.Remove(ctx, ei, key("k2")))
+	assert.NoError(t, c.Remove(ctx, ei, key("k2")))
+	_, err = c.Read(ctx, ei, key("k2"), dosa.All())
+	assert.True(t, dosa.ErrorIsNotFound(err))
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestEviction(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// room for three 4 byte values
+	c, err := NewConnector(dir, WithMaxBytes(12))
+	assert.NoError(t, err)
+	for _, k := range []string{"k1", "k2", "k3"} {
+		assert.NoError(t, c.Upsert(ctx, ei, entry(k, "vvvv")))
+	}
+	// touching k1 makes k2 the least recently used entry
+	_, err = c.Read(ctx, ei, key("k1"), dosa.All())
+	assert.NoError(t, err)
+	assert.NoError(t, c.Upsert(ctx, ei, entry("k4", "vvvv")))
+
+	_, err = c.Read(ctx, ei, key("k2"), dosa.All())
+	assert.True(t, dosa.ErrorIsNotFound(err))
+	for _, k := range []string{"k1", "k3", "k4"} {
+		_, err = c.Read(ctx, ei, key(k), dosa.All())
+		assert.NoError(t, err, k)
+	}
+	assert.Equal(t, int64(12), c.size)
+
+	// a larger value evicts as many entries as needed
+	assert.NoError(t, c.Upsert(ctx, ei, entry("k5", "vvvvvvvv")))
+	assert.Equal(t, 2, c.lru.Len())
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+
+	// the cap applies to entries found on disk at startup
+	c, err = NewConnector(dir, WithMaxBytes(8))
+	assert.NoError(t, err)
+	_, err = c.Read(ctx, ei, key("k5"), dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, c.lru.Len())
+}
+
+func TestPinnedEntriesAreNotEvicted(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// room for two 4 byte values
+	c, err := NewConnector(dir, WithMaxBytes(8))
+	assert.NoError(t, err)
+	assert.NoError(t, c.PinEntry(ei, key("pinned")))
+	assert.NoError(t, c.Upsert(ctx, ei, entry("pinned", "vvvv")))
+	for _, k := range []string{"k1", "k2", "k3"} {
+		assert.NoError(t, c.Upsert(ctx, ei, entry(k, "vvvv")))
+	}
+
+	// the least recently used entry survives, the others make room
+	_, err = c.Read(ctx, ei, key("pinned"), dosa.All())
+	assert.NoError(t, err)
+	_, err = c.Read(ctx, ei, key("k2"), dosa.All())
+	assert.True(t, dosa.ErrorIsNotFound(err))
+	assert.Equal(t, int64(8), c.size)
+
+	// unpinned, it is evicted like any other
+	_, err = c.Read(ctx, ei, key("k3"), dosa.All())
+	assert.NoError(t, err)
+	assert.NoError(t, c.UnpinEntry(ei, key("pinned")))
+	assert.NoError(t, c.Upsert(ctx, ei, entry("k4", "vvvv")))
+	_, err = c.Read(ctx, ei, key("pinned"), dosa.All())
+	assert.True(t, dosa.ErrorIsNotFound(err))
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	c, err := NewConnector(dir, WithMaxBytes(100))
+	assert.NoError(t, err)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			k := fmt.Sprintf("k%d", i%3)
+			for j := 0; j < 20; j++ {
+				assert.NoError(t, c.Upsert(ctx, ei, entry(k, fmt.Sprintf("value %d", j))))
+				if _, err := c.Read(ctx, ei, key(k), dosa.All()); err != nil {
+					assert.True(t, dosa.ErrorIsNotFound(err))
+				}
+				assert.NoError(t, c.Remove(ctx, ei, key(k)))
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int64(0), c.size)
+}
+
+func TestInvalidEntities(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	c, err := NewConnector(dir)
+	assert.NoError(t, err)
+
+	notKeyValue := &dosa.EntityInfo{Def: &dosa.EntityDefinition{
+		Name:    "wide",
+		Key:     &dosa.PrimaryKey{PartitionKeys: []string{"key"}},
+		Columns: []*dosa.ColumnDefinition{{Name: "key", Type: dosa.Blob}, {Name: "a", Type: dosa.Blob}, {Name: "b", Type: dosa.Blob}},
+	}}
+	_, err = c.Read(ctx, notKeyValue, key("k1"), dosa.All())
+	assert.Error(t, err)
+	assert.Error(t, c.Upsert(ctx, notKeyValue, entry("k1", "v1")))
+	assert.Error(t, c.Remove(ctx, notKeyValue, key("k1")))
+
+	assert.Error(t, c.Upsert(ctx, ei, key("k1")))
+	assert.Error(t, c.Upsert(ctx, ei, entry("", "v1")))
+
+	// file system errors are reported
+	_, err = NewConnector(filepath.Join(dir, "k1", "\x00"))
+	assert.Error(t, err)
+}
+
+func TestRegistered(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	conn, err := dosa.GetConnector("filecache", dosa.CreationArgs{"dir": dir, "maxbytes": int64(10)})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), conn.(*Connector).maxBytes)
+	_, err = dosa.GetConnector("filecache", nil)
+	assert.Error(t, err)
+}
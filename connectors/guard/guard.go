@@ -0,0 +1,130 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package guard provides a connector that rejects point reads missing a
+// partition key, which some stores would otherwise turn into a scan.
+package guard
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors"
+	"github.com/uber-go/dosa/connectors/base"
+)
+
+// ErrMissingPartitionKey is returned for a point read that does not give a
+// value for all of its entity's partition keys
+type ErrMissingPartitionKey struct {
+	Entity string
+	Keys   []string
+}
+
+// Error lists the entity and the partition keys the read left out
+func (e *ErrMissingPartitionKey) Error() string {
+	return fmt.Sprintf("read of entity %q is missing partition keys: %s", e.Entity, strings.Join(e.Keys, ", "))
+}
+
+// ErrorIsMissingPartitionKey checks if the error is caused by
+// "ErrMissingPartitionKey"
+func ErrorIsMissingPartitionKey(err error) bool {
+	_, ok := errors.Cause(err).(*ErrMissingPartitionKey)
+	return ok
+}
+
+// Connector checks that point reads give every partition key of their
+// entity, as described by the entity's primary key, and passes all other
+// operations through to the next connector
+type Connector struct {
+	base.Connector
+}
+
+// NewConnector returns a connector that rejects Read and MultiRead calls
+// missing a partition key, or giving it a nil value, before they reach next
+func NewConnector(next dosa.Connector) *Connector {
+	return &Connector{Connector: base.Connector{Next: next}}
+}
+
+// Middleware returns a connectors.Middleware that wraps a connector with
+// NewConnector
+func Middleware() connectors.Middleware {
+	return func(next dosa.Connector) dosa.Connector {
+		return NewConnector(next)
+	}
+}
+
+// check returns an *ErrMissingPartitionKey if keys lacks partition keys of ei
+func check(ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	if ei.Def.Key == nil {
+		return nil
+	}
+	var missing []string
+	for _, name := range ei.Def.Key.PartitionKeys {
+		if v, ok := keys[name]; !ok || v == nil {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return &ErrMissingPartitionKey{Entity: ei.Def.Name, Keys: missing}
+	}
+	return nil
+}
+
+// Read checks the partition keys before calling Next
+func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, minimumFields []string) (map[string]dosa.FieldValue, error) {
+	if err := check(ei, keys); err != nil {
+		return nil, err
+	}
+	return c.Connector.Read(ctx, ei, keys, minimumFields)
+}
+
+// MultiRead checks the partition keys of every row. Only the rows that pass
+// are sent to Next; the others get their *ErrMissingPartitionKey in the
+// result.
+func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, minimumFields []string) ([]*dosa.FieldValuesOrError, error) {
+	results := make([]*dosa.FieldValuesOrError, len(keys))
+	var indexes []int
+	var valid []map[string]dosa.FieldValue
+	for i, k := range keys {
+		if err := check(ei, k); err != nil {
+			results[i] = &dosa.FieldValuesOrError{Error: err}
+			continue
+		}
+		indexes = append(indexes, i)
+		valid = append(valid, k)
+	}
+	if len(valid) == len(keys) {
+		return c.Connector.MultiRead(ctx, ei, keys, minimumFields)
+	}
+	if len(valid) == 0 {
+		return results, nil
+	}
+	nextResults, err := c.Connector.MultiRead(ctx, ei, valid, minimumFields)
+	if err != nil {
+		return nil, err
+	}
+	for j, result := range nextResults {
+		results[indexes[j]] = result
+	}
+	return results, nil
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package guard
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/mocks"
+)
+
+var testEi = &dosa.EntityInfo{
+	Ref: &dosa.SchemaRef{Scope: "scope1", NamePrefix: "namePrefix", EntityName: "eName"},
+	Def: &dosa.EntityDefinition{
+		Name: "t1",
+		Key:  &dosa.PrimaryKey{PartitionKeys: []string{"p1", "p2"}},
+		Columns: []*dosa.ColumnDefinition{
+			{Name: "p1", Type: dosa.String},
+			{Name: "p2", Type: dosa.String},
+			{Name: "c1", Type: dosa.String},
+		},
+	},
+}
+
+func TestCompleteKeyRead(t *testing.T) {
+	ctx := context.TODO()
+	c := NewConnector(memory.NewConnector())
+	row := map[string]dosa.FieldValue{"p1": "a", "p2": "b", "c1": "x"}
+	assert.NoError(t, c.Upsert(ctx, testEi, row))
+
+	values, err := c.Read(ctx, testEi, map[string]dosa.FieldValue{"p1": "a", "p2": "b"}, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, "x", values["c1"])
+}
+
+func TestMissingPartitionKeyRead(t *testing.T) {
+	ctx := context.TODO()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockNext := mocks.NewMockConnector(ctrl)
+	c := NewConnector(mockNext)
+
+	// rejected reads never reach the next connector
+	_, err := c.Read(ctx, testEi, map[string]dosa.FieldValue{"p1": "a"}, dosa.All())
+	assert.True(t, ErrorIsMissingPartitionKey(err))
+	assert.EqualError(t, err, `read of entity "t1" is missing partition keys: p2`)
+	_, err = c.Read(ctx, testEi, map[string]dosa.FieldValue{"p1": nil, "c1": "x"}, dosa.All())
+	assert.Equal(t, []string{"p1", "p2"}, err.(*ErrMissingPartitionKey).Keys)
+	assert.False(t, ErrorIsMissingPartitionKey(assert.AnError))
+
+	// MultiRead sends the complete keys on and rejects the others
+	complete := map[string]dosa.FieldValue{"p1": "a", "p2": "b"}
+	mockNext.EXPECT().MultiRead(ctx, testEi, []map[string]dosa.FieldValue{complete}, dosa.All()).
+		Return([]*dosa.FieldValuesOrError{{Values: complete}}, nil)
+	results, err := c.MultiRead(ctx, testEi, []map[string]dosa.FieldValue{{"p2": "b"}, complete}, dosa.All())
+	assert.NoError(t, err)
+	if assert.Len(t, results, 2) {
+		assert.True(t, ErrorIsMissingPartitionKey(results[0].Error))
+		assert.Equal(t, complete, results[1].Values)
+	}
+}
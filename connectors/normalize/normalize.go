@@ -0,0 +1,162 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package normalize provides a connector that translates the errors of the
+// connector it wraps into DOSA's canonical errors, so that callers and other
+// connectors, such as the fallback cache, can tell a missing row or a
+// timeout apart whatever store is underneath.
+package normalize
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors"
+	"github.com/uber-go/dosa/connectors/base"
+)
+
+// Rules recognize the errors of the next connector that mean one of the
+// canonical conditions. Nil rules recognize nothing.
+type Rules struct {
+	// NotFound recognizes errors meaning the row does not exist; they are
+	// translated to *dosa.ErrNotFound
+	NotFound func(error) bool
+	// AlreadyExists recognizes errors meaning the row already exists; they
+	// are translated to *dosa.ErrAlreadyExists
+	AlreadyExists func(error) bool
+	// Timeout recognizes errors meaning the store took too long; they are
+	// translated to context.DeadlineExceeded
+	Timeout func(error) bool
+}
+
+// Connector translates the errors returned by the data operations of the
+// next connector according to its rules. Translated errors keep the message
+// of the original one, while errors.Cause returns the canonical error, which
+// is what the dosa.ErrorIsX helpers check. Errors no rule recognizes, and
+// the errors of the schema and scope operations, are returned as they are.
+type Connector struct {
+	base.Connector
+	rules Rules
+}
+
+// NewConnector returns a connector translating the errors of next
+func NewConnector(next dosa.Connector, rules Rules) *Connector {
+	return &Connector{
+		Connector: base.Connector{Next: next},
+		rules:     rules,
+	}
+}
+
+// Middleware returns a connectors.Middleware that wraps a connector with
+// NewConnector
+func Middleware(rules Rules) connectors.Middleware {
+	return func(next dosa.Connector) dosa.Connector {
+		return NewConnector(next, rules)
+	}
+}
+
+// translate returns the canonical error for err, or err if no rule applies
+func (c *Connector) translate(err error) error {
+	if err == nil {
+		return nil
+	}
+	var canonical error
+	switch {
+	case c.rules.NotFound != nil && c.rules.NotFound(err):
+		canonical = &dosa.ErrNotFound{}
+	case c.rules.AlreadyExists != nil && c.rules.AlreadyExists(err):
+		canonical = &dosa.ErrAlreadyExists{}
+	case c.rules.Timeout != nil && c.rules.Timeout(err):
+		canonical = context.DeadlineExceeded
+	default:
+		return err
+	}
+	return errors.Wrap(canonical, err.Error())
+}
+
+// translateAll translates every error of a multi operation result in place
+func (c *Connector) translateAll(errs []error) []error {
+	for i, err := range errs {
+		errs[i] = c.translate(err)
+	}
+	return errs
+}
+
+// CreateIfNotExists calls Next and translates its error
+func (c *Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	return c.translate(c.Connector.CreateIfNotExists(ctx, ei, values))
+}
+
+// Read calls Next and translates its error
+func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, minimumFields []string) (map[string]dosa.FieldValue, error) {
+	values, err := c.Connector.Read(ctx, ei, keys, minimumFields)
+	return values, c.translate(err)
+}
+
+// MultiRead calls Next and translates its error and those of every row
+func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, minimumFields []string) ([]*dosa.FieldValuesOrError, error) {
+	results, err := c.Connector.MultiRead(ctx, ei, keys, minimumFields)
+	for _, result := range results {
+		if result != nil {
+			result.Error = c.translate(result.Error)
+		}
+	}
+	return results, c.translate(err)
+}
+
+// Upsert calls Next and translates its error
+func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	return c.translate(c.Connector.Upsert(ctx, ei, values))
+}
+
+// MultiUpsert calls Next and translates its error and those of every row
+func (c *Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, multiValues []map[string]dosa.FieldValue) ([]error, error) {
+	results, err := c.Connector.MultiUpsert(ctx, ei, multiValues)
+	return c.translateAll(results), c.translate(err)
+}
+
+// Remove calls Next and translates its error
+func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	return c.translate(c.Connector.Remove(ctx, ei, keys))
+}
+
+// RemoveRange calls Next and translates its error
+func (c *Connector) RemoveRange(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition) error {
+	return c.translate(c.Connector.RemoveRange(ctx, ei, columnConditions))
+}
+
+// MultiRemove calls Next and translates its error and those of every row
+func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) ([]error, error) {
+	results, err := c.Connector.MultiRemove(ctx, ei, multiKeys)
+	return c.translateAll(results), c.translate(err)
+}
+
+// Range calls Next and translates its error
+func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, minimumFields []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	rows, next, err := c.Connector.Range(ctx, ei, columnConditions, minimumFields, token, limit)
+	return rows, next, c.translate(err)
+}
+
+// Scan calls Next and translates its error
+func (c *Connector) Scan(ctx context.Context, ei *dosa.EntityInfo, minimumFields []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	rows, next, err := c.Connector.Scan(ctx, ei, minimumFields, token, limit)
+	return rows, next, c.translate(err)
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package normalize
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/mocks"
+)
+
+var (
+	testEi = &dosa.EntityInfo{
+		Ref: &dosa.SchemaRef{Scope: "scope1", NamePrefix: "namePrefix", EntityName: "eName"},
+		Def: &dosa.EntityDefinition{
+			Name:    "t1",
+			Key:     &dosa.PrimaryKey{PartitionKeys: []string{"p1"}},
+			Columns: []*dosa.ColumnDefinition{{Name: "p1", Type: dosa.String}},
+		},
+	}
+	keys = map[string]dosa.FieldValue{"p1": "a"}
+
+	errRowMissing = errors.New("row missing")
+	errDuplicate  = errors.New("duplicate key")
+	errTooSlow    = errors.New("store took too long")
+)
+
+var testRules = Rules{
+	NotFound:      func(err error) bool { return err == errRowMissing },
+	AlreadyExists: func(err error) bool { return err == errDuplicate },
+	Timeout:       func(err error) bool { return err == errTooSlow },
+}
+
+func TestReadNotFound(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	next := mocks.NewMockConnector(ctrl)
+	c := NewConnector(next, testRules)
+
+	next.EXPECT().Read(context.TODO(), testEi, keys, dosa.All()).Return(nil, errRowMissing)
+	_, err := c.Read(context.TODO(), testEi, keys, dosa.All())
+	assert.True(t, dosa.ErrorIsNotFound(err))
+	assert.Contains(t, err.Error(), "row missing")
+
+	// errors no rule recognizes are returned unchanged
+	next.EXPECT().Read(context.TODO(), testEi, keys, dosa.All()).Return(nil, assert.AnError)
+	_, err = c.Read(context.TODO(), testEi, keys, dosa.All())
+	assert.Equal(t, assert.AnError, err)
+
+	next.EXPECT().Read(context.TODO(), testEi, keys, dosa.All()).Return(keys, nil)
+	values, err := c.Read(context.TODO(), testEi, keys, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, keys, values)
+}
+
+func TestCanonicalErrors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	next := mocks.NewMockConnector(ctrl)
+	c := NewConnector(next, testRules)
+
+	next.EXPECT().CreateIfNotExists(context.TODO(), testEi, keys).Return(errDuplicate)
+	assert.True(t, dosa.ErrorIsAlreadyExists(c.CreateIfNotExists(context.TODO(), testEi, keys)))
+
+	next.EXPECT().Range(context.TODO(), testEi, nil, dosa.All(), "", 10).Return(nil, "", errTooSlow)
+	_, _, err := c.Range(context.TODO(), testEi, nil, dosa.All(), "", 10)
+	assert.True(t, dosa.ErrorIsTransient(err))
+
+	next.EXPECT().Remove(context.TODO(), testEi, keys).Return(errRowMissing)
+	assert.True(t, dosa.ErrorIsNotFound(c.Remove(context.TODO(), testEi, keys)))
+
+	// the rows of multi operations are translated one by one
+	rows := []map[string]dosa.FieldValue{keys, keys, keys}
+	next.EXPECT().MultiUpsert(context.TODO(), testEi, rows).Return([]error{nil, errDuplicate, assert.AnError}, nil)
+	results, err := c.MultiUpsert(context.TODO(), testEi, rows)
+	assert.NoError(t, err)
+	assert.NoError(t, results[0])
+	assert.True(t, dosa.ErrorIsAlreadyExists(results[1]))
+	assert.Equal(t, assert.AnError, results[2])
+
+	next.EXPECT().MultiRead(context.TODO(), testEi, rows, dosa.All()).
+		Return([]*dosa.FieldValuesOrError{{Values: keys}, {Error: errRowMissing}}, nil)
+	read, err := c.MultiRead(context.TODO(), testEi, rows, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, keys, read[0].Values)
+	assert.True(t, dosa.ErrorIsNotFound(read[1].Error))
+
+	// without rules nothing is translated
+	next.EXPECT().Upsert(context.TODO(), testEi, keys).Return(errRowMissing)
+	assert.Equal(t, errRowMissing, NewConnector(next, Rules{}).Upsert(context.TODO(), testEi, keys))
+}
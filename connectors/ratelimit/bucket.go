@@ -0,0 +1,72 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// bucket is a token bucket holding up to burst tokens and refilling at rate
+// tokens per second
+type bucket struct {
+	mux    sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(l Limit, now time.Time) *bucket {
+	burst := float64(l.Burst)
+	if burst <= 0 {
+		burst = math.Max(1, math.Ceil(l.Rate))
+	}
+	return &bucket{
+		rate:   l.Rate,
+		burst:  burst,
+		tokens: burst,
+		last:   now,
+	}
+}
+
+// take removes n tokens from the bucket if they are available. Otherwise it
+// leaves the bucket untouched and returns how long it will take for n tokens
+// to become available.
+func (b *bucket) take(n int, now time.Time) (time.Duration, bool) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+	if b.tokens >= float64(n) {
+		b.tokens -= float64(n)
+		return 0, true
+	}
+	missing := float64(n) - b.tokens
+	return time.Duration(missing / b.rate * float64(time.Second)), false
+}
@@ -0,0 +1,253 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors"
+	"github.com/uber-go/dosa/connectors/base"
+)
+
+// Limit describes a token bucket: Rate tokens are added per second, up to
+// Burst tokens. Every row written takes one token. A zero Rate means no limit;
+// a zero Burst defaults to one second's worth of tokens, and at least one.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// ErrRateLimited is returned when an operation exceeds its entity's budget
+type ErrRateLimited struct {
+	Entity string
+}
+
+// Error returns the name of the entity that was throttled
+func (e *ErrRateLimited) Error() string {
+	return fmt.Sprintf("rate limit exceeded for entity %q", e.Entity)
+}
+
+// ErrorIsRateLimited checks if the error is caused by "ErrRateLimited"
+func ErrorIsRateLimited(err error) bool {
+	_, ok := errors.Cause(err).(*ErrRateLimited)
+	return ok
+}
+
+// Option configures optional behavior of the rate limiting connector
+type Option func(*Connector)
+
+// WithEntityLimit overrides the write limit for a single entity
+func WithEntityLimit(entity string, l Limit) Option {
+	return func(c *Connector) {
+		c.entityLimits[entity] = l
+	}
+}
+
+// WithReadLimit limits reads per entity as well. Reads are not throttled by
+// default. Reads and writes draw from separate buckets.
+func WithReadLimit(l Limit) Option {
+	return func(c *Connector) {
+		c.readLimit = l
+	}
+}
+
+// WithWait makes operations over budget wait for tokens instead of failing
+// with ErrRateLimited. Waiting stops when the context is done.
+func WithWait() Option {
+	return func(c *Connector) {
+		c.wait = true
+	}
+}
+
+// Connector throttles writes per entity with a token bucket and passes all
+// operations through to the next connector
+type Connector struct {
+	base.Connector
+	writeLimit   Limit
+	readLimit    Limit
+	entityLimits map[string]Limit
+	wait         bool
+
+	mux          sync.Mutex
+	writeBuckets map[string]*bucket
+	readBuckets  map[string]*bucket
+	now          func() time.Time
+}
+
+// NewConnector returns a connector that limits writes of each entity to
+// writeLimit
+func NewConnector(next dosa.Connector, writeLimit Limit, opts ...Option) *Connector {
+	c := &Connector{
+		Connector:    base.Connector{Next: next},
+		writeLimit:   writeLimit,
+		entityLimits: map[string]Limit{},
+		writeBuckets: map[string]*bucket{},
+		readBuckets:  map[string]*bucket{},
+		now:          time.Now,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Middleware returns a connectors.Middleware that wraps a connector with
+// NewConnector
+func Middleware(writeLimit Limit, opts ...Option) connectors.Middleware {
+	return func(next dosa.Connector) dosa.Connector {
+		return NewConnector(next, writeLimit, opts...)
+	}
+}
+
+// acquire takes n tokens for the entity from the write or read buckets,
+// waiting for them if the connector was created with WithWait
+func (c *Connector) acquire(ctx context.Context, ei *dosa.EntityInfo, n int, write bool) error {
+	entity := ei.Def.Name
+	b := c.bucketFor(entity, write)
+	if b == nil {
+		return nil
+	}
+	for {
+		delay, ok := b.take(n, c.now())
+		if ok {
+			return nil
+		}
+		if !c.wait || float64(n) > b.burst {
+			return &ErrRateLimited{Entity: entity}
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// bucketFor returns the bucket for the entity, or nil if it is not limited
+func (c *Connector) bucketFor(entity string, write bool) *bucket {
+	l, buckets := c.readLimit, c.readBuckets
+	if write {
+		l, buckets = c.writeLimit, c.writeBuckets
+		if el, ok := c.entityLimits[entity]; ok {
+			l = el
+		}
+	}
+	if l.Rate <= 0 {
+		return nil
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	b, ok := buckets[entity]
+	if !ok {
+		b = newBucket(l, c.now())
+		buckets[entity] = b
+	}
+	return b
+}
+
+// CreateIfNotExists takes a write token before calling Next
+func (c *Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	if err := c.acquire(ctx, ei, 1, true); err != nil {
+		return err
+	}
+	return c.Connector.CreateIfNotExists(ctx, ei, values)
+}
+
+// Upsert takes a write token before calling Next
+func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	if err := c.acquire(ctx, ei, 1, true); err != nil {
+		return err
+	}
+	return c.Connector.Upsert(ctx, ei, values)
+}
+
+// MultiUpsert takes one write token per row before calling Next
+func (c *Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, multiValues []map[string]dosa.FieldValue) ([]error, error) {
+	if err := c.acquire(ctx, ei, len(multiValues), true); err != nil {
+		return nil, err
+	}
+	return c.Connector.MultiUpsert(ctx, ei, multiValues)
+}
+
+// Remove takes a write token before calling Next
+func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	if err := c.acquire(ctx, ei, 1, true); err != nil {
+		return err
+	}
+	return c.Connector.Remove(ctx, ei, keys)
+}
+
+// RemoveRange takes a write token before calling Next
+func (c *Connector) RemoveRange(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition) error {
+	if err := c.acquire(ctx, ei, 1, true); err != nil {
+		return err
+	}
+	return c.Connector.RemoveRange(ctx, ei, columnConditions)
+}
+
+// MultiRemove takes one write token per row before calling Next
+func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) ([]error, error) {
+	if err := c.acquire(ctx, ei, len(multiKeys), true); err != nil {
+		return nil, err
+	}
+	return c.Connector.MultiRemove(ctx, ei, multiKeys)
+}
+
+// Read takes a read token, if reads are limited, before calling Next
+func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, minimumFields []string) (map[string]dosa.FieldValue, error) {
+	if err := c.acquire(ctx, ei, 1, false); err != nil {
+		return nil, err
+	}
+	return c.Connector.Read(ctx, ei, keys, minimumFields)
+}
+
+// MultiRead takes one read token per row, if reads are limited, before
+// calling Next
+func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, minimumFields []string) ([]*dosa.FieldValuesOrError, error) {
+	if err := c.acquire(ctx, ei, len(keys), false); err != nil {
+		return nil, err
+	}
+	return c.Connector.MultiRead(ctx, ei, keys, minimumFields)
+}
+
+// Range takes a read token, if reads are limited, before calling Next
+func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, minimumFields []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	if err := c.acquire(ctx, ei, 1, false); err != nil {
+		return nil, "", err
+	}
+	return c.Connector.Range(ctx, ei, columnConditions, minimumFields, token, limit)
+}
+
+// Scan takes a read token, if reads are limited, before calling Next
+func (c *Connector) Scan(ctx context.Context, ei *dosa.EntityInfo, minimumFields []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	if err := c.acquire(ctx, ei, 1, false); err != nil {
+		return nil, "", err
+	}
+	return c.Connector.Scan(ctx, ei, minimumFields, token, limit)
+}
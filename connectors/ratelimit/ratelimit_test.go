@@ -0,0 +1,151 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+)
+
+var (
+	testEi = &dosa.EntityInfo{
+		Ref: &dosa.SchemaRef{Scope: "scope1", NamePrefix: "namePrefix", EntityName: "eName"},
+		Def: &dosa.EntityDefinition{
+			Name:    "t1",
+			Key:     &dosa.PrimaryKey{PartitionKeys: []string{"p1"}},
+			Columns: []*dosa.ColumnDefinition{{Name: "p1", Type: dosa.String}},
+		},
+	}
+	otherEi = &dosa.EntityInfo{
+		Ref: testEi.Ref,
+		Def: &dosa.EntityDefinition{
+			Name:    "t2",
+			Key:     testEi.Def.Key,
+			Columns: testEi.Def.Columns,
+		},
+	}
+	row = map[string]dosa.FieldValue{"p1": "a"}
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	return f.now
+}
+
+func newTestConnector(l Limit, opts ...Option) (*Connector, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(1500000000, 0)}
+	c := NewConnector(memory.NewConnector(), l, opts...)
+	c.now = clock.Now
+	return c, clock
+}
+
+func TestAllowUnderLimit(t *testing.T) {
+	ctx := context.TODO()
+	c, clock := newTestConnector(Limit{Rate: 1, Burst: 3})
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, c.Upsert(ctx, testEi, row))
+	}
+
+	// tokens refill over time
+	clock.now = clock.now.Add(2 * time.Second)
+	assert.NoError(t, c.Upsert(ctx, testEi, row))
+	assert.NoError(t, c.Remove(ctx, testEi, row))
+
+	// reads are not limited
+	for i := 0; i < 10; i++ {
+		_, err := c.Read(ctx, testEi, row, dosa.All())
+		assert.True(t, dosa.ErrorIsNotFound(err))
+	}
+}
+
+func TestDefaultBurst(t *testing.T) {
+	ctx := context.TODO()
+	c, clock := newTestConnector(Limit{Rate: 2.5})
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, c.Upsert(ctx, testEi, row))
+	}
+	assert.True(t, ErrorIsRateLimited(c.Upsert(ctx, testEi, row)))
+
+	// a rate below one still allows a single write
+	c, clock = newTestConnector(Limit{Rate: 0.5})
+	assert.NoError(t, c.Upsert(ctx, testEi, row))
+	assert.True(t, ErrorIsRateLimited(c.Upsert(ctx, testEi, row)))
+	clock.now = clock.now.Add(2 * time.Second)
+	assert.NoError(t, c.Upsert(ctx, testEi, row))
+}
+
+func TestRejectOverLimit(t *testing.T) {
+	ctx := context.TODO()
+	c, _ := newTestConnector(Limit{Rate: 1, Burst: 2}, WithEntityLimit("t2", Limit{Rate: 1, Burst: 5}))
+	assert.NoError(t, c.Upsert(ctx, testEi, row))
+	assert.NoError(t, c.Remove(ctx, testEi, row))
+	err := c.Upsert(ctx, testEi, row)
+	assert.True(t, ErrorIsRateLimited(err))
+	assert.Contains(t, err.Error(), `"t1"`)
+	_, err = c.MultiUpsert(ctx, testEi, []map[string]dosa.FieldValue{row})
+	assert.True(t, ErrorIsRateLimited(err))
+
+	// each entity has its own budget
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, c.Upsert(ctx, otherEi, row))
+	}
+	assert.True(t, ErrorIsRateLimited(c.Upsert(ctx, otherEi, row)))
+}
+
+func TestReadLimit(t *testing.T) {
+	ctx := context.TODO()
+	c, _ := newTestConnector(Limit{}, WithReadLimit(Limit{Rate: 1, Burst: 1}))
+	assert.NoError(t, c.Upsert(ctx, testEi, row))
+	_, err := c.Read(ctx, testEi, row, dosa.All())
+	assert.NoError(t, err)
+	_, _, err = c.Range(ctx, testEi, nil, dosa.All(), "", 10)
+	assert.True(t, ErrorIsRateLimited(err))
+}
+
+func TestWaitForToken(t *testing.T) {
+	c := NewConnector(memory.NewConnector(), Limit{Rate: 100, Burst: 1}, WithWait())
+	ctx := context.TODO()
+	assert.NoError(t, c.Upsert(ctx, testEi, row))
+	start := time.Now()
+	assert.NoError(t, c.Upsert(ctx, testEi, row))
+	assert.True(t, time.Since(start) >= 5*time.Millisecond)
+
+	// more rows than the burst can never be satisfied
+	_, err := c.MultiUpsert(ctx, testEi, []map[string]dosa.FieldValue{row, row})
+	assert.True(t, ErrorIsRateLimited(err))
+}
+
+func TestWaitRespectsContext(t *testing.T) {
+	c, _ := newTestConnector(Limit{Rate: 0.001, Burst: 1}, WithWait())
+	assert.NoError(t, c.Upsert(context.TODO(), testEi, row))
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+	err := c.Upsert(ctx, testEi, row)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package retry
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Backoff decides how long to wait before the next attempt of a retried
+// operation. The attempt argument starts at 1 for the delay that follows the
+// first failed attempt.
+type Backoff interface {
+	NextDelay(attempt int) time.Duration
+}
+
+// NewConstantBackoff returns a Backoff that always waits the same delay
+func NewConstantBackoff(delay time.Duration) Backoff {
+	return constantBackoff{delay: delay}
+}
+
+type constantBackoff struct {
+	delay time.Duration
+}
+
+// NextDelay returns the configured delay regardless of the attempt
+func (b constantBackoff) NextDelay(attempt int) time.Duration {
+	return b.delay
+}
+
+// NewLinearBackoff returns a Backoff that waits initial after the first
+// failure and increases the delay by step after every following one. The
+// delay never goes above max; a max of zero means no upper bound.
+func NewLinearBackoff(initial, step, max time.Duration) Backoff {
+	return linearBackoff{initial: initial, step: step, max: max}
+}
+
+type linearBackoff struct {
+	initial time.Duration
+	step    time.Duration
+	max     time.Duration
+}
+
+// NextDelay returns initial + (attempt-1)*step, capped at max
+func (b linearBackoff) NextDelay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	d := b.initial + time.Duration(attempt-1)*b.step
+	if b.max > 0 && d > b.max {
+		return b.max
+	}
+	return d
+}
+
+// NewExponentialBackoff returns a Backoff that doubles the delay after every
+// failure, starting at base and capped at max, and then picks a random delay
+// between half of that value and the full value ("equal jitter") so that many
+// clients failing at the same time do not retry in lockstep.
+//
+// The source is used to compute the jitter; pass a seeded source for a
+// reproducible sequence, or nil to use a time-seeded one.
+func NewExponentialBackoff(base, max time.Duration, source rand.Source) Backoff {
+	if source == nil {
+		source = rand.NewSource(time.Now().UnixNano())
+	}
+	return &exponentialBackoff{
+		base: base,
+		max:  max,
+		rnd:  rand.New(source),
+	}
+}
+
+type exponentialBackoff struct {
+	base time.Duration
+	max  time.Duration
+	// rand.Rand is not safe for concurrent use
+	mux sync.Mutex
+	rnd *rand.Rand
+}
+
+// NextDelay returns a jittered delay in [d/2, d] where d is base*2^(attempt-1)
+// capped at max
+func (b *exponentialBackoff) NextDelay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	d := b.base
+	for i := 1; i < attempt; i++ {
+		if d > math.MaxInt64/2 {
+			d = math.MaxInt64
+			break
+		}
+		d *= 2
+	}
+	if b.max > 0 && d > b.max {
+		d = b.max
+	}
+	half := d / 2
+	if half <= 0 {
+		return d
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return half + time.Duration(b.rnd.Int63n(int64(d-half)+1))
+}
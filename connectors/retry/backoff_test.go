@@ -0,0 +1,81 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package retry
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConstantBackoff(t *testing.T) {
+	b := NewConstantBackoff(5 * time.Millisecond)
+	for attempt := 1; attempt <= 5; attempt++ {
+		assert.Equal(t, 5*time.Millisecond, b.NextDelay(attempt))
+	}
+}
+
+func TestLinearBackoff(t *testing.T) {
+	b := NewLinearBackoff(10*time.Millisecond, 5*time.Millisecond, 25*time.Millisecond)
+	expected := []time.Duration{
+		10 * time.Millisecond,
+		15 * time.Millisecond,
+		20 * time.Millisecond,
+		25 * time.Millisecond,
+		25 * time.Millisecond,
+	}
+	for i, d := range expected {
+		assert.Equal(t, d, b.NextDelay(i+1), "attempt %d", i+1)
+	}
+
+	// no upper bound
+	b = NewLinearBackoff(time.Millisecond, time.Millisecond, 0)
+	assert.Equal(t, 100*time.Millisecond, b.NextDelay(100))
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	b := NewExponentialBackoff(10*time.Millisecond, 100*time.Millisecond, rand.NewSource(1))
+	ceilings := []time.Duration{
+		10 * time.Millisecond,
+		20 * time.Millisecond,
+		40 * time.Millisecond,
+		80 * time.Millisecond,
+		100 * time.Millisecond,
+		100 * time.Millisecond,
+	}
+	for i, ceiling := range ceilings {
+		d := b.NextDelay(i + 1)
+		assert.True(t, d >= ceiling/2 && d <= ceiling, "attempt %d: %v not in [%v, %v]", i+1, d, ceiling/2, ceiling)
+	}
+
+	// the same seed gives the same sequence
+	b1 := NewExponentialBackoff(10*time.Millisecond, time.Second, rand.NewSource(42))
+	b2 := NewExponentialBackoff(10*time.Millisecond, time.Second, rand.NewSource(42))
+	for attempt := 1; attempt <= 10; attempt++ {
+		assert.Equal(t, b1.NextDelay(attempt), b2.NextDelay(attempt))
+	}
+
+	// huge attempts do not overflow
+	b = NewExponentialBackoff(time.Second, 0, rand.NewSource(1))
+	assert.True(t, b.NextDelay(1000) > 0)
+}
@@ -0,0 +1,234 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package retry
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors"
+	"github.com/uber-go/dosa/connectors/base"
+)
+
+const (
+	defaultMaxAttempts = 3
+	defaultBaseDelay   = 10 * time.Millisecond
+	defaultMaxDelay    = time.Second
+)
+
+// Option configures optional behavior of a retry Connector
+type Option func(*Connector)
+
+// WithBackoff sets the strategy used to pick the delay between attempts.
+// The default is an exponential backoff with jitter starting at 10ms and
+// capped at one second.
+func WithBackoff(b Backoff) Option {
+	return func(c *Connector) {
+		c.backoff = b
+	}
+}
+
+// WithMaxAttempts sets the total number of attempts made for an operation,
+// including the first one. Values lower than 1 are ignored.
+func WithMaxAttempts(n int) Option {
+	return func(c *Connector) {
+		if n > 0 {
+			c.maxAttempts = n
+		}
+	}
+}
+
+// WithRetryable sets the predicate deciding whether an error is worth
+// another attempt. By default every error is retried except not found,
+// already exists and context errors.
+func WithRetryable(f func(error) bool) Option {
+	return func(c *Connector) {
+		c.retryable = f
+	}
+}
+
+// Connector retries failed operations against the next connector.
+//
+// Only operations that are safe to repeat are retried: reads, scans, upserts,
+// removes and the read-only schema and scope checks CheckSchema,
+// CheckSchemaStatus and ScopeExists. CreateIfNotExists and the calls that
+// change schemas or scopes, such as UpsertSchema and CreateScope, are passed
+// through untouched, since repeating them after an ambiguous failure can
+// change their outcome.
+type Connector struct {
+	base.Connector
+	backoff     Backoff
+	maxAttempts int
+	retryable   func(error) bool
+}
+
+// NewConnector creates a retry connector wrapping next
+func NewConnector(next dosa.Connector, opts ...Option) *Connector {
+	c := &Connector{
+		Connector:   base.Connector{Next: next},
+		backoff:     NewExponentialBackoff(defaultBaseDelay, defaultMaxDelay, nil),
+		maxAttempts: defaultMaxAttempts,
+		retryable:   isRetryable,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Middleware returns a connectors.Middleware that wraps a connector with
+// NewConnector
+func Middleware(opts ...Option) connectors.Middleware {
+	return func(next dosa.Connector) dosa.Connector {
+		return NewConnector(next, opts...)
+	}
+}
+
+func isRetryable(err error) bool {
+	if dosa.ErrorIsNotFound(err) || dosa.ErrorIsAlreadyExists(err) {
+		return false
+	}
+	cause := errors.Cause(err)
+	return cause != context.Canceled && cause != context.DeadlineExceeded
+}
+
+// do calls f until it succeeds, returns a non-retryable error or runs out of
+// attempts. The wait between attempts is abandoned as soon as ctx is done, in
+// which case the context error is returned.
+func (c *Connector) do(ctx context.Context, f func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= c.maxAttempts || !c.retryable(err) {
+			return err
+		}
+		timer := time.NewTimer(c.backoff.NextDelay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Read calls Next, retrying on failure
+func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, minimumFields []string) (values map[string]dosa.FieldValue, err error) {
+	err = c.do(ctx, func() error {
+		values, err = c.Connector.Read(ctx, ei, keys, minimumFields)
+		return err
+	})
+	return values, err
+}
+
+// MultiRead calls Next, retrying on failure
+func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, minimumFields []string) (results []*dosa.FieldValuesOrError, err error) {
+	err = c.do(ctx, func() error {
+		results, err = c.Connector.MultiRead(ctx, ei, keys, minimumFields)
+		return err
+	})
+	return results, err
+}
+
+// Upsert calls Next, retrying on failure
+func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	return c.do(ctx, func() error {
+		return c.Connector.Upsert(ctx, ei, values)
+	})
+}
+
+// MultiUpsert calls Next, retrying on failure
+func (c *Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, multiValues []map[string]dosa.FieldValue) (result []error, err error) {
+	err = c.do(ctx, func() error {
+		result, err = c.Connector.MultiUpsert(ctx, ei, multiValues)
+		return err
+	})
+	return result, err
+}
+
+// Remove calls Next, retrying on failure
+func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	return c.do(ctx, func() error {
+		return c.Connector.Remove(ctx, ei, keys)
+	})
+}
+
+// RemoveRange calls Next, retrying on failure
+func (c *Connector) RemoveRange(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition) error {
+	return c.do(ctx, func() error {
+		return c.Connector.RemoveRange(ctx, ei, columnConditions)
+	})
+}
+
+// MultiRemove calls Next, retrying on failure
+func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) (result []error, err error) {
+	err = c.do(ctx, func() error {
+		result, err = c.Connector.MultiRemove(ctx, ei, multiKeys)
+		return err
+	})
+	return result, err
+}
+
+// Range calls Next, retrying on failure
+func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, minimumFields []string, token string, limit int) (rows []map[string]dosa.FieldValue, nextToken string, err error) {
+	err = c.do(ctx, func() error {
+		rows, nextToken, err = c.Connector.Range(ctx, ei, columnConditions, minimumFields, token, limit)
+		return err
+	})
+	return rows, nextToken, err
+}
+
+// Scan calls Next, retrying on failure
+func (c *Connector) Scan(ctx context.Context, ei *dosa.EntityInfo, minimumFields []string, token string, limit int) (rows []map[string]dosa.FieldValue, nextToken string, err error) {
+	err = c.do(ctx, func() error {
+		rows, nextToken, err = c.Connector.Scan(ctx, ei, minimumFields, token, limit)
+		return err
+	})
+	return rows, nextToken, err
+}
+
+// CheckSchema calls Next, retrying on failure
+func (c *Connector) CheckSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (version int32, err error) {
+	err = c.do(ctx, func() error {
+		version, err = c.Connector.CheckSchema(ctx, scope, namePrefix, ed)
+		return err
+	})
+	return version, err
+}
+
+// CheckSchemaStatus calls Next, retrying on failure
+func (c *Connector) CheckSchemaStatus(ctx context.Context, scope, namePrefix string, version int32) (status *dosa.SchemaStatus, err error) {
+	err = c.do(ctx, func() error {
+		status, err = c.Connector.CheckSchemaStatus(ctx, scope, namePrefix, version)
+		return err
+	})
+	return status, err
+}
+
+// ScopeExists calls Next, retrying on failure
+func (c *Connector) ScopeExists(ctx context.Context, scope string) (exists bool, err error) {
+	err = c.do(ctx, func() error {
+		exists, err = c.Connector.ScopeExists(ctx, scope)
+		return err
+	})
+	return exists, err
+}
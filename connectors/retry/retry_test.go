@@ -0,0 +1,146 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package retry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/mocks"
+)
+
+var (
+	testEi = &dosa.EntityInfo{
+		Ref: &dosa.SchemaRef{Scope: "testing", NamePrefix: "example", EntityName: "e"},
+		Def: &dosa.EntityDefinition{Name: "e"},
+	}
+	testKeys = map[string]dosa.FieldValue{"id": int64(1)}
+	noDelay  = WithBackoff(NewConstantBackoff(0))
+)
+
+func TestRetrySucceedsAfterFailures(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+
+	row := map[string]dosa.FieldValue{"id": int64(1), "name": "foo"}
+	gomock.InOrder(
+		mockConn.EXPECT().Read(context.TODO(), testEi, testKeys, dosa.All()).Return(nil, assert.AnError).Times(2),
+		mockConn.EXPECT().Read(context.TODO(), testEi, testKeys, dosa.All()).Return(row, nil),
+	)
+
+	c := NewConnector(mockConn, noDelay, WithMaxAttempts(3))
+	values, err := c.Read(context.TODO(), testEi, testKeys, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, row, values)
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().Upsert(context.TODO(), testEi, testKeys).Return(assert.AnError).Times(2)
+
+	c := NewConnector(mockConn, noDelay, WithMaxAttempts(2))
+	assert.Equal(t, assert.AnError, c.Upsert(context.TODO(), testEi, testKeys))
+}
+
+func TestRetryNotRetryable(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().Read(context.TODO(), testEi, testKeys, dosa.All()).Return(nil, &dosa.ErrNotFound{}).Times(1)
+	mockConn.EXPECT().Range(context.TODO(), testEi, nil, dosa.All(), "", 10).Return(nil, "", assert.AnError).Times(1)
+
+	c := NewConnector(mockConn, noDelay)
+	_, err := c.Read(context.TODO(), testEi, testKeys, dosa.All())
+	assert.True(t, dosa.ErrorIsNotFound(err))
+
+	c = NewConnector(mockConn, noDelay, WithRetryable(func(error) bool { return false }))
+	_, _, err = c.Range(context.TODO(), testEi, nil, dosa.All(), "", 10)
+	assert.Equal(t, assert.AnError, err)
+}
+
+func TestRetryCreateIfNotExistsIsNotRetried(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().CreateIfNotExists(context.TODO(), testEi, testKeys).Return(assert.AnError).Times(1)
+
+	c := NewConnector(mockConn, noDelay)
+	assert.Equal(t, assert.AnError, c.CreateIfNotExists(context.TODO(), testEi, testKeys))
+}
+
+func TestRetrySchemaAndScopeCalls(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	c := NewConnector(mockConn, noDelay, WithMaxAttempts(2))
+
+	// the read-only checks are retried
+	gomock.InOrder(
+		mockConn.EXPECT().ScopeExists(context.TODO(), "testing").Return(false, assert.AnError),
+		mockConn.EXPECT().ScopeExists(context.TODO(), "testing").Return(true, nil),
+	)
+	exists, err := c.ScopeExists(context.TODO(), "testing")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	gomock.InOrder(
+		mockConn.EXPECT().CheckSchema(context.TODO(), "testing", "example", nil).Return(int32(0), assert.AnError),
+		mockConn.EXPECT().CheckSchema(context.TODO(), "testing", "example", nil).Return(int32(3), nil),
+	)
+	version, err := c.CheckSchema(context.TODO(), "testing", "example", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), version)
+	mockConn.EXPECT().CheckSchemaStatus(context.TODO(), "testing", "example", int32(3)).Return(nil, assert.AnError).Times(2)
+	_, err = c.CheckSchemaStatus(context.TODO(), "testing", "example", 3)
+	assert.Equal(t, assert.AnError, err)
+
+	// the calls that change them are not
+	mockConn.EXPECT().CreateScope(context.TODO(), "testing").Return(assert.AnError).Times(1)
+	assert.Equal(t, assert.AnError, c.CreateScope(context.TODO(), "testing"))
+	mockConn.EXPECT().UpsertSchema(context.TODO(), "testing", "example", nil).Return(nil, assert.AnError).Times(1)
+	_, err = c.UpsertSchema(context.TODO(), "testing", "example", nil)
+	assert.Equal(t, assert.AnError, err)
+}
+
+func TestRetryCancelledDuringBackoff(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	mockConn.EXPECT().Scan(ctx, testEi, dosa.All(), "", 10).Do(
+		func(context.Context, *dosa.EntityInfo, []string, string, int) {
+			// cancel while the connector is about to wait for a minute
+			cancel()
+		}).Return(nil, "", assert.AnError).Times(1)
+
+	c := NewConnector(mockConn, WithBackoff(NewConstantBackoff(time.Minute)), WithMaxAttempts(5))
+	start := time.Now()
+	_, _, err := c.Scan(ctx, testEi, dosa.All(), "", 10)
+	assert.Equal(t, context.Canceled, err)
+	assert.True(t, time.Since(start) < time.Minute)
+}
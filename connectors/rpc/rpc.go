@@ -0,0 +1,227 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package rpc adapts an origin that is only reachable over RPC, such as a
+// gRPC or REST service, to the dosa.Connector interface. Each supported
+// operation is sent to a configurable procedure as a request struct encoded
+// with a pluggable Codec, and the response is decoded the same way. The
+// package knows nothing about a particular service or transport: callers
+// provide a Transport that performs the actual call.
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/base"
+)
+
+// Transport sends an encoded request to procedure and returns the encoded
+// response
+type Transport interface {
+	Call(ctx context.Context, procedure string, request []byte) ([]byte, error)
+}
+
+// Codec encodes requests and decodes responses. It is used for the request
+// and response structs of this package.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONCodec encodes with encoding/json. Decoded numbers are float64, so
+// services with typed columns usually want a codec of their own.
+type JSONCodec struct{}
+
+// Marshal encodes v as JSON
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal decodes JSON data into v
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// Procedures names the procedure each operation is sent to. Operations with
+// an empty name fail with dosa.ErrUnsupported.
+type Procedures struct {
+	Read   string
+	Range  string
+	Upsert string
+	Remove string
+}
+
+// ReadRequest is sent for Read
+type ReadRequest struct {
+	Scope  string
+	Entity string
+	Keys   map[string]dosa.FieldValue
+	Fields []string
+}
+
+// ReadResponse is expected back from Read
+type ReadResponse struct {
+	Values map[string]dosa.FieldValue
+}
+
+// RangeRequest is sent for Range
+type RangeRequest struct {
+	Scope      string
+	Entity     string
+	Conditions map[string][]*dosa.Condition
+	Fields     []string
+	Token      string
+	Limit      int
+}
+
+// RangeResponse is expected back from Range
+type RangeResponse struct {
+	Rows  []map[string]dosa.FieldValue
+	Token string
+}
+
+// UpsertRequest is sent for Upsert
+type UpsertRequest struct {
+	Scope  string
+	Entity string
+	Values map[string]dosa.FieldValue
+}
+
+// RemoveRequest is sent for Remove
+type RemoveRequest struct {
+	Scope  string
+	Entity string
+	Keys   map[string]dosa.FieldValue
+}
+
+// Option configures optional behavior of the connector
+type Option func(*Connector)
+
+// WithNotFound sets how transport errors meaning that a row does not exist
+// are recognized. Those errors are returned from Read and Remove as
+// dosa.ErrNotFound, which is what clients and other connectors check for.
+func WithNotFound(isNotFound func(error) bool) Option {
+	return func(c *Connector) {
+		c.isNotFound = isNotFound
+	}
+}
+
+// Connector maps Read, Range, Upsert and Remove onto RPC calls. Operations
+// that are not mapped fall through to the embedded base connector, which has
+// no next connector and fails them.
+type Connector struct {
+	base.Connector
+	transport  Transport
+	codec      Codec
+	procedures Procedures
+	isNotFound func(error) bool
+}
+
+// NewConnector returns a connector sending requests through transport. A nil
+// codec encodes with JSONCodec.
+func NewConnector(transport Transport, codec Codec, procedures Procedures, opts ...Option) *Connector {
+	if codec == nil {
+		codec = JSONCodec{}
+	}
+	c := &Connector{
+		transport:  transport,
+		codec:      codec,
+		procedures: procedures,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Read sends a ReadRequest to the Read procedure
+func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, fieldsToRead []string) (map[string]dosa.FieldValue, error) {
+	var response ReadResponse
+	err := c.call(ctx, "Read", c.procedures.Read, &ReadRequest{
+		Scope:  ei.Ref.Scope,
+		Entity: ei.Def.Name,
+		Keys:   keys,
+		Fields: fieldsToRead,
+	}, &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Values, nil
+}
+
+// Range sends a RangeRequest to the Range procedure
+func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	var response RangeResponse
+	err := c.call(ctx, "Range", c.procedures.Range, &RangeRequest{
+		Scope:      ei.Ref.Scope,
+		Entity:     ei.Def.Name,
+		Conditions: columnConditions,
+		Fields:     fieldsToRead,
+		Token:      token,
+		Limit:      limit,
+	}, &response)
+	if err != nil {
+		return nil, "", err
+	}
+	return response.Rows, response.Token, nil
+}
+
+// Upsert sends an UpsertRequest to the Upsert procedure
+func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	return c.call(ctx, "Upsert", c.procedures.Upsert, &UpsertRequest{
+		Scope:  ei.Ref.Scope,
+		Entity: ei.Def.Name,
+		Values: values,
+	}, nil)
+}
+
+// Remove sends a RemoveRequest to the Remove procedure
+func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	return c.call(ctx, "Remove", c.procedures.Remove, &RemoveRequest{
+		Scope:  ei.Ref.Scope,
+		Entity: ei.Def.Name,
+		Keys:   keys,
+	}, nil)
+}
+
+// call encodes request, sends it to procedure and decodes the response into
+// response unless it is nil
+func (c *Connector) call(ctx context.Context, op, procedure string, request, response interface{}) error {
+	if procedure == "" {
+		return &dosa.ErrUnsupported{Op: op}
+	}
+	data, err := c.codec.Marshal(request)
+	if err != nil {
+		return err
+	}
+	data, err = c.transport.Call(ctx, procedure, data)
+	if err != nil {
+		if c.isNotFound != nil && c.isNotFound(err) {
+			return &dosa.ErrNotFound{}
+		}
+		return err
+	}
+	if response == nil {
+		return nil
+	}
+	return c.codec.Unmarshal(data, response)
+}
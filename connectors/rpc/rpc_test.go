@@ -0,0 +1,133 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+)
+
+var testEi = &dosa.EntityInfo{
+	Ref: &dosa.SchemaRef{Scope: "scope1", NamePrefix: "namePrefix", EntityName: "eName"},
+	Def: &dosa.EntityDefinition{
+		Name:    "t1",
+		Key:     &dosa.PrimaryKey{PartitionKeys: []string{"p1"}, ClusteringKeys: []*dosa.ClusteringKey{{Name: "c1"}}},
+		Columns: []*dosa.ColumnDefinition{{Name: "p1", Type: dosa.String}, {Name: "c1", Type: dosa.Int64}, {Name: "v1", Type: dosa.String}},
+	},
+}
+
+var testProcedures = Procedures{Read: "svc/read", Range: "svc/range", Upsert: "svc/upsert"}
+
+// fakeTransport records the calls made and answers them with a canned
+// response or error
+type fakeTransport struct {
+	procedures []string
+	requests   [][]byte
+	response   []byte
+	err        error
+}
+
+func (f *fakeTransport) Call(ctx context.Context, procedure string, request []byte) ([]byte, error) {
+	f.procedures = append(f.procedures, procedure)
+	f.requests = append(f.requests, request)
+	return f.response, f.err
+}
+
+func TestRead(t *testing.T) {
+	transport := &fakeTransport{response: []byte(`{"Values":{"p1":"a","v1":"value"}}`)}
+	c := NewConnector(transport, nil, testProcedures)
+
+	values, err := c.Read(context.TODO(), testEi, map[string]dosa.FieldValue{"p1": "a", "c1": int64(1)}, []string{"v1"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]dosa.FieldValue{"p1": "a", "v1": "value"}, values)
+
+	assert.Equal(t, []string{"svc/read"}, transport.procedures)
+	var request ReadRequest
+	assert.NoError(t, JSONCodec{}.Unmarshal(transport.requests[0], &request))
+	assert.Equal(t, "scope1", request.Scope)
+	assert.Equal(t, "t1", request.Entity)
+	assert.Equal(t, []string{"v1"}, request.Fields)
+	assert.Equal(t, "a", request.Keys["p1"])
+	assert.EqualValues(t, 1, request.Keys["c1"])
+}
+
+func TestReadNotFound(t *testing.T) {
+	missing := errors.New("no such row")
+	transport := &fakeTransport{err: missing}
+	c := NewConnector(transport, nil, testProcedures, WithNotFound(func(err error) bool {
+		return err == missing
+	}))
+	_, err := c.Read(context.TODO(), testEi, map[string]dosa.FieldValue{"p1": "a"}, nil)
+	assert.True(t, dosa.ErrorIsNotFound(err))
+
+	// other transport errors are returned as they are
+	transport.err = assert.AnError
+	_, err = c.Read(context.TODO(), testEi, map[string]dosa.FieldValue{"p1": "a"}, nil)
+	assert.Equal(t, assert.AnError, err)
+}
+
+func TestRange(t *testing.T) {
+	transport := &fakeTransport{response: []byte(`{"Rows":[{"p1":"a","v1":"x"},{"p1":"a","v1":"y"}],"Token":"next"}`)}
+	c := NewConnector(transport, nil, testProcedures)
+
+	conditions := map[string][]*dosa.Condition{
+		"p1": {{Op: dosa.Eq, Value: "a"}},
+		"c1": {{Op: dosa.GtOrEq, Value: int64(5)}},
+	}
+	rows, token, err := c.Range(context.TODO(), testEi, conditions, []string{"p1", "v1"}, "token", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "next", token)
+	assert.Equal(t, []map[string]dosa.FieldValue{{"p1": "a", "v1": "x"}, {"p1": "a", "v1": "y"}}, rows)
+
+	assert.Equal(t, []string{"svc/range"}, transport.procedures)
+	var request RangeRequest
+	assert.NoError(t, JSONCodec{}.Unmarshal(transport.requests[0], &request))
+	assert.Equal(t, "scope1", request.Scope)
+	assert.Equal(t, "t1", request.Entity)
+	assert.Equal(t, []string{"p1", "v1"}, request.Fields)
+	assert.Equal(t, "token", request.Token)
+	assert.Equal(t, 10, request.Limit)
+	assert.Len(t, request.Conditions, 2)
+	assert.Equal(t, dosa.Eq, request.Conditions["p1"][0].Op)
+	assert.Equal(t, "a", request.Conditions["p1"][0].Value)
+	assert.Equal(t, dosa.GtOrEq, request.Conditions["c1"][0].Op)
+	assert.EqualValues(t, 5, request.Conditions["c1"][0].Value)
+}
+
+func TestWritesAndUnmapped(t *testing.T) {
+	transport := &fakeTransport{}
+	c := NewConnector(transport, nil, testProcedures)
+
+	assert.NoError(t, c.Upsert(context.TODO(), testEi, map[string]dosa.FieldValue{"p1": "a", "v1": "x"}))
+	assert.Equal(t, []string{"svc/upsert"}, transport.procedures)
+	var request UpsertRequest
+	assert.NoError(t, JSONCodec{}.Unmarshal(transport.requests[0], &request))
+	assert.Equal(t, map[string]dosa.FieldValue{"p1": "a", "v1": "x"}, request.Values)
+
+	// no Remove procedure is configured
+	err := c.Remove(context.TODO(), testEi, map[string]dosa.FieldValue{"p1": "a"})
+	assert.True(t, dosa.ErrorIsUnsupported(err))
+	assert.Len(t, transport.procedures, 1)
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package schemawatch provides a connector that remembers the entity
+// definitions passed through it, for debugging schema drift between what
+// callers register and what reaches the store.
+package schemawatch
+
+import (
+	"context"
+	"sync"
+
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors"
+	"github.com/uber-go/dosa/connectors/base"
+)
+
+// Connector records the most recent entity definition seen for each entity
+// name and passes every operation to the next connector unchanged
+type Connector struct {
+	base.Connector
+	mu      sync.Mutex
+	schemas map[string]*dosa.EntityDefinition
+}
+
+// NewConnector returns a connector that records the definitions flowing
+// to next
+func NewConnector(next dosa.Connector) *Connector {
+	return &Connector{
+		Connector: base.Connector{Next: next},
+		schemas:   map[string]*dosa.EntityDefinition{},
+	}
+}
+
+// Middleware returns a connectors.Middleware that wraps a connector with
+// NewConnector
+func Middleware() connectors.Middleware {
+	return func(next dosa.Connector) dosa.Connector {
+		return NewConnector(next)
+	}
+}
+
+// LastSchema returns the definition most recently passed for the named
+// entity, either with an operation on its rows or to CheckSchema or
+// UpsertSchema. The definition is the caller's and must not be modified.
+func (c *Connector) LastSchema(name string) (*dosa.EntityDefinition, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ed, ok := c.schemas[name]
+	return ed, ok
+}
+
+func (c *Connector) record(ei *dosa.EntityInfo) {
+	if ei == nil || ei.Def == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.schemas[ei.Def.Name] = ei.Def
+}
+
+func (c *Connector) recordDefinitions(eds []*dosa.EntityDefinition) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, ed := range eds {
+		if ed != nil {
+			c.schemas[ed.Name] = ed
+		}
+	}
+}
+
+// CreateIfNotExists records the entity definition and calls Next
+func (c *Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	c.record(ei)
+	return c.Connector.CreateIfNotExists(ctx, ei, values)
+}
+
+// Read records the entity definition and calls Next
+func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, minimumFields []string) (map[string]dosa.FieldValue, error) {
+	c.record(ei)
+	return c.Connector.Read(ctx, ei, keys, minimumFields)
+}
+
+// MultiRead records the entity definition and calls Next
+func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, minimumFields []string) ([]*dosa.FieldValuesOrError, error) {
+	c.record(ei)
+	return c.Connector.MultiRead(ctx, ei, keys, minimumFields)
+}
+
+// Upsert records the entity definition and calls Next
+func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	c.record(ei)
+	return c.Connector.Upsert(ctx, ei, values)
+}
+
+// MultiUpsert records the entity definition and calls Next
+func (c *Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, multiValues []map[string]dosa.FieldValue) ([]error, error) {
+	c.record(ei)
+	return c.Connector.MultiUpsert(ctx, ei, multiValues)
+}
+
+// Remove records the entity definition and calls Next
+func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	c.record(ei)
+	return c.Connector.Remove(ctx, ei, keys)
+}
+
+// RemoveRange records the entity definition and calls Next
+func (c *Connector) RemoveRange(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition) error {
+	c.record(ei)
+	return c.Connector.RemoveRange(ctx, ei, columnConditions)
+}
+
+// MultiRemove records the entity definition and calls Next
+func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) ([]error, error) {
+	c.record(ei)
+	return c.Connector.MultiRemove(ctx, ei, multiKeys)
+}
+
+// Range records the entity definition and calls Next
+func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, minimumFields []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	c.record(ei)
+	return c.Connector.Range(ctx, ei, columnConditions, minimumFields, token, limit)
+}
+
+// Scan records the entity definition and calls Next
+func (c *Connector) Scan(ctx context.Context, ei *dosa.EntityInfo, minimumFields []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	c.record(ei)
+	return c.Connector.Scan(ctx, ei, minimumFields, token, limit)
+}
+
+// CheckSchema records the entity definitions and calls Next
+func (c *Connector) CheckSchema(ctx context.Context, scope, namePrefix string, eds []*dosa.EntityDefinition) (int32, error) {
+	c.recordDefinitions(eds)
+	return c.Connector.CheckSchema(ctx, scope, namePrefix, eds)
+}
+
+// UpsertSchema records the entity definitions and calls Next
+func (c *Connector) UpsertSchema(ctx context.Context, scope, namePrefix string, eds []*dosa.EntityDefinition) (*dosa.SchemaStatus, error) {
+	c.recordDefinitions(eds)
+	return c.Connector.UpsertSchema(ctx, scope, namePrefix, eds)
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package schemawatch
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/mocks"
+)
+
+var (
+	ctx = context.TODO()
+	ei  = &dosa.EntityInfo{
+		Ref: &dosa.SchemaRef{Scope: "scope1", NamePrefix: "namePrefix", EntityName: "t1"},
+		Def: &dosa.EntityDefinition{
+			Name:    "t1",
+			Key:     &dosa.PrimaryKey{PartitionKeys: []string{"p1"}},
+			Columns: []*dosa.ColumnDefinition{{Name: "p1", Type: dosa.String}},
+		},
+	}
+	keys = map[string]dosa.FieldValue{"p1": "a"}
+)
+
+func TestLastSchema(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	sut := NewConnector(mockConn)
+
+	_, ok := sut.LastSchema("t1")
+	assert.False(t, ok)
+
+	mockConn.EXPECT().Read(ctx, ei, keys, dosa.All()).Return(keys, nil)
+	_, err := sut.Read(ctx, ei, keys, dosa.All())
+	assert.NoError(t, err)
+	ed, ok := sut.LastSchema("t1")
+	assert.True(t, ok)
+	assert.Equal(t, ei.Def, ed)
+
+	// a later definition replaces it, whichever call carries it
+	changed := ei.Def.Clone()
+	changed.Columns = append(changed.Columns, &dosa.ColumnDefinition{Name: "c1", Type: dosa.Int64})
+	mockConn.EXPECT().CheckSchema(ctx, "scope1", "namePrefix", []*dosa.EntityDefinition{changed}).Return(int32(2), nil)
+	_, err = sut.CheckSchema(ctx, "scope1", "namePrefix", []*dosa.EntityDefinition{changed})
+	assert.NoError(t, err)
+	ed, _ = sut.LastSchema("t1")
+	assert.Equal(t, changed, ed)
+
+	mockConn.EXPECT().Upsert(ctx, ei, keys).Return(nil)
+	assert.NoError(t, sut.Upsert(ctx, ei, keys))
+	ed, _ = sut.LastSchema("t1")
+	assert.Equal(t, ei.Def, ed)
+
+	// other entities are kept apart
+	_, ok = sut.LastSchema("t2")
+	assert.False(t, ok)
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sharded
+
+import "hash/fnv"
+
+// Hasher maps keys to positions on the hash ring. Placement depends entirely
+// on the hasher, so switching to a different one moves most keys to another
+// shard and invalidates everything already stored.
+type Hasher interface {
+	Hash(key []byte) uint32
+}
+
+// HasherFunc adapts a function to the Hasher interface
+type HasherFunc func(key []byte) uint32
+
+// Hash calls f(key)
+func (f HasherFunc) Hash(key []byte) uint32 {
+	return f(key)
+}
+
+// NewFNVHasher returns the default hasher: 32-bit FNV-1a followed by the
+// murmur3 finalizer, so that keys differing only in their last bytes still
+// land far apart on the ring. Its output is fixed and will not change
+// between versions.
+func NewFNVHasher() Hasher {
+	return HasherFunc(fnvHash)
+}
+
+func fnvHash(key []byte) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write(key)
+	x := h.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sharded
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+)
+
+// The placement of stored keys depends on these values; if this test fails,
+// the default hasher has changed and existing deployments would reshuffle.
+func TestFNVHasherIsStable(t *testing.T) {
+	h := NewFNVHasher()
+	assert.Equal(t, uint32(0xab3e7c0b), h.Hash(nil))
+	assert.Equal(t, uint32(0x63e4d497), h.Hash([]byte("key-1")))
+	assert.Equal(t, uint32(0x8d9085e4), h.Hash([]byte(`[{"an_uuid_key":"d1449c93-25b8-4032-920b-60471d91acc9"}]`)))
+}
+
+func TestWithHasher(t *testing.T) {
+	ctx := context.TODO()
+	shards := newShards(3)
+	// a hasher that puts everything at the same point sends every key to the
+	// same shard
+	constant := HasherFunc(func(key []byte) uint32 { return 42 })
+	sut := NewConnector(shards...).WithOptions(WithHasher(constant))
+	for i := 0; i < 10; i++ {
+		values := keyValues(i)
+		values["value"] = []byte{byte(i)}
+		assert.NoError(t, sut.Upsert(ctx, testEi, values))
+	}
+	owner := sut.ring.shard(nil)
+	for i, shard := range shards {
+		_, err := shard.Read(ctx, testEi, keyValues(0), dosa.All())
+		assert.Equal(t, i == owner, err == nil)
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sharded
+
+import (
+	"sort"
+	"strconv"
+)
+
+// replicas is the number of points each shard owns on the ring. More points
+// spread keys more evenly at the cost of a larger ring.
+const replicas = 64
+
+// ring is a consistent hash ring; adding a shard only moves the keys that
+// land on the new shard's points
+type ring struct {
+	hasher Hasher
+	points []uint32
+	owners map[uint32]int
+}
+
+func newRing(shards int, hasher Hasher) *ring {
+	r := &ring{hasher: hasher, owners: make(map[uint32]int, shards*replicas)}
+	for s := 0; s < shards; s++ {
+		for i := 0; i < replicas; i++ {
+			p := hasher.Hash([]byte(strconv.Itoa(s) + "-" + strconv.Itoa(i)))
+			if _, ok := r.owners[p]; ok {
+				continue
+			}
+			r.owners[p] = s
+			r.points = append(r.points, p)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// shard returns the index of the shard owning key
+func (r *ring) shard(key []byte) int {
+	h := r.hasher.Hash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
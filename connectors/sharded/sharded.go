@@ -0,0 +1,358 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sharded
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+)
+
+// Connector spreads entities across several connectors. Every operation on
+// a single entity is sent to the shard chosen by consistent hashing the
+// entity's partition key values, so a given key always maps to the same
+// shard. For the key-value entities written by the cache connector that is
+// the "key" column, which carries both point and range cache keys.
+type Connector struct {
+	shards []dosa.Connector
+	ring   *ring
+}
+
+// NewConnector returns a Connector that shards across the given connectors.
+// The order of the shards determines the placement of each key, so it must
+// be the same every time the connector is created.
+func NewConnector(shards ...dosa.Connector) *Connector {
+	return &Connector{
+		shards: shards,
+		ring:   newRing(len(shards), NewFNVHasher()),
+	}
+}
+
+// Option configures optional behavior of the sharded connector
+type Option func(*Connector)
+
+// WithHasher replaces the default FNV hasher used to place keys. Changing
+// the hasher of an existing deployment moves keys to different shards, so
+// previously stored entries will no longer be found.
+func WithHasher(h Hasher) Option {
+	return func(c *Connector) {
+		c.ring = newRing(len(c.shards), h)
+	}
+}
+
+// WithOptions applies the given options to the connector and returns it. It
+// should be called before the connector is used.
+func (c *Connector) WithOptions(opts ...Option) *Connector {
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// shardFor returns the connector that owns the given partition key values
+func (c *Connector) shardFor(ei *dosa.EntityInfo, values map[string]dosa.FieldValue) (dosa.Connector, error) {
+	if len(c.shards) == 0 {
+		return nil, errors.New("sharded connector has no shards")
+	}
+	shardKey, err := partitionKey(ei, values)
+	if err != nil {
+		return nil, err
+	}
+	return c.shards[c.ring.shard(shardKey)], nil
+}
+
+// shardForConditions returns the connector that owns the partition named by
+// the equality conditions on the partition key columns
+func (c *Connector) shardForConditions(ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition) (dosa.Connector, error) {
+	values := map[string]dosa.FieldValue{}
+	for _, pk := range ei.Def.Key.PartitionKeys {
+		for _, cond := range columnConditions[pk] {
+			if cond.Op == dosa.Eq {
+				values[pk] = cond.Value
+			}
+		}
+	}
+	return c.shardFor(ei, values)
+}
+
+// partitionKey serializes the partition key values in key order, each
+// prefixed with its length
+func partitionKey(ei *dosa.EntityInfo, values map[string]dosa.FieldValue) ([]byte, error) {
+	var shardKey []byte
+	var length [binary.MaxVarintLen64]byte
+	for _, pk := range ei.Def.Key.PartitionKeys {
+		v, ok := values[pk]
+		if !ok {
+			return nil, errors.Errorf("missing partition key %q for entity %q", pk, ei.Def.Name)
+		}
+		encoded, err := encodeKeyValue(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "partition key %q for entity %q", pk, ei.Def.Name)
+		}
+		shardKey = append(shardKey, length[:binary.PutUvarint(length[:], uint64(len(encoded)))]...)
+		shardKey = append(shardKey, encoded...)
+	}
+	return shardKey, nil
+}
+
+// encodeKeyValue returns a stable encoding of a key value. Pointers are
+// dereferenced and times are encoded as their UTC UnixNano, so that equal
+// values always land on the same shard.
+func encodeKeyValue(v dosa.FieldValue) ([]byte, error) {
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, errors.New("value is nil")
+		}
+		v = rv.Elem().Interface()
+	}
+	switch t := v.(type) {
+	case []byte:
+		return t, nil
+	case string:
+		return []byte(t), nil
+	case dosa.UUID:
+		return []byte(t), nil
+	case int32:
+		return strconv.AppendInt(nil, int64(t), 10), nil
+	case int64:
+		return strconv.AppendInt(nil, t, 10), nil
+	case float64:
+		return strconv.AppendFloat(nil, t, 'g', -1, 64), nil
+	case bool:
+		return strconv.AppendBool(nil, t), nil
+	case time.Time:
+		return strconv.AppendInt(nil, t.UTC().UnixNano(), 10), nil
+	}
+	return nil, errors.Errorf("unsupported type %T", v)
+}
+
+// CreateIfNotExists creates the entity on the shard that owns its key
+func (c *Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	shard, err := c.shardFor(ei, values)
+	if err != nil {
+		return err
+	}
+	return shard.CreateIfNotExists(ctx, ei, values)
+}
+
+// Read reads the entity from the shard that owns its key
+func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, minimumFields []string) (map[string]dosa.FieldValue, error) {
+	shard, err := c.shardFor(ei, keys)
+	if err != nil {
+		return nil, err
+	}
+	return shard.Read(ctx, ei, keys, minimumFields)
+}
+
+// MultiRead reads each entity from the shard that owns its key
+func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, minimumFields []string) ([]*dosa.FieldValuesOrError, error) {
+	results := make([]*dosa.FieldValuesOrError, len(keys))
+	for i, k := range keys {
+		values, err := c.Read(ctx, ei, k, minimumFields)
+		results[i] = &dosa.FieldValuesOrError{Values: values, Error: err}
+	}
+	return results, nil
+}
+
+// Upsert writes the entity to the shard that owns its key
+func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	shard, err := c.shardFor(ei, values)
+	if err != nil {
+		return err
+	}
+	return shard.Upsert(ctx, ei, values)
+}
+
+// MultiUpsert writes each entity to the shard that owns its key
+func (c *Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, multiValues []map[string]dosa.FieldValue) ([]error, error) {
+	errs := make([]error, len(multiValues))
+	for i, values := range multiValues {
+		errs[i] = c.Upsert(ctx, ei, values)
+	}
+	return errs, nil
+}
+
+// Remove deletes the entity from the shard that owns its key
+func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	shard, err := c.shardFor(ei, keys)
+	if err != nil {
+		return err
+	}
+	return shard.Remove(ctx, ei, keys)
+}
+
+// RemoveRange removes the range from the shard that owns its partition
+func (c *Connector) RemoveRange(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition) error {
+	shard, err := c.shardForConditions(ei, columnConditions)
+	if err != nil {
+		return err
+	}
+	return shard.RemoveRange(ctx, ei, columnConditions)
+}
+
+// MultiRemove deletes each entity from the shard that owns its key
+func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) ([]error, error) {
+	errs := make([]error, len(multiKeys))
+	for i, keys := range multiKeys {
+		errs[i] = c.Remove(ctx, ei, keys)
+	}
+	return errs, nil
+}
+
+// Range queries the shard that owns the partition named by the conditions
+func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, minimumFields []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	shard, err := c.shardForConditions(ei, columnConditions)
+	if err != nil {
+		return nil, "", err
+	}
+	return shard.Range(ctx, ei, columnConditions, minimumFields, token, limit)
+}
+
+// Scan walks the shards one after another. The returned token is prefixed
+// with the index of the shard the scan should continue from.
+func (c *Connector) Scan(ctx context.Context, ei *dosa.EntityInfo, minimumFields []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	index := 0
+	if token != "" {
+		parts := strings.SplitN(token, ":", 2)
+		i, err := strconv.Atoi(parts[0])
+		if len(parts) != 2 || err != nil || i < 0 || i >= len(c.shards) {
+			return nil, "", errors.Errorf("invalid scan token %q", token)
+		}
+		index, token = i, parts[1]
+	}
+	for ; index < len(c.shards); index++ {
+		rows, next, err := c.shards[index].Scan(ctx, ei, minimumFields, token, limit)
+		if err != nil && !dosa.ErrorIsNotFound(err) {
+			return nil, "", err
+		}
+		if next != "" {
+			return rows, fmt.Sprintf("%d:%s", index, next), nil
+		}
+		if len(rows) > 0 {
+			if index+1 < len(c.shards) {
+				return rows, fmt.Sprintf("%d:", index+1), nil
+			}
+			return rows, "", nil
+		}
+		token = ""
+	}
+	return nil, "", &dosa.ErrNotFound{}
+}
+
+// CheckSchema checks the schema on every shard; all shards must agree on
+// the version
+func (c *Connector) CheckSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (int32, error) {
+	version := int32(dosa.InvalidVersion)
+	for i, shard := range c.shards {
+		v, err := shard.CheckSchema(ctx, scope, namePrefix, ed)
+		if err != nil {
+			return dosa.InvalidVersion, errors.Wrapf(err, "shard %d", i)
+		}
+		if i > 0 && v != version {
+			return dosa.InvalidVersion, errors.Errorf("shard %d has schema version %d, expected %d", i, v, version)
+		}
+		version = v
+	}
+	return version, nil
+}
+
+// UpsertSchema upserts the schema on every shard and returns the status
+// reported by the first one
+func (c *Connector) UpsertSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (*dosa.SchemaStatus, error) {
+	var status *dosa.SchemaStatus
+	for i, shard := range c.shards {
+		s, err := shard.UpsertSchema(ctx, scope, namePrefix, ed)
+		if err != nil {
+			return nil, errors.Wrapf(err, "shard %d", i)
+		}
+		if i == 0 {
+			status = s
+		}
+	}
+	return status, nil
+}
+
+// CheckSchemaStatus checks the schema status of the first shard
+func (c *Connector) CheckSchemaStatus(ctx context.Context, scope, namePrefix string, version int32) (*dosa.SchemaStatus, error) {
+	if len(c.shards) == 0 {
+		return nil, errors.New("sharded connector has no shards")
+	}
+	return c.shards[0].CheckSchemaStatus(ctx, scope, namePrefix, version)
+}
+
+// CreateScope creates the scope on every shard
+func (c *Connector) CreateScope(ctx context.Context, scope string) error {
+	return c.eachShard(func(shard dosa.Connector) error {
+		return shard.CreateScope(ctx, scope)
+	})
+}
+
+// TruncateScope truncates the scope on every shard
+func (c *Connector) TruncateScope(ctx context.Context, scope string) error {
+	return c.eachShard(func(shard dosa.Connector) error {
+		return shard.TruncateScope(ctx, scope)
+	})
+}
+
+// DropScope drops the scope on every shard
+func (c *Connector) DropScope(ctx context.Context, scope string) error {
+	return c.eachShard(func(shard dosa.Connector) error {
+		return shard.DropScope(ctx, scope)
+	})
+}
+
+// ScopeExists returns true only if the scope exists on every shard
+func (c *Connector) ScopeExists(ctx context.Context, scope string) (bool, error) {
+	exists := true
+	err := c.eachShard(func(shard dosa.Connector) error {
+		ok, err := shard.ScopeExists(ctx, scope)
+		exists = exists && ok
+		return err
+	})
+	return exists && err == nil, err
+}
+
+// Shutdown shuts down every shard, returning the first error encountered
+func (c *Connector) Shutdown() error {
+	var firstErr error
+	for i, shard := range c.shards {
+		if err := shard.Shutdown(); err != nil && firstErr == nil {
+			firstErr = errors.Wrapf(err, "shard %d", i)
+		}
+	}
+	return firstErr
+}
+
+func (c *Connector) eachShard(f func(dosa.Connector) error) error {
+	for i, shard := range c.shards {
+		if err := f(shard); err != nil {
+			return errors.Wrapf(err, "shard %d", i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sharded
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/mocks"
+)
+
+var testEi = &dosa.EntityInfo{
+	Ref: &dosa.SchemaRef{
+		Scope:      "scope1",
+		NamePrefix: "namePrefix",
+		EntityName: "eName",
+		Version:    12345,
+	},
+	Def: &dosa.EntityDefinition{
+		Name: "t1",
+		Key: &dosa.PrimaryKey{
+			PartitionKeys: []string{"key"},
+		},
+		Columns: []*dosa.ColumnDefinition{
+			{Name: "key", Type: dosa.Blob},
+			{Name: "value", Type: dosa.Blob},
+		},
+	},
+}
+
+func newShards(n int) []dosa.Connector {
+	shards := make([]dosa.Connector, n)
+	for i := range shards {
+		shards[i] = memory.NewConnector()
+	}
+	return shards
+}
+
+func keyValues(i int) map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{"key": []byte(fmt.Sprintf("key-%d", i))}
+}
+
+func TestDistribution(t *testing.T) {
+	shards := newShards(4)
+	sut := NewConnector(shards...)
+	counts := make([]int, len(shards))
+	for i := 0; i < 1000; i++ {
+		counts[sut.ring.shard(keyValues(i)["key"].([]byte))]++
+	}
+	for i, count := range counts {
+		assert.True(t, count > 100, "shard %d only owns %d keys", i, count)
+	}
+}
+
+func TestKeyAlwaysMapsToSameShard(t *testing.T) {
+	ctx := context.TODO()
+	shards := newShards(3)
+	sut := NewConnector(shards...)
+	for i := 0; i < 50; i++ {
+		values := keyValues(i)
+		values["value"] = []byte{byte(i)}
+		assert.NoError(t, sut.Upsert(ctx, testEi, values))
+	}
+
+	// a new connector over the same shards finds every key, and each key
+	// lives on exactly one shard
+	again := NewConnector(shards...)
+	for i := 0; i < 50; i++ {
+		result, err := again.Read(ctx, testEi, keyValues(i), dosa.All())
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{byte(i)}, result["value"])
+
+		found := 0
+		for _, shard := range shards {
+			if _, err := shard.Read(ctx, testEi, keyValues(i), dosa.All()); err == nil {
+				found++
+			}
+		}
+		assert.Equal(t, 1, found)
+	}
+
+	// range queries route by the same key
+	conds := map[string][]*dosa.Condition{
+		"key": {{Op: dosa.Eq, Value: keyValues(7)["key"]}},
+	}
+	rows, _, err := sut.Range(ctx, testEi, conds, dosa.All(), "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 1)
+	assert.Equal(t, []byte{7}, rows[0]["value"])
+
+	assert.NoError(t, sut.Remove(ctx, testEi, keyValues(7)))
+	_, err = sut.Read(ctx, testEi, keyValues(7), dosa.All())
+	assert.True(t, dosa.ErrorIsNotFound(err))
+}
+
+func TestMissingKey(t *testing.T) {
+	ctx := context.TODO()
+	sut := NewConnector(newShards(2)...)
+	err := sut.Upsert(ctx, testEi, map[string]dosa.FieldValue{"value": []byte{1}})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "missing partition key")
+
+	_, _, err = sut.Range(ctx, testEi, map[string][]*dosa.Condition{}, dosa.All(), "", 10)
+	assert.Error(t, err)
+
+	_, err = NewConnector().Read(ctx, testEi, keyValues(1), dosa.All())
+	assert.Error(t, err)
+}
+
+func TestScan(t *testing.T) {
+	ctx := context.TODO()
+	sut := NewConnector(newShards(3)...)
+	for i := 0; i < 20; i++ {
+		values := keyValues(i)
+		values["value"] = []byte{byte(i)}
+		assert.NoError(t, sut.Upsert(ctx, testEi, values))
+	}
+
+	seen := map[string]bool{}
+	token := ""
+	for {
+		rows, next, err := sut.Scan(ctx, testEi, dosa.All(), token, 4)
+		if dosa.ErrorIsNotFound(err) {
+			break
+		}
+		assert.NoError(t, err)
+		for _, row := range rows {
+			seen[string(row["key"].([]byte))] = true
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	assert.Len(t, seen, 20)
+
+	_, _, err := sut.Scan(ctx, testEi, dosa.All(), "bogus", 4)
+	assert.Error(t, err)
+}
+
+func TestSchema(t *testing.T) {
+	ctx := context.TODO()
+	sut := NewConnector(newShards(2)...)
+	_, err := sut.CheckSchema(ctx, "scope", "prefix", []*dosa.EntityDefinition{testEi.Def})
+	assert.NoError(t, err)
+	assert.NoError(t, sut.Shutdown())
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	shard0 := mocks.NewMockConnector(ctrl)
+	shard1 := mocks.NewMockConnector(ctrl)
+	status := &dosa.SchemaStatus{Version: 1}
+	shard0.EXPECT().UpsertSchema(ctx, "scope", "prefix", gomock.Any()).Return(status, nil)
+	shard1.EXPECT().UpsertSchema(ctx, "scope", "prefix", gomock.Any()).Return(&dosa.SchemaStatus{Version: 1}, nil)
+	shard0.EXPECT().CheckSchemaStatus(ctx, "scope", "prefix", int32(1)).Return(status, nil)
+	sut = NewConnector(shard0, shard1)
+	result, err := sut.UpsertSchema(ctx, "scope", "prefix", []*dosa.EntityDefinition{testEi.Def})
+	assert.NoError(t, err)
+	assert.Equal(t, status, result)
+	result, err = sut.CheckSchemaStatus(ctx, "scope", "prefix", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, status, result)
+
+	skewed := mocks.NewMockConnector(ctrl)
+	skewed.EXPECT().CheckSchema(ctx, "scope", "prefix", gomock.Any()).Return(int32(2), nil)
+	sut = NewConnector(memory.NewConnector(), skewed)
+	_, err = sut.CheckSchema(ctx, "scope", "prefix", []*dosa.EntityDefinition{testEi.Def})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "shard 1 has schema version 2")
+}
+
+func TestScopes(t *testing.T) {
+	ctx := context.TODO()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	shard0 := mocks.NewMockConnector(ctrl)
+	shard1 := mocks.NewMockConnector(ctrl)
+	sut := NewConnector(shard0, shard1)
+
+	for _, shard := range []*mocks.MockConnector{shard0, shard1} {
+		shard.EXPECT().CreateScope(ctx, "scope").Return(nil)
+		shard.EXPECT().TruncateScope(ctx, "scope").Return(nil)
+		shard.EXPECT().DropScope(ctx, "scope").Return(nil)
+	}
+	shard0.EXPECT().ScopeExists(ctx, "scope").Return(true, nil)
+	shard1.EXPECT().ScopeExists(ctx, "scope").Return(false, nil)
+
+	assert.NoError(t, sut.CreateScope(ctx, "scope"))
+	assert.NoError(t, sut.TruncateScope(ctx, "scope"))
+	assert.NoError(t, sut.DropScope(ctx, "scope"))
+	exists, err := sut.ScopeExists(ctx, "scope")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestPartitionKeyIsStable(t *testing.T) {
+	ei := &dosa.EntityInfo{Def: &dosa.EntityDefinition{
+		Name: "pointers",
+		Key:  &dosa.PrimaryKey{PartitionKeys: []string{"when", "who"}},
+	}}
+	when := time.Unix(1500000000, 42)
+	who := "someone"
+	key, err := partitionKey(ei, map[string]dosa.FieldValue{"when": &when, "who": &who})
+	assert.NoError(t, err)
+
+	// equal values behind other pointers, in another location and without
+	// a monotonic clock reading give the same key
+	otherWhen := when.In(time.FixedZone("elsewhere", 3600)).Round(0)
+	otherWho := "someone"
+	again, err := partitionKey(ei, map[string]dosa.FieldValue{"when": &otherWhen, "who": &otherWho})
+	assert.NoError(t, err)
+	assert.Equal(t, key, again)
+
+	// components are length prefixed, so they cannot run into each other
+	split := &dosa.EntityInfo{Def: &dosa.EntityDefinition{
+		Name: "split",
+		Key:  &dosa.PrimaryKey{PartitionKeys: []string{"a", "b"}},
+	}}
+	ab, err := partitionKey(split, map[string]dosa.FieldValue{"a": "ab", "b": "c"})
+	assert.NoError(t, err)
+	bc, err := partitionKey(split, map[string]dosa.FieldValue{"a": "a", "b": "bc"})
+	assert.NoError(t, err)
+	assert.NotEqual(t, ab, bc)
+
+	var nilWho *string
+	_, err = partitionKey(ei, map[string]dosa.FieldValue{"when": &when, "who": nilWho})
+	assert.Error(t, err)
+}
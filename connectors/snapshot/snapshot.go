@@ -0,0 +1,221 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package snapshot contains a connector that pins every read to a single
+// snapshot of the datastore, so that a job sees one consistent view of the
+// data no matter how long it runs.
+package snapshot
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors"
+	"github.com/uber-go/dosa/connectors/base"
+)
+
+// Reader is implemented by connectors that can serve reads as of a snapshot.
+// The snapshot token is opaque to this package; its format is defined by the
+// connector that accepts it.
+type Reader interface {
+	ReadAt(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, minimumFields []string, snapshot string) (map[string]dosa.FieldValue, error)
+	RangeAt(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, minimumFields []string, token string, limit int, snapshot string) ([]map[string]dosa.FieldValue, string, error)
+	ScanAt(ctx context.Context, ei *dosa.EntityInfo, minimumFields []string, token string, limit int, snapshot string) ([]map[string]dosa.FieldValue, string, error)
+}
+
+// ErrMutationRejected is returned for mutations when the connector was not
+// configured with AllowMutations
+type ErrMutationRejected struct {
+	Op string
+}
+
+// Error returns a message naming the rejected operation
+func (e *ErrMutationRejected) Error() string {
+	return fmt.Sprintf("%s is not allowed on a snapshot connector", e.Op)
+}
+
+// ErrorIsMutationRejected checks if the error is caused by "ErrMutationRejected"
+func ErrorIsMutationRejected(err error) bool {
+	_, ok := errors.Cause(err).(*ErrMutationRejected)
+	return ok
+}
+
+type contextKey struct{}
+
+// NewContext returns a context that makes the snapshot connector read as of
+// snapshot instead of the token it was constructed with
+func NewContext(ctx context.Context, snapshot string) context.Context {
+	return context.WithValue(ctx, contextKey{}, snapshot)
+}
+
+// FromContext returns the snapshot token stored in ctx by NewContext
+func FromContext(ctx context.Context) (string, bool) {
+	snapshot, ok := ctx.Value(contextKey{}).(string)
+	return snapshot, ok
+}
+
+// Option configures optional behavior of the snapshot connector
+type Option func(*Connector)
+
+// AllowMutations passes mutations through to the next connector unchanged.
+// By default they are rejected with ErrMutationRejected, since a job that
+// writes while reading from a fixed snapshot will not see its own writes.
+func AllowMutations() Option {
+	return func(c *Connector) {
+		c.allowMutations = true
+	}
+}
+
+// Connector forwards Read, Range and Scan to the next connector's Reader
+// methods along with a fixed snapshot token. Reads that cannot be pinned,
+// including all reads when the next connector is not a Reader, fail with
+// dosa.ErrUnsupported rather than silently reading the latest data.
+type Connector struct {
+	base.Connector
+	snapshot       string
+	allowMutations bool
+}
+
+// NewConnector returns a connector that reads from next as of snapshot. A
+// token stored in the request context with NewContext takes precedence.
+func NewConnector(next dosa.Connector, snapshot string, opts ...Option) *Connector {
+	c := &Connector{
+		Connector: base.Connector{Next: next},
+		snapshot:  snapshot,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Middleware returns a connectors.Middleware that wraps a connector with
+// NewConnector
+func Middleware(snapshot string, opts ...Option) connectors.Middleware {
+	return func(next dosa.Connector) dosa.Connector {
+		return NewConnector(next, snapshot, opts...)
+	}
+}
+
+// reader returns the next connector's Reader and the snapshot to read at
+func (c *Connector) reader(ctx context.Context, op string) (Reader, string, error) {
+	r, ok := c.Next.(Reader)
+	if !ok {
+		return nil, "", &dosa.ErrUnsupported{Op: op}
+	}
+	snapshot := c.snapshot
+	if s, ok := FromContext(ctx); ok {
+		snapshot = s
+	}
+	if snapshot == "" {
+		return nil, "", errors.Errorf("%s: no snapshot token", op)
+	}
+	return r, snapshot, nil
+}
+
+// mutation returns an error unless mutations are allowed
+func (c *Connector) mutation(op string) error {
+	if c.allowMutations {
+		return nil
+	}
+	return &ErrMutationRejected{Op: op}
+}
+
+// Read reads the row as of the snapshot
+func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, minimumFields []string) (map[string]dosa.FieldValue, error) {
+	r, snapshot, err := c.reader(ctx, "ReadAt")
+	if err != nil {
+		return nil, err
+	}
+	return r.ReadAt(ctx, ei, keys, minimumFields, snapshot)
+}
+
+// MultiRead is not supported because there is no snapshot variant of it
+func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, minimumFields []string) ([]*dosa.FieldValuesOrError, error) {
+	return nil, &dosa.ErrUnsupported{Op: "MultiRead"}
+}
+
+// Range reads the range as of the snapshot
+func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, minimumFields []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	r, snapshot, err := c.reader(ctx, "RangeAt")
+	if err != nil {
+		return nil, "", err
+	}
+	return r.RangeAt(ctx, ei, columnConditions, minimumFields, token, limit, snapshot)
+}
+
+// Scan scans the entity as of the snapshot
+func (c *Connector) Scan(ctx context.Context, ei *dosa.EntityInfo, minimumFields []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	r, snapshot, err := c.reader(ctx, "ScanAt")
+	if err != nil {
+		return nil, "", err
+	}
+	return r.ScanAt(ctx, ei, minimumFields, token, limit, snapshot)
+}
+
+// CreateIfNotExists is rejected unless mutations are allowed
+func (c *Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	if err := c.mutation("CreateIfNotExists"); err != nil {
+		return err
+	}
+	return c.Connector.CreateIfNotExists(ctx, ei, values)
+}
+
+// Upsert is rejected unless mutations are allowed
+func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	if err := c.mutation("Upsert"); err != nil {
+		return err
+	}
+	return c.Connector.Upsert(ctx, ei, values)
+}
+
+// MultiUpsert is rejected unless mutations are allowed
+func (c *Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, multiValues []map[string]dosa.FieldValue) ([]error, error) {
+	if err := c.mutation("MultiUpsert"); err != nil {
+		return nil, err
+	}
+	return c.Connector.MultiUpsert(ctx, ei, multiValues)
+}
+
+// Remove is rejected unless mutations are allowed
+func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	if err := c.mutation("Remove"); err != nil {
+		return err
+	}
+	return c.Connector.Remove(ctx, ei, keys)
+}
+
+// RemoveRange is rejected unless mutations are allowed
+func (c *Connector) RemoveRange(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition) error {
+	if err := c.mutation("RemoveRange"); err != nil {
+		return err
+	}
+	return c.Connector.RemoveRange(ctx, ei, columnConditions)
+}
+
+// MultiRemove is rejected unless mutations are allowed
+func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) ([]error, error) {
+	if err := c.mutation("MultiRemove"); err != nil {
+		return nil, err
+	}
+	return c.Connector.MultiRemove(ctx, ei, multiKeys)
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package snapshot
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/mocks"
+)
+
+var (
+	ctx  = context.TODO()
+	ei   = &dosa.EntityInfo{Def: &dosa.EntityDefinition{Name: "t1"}}
+	keys = map[string]dosa.FieldValue{"p1": "a"}
+)
+
+// snapshotReader records the snapshot of every read and delegates
+// everything else to the embedded connector
+type snapshotReader struct {
+	dosa.Connector
+	snapshots []string
+}
+
+func (r *snapshotReader) ReadAt(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, minimumFields []string, snapshot string) (map[string]dosa.FieldValue, error) {
+	r.snapshots = append(r.snapshots, snapshot)
+	return keys, nil
+}
+
+func (r *snapshotReader) RangeAt(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, minimumFields []string, token string, limit int, snapshot string) ([]map[string]dosa.FieldValue, string, error) {
+	r.snapshots = append(r.snapshots, snapshot)
+	return []map[string]dosa.FieldValue{keys}, token + "-next", nil
+}
+
+func (r *snapshotReader) ScanAt(ctx context.Context, ei *dosa.EntityInfo, minimumFields []string, token string, limit int, snapshot string) ([]map[string]dosa.FieldValue, string, error) {
+	r.snapshots = append(r.snapshots, snapshot)
+	return []map[string]dosa.FieldValue{keys}, "", nil
+}
+
+func TestSnapshotForwarded(t *testing.T) {
+	reader := &snapshotReader{}
+	sut := NewConnector(reader, "snap-1")
+
+	values, err := sut.Read(ctx, ei, keys, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, keys, values)
+	rows, token, err := sut.Range(ctx, ei, nil, dosa.All(), "t", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]dosa.FieldValue{keys}, rows)
+	assert.Equal(t, "t-next", token)
+	_, _, err = sut.Scan(ctx, ei, dosa.All(), "", 10)
+	assert.NoError(t, err)
+
+	// the context overrides the token given at construction
+	_, err = sut.Read(NewContext(ctx, "snap-2"), ei, keys, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"snap-1", "snap-1", "snap-1", "snap-2"}, reader.snapshots)
+
+	// a snapshot is required
+	_, err = NewConnector(reader, "").Read(ctx, ei, keys, dosa.All())
+	assert.Contains(t, err.Error(), "no snapshot token")
+	_, err = NewConnector(reader, "").Read(NewContext(ctx, "snap-3"), ei, keys, dosa.All())
+	assert.NoError(t, err)
+}
+
+func TestUnsupportedDownstream(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	sut := NewConnector(mocks.NewMockConnector(ctrl), "snap-1")
+
+	_, err := sut.Read(ctx, ei, keys, dosa.All())
+	assert.True(t, dosa.ErrorIsUnsupported(err))
+	_, _, err = sut.Range(ctx, ei, nil, dosa.All(), "", 10)
+	assert.True(t, dosa.ErrorIsUnsupported(err))
+	_, _, err = sut.Scan(ctx, ei, dosa.All(), "", 10)
+	assert.True(t, dosa.ErrorIsUnsupported(err))
+	_, err = sut.MultiRead(ctx, ei, []map[string]dosa.FieldValue{keys}, dosa.All())
+	assert.True(t, dosa.ErrorIsUnsupported(err))
+}
+
+func TestMutationsRejected(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	sut := NewConnector(&snapshotReader{Connector: mocks.NewMockConnector(ctrl)}, "snap-1")
+
+	assert.True(t, ErrorIsMutationRejected(sut.CreateIfNotExists(ctx, ei, keys)))
+	assert.True(t, ErrorIsMutationRejected(sut.Upsert(ctx, ei, keys)))
+	assert.True(t, ErrorIsMutationRejected(sut.Remove(ctx, ei, keys)))
+	assert.True(t, ErrorIsMutationRejected(sut.RemoveRange(ctx, ei, nil)))
+	_, err := sut.MultiUpsert(ctx, ei, []map[string]dosa.FieldValue{keys})
+	assert.True(t, ErrorIsMutationRejected(err))
+	_, err = sut.MultiRemove(ctx, ei, []map[string]dosa.FieldValue{keys})
+	assert.True(t, ErrorIsMutationRejected(err))
+	assert.EqualError(t, sut.Upsert(ctx, ei, keys), "Upsert is not allowed on a snapshot connector")
+}
+
+func TestMutationsPassedThrough(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	multi := []map[string]dosa.FieldValue{keys}
+	mockConn.EXPECT().CreateIfNotExists(ctx, ei, keys).Return(nil)
+	mockConn.EXPECT().Upsert(ctx, ei, keys).Return(nil)
+	mockConn.EXPECT().MultiUpsert(ctx, ei, multi).Return(nil, nil)
+	mockConn.EXPECT().Remove(ctx, ei, keys).Return(nil)
+	mockConn.EXPECT().RemoveRange(ctx, ei, gomock.Any()).Return(nil)
+	mockConn.EXPECT().MultiRemove(ctx, ei, multi).Return(nil, nil)
+	sut := NewConnector(&snapshotReader{Connector: mockConn}, "snap-1", AllowMutations())
+
+	assert.NoError(t, sut.CreateIfNotExists(ctx, ei, keys))
+	assert.NoError(t, sut.Upsert(ctx, ei, keys))
+	_, err := sut.MultiUpsert(ctx, ei, multi)
+	assert.NoError(t, err)
+	assert.NoError(t, sut.Remove(ctx, ei, keys))
+	assert.NoError(t, sut.RemoveRange(ctx, ei, nil))
+	_, err = sut.MultiRemove(ctx, ei, multi)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package validate provides a connector that rejects writes missing columns
+// their entity requires, before they reach the store as half-populated rows.
+package validate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors"
+	"github.com/uber-go/dosa/connectors/base"
+)
+
+// ErrMissingColumns is returned for a write that does not set all of its
+// entity's required columns
+type ErrMissingColumns struct {
+	Entity  string
+	Columns []string
+}
+
+// Error lists the entity and the required columns the write left out
+func (e *ErrMissingColumns) Error() string {
+	return fmt.Sprintf("write to entity %q is missing required columns: %s", e.Entity, strings.Join(e.Columns, ", "))
+}
+
+// ErrorIsMissingColumns checks if the error is caused by "ErrMissingColumns"
+func ErrorIsMissingColumns(err error) bool {
+	_, ok := errors.Cause(err).(*ErrMissingColumns)
+	return ok
+}
+
+// Connector checks that upserts and creates set every required column of
+// their entity, and passes all other operations through to the next connector
+type Connector struct {
+	base.Connector
+	required map[string][]string
+}
+
+// NewConnector returns a connector that requires the columns listed for each
+// entity, keyed by entity name, to be set on every Upsert, MultiUpsert and
+// CreateIfNotExists. The lists name non-key columns; the store already
+// rejects writes without their primary key. Entities that are not listed are
+// not checked.
+func NewConnector(next dosa.Connector, required map[string][]string) *Connector {
+	return &Connector{
+		Connector: base.Connector{Next: next},
+		required:  required,
+	}
+}
+
+// Middleware returns a connectors.Middleware that wraps a connector with
+// NewConnector
+func Middleware(required map[string][]string) connectors.Middleware {
+	return func(next dosa.Connector) dosa.Connector {
+		return NewConnector(next, required)
+	}
+}
+
+// check returns an *ErrMissingColumns if values lacks required columns of ei
+func (c *Connector) check(ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	var missing []string
+	for _, name := range c.required[ei.Def.Name] {
+		if _, ok := values[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return &ErrMissingColumns{Entity: ei.Def.Name, Columns: missing}
+	}
+	return nil
+}
+
+// CreateIfNotExists checks the required columns before calling Next
+func (c *Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	if err := c.check(ei, values); err != nil {
+		return err
+	}
+	return c.Connector.CreateIfNotExists(ctx, ei, values)
+}
+
+// Upsert checks the required columns before calling Next
+func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	if err := c.check(ei, values); err != nil {
+		return err
+	}
+	return c.Connector.Upsert(ctx, ei, values)
+}
+
+// MultiUpsert checks the required columns of every row. Only the rows that
+// pass are sent to Next; the others get their *ErrMissingColumns in the
+// result.
+func (c *Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, multiValues []map[string]dosa.FieldValue) ([]error, error) {
+	results := make([]error, len(multiValues))
+	var indexes []int
+	var valid []map[string]dosa.FieldValue
+	for i, values := range multiValues {
+		if err := c.check(ei, values); err != nil {
+			results[i] = err
+			continue
+		}
+		indexes = append(indexes, i)
+		valid = append(valid, values)
+	}
+	if len(valid) == len(multiValues) {
+		return c.Connector.MultiUpsert(ctx, ei, multiValues)
+	}
+	if len(valid) == 0 {
+		return results, nil
+	}
+	nextResults, err := c.Connector.MultiUpsert(ctx, ei, valid)
+	if err != nil {
+		return nil, err
+	}
+	for j, err := range nextResults {
+		results[indexes[j]] = err
+	}
+	return results, nil
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package validate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/mocks"
+)
+
+var testEi = &dosa.EntityInfo{
+	Ref: &dosa.SchemaRef{Scope: "scope1", NamePrefix: "namePrefix", EntityName: "eName"},
+	Def: &dosa.EntityDefinition{
+		Name: "t1",
+		Key:  &dosa.PrimaryKey{PartitionKeys: []string{"p1"}},
+		Columns: []*dosa.ColumnDefinition{
+			{Name: "p1", Type: dosa.String},
+			{Name: "c1", Type: dosa.String},
+			{Name: "c2", Type: dosa.String},
+			{Name: "c3", Type: dosa.String},
+		},
+	},
+}
+
+func newTestConnector() *Connector {
+	return NewConnector(memory.NewConnector(), map[string][]string{"t1": {"c1", "c2"}})
+}
+
+func TestValidWrite(t *testing.T) {
+	ctx := context.TODO()
+	c := newTestConnector()
+	row := map[string]dosa.FieldValue{"p1": "a", "c1": "x", "c2": "y"}
+	assert.NoError(t, c.Upsert(ctx, testEi, row))
+	assert.NoError(t, c.CreateIfNotExists(ctx, testEi, map[string]dosa.FieldValue{"p1": "b", "c1": "x", "c2": "y"}))
+
+	// reads are not checked
+	values, err := c.Read(ctx, testEi, map[string]dosa.FieldValue{"p1": "a"}, []string{"c1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "x", values["c1"])
+
+	// entities without required columns are not checked either
+	other := &dosa.EntityInfo{Ref: testEi.Ref, Def: &dosa.EntityDefinition{Name: "t2", Key: testEi.Def.Key, Columns: testEi.Def.Columns}}
+	assert.NoError(t, c.Upsert(ctx, other, map[string]dosa.FieldValue{"p1": "a"}))
+}
+
+func TestMissingRequiredColumn(t *testing.T) {
+	ctx := context.TODO()
+	c := newTestConnector()
+	err := c.Upsert(ctx, testEi, map[string]dosa.FieldValue{"p1": "a", "c3": "z"})
+	assert.True(t, ErrorIsMissingColumns(err))
+	assert.EqualError(t, err, `write to entity "t1" is missing required columns: c1, c2`)
+	err = c.CreateIfNotExists(ctx, testEi, map[string]dosa.FieldValue{"p1": "a", "c1": "x"})
+	assert.True(t, ErrorIsMissingColumns(err))
+	assert.Equal(t, []string{"c2"}, err.(*ErrMissingColumns).Columns)
+	assert.False(t, ErrorIsMissingColumns(assert.AnError))
+
+	// nothing was written
+	_, err = c.Read(ctx, testEi, map[string]dosa.FieldValue{"p1": "a"}, dosa.All())
+	assert.True(t, dosa.ErrorIsNotFound(err))
+
+	// MultiUpsert sends the valid rows on and rejects the others
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockNext := mocks.NewMockConnector(ctrl)
+	valid := map[string]dosa.FieldValue{"p1": "b", "c1": "x", "c2": "y"}
+	mockNext.EXPECT().MultiUpsert(ctx, testEi, []map[string]dosa.FieldValue{valid}).Return([]error{nil}, nil)
+	c = NewConnector(mockNext, map[string][]string{"t1": {"c1", "c2"}})
+	results, err := c.MultiUpsert(ctx, testEi, []map[string]dosa.FieldValue{{"p1": "a"}, valid})
+	assert.NoError(t, err)
+	if assert.Len(t, results, 2) {
+		assert.True(t, ErrorIsMissingColumns(results[0]))
+		assert.NoError(t, results[1])
+	}
+}
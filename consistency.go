@@ -0,0 +1,54 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dosa
+
+import "context"
+
+// Consistency selects whether a read may be answered from a cache when the
+// source of truth fails
+type Consistency int
+
+const (
+	// Eventual allows caching connectors to serve the read from their cache
+	// when origin fails. It is the default.
+	Eventual Consistency = iota
+
+	// Strong requires the read to be answered by origin. Caching connectors
+	// neither consult nor populate their cache and return the origin error
+	// on failure.
+	Strong
+)
+
+type consistencyKey struct{}
+
+// WithConsistency returns a context that requests the given consistency for
+// the reads made with it. Point reads take their consistency from the
+// context; range reads can also set it with RangeOp.Consistency.
+func WithConsistency(ctx context.Context, c Consistency) context.Context {
+	return context.WithValue(ctx, consistencyKey{}, c)
+}
+
+// ConsistencyFromContext returns the consistency requested by ctx, Eventual
+// if none was set
+func ConsistencyFromContext(ctx context.Context) Consistency {
+	c, _ := ctx.Value(consistencyKey{}).(Consistency)
+	return c
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dosa
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// FieldValuesEqual returns true if both maps have the same columns and every
+// column holds an equal value. Values are compared by content: byte slices
+// by their bytes, times with time.Time.Equal, and pointers to nullable types
+// by the value they point to, with nil pointers equal to nil.
+func FieldValuesEqual(a, b map[string]FieldValue) bool {
+	return len(FieldValuesDiff(a, b)) == 0
+}
+
+// FieldValuesDiff returns the sorted names of the columns whose values differ
+// between a and b, including columns that are only present in one of them.
+func FieldValuesDiff(a, b map[string]FieldValue) []string {
+	var diff []string
+	for name, av := range a {
+		bv, ok := b[name]
+		if !ok || !fieldValueEqual(av, bv) {
+			diff = append(diff, name)
+		}
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			diff = append(diff, name)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+func fieldValueEqual(a, b FieldValue) bool {
+	a, b = derefFieldValue(a), derefFieldValue(b)
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	switch av := a.(type) {
+	case []byte:
+		bv, ok := b.([]byte)
+		return ok && bytes.Equal(av, bv)
+	case time.Time:
+		bv, ok := b.(time.Time)
+		return ok && av.Equal(bv)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// derefFieldValue returns the value a pointer points to, or nil for a nil
+// pointer
+func derefFieldValue(v FieldValue) FieldValue {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr {
+		return v
+	}
+	if rv.IsNil() {
+		return nil
+	}
+	return rv.Elem().Interface()
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dosa_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+)
+
+func TestFieldValuesEqual(t *testing.T) {
+	now := time.Now()
+	sameInstant := now.In(time.FixedZone("elsewhere", 3600))
+	str := "hello"
+	otherStr := "hello"
+	uuid := dosa.UUID("66ad6a32-7c5a-4d9e-9fc4-2a2c1a0d3b64")
+	var nilStr *string
+
+	dataTests := []struct {
+		descript string
+		a, b     dosa.FieldValue
+		equal    bool
+	}{
+		{"int32", int32(1), int32(1), true},
+		{"int32 differs", int32(1), int32(2), false},
+		{"int64", int64(1), int64(1), true},
+		{"int32 vs int64", int32(1), int64(1), false},
+		{"double", float64(1.5), float64(1.5), true},
+		{"bool", true, false, false},
+		{"string", "a", "a", true},
+		{"uuid", uuid, dosa.UUID(string(uuid)), true},
+		{"uuid differs", uuid, dosa.UUID("0d6b7a3c-9b5b-4a11-a6c2-0a85c24c6b1e"), false},
+		{"blob", []byte{1, 2}, []byte{1, 2}, true},
+		{"blob differs", []byte{1, 2}, []byte{1, 3}, false},
+		{"empty blob vs nil blob", []byte{}, []byte(nil), true},
+		{"time", now, sameInstant, true},
+		{"time differs", now, now.Add(time.Nanosecond), false},
+		{"pointer to equal values", &str, &otherStr, true},
+		{"pointer vs value", &str, "hello", true},
+		{"nil pointer vs nil", nilStr, nil, true},
+		{"nil pointer vs value", nilStr, "hello", false},
+	}
+	for _, test := range dataTests {
+		a := map[string]dosa.FieldValue{"c": test.a}
+		b := map[string]dosa.FieldValue{"c": test.b}
+		assert.Equal(t, test.equal, dosa.FieldValuesEqual(a, b), test.descript)
+		assert.Equal(t, test.equal, dosa.FieldValuesEqual(b, a), test.descript)
+	}
+}
+
+func TestFieldValuesDiff(t *testing.T) {
+	a := map[string]dosa.FieldValue{
+		"same":   []byte("x"),
+		"change": int64(1),
+		"onlyA":  "a",
+	}
+	b := map[string]dosa.FieldValue{
+		"same":   []byte("x"),
+		"change": int64(2),
+		"onlyB":  "b",
+	}
+	assert.Equal(t, []string{"change", "onlyA", "onlyB"}, dosa.FieldValuesDiff(a, b))
+	assert.Empty(t, dosa.FieldValuesDiff(a, a))
+	assert.Empty(t, dosa.FieldValuesDiff(nil, map[string]dosa.FieldValue{}))
+	assert.True(t, dosa.FieldValuesEqual(nil, nil))
+	assert.False(t, dosa.FieldValuesEqual(a, nil))
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dosa
+
+import (
+	"bytes"
+	"strings"
+	"time"
+)
+
+// filterRows returns the rows that satisfy every condition, for connectors
+// that cannot filter scans themselves
+func filterRows(rows []map[string]FieldValue, columnConditions map[string][]*Condition) []map[string]FieldValue {
+	filtered := make([]map[string]FieldValue, 0, len(rows))
+	for _, row := range rows {
+		if matchesConditions(row, columnConditions) {
+			filtered = append(filtered, row)
+		}
+	}
+	return filtered
+}
+
+// matchesConditions reports whether the row satisfies every condition. A
+// missing or null column satisfies none.
+func matchesConditions(row map[string]FieldValue, columnConditions map[string][]*Condition) bool {
+	for column, conds := range columnConditions {
+		value := derefFieldValue(row[column])
+		if value == nil {
+			return false
+		}
+		for _, cond := range conds {
+			cmp, ok := compareFieldValues(value, derefFieldValue(cond.Value))
+			if !ok || !cond.Op.accepts(cmp) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// accepts reports whether a comparison result satisfies the operator
+func (op Operator) accepts(cmp int) bool {
+	switch op {
+	case Eq:
+		return cmp == 0
+	case Lt:
+		return cmp < 0
+	case LtOrEq:
+		return cmp <= 0
+	case Gt:
+		return cmp > 0
+	case GtOrEq:
+		return cmp >= 0
+	}
+	return false
+}
+
+// compareFieldValues orders two values of the same type, returning false if
+// they cannot be compared
+func compareFieldValues(a, b FieldValue) (int, bool) {
+	switch a := a.(type) {
+	case int32:
+		b, ok := b.(int32)
+		return compareInt64(int64(a), int64(b)), ok
+	case int64:
+		b, ok := b.(int64)
+		return compareInt64(a, b), ok
+	case float64:
+		b, ok := b.(float64)
+		switch {
+		case !ok:
+			return 0, false
+		case a < b:
+			return -1, true
+		case a > b:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		b, ok := b.(string)
+		return strings.Compare(a, b), ok
+	case UUID:
+		b, ok := b.(UUID)
+		return strings.Compare(string(a), string(b)), ok
+	case []byte:
+		b, ok := b.([]byte)
+		return bytes.Compare(a, b), ok
+	case bool:
+		b, ok := b.(bool)
+		switch {
+		case !ok:
+			return 0, false
+		case a == b:
+			return 0, true
+		case b:
+			return -1, true
+		}
+		return 1, true
+	case time.Time:
+		b, ok := b.(time.Time)
+		switch {
+		case !ok:
+			return 0, false
+		case a.Before(b):
+			return -1, true
+		case a.After(b):
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// withFilterColumns adds the filtered columns to the columns to read, so
+// that they are available for client side filtering
+func withFilterColumns(columns []string, columnConditions map[string][]*Condition) []string {
+	result := append([]string{}, columns...)
+	have := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		have[column] = true
+	}
+	for column := range columnConditions {
+		if !have[column] {
+			result = append(result, column)
+		}
+	}
+	return result
+}
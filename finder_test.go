@@ -76,6 +76,7 @@ func TestParser(t *testing.T) {
 		"registrytestvalid":      struct{}{}, // skip, same as above
 		"allfieldtypes":          struct{}{},
 		"alltypesscantestentity": struct{}{},
+		"validatetestentity":     struct{}{},
 	}
 
 	assert.Equal(t, len(expectedEntities)+len(entitiesExcludedForTest), len(entities), fmt.Sprintf("%s", entities))
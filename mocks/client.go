@@ -53,6 +53,35 @@ func (_m *MockClient) EXPECT() *_MockClientRecorder {
 	return _m.recorder
 }
 
+// BatchUpsert is a mock implementation of MockClient.BatchUpsert
+func (_m *MockClient) BatchUpsert(_param0 context.Context, _param1 []string, _param2 ...dosa.DomainObject) (dosa.MultiResult, error) {
+	_s := []interface{}{_param0, _param1}
+	for _, _x := range _param2 {
+		_s = append(_s, _x)
+	}
+	ret := _m.ctrl.Call(_m, "BatchUpsert", _s...)
+	ret0, _ := ret[0].(dosa.MultiResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockClientRecorder) BatchUpsert(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	_s := append([]interface{}{arg0, arg1}, arg2...)
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "BatchUpsert", _s...)
+}
+
+// CacheKeyFor is a mock implementation of MockClient.CacheKeyFor
+func (_m *MockClient) CacheKeyFor(_param0 dosa.DomainObject) ([]byte, error) {
+	ret := _m.ctrl.Call(_m, "CacheKeyFor", _param0)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockClientRecorder) CacheKeyFor(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CacheKeyFor", arg0)
+}
+
 // CreateIfNotExists is a mock implementation of MockClient.CreateIfNotExists
 func (_m *MockClient) CreateIfNotExists(_param0 context.Context, _param1 dosa.DomainObject) error {
 	ret := _m.ctrl.Call(_m, "CreateIfNotExists", _param0, _param1)
@@ -64,6 +93,30 @@ func (_mr *_MockClientRecorder) CreateIfNotExists(arg0, arg1 interface{}) *gomoc
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "CreateIfNotExists", arg0, arg1)
 }
 
+// Count is a mock implementation of MockClient.Count
+func (_m *MockClient) Count(_param0 context.Context, _param1 *dosa.RangeOp) (int64, error) {
+	ret := _m.ctrl.Call(_m, "Count", _param0, _param1)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockClientRecorder) Count(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Count", arg0, arg1)
+}
+
+// Exists is a mock implementation of MockClient.Exists
+func (_m *MockClient) Exists(_param0 context.Context, _param1 dosa.DomainObject) (bool, error) {
+	ret := _m.ctrl.Call(_m, "Exists", _param0, _param1)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockClientRecorder) Exists(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Exists", arg0, arg1)
+}
+
 // Initialize is a mock implementation of MockClient.Initialize
 func (_m *MockClient) Initialize(_param0 context.Context) error {
 	ret := _m.ctrl.Call(_m, "Initialize", _param0)
@@ -88,6 +141,32 @@ func (_mr *_MockClientRecorder) Range(arg0, arg1 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Range", arg0, arg1)
 }
 
+// RangeAcross is a mock implementation of MockClient.RangeAcross
+func (_m *MockClient) RangeAcross(_param0 context.Context, _param1 *dosa.RangeOp, _param2 []map[string]dosa.FieldValue) ([]dosa.DomainObject, string, error) {
+	ret := _m.ctrl.Call(_m, "RangeAcross", _param0, _param1, _param2)
+	ret0, _ := ret[0].([]dosa.DomainObject)
+	ret1, _ := ret[1].(string)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+func (_mr *_MockClientRecorder) RangeAcross(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "RangeAcross", arg0, arg1, arg2)
+}
+
+// RangeRaw is a mock implementation of MockClient.RangeRaw
+func (_m *MockClient) RangeRaw(_param0 context.Context, _param1 *dosa.RangeOp) ([]map[string]dosa.FieldValue, string, error) {
+	ret := _m.ctrl.Call(_m, "RangeRaw", _param0, _param1)
+	ret0, _ := ret[0].([]map[string]dosa.FieldValue)
+	ret1, _ := ret[1].(string)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+func (_mr *_MockClientRecorder) RangeRaw(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "RangeRaw", arg0, arg1)
+}
+
 // Read is a mock implementation of MockClient.Read
 func (_m *MockClient) Read(_param0 context.Context, _param1 []string, _param2 dosa.DomainObject) error {
 	ret := _m.ctrl.Call(_m, "Read", _param0, _param1, _param2)
@@ -99,6 +178,40 @@ func (_mr *_MockClientRecorder) Read(arg0, arg1, arg2 interface{}) *gomock.Call
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Read", arg0, arg1, arg2)
 }
 
+// ReadConsistent is a mock implementation of MockClient.ReadConsistent
+func (_m *MockClient) ReadConsistent(_param0 context.Context, _param1 []string, _param2 dosa.DomainObject) error {
+	ret := _m.ctrl.Call(_m, "ReadConsistent", _param0, _param1, _param2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockClientRecorder) ReadConsistent(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ReadConsistent", arg0, arg1, arg2)
+}
+
+// ReadWithPresence is a mock implementation of MockClient.ReadWithPresence
+func (_m *MockClient) ReadWithPresence(_param0 context.Context, _param1 []string, _param2 dosa.DomainObject) (map[string]bool, error) {
+	ret := _m.ctrl.Call(_m, "ReadWithPresence", _param0, _param1, _param2)
+	ret0, _ := ret[0].(map[string]bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockClientRecorder) ReadWithPresence(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ReadWithPresence", arg0, arg1, arg2)
+}
+
+// Refresh is a mock implementation of MockClient.Refresh
+func (_m *MockClient) Refresh(_param0 context.Context, _param1 dosa.DomainObject) error {
+	ret := _m.ctrl.Call(_m, "Refresh", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockClientRecorder) Refresh(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Refresh", arg0, arg1)
+}
+
 // Remove is a mock implementation of MockClient.Remove
 func (_m *MockClient) Remove(_param0 context.Context, _param1 dosa.DomainObject) error {
 	ret := _m.ctrl.Call(_m, "Remove", _param0, _param1)
@@ -110,6 +223,18 @@ func (_mr *_MockClientRecorder) Remove(arg0, arg1 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Remove", arg0, arg1)
 }
 
+// RemoveN is a mock implementation of MockClient.RemoveN
+func (_m *MockClient) RemoveN(_param0 context.Context, _param1 dosa.DomainObject) (dosa.MutationResult, error) {
+	ret := _m.ctrl.Call(_m, "RemoveN", _param0, _param1)
+	ret0, _ := ret[0].(dosa.MutationResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockClientRecorder) RemoveN(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "RemoveN", arg0, arg1)
+}
+
 // RemoveRange is a mock implementation of MockClient.RemoveRange
 func (_m *MockClient) RemoveRange(_param0 context.Context, _param1 *dosa.RemoveRangeOp) error {
 	ret := _m.ctrl.Call(_m, "RemoveRange", _param0, _param1)
@@ -145,6 +270,30 @@ func (_mr *_MockClientRecorder) Upsert(arg0, arg1, arg2 interface{}) *gomock.Cal
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Upsert", arg0, arg1, arg2)
 }
 
+// UpsertN is a mock implementation of MockClient.UpsertN
+func (_m *MockClient) UpsertN(_param0 context.Context, _param1 []string, _param2 dosa.DomainObject) (dosa.MutationResult, error) {
+	ret := _m.ctrl.Call(_m, "UpsertN", _param0, _param1, _param2)
+	ret0, _ := ret[0].(dosa.MutationResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockClientRecorder) UpsertN(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "UpsertN", arg0, arg1, arg2)
+}
+
+// UpsertIfChanged is a mock implementation of MockClient.UpsertIfChanged
+func (_m *MockClient) UpsertIfChanged(_param0 context.Context, _param1 dosa.DomainObject) (bool, error) {
+	ret := _m.ctrl.Call(_m, "UpsertIfChanged", _param0, _param1)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockClientRecorder) UpsertIfChanged(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "UpsertIfChanged", arg0, arg1)
+}
+
 // WalkRange is a mock implementation of MockClient.WalkRange
 func (_m *MockClient) WalkRange(_param0 context.Context, _param1 *dosa.RangeOp, _param2 func(dosa.DomainObject) error) error {
 	ret := _m.ctrl.Call(_m, "WalkRange", _param0, _param1, _param2)
@@ -156,6 +305,17 @@ func (_mr *_MockClientRecorder) WalkRange(arg0, arg1, arg2 interface{}) *gomock.
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "WalkRange", arg0, arg1, arg2)
 }
 
+// WriteBatch is a mock implementation of MockClient.WriteBatch
+func (_m *MockClient) WriteBatch(_param0 context.Context) *dosa.WriteBatch {
+	ret := _m.ctrl.Call(_m, "WriteBatch", _param0)
+	ret0, _ := ret[0].(*dosa.WriteBatch)
+	return ret0
+}
+
+func (_mr *_MockClientRecorder) WriteBatch(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "WriteBatch", arg0)
+}
+
 // MockAdminClient is a mock of AdminClient interface
 type MockAdminClient struct {
 	ctrl     *gomock.Controller
@@ -34,6 +34,10 @@ import (
 type RangeOp struct {
 	pager
 	conditioner
+	backward       bool
+	keysOnly       bool
+	allowFiltering bool
+	consistency    Consistency
 }
 
 // NewRangeOp returns a new RangeOp instance
@@ -54,8 +58,21 @@ func (r *RangeOp) Limit(n int) *RangeOp {
 }
 
 // Offset sets the pagination token. If not set, an empty token would be used.
+// The next call returns the rows that follow the token's position.
 func (r *RangeOp) Offset(token string) *RangeOp {
 	r.token = token
+	r.backward = false
+	return r
+}
+
+// Before sets the pagination token and requests the page that precedes the
+// token's position instead of the one that follows it. The token returned by
+// such a call can be passed to Before again to keep paging backward, or to
+// Offset to page forward from there. Connectors that do not implement
+// BackwardRanger return ErrUnsupported.
+func (r *RangeOp) Before(token string) *RangeOp {
+	r.token = token
+	r.backward = true
 	return r
 }
 
@@ -66,6 +83,33 @@ func (r *RangeOp) Fields(fields []string) *RangeOp {
 	return r
 }
 
+// KeysOnly limits the fields fetched to the primary key columns, which makes
+// walking a range cheap when the rows themselves are not needed. It takes
+// precedence over Fields.
+func (r *RangeOp) KeysOnly() *RangeOp {
+	r.keysOnly = true
+	return r
+}
+
+// AllowFiltering opts in to conditions that do not fit the entity's key
+// layout, such as conditions on non-key columns or a partition key without an
+// Eq condition. Backends can only answer these by filtering, which is
+// expensive, so ValidateAgainst rejects them unless this is set. The intent
+// is forwarded to connectors that implement FilteringRanger; other
+// connectors, and ranges paged with Before, receive the query as usual.
+func (r *RangeOp) AllowFiltering() *RangeOp {
+	r.allowFiltering = true
+	return r
+}
+
+// Consistency sets the consistency of the range. Strong keeps caching
+// connectors from answering the range out of their cache, whatever the
+// context passed to Range requests; Eventual leaves the context's choice.
+func (r *RangeOp) Consistency(c Consistency) *RangeOp {
+	r.consistency = c
+	return r
+}
+
 // String satisfies the Stringer interface
 func (r *RangeOp) String() string {
 	result := &bytes.Buffer{}
@@ -93,10 +137,42 @@ func (r *RangeOp) String() string {
 			}
 		}
 	}
-	addLimitTokenString(result, r.limit, r.token)
+	if r.backward {
+		addLimitTokenString(result, r.limit, "")
+		fmt.Fprintf(result, " before %q", r.token)
+	} else {
+		addLimitTokenString(result, r.limit, r.token)
+	}
 	return result.String()
 }
 
+// ValidateAgainst checks that the op's conditions fit the key layout of ei:
+// every partition key must have exactly one Eq condition, conditions may
+// only be placed on key columns, clustering keys must be constrained in key
+// order, and only the last constrained clustering key may use inequalities.
+// The returned error names the offending fields. When AllowFiltering is set
+// only the field names and value types are checked.
+func (r *RangeOp) ValidateAgainst(ei *EntityInfo) error {
+	t, err := TableFromInstance(r.object)
+	if err != nil {
+		return errors.Wrap(err, "invalid range op")
+	}
+	columnConditions, err := convertConditions(r.conditions, t)
+	if err != nil {
+		return errors.Wrap(err, "invalid range op")
+	}
+	if r.allowFiltering {
+		return nil
+	}
+	transform := func(column string) string {
+		if field, ok := t.ColToField[column]; ok {
+			return field
+		}
+		return column
+	}
+	return errors.Wrap(EnsureValidRangeConditions(ei.Def, ei.Def.Key, columnConditions, transform), "invalid range op")
+}
+
 // Eq is used to express an equality constraint for a range query
 func (r *RangeOp) Eq(fieldName string, value interface{}) *RangeOp {
 	r.appendOp(Eq, fieldName, value)
@@ -130,9 +206,13 @@ func (r *RangeOp) LtOrEq(fieldName string, value interface{}) *RangeOp {
 }
 
 type rangeOpMatcher struct {
-	conds map[string]map[Condition]bool
-	p     pager
-	typ   reflect.Type
+	conds          map[string]map[Condition]bool
+	p              pager
+	backward       bool
+	keysOnly       bool
+	allowFiltering bool
+	consistency    Consistency
+	typ            reflect.Type
 }
 
 // EqRangeOp creates a gomock Matcher that will match any RangeOp with the same conditions, limit, token, and fields
@@ -147,9 +227,13 @@ func EqRangeOp(op *RangeOp) gomock.Matcher {
 	}
 
 	return rangeOpMatcher{
-		conds: conds,
-		p:     op.pager,
-		typ:   reflect.TypeOf(op.object).Elem(),
+		conds:          conds,
+		p:              op.pager,
+		backward:       op.backward,
+		keysOnly:       op.keysOnly,
+		allowFiltering: op.allowFiltering,
+		consistency:    op.consistency,
+		typ:            reflect.TypeOf(op.object).Elem(),
 	}
 }
 
@@ -168,7 +252,8 @@ func (m rangeOpMatcher) Matches(x interface{}) bool {
 		}
 	}
 
-	return m.p.equals(op.pager) && reflect.TypeOf(op.object).Elem() == m.typ
+	return m.p.equals(op.pager) && m.backward == op.backward && m.keysOnly == op.keysOnly &&
+		m.allowFiltering == op.allowFiltering && m.consistency == op.consistency && reflect.TypeOf(op.object).Elem() == m.typ
 }
 
 // String satisfies the gomock.Matcher and Stringer interface
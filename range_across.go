@@ -0,0 +1,245 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dosa
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// acrossPosition is where RangeAcross resumes one partition: the connector
+// token of the page it stopped in, how many rows of that page were already
+// returned, and whether the partition has been read to its end
+type acrossPosition struct {
+	Token string `json:",omitempty"`
+	Skip  int    `json:",omitempty"`
+	Done  bool   `json:",omitempty"`
+}
+
+// RangeAcross ranges over several partitions of the entity of r at once.
+// Each partition is a map from the partition key field names to their
+// values; its conditions are added to those of r, and one Range per
+// partition is issued to the connector concurrently. The rows are merged in
+// clustering key order, ties going to the partition listed first, and at
+// most the limit of r are returned.
+//
+// The returned token resumes the merge where it stopped: pass it to Offset
+// of the same RangeOp and call RangeAcross again with the same partitions in
+// the same order. It records, for every partition, the connector token of
+// the page the merge stopped in and how many of its rows were returned, so
+// resuming rereads the part of that page that was already returned. An empty
+// token means every partition has been read to its end. Backward ranges are
+// not supported.
+func (c *client) RangeAcross(ctx context.Context, r *RangeOp, partitions []map[string]FieldValue) ([]DomainObject, string, error) {
+	if !c.initialized {
+		return nil, "", &ErrNotInitialized{}
+	}
+	if r.backward {
+		return nil, "", &ErrUnsupported{Op: "RangeAcross with RangeBefore"}
+	}
+	re, err := c.registrar.Find(r.object)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "RangeAcross")
+	}
+	positions, err := parseAcrossToken(r.token, len(partitions))
+	if err != nil {
+		return nil, "", errors.Wrap(err, "RangeAcross")
+	}
+	columnConditions, err := convertConditions(r.conditions, re.table)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "RangeAcross")
+	}
+	clustering := re.info.Def.Key.ClusteringKeys
+	fieldsToRead, err := re.ColumnNames(c.projection(re, r.fieldsToRead))
+	if err != nil {
+		return nil, "", errors.Wrap(err, "RangeAcross")
+	}
+	if r.keysOnly {
+		fieldsToRead = keyColumnNames(re.info.Def.Key)
+	}
+	// the clustering columns are needed to merge the partitions
+	clusteringColumns := make(map[string][]*Condition, len(clustering))
+	for _, ck := range clustering {
+		clusteringColumns[ck.Name] = nil
+	}
+	fieldsToRead = withFilterColumns(fieldsToRead, clusteringColumns)
+
+	partitionConditions := make([]map[string][]*Condition, len(partitions))
+	for i, partition := range partitions {
+		conds := make(map[string][]*Condition, len(columnConditions)+len(partition))
+		for column, cs := range columnConditions {
+			conds[column] = cs
+		}
+		for field, value := range partition {
+			column, ok := re.table.FieldToCol[field]
+			if !ok {
+				return nil, "", errors.Errorf("RangeAcross: %s is not a valid field for %s", field, re.table.StructName)
+			}
+			conds[column] = append(append([]*Condition{}, conds[column]...), &Condition{Op: Eq, Value: value})
+		}
+		partitionConditions[i] = conds
+	}
+
+	if r.consistency != Eventual {
+		ctx = WithConsistency(ctx, r.consistency)
+	}
+	pages := make([][]map[string]FieldValue, len(partitions))
+	nexts := make([]string, len(partitions))
+	errs := make([]error, len(partitions))
+	var wg sync.WaitGroup
+	for i := range partitions {
+		if positions[i].Done {
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			limit := r.limit
+			if limit > 0 {
+				limit += positions[i].Skip
+			}
+			rows, next, err := c.connector.Range(ctx, re.info, partitionConditions[i], fieldsToRead, positions[i].Token, limit)
+			if skip := positions[i].Skip; skip > 0 {
+				if skip > len(rows) {
+					skip = len(rows)
+				}
+				rows = rows[skip:]
+			}
+			pages[i], nexts[i], errs[i] = rows, next, err
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, "", errors.Wrap(err, "RangeAcross")
+		}
+	}
+
+	merged, consumed := mergePages(pages, nexts, positions, clustering, r.limit)
+	token, err := acrossToken(positions, pages, nexts, consumed)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "RangeAcross")
+	}
+	objectArray := objectsFromValueArray(r.object, merged, re, nil)
+	if err := c.runAfterRead(objectArray...); err != nil {
+		return nil, "", errors.Wrap(err, "RangeAcross")
+	}
+	return objectArray, token, nil
+}
+
+// mergePages merges the pages in clustering order, up to limit rows if it is
+// positive, and returns how many rows of each page were taken. The merge
+// stops early when a page runs out while its partition has more rows, since
+// those could sort before the rows left in the other pages.
+func mergePages(pages [][]map[string]FieldValue, nexts []string, positions []acrossPosition, clustering []*ClusteringKey, limit int) ([]map[string]FieldValue, []int) {
+	consumed := make([]int, len(pages))
+	var merged []map[string]FieldValue
+	for limit <= 0 || len(merged) < limit {
+		best := -1
+		for i, page := range pages {
+			if positions[i].Done {
+				continue
+			}
+			if consumed[i] == len(page) {
+				if nexts[i] != "" {
+					return merged, consumed
+				}
+				continue
+			}
+			if best < 0 || compareClustering(page[consumed[i]], pages[best][consumed[best]], clustering) < 0 {
+				best = i
+			}
+		}
+		if best < 0 {
+			break
+		}
+		merged = append(merged, pages[best][consumed[best]])
+		consumed[best]++
+	}
+	return merged, consumed
+}
+
+// compareClustering orders two rows by their clustering columns
+func compareClustering(a, b map[string]FieldValue, clustering []*ClusteringKey) int {
+	for _, ck := range clustering {
+		cmp, ok := compareFieldValues(derefFieldValue(a[ck.Name]), derefFieldValue(b[ck.Name]))
+		if !ok || cmp == 0 {
+			continue
+		}
+		if ck.Descending {
+			return -cmp
+		}
+		return cmp
+	}
+	return 0
+}
+
+// acrossToken returns the token resuming every partition after the rows
+// consumed by the merge, or an empty token if all of them are exhausted
+func acrossToken(positions []acrossPosition, pages [][]map[string]FieldValue, nexts []string, consumed []int) (string, error) {
+	next := make([]acrossPosition, len(positions))
+	done := true
+	for i, position := range positions {
+		switch {
+		case position.Done:
+			next[i] = position
+		case consumed[i] < len(pages[i]):
+			next[i] = acrossPosition{Token: position.Token, Skip: position.Skip + consumed[i]}
+		case nexts[i] == "":
+			next[i] = acrossPosition{Done: true}
+		default:
+			next[i] = acrossPosition{Token: nexts[i]}
+		}
+		done = done && next[i].Done
+	}
+	if done {
+		return "", nil
+	}
+	body, err := json.Marshal(next)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(body), nil
+}
+
+// parseAcrossToken returns the positions recorded in a RangeAcross token, or
+// the starting positions for an empty token
+func parseAcrossToken(token string, partitions int) ([]acrossPosition, error) {
+	if token == "" {
+		return make([]acrossPosition, partitions), nil
+	}
+	body, err := base64.URLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid RangeAcross token")
+	}
+	var positions []acrossPosition
+	if err := json.Unmarshal(body, &positions); err != nil {
+		return nil, errors.Wrap(err, "invalid RangeAcross token")
+	}
+	if len(positions) != partitions {
+		return nil, errors.Errorf("RangeAcross token is for %d partitions, not %d", len(positions), partitions)
+	}
+	return positions, nil
+}
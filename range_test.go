@@ -75,6 +75,12 @@ var rangeTestCases = []struct {
 		stringer:  "<empty> token \"toketoketoke\"",
 		converted: "<empty> token \"toketoketoke\"",
 	},
+	{
+		descript:  "empty with backward token",
+		rop:       NewRangeOp(&AllTypes{}).Limit(10).Before("toketoketoke"),
+		stringer:  "<empty> limit 10 before \"toketoketoke\"",
+		converted: "<empty> limit 10 before \"toketoketoke\"",
+	},
 	{
 		descript: "error in one field",
 		rop:      NewRangeOp(&AllTypes{}).Lt("badfieldpropogate", "oopsie").Lt("StringType", "42").Limit(10),
@@ -113,6 +119,9 @@ func TestRangeOpMatcher(t *testing.T) {
 	RangeOp3 := NewRangeOp(&AllTypes{}).Eq("StringType", "Hello").Offset("token1")
 	RangeOp4 := NewRangeOp(&AllTypes{}).Eq("StringType", "Hello").Limit(5)
 	RangeOp5 := NewRangeOp(&AllTypes{}).Eq("StringType", "Hello").Fields([]string{"BoolType"})
+	RangeOp6 := NewRangeOp(&AllTypes{}).Eq("StringType", "Hello").Offset("token1")
+	RangeOp7 := NewRangeOp(&AllTypes{}).Eq("StringType", "Hello").Before("token1")
+	RangeOp8 := NewRangeOp(&AllTypes{}).Eq("StringType", "Hello").AllowFiltering()
 
 	matcher := EqRangeOp(RangeOp0)
 	assert.True(t, matcher.Matches(RangeOp1))
@@ -120,5 +129,57 @@ func TestRangeOpMatcher(t *testing.T) {
 	assert.False(t, matcher.Matches(RangeOp3))
 	assert.False(t, matcher.Matches(RangeOp4))
 	assert.False(t, matcher.Matches(RangeOp5))
+	assert.True(t, EqRangeOp(RangeOp3).Matches(RangeOp6))
+	assert.False(t, EqRangeOp(RangeOp3).Matches(RangeOp7))
+	assert.False(t, matcher.Matches(RangeOp8))
+	assert.False(t, matcher.Matches(NewRangeOp(&AllTypes{}).Eq("StringType", "Hello").Consistency(Strong)))
+	assert.True(t, EqRangeOp(RangeOp8).Matches(NewRangeOp(&AllTypes{}).Eq("StringType", "Hello").AllowFiltering()))
 	assert.False(t, matcher.Matches(3))
 }
+
+type validateTestEntity struct {
+	Entity  `dosa:"primaryKey=((Tenant, Region), First, Second, Third)"`
+	Tenant  string
+	Region  string
+	First   int32
+	Second  int64
+	Third   string
+	Payload string
+}
+
+func TestRangeOpValidateAgainst(t *testing.T) {
+	table, err := TableFromInstance(&validateTestEntity{})
+	assert.NoError(t, err)
+	ei := &EntityInfo{Def: &table.EntityDefinition}
+	partition := func() *RangeOp {
+		return NewRangeOp(&validateTestEntity{}).Eq("Tenant", "t").Eq("Region", "r")
+	}
+
+	dataTests := []struct {
+		descript string
+		rop      *RangeOp
+		err      string
+	}{
+		{descript: "partition only", rop: partition()},
+		{descript: "inequality on the first clustering key", rop: partition().Gt("First", int32(1)).Lt("First", int32(9))},
+		{descript: "inequality on the last constrained clustering key", rop: partition().Eq("First", int32(1)).GtOrEq("Second", int64(2))},
+		{descript: "missing partition key", rop: NewRangeOp(&validateTestEntity{}).Eq("Tenant", "t"), err: "missing Eq condition on partition keys: [Region]"},
+		{descript: "inequality on partition key", rop: partition().Gt("Region", "a"), err: "invalid conditions for partition key: Region"},
+		{descript: "inequality on a non-terminal clustering key", rop: partition().Gt("First", int32(1)).Eq("Second", int64(2)), err: "found invalid condition for key: First"},
+		{descript: "skipped clustering key", rop: partition().Eq("First", int32(1)).Eq("Third", "x"), err: "unconstrained before: Third"},
+		{descript: "non-key column", rop: partition().Eq("Payload", "x"), err: "non-key column: Payload"},
+		{descript: "unknown field", rop: partition().Eq("Bogus", "x"), err: "Bogus"},
+		{descript: "filtering on a non-key column", rop: partition().Eq("Payload", "x").AllowFiltering()},
+		{descript: "filtering without a partition key", rop: NewRangeOp(&validateTestEntity{}).Gt("Second", int64(2)).AllowFiltering()},
+		{descript: "filtering on an unknown field", rop: partition().Eq("Bogus", "x").AllowFiltering(), err: "Bogus"},
+		{descript: "filtering with a mistyped value", rop: partition().Eq("Payload", int32(1)).AllowFiltering(), err: "Payload"},
+	}
+	for _, test := range dataTests {
+		err := test.rop.ValidateAgainst(ei)
+		if test.err == "" {
+			assert.NoError(t, err, test.descript)
+		} else if assert.Error(t, err, test.descript) {
+			assert.Contains(t, err.Error(), test.err, test.descript)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dosa
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+// rangeTokenVersion is the first byte of every marshaled range token
+const rangeTokenVersion byte = 1
+
+// TokenPayload is the content of a marshaled RangeOp, as handed to a
+// TokenCodec
+type TokenPayload struct {
+	Entity     string
+	Conditions map[string][]TokenCondition `json:",omitempty"`
+	Fields     []string                    `json:",omitempty"`
+	Limit      int                         `json:",omitempty"`
+	Token      string                      `json:",omitempty"`
+	Backward   bool                        `json:",omitempty"`
+	KeysOnly   bool                        `json:",omitempty"`
+	// fields added later are omitted when unset, so the tokens of ops that
+	// do not use them stay the same
+	AllowFiltering bool        `json:",omitempty"`
+	Consistency    Consistency `json:",omitempty"`
+}
+
+// TokenCondition is a condition of a TokenPayload. Value holds the JSON
+// encoding of the condition's value, which is decoded into the type of the
+// entity's field when the token is parsed.
+type TokenCondition struct {
+	Op    Operator
+	Value json.RawMessage
+}
+
+// TokenCodec turns the payload of a marshaled RangeOp into a token string and
+// back. Decode must accept every token Encode produces.
+type TokenCodec interface {
+	Encode(TokenPayload) (string, error)
+	Decode(string) (TokenPayload, error)
+}
+
+// JSONTokenCodec is the TokenCodec used by MarshalToken and ParseRangeToken.
+// Tokens are the URL safe base64 encoding of a version byte, the JSON
+// payload and a CRC-32 checksum of both.
+type JSONTokenCodec struct{}
+
+// Encode returns the token for payload
+func (JSONTokenCodec) Encode(payload TokenPayload) (string, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, len(body)+5))
+	buf.WriteByte(rangeTokenVersion)
+	buf.Write(body)
+	_ = binary.Write(buf, binary.BigEndian, crc32.ChecksumIEEE(buf.Bytes()))
+	return base64.RawURLEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// Decode returns the payload of a token produced by Encode
+func (JSONTokenCodec) Decode(token string) (TokenPayload, error) {
+	var payload TokenPayload
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return payload, errors.Wrap(err, "malformed token")
+	}
+	if len(data) < 5 {
+		return payload, errors.New("token too short")
+	}
+	checked, sum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(checked) != sum {
+		return payload, errors.New("checksum mismatch")
+	}
+	if checked[0] != rangeTokenVersion {
+		return payload, errors.Errorf("unsupported token version %d", checked[0])
+	}
+	if err := json.Unmarshal(checked[1:], &payload); err != nil {
+		return payload, errors.Wrap(err, "malformed token")
+	}
+	return payload, nil
+}
+
+// HMACTokenCodec is a TokenCodec that signs tokens with an HMAC-SHA256 of
+// their payload, keyed by Key. Unlike the checksum of JSONTokenCodec, the
+// signature cannot be recomputed without the key, so a token that was edited
+// fails to decode. Tokens are the URL safe base64 encoding of a version byte,
+// the JSON payload and the signature of both.
+type HMACTokenCodec struct {
+	Key []byte
+}
+
+// Encode returns the signed token for payload
+func (h HMACTokenCodec) Encode(payload TokenPayload) (string, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, len(body)+1+sha256.Size))
+	buf.WriteByte(rangeTokenVersion)
+	buf.Write(body)
+	buf.Write(h.sign(buf.Bytes()))
+	return base64.RawURLEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// Decode returns the payload of a token produced by Encode with the same key
+func (h HMACTokenCodec) Decode(token string) (TokenPayload, error) {
+	var payload TokenPayload
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return payload, errors.Wrap(err, "malformed token")
+	}
+	if len(data) < 1+sha256.Size {
+		return payload, errors.New("token too short")
+	}
+	signed, sig := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	if !hmac.Equal(h.sign(signed), sig) {
+		return payload, errors.New("signature mismatch")
+	}
+	if signed[0] != rangeTokenVersion {
+		return payload, errors.Errorf("unsupported token version %d", signed[0])
+	}
+	if err := json.Unmarshal(signed[1:], &payload); err != nil {
+		return payload, errors.Wrap(err, "malformed token")
+	}
+	return payload, nil
+}
+
+func (h HMACTokenCodec) sign(data []byte) []byte {
+	mac := hmac.New(sha256.New, h.Key)
+	_, _ = mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// MarshalToken encodes the complete op, including its conditions, fields,
+// limit and pagination token, into a URL safe string. ParseRangeToken
+// restores it, so pagination can continue on a process that did not build
+// the original op. The token is checksummed, not signed: ParseRangeToken
+// verifies the checksum, which detects corrupted tokens, but anyone can edit
+// a token and recompute it. Use MarshalTokenWith and an HMACTokenCodec when
+// tokens pass through hands that must not change what is queried.
+func (r *RangeOp) MarshalToken() (string, error) {
+	return r.MarshalTokenWith(JSONTokenCodec{})
+}
+
+// MarshalTokenWith is MarshalToken with the token encoded by codec. The
+// token must be parsed with ParseRangeTokenWith and the same codec.
+func (r *RangeOp) MarshalTokenWith(codec TokenCodec) (string, error) {
+	t, err := TableFromInstance(r.object)
+	if err != nil {
+		return "", errors.Wrap(err, "MarshalToken")
+	}
+	payload := TokenPayload{
+		Entity:   t.Name,
+		Fields:   r.fieldsToRead,
+		Limit:    r.limit,
+		Token:    r.token,
+		Backward: r.backward,
+		KeysOnly: r.keysOnly,
+
+		AllowFiltering: r.allowFiltering,
+		Consistency:    r.consistency,
+	}
+	if len(r.conditions) > 0 {
+		payload.Conditions = make(map[string][]TokenCondition, len(r.conditions))
+	}
+	for field, conds := range r.conditions {
+		for _, cond := range conds {
+			value, err := json.Marshal(cond.Value)
+			if err != nil {
+				return "", errors.Wrapf(err, "MarshalToken: cannot encode value for %s", field)
+			}
+			payload.Conditions[field] = append(payload.Conditions[field], TokenCondition{Op: cond.Op, Value: value})
+		}
+	}
+	token, err := codec.Encode(payload)
+	if err != nil {
+		return "", errors.Wrap(err, "MarshalToken")
+	}
+	return token, nil
+}
+
+// ParseRangeToken restores a RangeOp from a token produced by MarshalToken.
+// object must be an instance of the entity the op was built for, since the
+// token only records the entity's name; condition values are decoded into
+// the types of the corresponding fields of object.
+func ParseRangeToken(token string, object DomainObject) (*RangeOp, error) {
+	return ParseRangeTokenWith(token, object, JSONTokenCodec{})
+}
+
+// ParseRangeTokenWith is ParseRangeToken for a token produced by
+// MarshalTokenWith and codec
+func ParseRangeTokenWith(token string, object DomainObject, codec TokenCodec) (*RangeOp, error) {
+	payload, err := codec.Decode(token)
+	if err != nil {
+		return nil, errors.Wrap(err, "ParseRangeToken")
+	}
+
+	t, err := TableFromInstance(object)
+	if err != nil {
+		return nil, errors.Wrap(err, "ParseRangeToken")
+	}
+	if payload.Entity != t.Name {
+		return nil, errors.Errorf("ParseRangeToken: token is for entity %q, not %q", payload.Entity, t.Name)
+	}
+
+	r := NewRangeOp(object)
+	r.fieldsToRead = payload.Fields
+	r.limit = payload.Limit
+	r.token = payload.Token
+	r.backward = payload.Backward
+	r.keysOnly = payload.KeysOnly
+	r.allowFiltering = payload.AllowFiltering
+	r.consistency = payload.Consistency
+	structType := reflect.TypeOf(object).Elem()
+	for field, conds := range payload.Conditions {
+		sf, ok := structType.FieldByName(field)
+		if !ok {
+			return nil, errors.Errorf("ParseRangeToken: %s is not a field of %s", field, t.StructName)
+		}
+		for _, cond := range conds {
+			value := reflect.New(sf.Type)
+			if err := json.Unmarshal(cond.Value, value.Interface()); err != nil {
+				return nil, errors.Wrapf(err, "ParseRangeToken: cannot decode value for %s", field)
+			}
+			r.conditions[field] = append(r.conditions[field], newCondition(cond.Op, value.Elem().Interface()))
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dosa
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRangeTokenRoundTrip(t *testing.T) {
+	ts := time.Date(2017, 3, 4, 5, 6, 7, 8, time.UTC)
+	i32 := int32(-3)
+	ops := map[string]*RangeOp{
+		"empty": NewRangeOp(&AllTypes{}),
+		"conditions and fields": NewRangeOp(&AllTypes{}).
+			Eq("BoolType", true).
+			Eq("StringType", "a \"quoted\" string").
+			Eq("UUIDType", UUID("d1449c93-25b8-4032-920b-60471d91acc9")).
+			Gt("Int32Type", int32(1)).LtOrEq("Int32Type", int32(9)).
+			GtOrEq("Int64Type", int64(1)<<62).
+			Lt("DoubleType", 2.5).
+			Eq("BlobType", []byte{0, 1, 2, 255}).
+			Gt("TimeType", ts).
+			Eq("NullInt32Type", &i32).
+			Fields([]string{"StringType", "Int64Type"}).
+			Limit(25).
+			Offset("opaque-backend-token"),
+		"backward keys only": NewRangeOp(&AllTypes{}).Eq("BoolType", false).Before("tok").KeysOnly(),
+		"allow filtering":    NewRangeOp(&AllTypes{}).Gt("Int64Type", int64(3)).AllowFiltering(),
+		"strong":             NewRangeOp(&AllTypes{}).Eq("BoolType", true).Consistency(Strong),
+	}
+	for name, op := range ops {
+		token, err := op.MarshalToken()
+		assert.NoError(t, err, name)
+		parsed, err := ParseRangeToken(token, &AllTypes{})
+		assert.NoError(t, err, name)
+		assert.Equal(t, op, parsed, name)
+	}
+}
+
+func TestParseRangeTokenErrors(t *testing.T) {
+	token, err := NewRangeOp(&AllTypes{}).Eq("BoolType", true).Limit(5).MarshalToken()
+	assert.NoError(t, err)
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	assert.NoError(t, err)
+
+	// reseal recomputes the checksum so that only the content is wrong
+	reseal := func(body []byte) string {
+		data := append([]byte{}, body...)
+		sum := make([]byte, 4)
+		binary.BigEndian.PutUint32(sum, crc32.ChecksumIEEE(data))
+		return base64.RawURLEncoding.EncodeToString(append(data, sum...))
+	}
+	body := raw[:len(raw)-4]
+	tampered := append([]byte{}, raw...)
+	tampered[len(tampered)-6] ^= 0x01
+	versioned := append([]byte{}, body...)
+	versioned[0] = 2
+
+	dataTests := []struct {
+		descript string
+		token    string
+		object   DomainObject
+		err      string
+	}{
+		{descript: "not base64", token: "not base64!", object: &AllTypes{}, err: "malformed token"},
+		{descript: "too short", token: base64.RawURLEncoding.EncodeToString([]byte{1, 2}), object: &AllTypes{}, err: "too short"},
+		{descript: "tampered", token: base64.RawURLEncoding.EncodeToString(tampered), object: &AllTypes{}, err: "checksum mismatch"},
+		{descript: "unknown version", token: reseal(versioned), object: &AllTypes{}, err: "unsupported token version 2"},
+		{descript: "bad body", token: reseal([]byte{rangeTokenVersion, '{'}), object: &AllTypes{}, err: "malformed token"},
+		{descript: "other entity", token: token, object: &SinglePrimaryKey{}, err: `token is for entity "alltypes"`},
+		{descript: "unknown field", token: reseal(append([]byte{rangeTokenVersion}, `{"Entity":"alltypes","Conditions":{"Nope":[{"Op":1,"Value":true}]}}`...)), object: &AllTypes{}, err: "Nope is not a field"},
+		{descript: "wrong value type", token: reseal(append([]byte{rangeTokenVersion}, `{"Entity":"alltypes","Conditions":{"BoolType":[{"Op":1,"Value":"x"}]}}`...)), object: &AllTypes{}, err: "cannot decode value for BoolType"},
+	}
+	for _, test := range dataTests {
+		_, err := ParseRangeToken(test.token, test.object)
+		if assert.Error(t, err, test.descript) {
+			assert.Contains(t, err.Error(), test.err, test.descript)
+		}
+	}
+}
+
+// prefixCodec wraps the JSON codec with a marker, standing in for a codec
+// with a format of its own
+type prefixCodec struct{}
+
+func (prefixCodec) Encode(payload TokenPayload) (string, error) {
+	token, err := JSONTokenCodec{}.Encode(payload)
+	return "v2." + token, err
+}
+
+func (prefixCodec) Decode(token string) (TokenPayload, error) {
+	if !strings.HasPrefix(token, "v2.") {
+		return TokenPayload{}, errors.New("not a v2 token")
+	}
+	return JSONTokenCodec{}.Decode(strings.TrimPrefix(token, "v2."))
+}
+
+func TestTokenCodec(t *testing.T) {
+	// the default codec round trips payloads
+	payload := TokenPayload{
+		Entity:     "alltypes",
+		Conditions: map[string][]TokenCondition{"BoolType": {{Op: Eq, Value: json.RawMessage("true")}}},
+		Fields:     []string{"StringType"},
+		Limit:      10,
+		Token:      "tok",
+		Backward:   true,
+		KeysOnly:   true,
+	}
+	token, err := JSONTokenCodec{}.Encode(payload)
+	assert.NoError(t, err)
+	decoded, err := JSONTokenCodec{}.Decode(token)
+	assert.NoError(t, err)
+	assert.Equal(t, payload, decoded)
+
+	// and is the one MarshalToken uses
+	op := NewRangeOp(&AllTypes{}).Eq("BoolType", true).Limit(5)
+	viaOp, err := op.MarshalToken()
+	assert.NoError(t, err)
+	viaCodec, err := op.MarshalTokenWith(JSONTokenCodec{})
+	assert.NoError(t, err)
+	assert.Equal(t, viaOp, viaCodec)
+
+	// other codecs are used for both directions
+	custom, err := op.MarshalTokenWith(prefixCodec{})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(custom, "v2."))
+	parsed, err := ParseRangeTokenWith(custom, &AllTypes{}, prefixCodec{})
+	assert.NoError(t, err)
+	assert.Equal(t, op, parsed)
+	_, err = ParseRangeTokenWith(viaOp, &AllTypes{}, prefixCodec{})
+	assert.Error(t, err)
+}
+
+func TestHMACTokenCodec(t *testing.T) {
+	codec := HMACTokenCodec{Key: []byte("secret")}
+	op := NewRangeOp(&AllTypes{}).Eq("BoolType", true).Limit(5)
+	token, err := op.MarshalTokenWith(codec)
+	assert.NoError(t, err)
+	parsed, err := ParseRangeTokenWith(token, &AllTypes{}, codec)
+	assert.NoError(t, err)
+	assert.Equal(t, op, parsed)
+
+	// an edited token fails, even when it is otherwise well formed
+	payload, err := codec.Decode(token)
+	assert.NoError(t, err)
+	payload.Limit = 1000
+	forged, err := HMACTokenCodec{Key: []byte("guess")}.Encode(payload)
+	assert.NoError(t, err)
+	_, err = ParseRangeTokenWith(forged, &AllTypes{}, codec)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "signature mismatch")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	assert.NoError(t, err)
+	data[3] ^= 1
+	_, err = codec.Decode(base64.RawURLEncoding.EncodeToString(data))
+	assert.Error(t, err)
+
+	// and so do tokens of the checksummed codec
+	plain, err := op.MarshalToken()
+	assert.NoError(t, err)
+	_, err = ParseRangeTokenWith(plain, &AllTypes{}, codec)
+	assert.Error(t, err)
+}
@@ -24,6 +24,7 @@ import (
 	"bytes"
 	"fmt"
 	"reflect"
+	"sort"
 
 	"github.com/golang/mock/gomock"
 )
@@ -31,12 +32,28 @@ import (
 // ScanOp represents the scan query
 type ScanOp struct {
 	pager
-	object DomainObject
+	conditioner
 }
 
 // NewScanOp returns a new ScanOp instance
 func NewScanOp(obj DomainObject) *ScanOp {
-	return &ScanOp{object: obj}
+	return &ScanOp{
+		conditioner: conditioner{
+			object:     obj,
+			conditions: map[string][]*Condition{},
+		},
+	}
+}
+
+// Filter restricts the scan to rows whose field satisfies the condition.
+// Unlike range conditions, filters may be placed on any field and do not
+// require the partition key. Connectors that implement FilteredScanner apply
+// them server side; for all others the client scans and drops the rows that
+// do not match, so a page may hold fewer rows than the limit, or none, even
+// though more rows follow.
+func (s *ScanOp) Filter(fieldName string, op Operator, value interface{}) *ScanOp {
+	s.appendOp(op, fieldName, value)
+	return s
 }
 
 // Limit sets the number of rows returned per call. Default is 100
@@ -62,22 +79,53 @@ func (s *ScanOp) Fields(fields []string) *ScanOp {
 func (s *ScanOp) String() string {
 	result := &bytes.Buffer{}
 	result.WriteString("ScanOp")
+	if len(s.conditions) > 0 {
+		// sort the fields by name for deterministic results
+		fields := make([]string, 0, len(s.conditions))
+		for field := range s.conditions {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		result.WriteString(" where")
+		for i, field := range fields {
+			for j, cond := range s.conditions[field] {
+				if i > 0 || j > 0 {
+					result.WriteString(",")
+				}
+				fmt.Fprintf(result, " %s %s %v", field, cond.Op.String(), cond.Value)
+			}
+		}
+	}
 	addLimitTokenString(result, s.limit, s.token)
 	return result.String()
 }
 
 type scanOpMatcher struct {
-	p   pager
-	typ reflect.Type
+	p     pager
+	conds map[string]map[Condition]bool
+	typ   reflect.Type
 }
 
 // EqScanOp provides a gomock Matcher that matches any ScanOp with a limit,
-// token, and fields to read that are the same as those specificed by the op argument.
+// token, fields to read and filters that are the same as those specificed by the op argument.
 func EqScanOp(op *ScanOp) gomock.Matcher {
 	return scanOpMatcher{
-		p:   op.pager,
-		typ: reflect.TypeOf(op.object).Elem(),
+		p:     op.pager,
+		conds: conditionSet(op.conditions),
+		typ:   reflect.TypeOf(op.object).Elem(),
+	}
+}
+
+// conditionSet returns the conditions of each field as a set
+func conditionSet(conditions map[string][]*Condition) map[string]map[Condition]bool {
+	set := make(map[string]map[Condition]bool, len(conditions))
+	for field, conds := range conditions {
+		set[field] = make(map[Condition]bool, len(conds))
+		for _, cond := range conds {
+			set[field][*cond] = true
+		}
 	}
+	return set
 }
 
 // Matches satisfies the gomock.Matcher interface
@@ -87,15 +135,16 @@ func (m scanOpMatcher) Matches(x interface{}) bool {
 		return false
 	}
 
-	return m.p.equals(op.pager) && reflect.TypeOf(op.object).Elem() == m.typ
+	return m.p.equals(op.pager) && reflect.DeepEqual(m.conds, conditionSet(op.conditions)) && reflect.TypeOf(op.object).Elem() == m.typ
 }
 
 // String satisfies the gomock.Matcher and Stringer interface
 func (m scanOpMatcher) String() string {
 	return fmt.Sprintf(
-		" is equal to ScanOp with token %s, limit %d, fields %v, and entity type %v",
+		" is equal to ScanOp with token %s, limit %d, fields %v, filters %v, and entity type %v",
 		m.p.token,
 		m.p.limit,
 		m.p.fieldsToRead,
+		m.conds,
 		m.typ)
 }
@@ -86,6 +86,11 @@ var ScanTestCases = []struct {
 		sop:      dosa.NewScanOp(&AllTypesScanTestEntity{}).Fields([]string{"StringType"}),
 		stringer: "ScanOp",
 	},
+	{
+		descript: "with filters",
+		sop:      dosa.NewScanOp(&AllTypesScanTestEntity{}).Filter("StringType", dosa.Eq, "a").Filter("Int32Type", dosa.Gt, int32(3)).Limit(5),
+		stringer: "ScanOp where Int32Type Gt 3, StringType Eq a limit 5",
+	},
 }
 
 func TestScanOpMatcher(t *testing.T) {
@@ -94,11 +99,18 @@ func TestScanOpMatcher(t *testing.T) {
 	scanOp2 := dosa.NewScanOp(&AllTypesScanTestEntity{}).Limit(1).Offset("token1")
 	scanOp3 := dosa.NewScanOp(&AllTypesScanTestEntity{}).Limit(1).Fields([]string{"BoolType", "TimeType"})
 	scanOp4 := dosa.NewScanOp(&dosa.Entity{}).Limit(1)
+	scanOp5 := dosa.NewScanOp(&AllTypesScanTestEntity{}).Limit(1).Filter("Int32Type", dosa.Gt, int32(3))
+	scanOp6 := dosa.NewScanOp(&AllTypesScanTestEntity{}).Limit(1).Filter("Int32Type", dosa.Gt, int32(3))
 
 	matcher := dosa.EqScanOp(scanOp0)
 	assert.True(t, matcher.Matches(scanOp1))
 	assert.False(t, matcher.Matches(scanOp2))
 	assert.False(t, matcher.Matches(scanOp3))
 	assert.False(t, matcher.Matches(scanOp4))
+	assert.False(t, matcher.Matches(scanOp5))
 	assert.False(t, matcher.Matches(3))
+
+	matcher = dosa.EqScanOp(scanOp5)
+	assert.True(t, matcher.Matches(scanOp6))
+	assert.False(t, matcher.Matches(scanOp0))
 }
@@ -0,0 +1,92 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build go1.18
+// +build go1.18
+
+package dosa
+
+import "context"
+
+// TypedClient wraps a Client for a single entity type. T is the pointer type
+// of the entity, e.g. *User, so results come back as []T rather than
+// []DomainObject and callers do not need type assertions. Everything is
+// delegated to the wrapped Client, which must have the entity registered.
+type TypedClient[T DomainObject] struct {
+	client Client
+}
+
+// NewTypedClient returns a TypedClient for entities of type T
+func NewTypedClient[T DomainObject](client Client) *TypedClient[T] {
+	return &TypedClient[T]{client: client}
+}
+
+// Client returns the wrapped Client
+func (c *TypedClient[T]) Client() Client {
+	return c.client
+}
+
+// Read fetches the entity by its primary key, see Client.Read
+func (c *TypedClient[T]) Read(ctx context.Context, fieldsToRead []string, entity T) error {
+	return c.client.Read(ctx, fieldsToRead, entity)
+}
+
+// Upsert creates or updates the entity, see Client.Upsert
+func (c *TypedClient[T]) Upsert(ctx context.Context, fieldsToUpdate []string, entity T) error {
+	return c.client.Upsert(ctx, fieldsToUpdate, entity)
+}
+
+// Remove deletes the entity by its primary key, see Client.Remove
+func (c *TypedClient[T]) Remove(ctx context.Context, entity T) error {
+	return c.client.Remove(ctx, entity)
+}
+
+// Range fetches a page of entities, see Client.Range
+func (c *TypedClient[T]) Range(ctx context.Context, r *RangeOp) ([]T, string, error) {
+	objs, token, err := c.client.Range(ctx, r)
+	if err != nil {
+		return nil, "", err
+	}
+	return typedSlice[T](objs), token, nil
+}
+
+// ScanEverything fetches a page of all entities, see Client.ScanEverything
+func (c *TypedClient[T]) ScanEverything(ctx context.Context, sop *ScanOp) ([]T, string, error) {
+	objs, token, err := c.client.ScanEverything(ctx, sop)
+	if err != nil {
+		return nil, "", err
+	}
+	return typedSlice[T](objs), token, nil
+}
+
+// WalkRange calls onNext for every entity of the range, see Client.WalkRange
+func (c *TypedClient[T]) WalkRange(ctx context.Context, r *RangeOp, onNext func(entity T) error) error {
+	return c.client.WalkRange(ctx, r, func(obj DomainObject) error {
+		return onNext(obj.(T))
+	})
+}
+
+func typedSlice[T DomainObject](objs []DomainObject) []T {
+	typed := make([]T, len(objs))
+	for i, obj := range objs {
+		typed[i] = obj.(T)
+	}
+	return typed
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build go1.18
+// +build go1.18
+
+package dosa_test
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	dosaRenamed "github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/mocks"
+)
+
+func TestTypedClient(t *testing.T) {
+	reg1, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().CheckSchema(ctx, gomock.Any(), gomock.Any(), gomock.Any()).Return(int32(1), nil).AnyTimes()
+	c1 := dosaRenamed.NewClient(reg1, mockConn)
+	assert.NoError(t, c1.Initialize(ctx))
+	typed := dosaRenamed.NewTypedClient[*ClientTestEntity1](c1)
+	assert.Equal(t, c1, typed.Client())
+
+	// Read
+	mockConn.EXPECT().Read(ctx, gomock.Any(), map[string]dosaRenamed.FieldValue{"id": int64(1)}, gomock.Any()).
+		Return(map[string]dosaRenamed.FieldValue{"id": int64(1), "name": "foo"}, nil)
+	entity := &ClientTestEntity1{ID: int64(1)}
+	assert.NoError(t, typed.Read(ctx, dosaRenamed.All(), entity))
+	assert.Equal(t, "foo", entity.Name)
+
+	// Range
+	rows := []map[string]dosaRenamed.FieldValue{
+		{"id": int64(1), "name": "foo"},
+		{"id": int64(2), "name": "bar"},
+	}
+	mockConn.EXPECT().Range(ctx, gomock.Any(), gomock.Any(), gomock.Any(), "", 0).Return(rows, "next", nil)
+	results, token, err := typed.Range(ctx, dosaRenamed.NewRangeOp(cte1))
+	assert.NoError(t, err)
+	assert.Equal(t, "next", token)
+	if assert.Len(t, results, 2) {
+		assert.Equal(t, "foo", results[0].Name)
+		assert.Equal(t, int64(2), results[1].ID)
+	}
+
+	// errors are passed through
+	mockConn.EXPECT().Range(ctx, gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, "", assert.AnError)
+	results, _, err = typed.Range(ctx, dosaRenamed.NewRangeOp(cte1))
+	assert.Error(t, err)
+	assert.Nil(t, results)
+
+	// WalkRange
+	mockConn.EXPECT().Range(ctx, gomock.Any(), gomock.Any(), gomock.Any(), "", 0).Return(rows, "", nil)
+	var names []string
+	err = typed.WalkRange(ctx, dosaRenamed.NewRangeOp(cte1), func(e *ClientTestEntity1) error {
+		names = append(names, e.Name)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo", "bar"}, names)
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dosa
+
+import "context"
+
+// WriteBatch collects upserts and removes to be committed together, all or
+// nothing. Create one with Client.WriteBatch. A batch is not safe for
+// concurrent use and should not be used again after Commit.
+type WriteBatch struct {
+	ctx    context.Context
+	client *client
+	ops    []WriteOp
+	err    error
+}
+
+// WriteBatch starts an empty batch that is committed with ctx
+func (c *client) WriteBatch(ctx context.Context) *WriteBatch {
+	return &WriteBatch{ctx: ctx, client: c}
+}
+
+// Upsert adds an upsert of the object to the batch. A list of fields to
+// update can be specified. Use All() or nil for all fields. An object that
+// cannot be upserted makes Commit fail without writing anything.
+func (b *WriteBatch) Upsert(fieldsToUpdate []string, objectToUpdate DomainObject) *WriteBatch {
+	if b.err != nil {
+		return b
+	}
+	re, err := b.client.registrar.Find(objectToUpdate)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	values, err := re.OnlyFieldValues(objectToUpdate, fieldsToUpdate)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	for k, v := range re.KeyFieldValues(objectToUpdate) {
+		values[k] = v
+	}
+	b.ops = append(b.ops, WriteOp{EI: re.EntityInfo(), Values: values})
+	return b
+}
+
+// Delete adds a removal of the object's row to the batch. Only the primary
+// key fields of the object are used.
+func (b *WriteBatch) Delete(objectToRemove DomainObject) *WriteBatch {
+	if b.err != nil {
+		return b
+	}
+	re, err := b.client.registrar.Find(objectToRemove)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	b.ops = append(b.ops, WriteOp{EI: re.EntityInfo(), Remove: true, Values: re.KeyFieldValues(objectToRemove)})
+	return b
+}
+
+// Commit applies the writes of the batch through the connector's Transactor,
+// in the order they were added. An empty batch commits without calling the
+// connector.
+func (b *WriteBatch) Commit() error {
+	if !b.client.initialized {
+		return &ErrNotInitialized{}
+	}
+	if b.err != nil {
+		return b.err
+	}
+	t, ok := b.client.connector.(Transactor)
+	if !ok {
+		return &ErrUnsupported{Op: "WriteBatch"}
+	}
+	if len(b.ops) == 0 {
+		return nil
+	}
+	return t.CommitBatch(b.ctx, b.ops)
+}